@@ -2,18 +2,146 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"gibber/config"
+	"gibber/datastore"
+	gibberlog "gibber/log"
 	"gibber/service"
+	"gibber/user"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-// details of the endpoint exposed
-const (
-	host = "127.0.0.1"
-	port = "7000"
-)
-
 func main() {
-	_, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
-	log.Fatal(service.StartServer(host, port, cancelFunc))
+	cfg, err := config.Load(os.Args[1:])
+	switch err {
+	case nil, config.ErrUnlockAccount:
+	case flag.ErrHelp:
+		return
+	case config.ErrPrintConfig:
+		if err = cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		log.Fatal(err)
+	}
+
+	gibberlog.SetOptions(cfg.LogOptions())
+	datastore.SetMongoConfig(cfg.Store.Mongo)
+	store, err := user.NewStore(cfg.Store.Backend)
+	if err != nil {
+		log.Fatal(err)
+	}
+	user.SetStore(store)
+	if cfg.UnlockEmail != "" {
+		if err = user.UnlockAccount(cfg.UnlockEmail); err != nil {
+			log.Fatalf("unlocking %s failed: %s", cfg.UnlockEmail, err)
+		}
+		log.Printf("account %s unlocked", cfg.UnlockEmail)
+		return
+	}
+	throttle := func(maxFailures int) user.LoginThrottle {
+		return user.LoginThrottle{
+			MaxFailures: maxFailures,
+			Window:      cfg.Timeouts.LockoutWindow.Duration,
+			Lockout:     cfg.Timeouts.Lockout.Duration,
+			Backoff:     cfg.Timeouts.LoginBackoff.Duration,
+		}
+	}
+	user.SetMailer(cfg.Mailer())
+	service.SetLimits(service.Limits{
+		MaxAttempts:       cfg.Security.MaxAttempts,
+		PasswordMinLength: cfg.Security.PasswordMinLength,
+		TokenTTL:          cfg.Timeouts.TokenTTL.Duration,
+		ResetTokenTTL:     cfg.Timeouts.ResetTokenTTL.Duration,
+		VerificationTTL:   cfg.Timeouts.VerificationTTL.Duration,
+		SessionTTL:        cfg.Timeouts.SessionTTL.Duration,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader.Duration,
+		AccountLogins:     throttle(cfg.Security.LockoutFailures),
+		SourceLogins:      throttle(cfg.Security.IPLockoutFailures),
+	})
+
+	var tlsConfig *tls.Config
+	if cfg.TLS.CertFile != "" {
+		tlsConfig, err = service.TLSConfig(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	host := cfg.Server.Host
+
+	// each listener runs till it fails, or the server is shut down
+	stopped := make(chan error, 4)
+	start := func(startFunc func(complete context.CancelFunc) error) {
+		_, cancelFunc := context.WithTimeout(context.Background(), 5*time.Second)
+		go func() {
+			stopped <- startFunc(cancelFunc)
+		}()
+	}
+	listeners := 1
+	start(func(complete context.CancelFunc) error {
+		if tlsConfig == nil {
+			return service.StartServer(host, cfg.Server.Port, complete)
+		}
+		return service.StartTLSServer(host, cfg.Server.Port, tlsConfig, complete)
+	})
+	if wsPort := cfg.Server.WebSocketPort; wsPort != "" {
+		listeners++
+		start(func(complete context.CancelFunc) error {
+			return service.StartWebSocketServer(host, wsPort, tlsConfig, complete)
+		})
+	}
+	if apiPort := cfg.Server.APIPort; apiPort != "" {
+		listeners++
+		start(func(complete context.CancelFunc) error {
+			return service.StartAPIServer(host, apiPort, tlsConfig, complete)
+		})
+	}
+	if metricsPort := cfg.Server.MetricsPort; metricsPort != "" {
+		listeners++
+		start(func(complete context.CancelFunc) error {
+			return service.StartMetricsServer(host, metricsPort, tlsConfig, complete)
+		})
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	var failure error
+	select {
+	case sig := <-signals:
+		log.Printf("received %s, shutting down", sig)
+	case failure = <-stopped:
+		log.Printf("listener failed, shutting down: %s", failure)
+		listeners--
+	}
+	if err = shutdown(listeners, stopped, cfg.Timeouts.Shutdown.Duration); err == nil {
+		err = failure
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Print("server shut down")
+}
+
+// shutdown gracefully shuts down the server, waiting for the given number of listeners to stop. It fails if the
+// live sessions couldn't end within the timeout.
+func shutdown(listeners int, stopped <-chan error, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := service.Shutdown(ctx)
+	for ; listeners > 0; listeners-- {
+		if er := <-stopped; er != service.ErrServerClosed {
+			log.Printf("listener stopped: %s", er)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("shutdown incomplete: %s", err)
+	}
+	return nil
 }
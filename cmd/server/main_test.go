@@ -1,13 +1,19 @@
 package main
 
 import (
+	"gibber/user"
 	"github.com/stretchr/testify/assert"
 	"net"
+	"os"
 	"testing"
 	"time"
 )
 
 func TestMainFunc(t *testing.T) {
+	if os.Getenv("GIBBER_STORE") == "" {
+		_ = os.Setenv("GIBBER_STORE", user.MemoryStore) // no live mongo needed unless asked for
+	}
+	os.Args = []string{"gibber-server"} // the test flags are not the server's
 	go main()
 	time.Sleep(time.Second) // wait for the server to get started
 
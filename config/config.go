@@ -0,0 +1,539 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gibber/datastore"
+	"gibber/log"
+	"gibber/mail"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// store backends, as known to the user package
+const (
+	mongoStore  = "mongo"
+	memoryStore = "memory"
+)
+
+// masked replaces the secrets when the configuration is printed
+const masked = "******"
+
+// Config is the configuration of the server. Each setting is taken from the first of these having it: the
+// command-line flags, the environment variables, the config file (JSON), and the defaults.
+type Config struct {
+	Server   ServerConfig   `json:"server"`
+	TLS      TLSConfig      `json:"tls"`
+	Store    StoreConfig    `json:"store"`
+	Log      LogConfig      `json:"log"`
+	Mail     MailConfig     `json:"mail"`
+	Security SecurityConfig `json:"security"`
+	Timeouts TimeoutsConfig `json:"timeouts"`
+
+	UnlockEmail string `json:"-"` // account to be unlocked instead of starting the server
+}
+
+// ServerConfig is where the server listens for the clients
+type ServerConfig struct {
+	Host          string `json:"host"`
+	Port          string `json:"port"`
+	WebSocketPort string `json:"websocket_port"` // WebSocket gateway not started if empty
+	APIPort       string `json:"api_port"`       // REST API not started if empty
+	MetricsPort   string `json:"metrics_port"`   // metrics endpoint not started if empty
+}
+
+// TLSConfig is the certificate to serve the clients over TLS, plaintext if empty
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"` // requires the clients to present a certificate signed by its CAs
+}
+
+// StoreConfig is where the data is persisted
+type StoreConfig struct {
+	Backend string                `json:"backend"` // mongo or memory
+	Mongo   datastore.MongoConfig `json:"mongo"`   // for the mongo backend
+}
+
+// LogConfig is where and how the logs are written
+type LogConfig struct {
+	Level      string `json:"level"`       // debug, info, warn or error
+	Format     string `json:"format"`      // text or json
+	File       string `json:"file"`        // generated/debug.log in the project root if empty, or stdout
+	MaxSizeMB  int    `json:"max_size_mb"` // size after which the file is rotated, never rotated if 0
+	MaxBackups int    `json:"max_backups"` // rotated files kept
+}
+
+// MailConfig is how the emails (e.g. the password reset tokens) are sent
+type MailConfig struct {
+	From     string `json:"from"`
+	SMTPHost string `json:"smtp_host"` // emails written to the file if empty
+	SMTPPort string `json:"smtp_port"`
+	Username string `json:"username"` // no authentication if empty
+	Password string `json:"password"`
+	File     string `json:"file"` // where the emails are written when there is no SMTP server
+}
+
+// SecurityConfig limits what the clients can do
+type SecurityConfig struct {
+	MaxAttempts       int `json:"max_attempts"` // to enter a valid email or password before being disconnected
+	PasswordMinLength int `json:"password_min_length"`
+	LockoutFailures   int `json:"lockout_failures"`    // failed logins to an account locking it out for a while
+	IPLockoutFailures int `json:"ip_lockout_failures"` // failed logins from an IP locking it out for a while
+}
+
+// TimeoutsConfig are the durations after which things are given up
+type TimeoutsConfig struct {
+	Shutdown        Duration `json:"shutdown"`         // for the live sessions to end on shutdown
+	TokenTTL        Duration `json:"token_ttl"`        // for the REST API tokens to be valid
+	ResetTokenTTL   Duration `json:"reset_token_ttl"`  // for the password reset tokens to be valid
+	VerificationTTL Duration `json:"verification_ttl"` // for the email verification codes to be valid
+	SessionTTL      Duration `json:"session_ttl"`      // for the sessions to be resumable with their tokens
+	LockoutWindow   Duration `json:"lockout_window"`   // in which the failed logins are counted
+	Lockout         Duration `json:"lockout"`          // for which the logins are locked out after too many failures
+	LoginBackoff    Duration `json:"login_backoff"`    // first delay after the failed logins beyond half the maximum
+	ReadHeader      Duration `json:"read_header"`      // for the HTTP clients to send the headers of a request
+}
+
+// Duration is a time.Duration written as a string (e.g. "1m30s") in the config file
+type Duration struct {
+	time.Duration
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads the duration from a string
+func (d *Duration) UnmarshalJSON(data []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration should be a string like \"10s\": %s", err)
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return
+}
+
+// Default gives the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{Host: "127.0.0.1", Port: "7000"},
+		Store:  StoreConfig{Backend: mongoStore},
+		Log:    LogConfig{Level: "info", Format: log.TextFormat, MaxSizeMB: 100, MaxBackups: 3},
+		Mail:   MailConfig{From: "gibber@localhost", SMTPPort: "587", File: "generated/mail.log"},
+		Security: SecurityConfig{
+			MaxAttempts:       3,
+			PasswordMinLength: 6,
+			LockoutFailures:   5,
+			IPLockoutFailures: 20,
+		},
+		Timeouts: TimeoutsConfig{
+			Shutdown:        Duration{10 * time.Second},
+			TokenTTL:        Duration{24 * time.Hour},
+			ResetTokenTTL:   Duration{time.Hour},
+			VerificationTTL: Duration{24 * time.Hour},
+			SessionTTL:      Duration{7 * 24 * time.Hour},
+			LockoutWindow:   Duration{15 * time.Minute},
+			Lockout:         Duration{15 * time.Minute},
+			LoginBackoff:    Duration{time.Second},
+			ReadHeader:      Duration{10 * time.Second},
+		},
+	}
+}
+
+// setting is a configuration setting which can be set through a flag and an environment variable
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value func(c *Config) flag.Value
+}
+
+// settings are all the settings which can be set through flags and environment variables
+var settings = []setting{
+	{"host", "GIBBER_HOST", "host to listen on", stringSetting(func(c *Config) *string { return &c.Server.Host })},
+	{"port", "GIBBER_PORT", "port of the chat listener",
+		stringSetting(func(c *Config) *string { return &c.Server.Port })},
+	{"ws-port", "GIBBER_WS_PORT", "port of the WebSocket gateway, not started if empty",
+		stringSetting(func(c *Config) *string { return &c.Server.WebSocketPort })},
+	{"api-port", "GIBBER_API_PORT", "port of the REST API, not started if empty",
+		stringSetting(func(c *Config) *string { return &c.Server.APIPort })},
+	{"metrics-port", "GIBBER_METRICS_PORT", "port of the metrics endpoint (Prometheus), not started if empty",
+		stringSetting(func(c *Config) *string { return &c.Server.MetricsPort })},
+	{"tls-cert", "GIBBER_TLS_CERT", "TLS certificate (PEM) file, plaintext if empty",
+		stringSetting(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"tls-key", "GIBBER_TLS_KEY", "TLS key (PEM) file",
+		stringSetting(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"tls-client-ca", "GIBBER_TLS_CLIENT_CA", "CA certificates (PEM) file the client certificates are to be signed by",
+		stringSetting(func(c *Config) *string { return &c.TLS.ClientCAFile })},
+	{"store", "GIBBER_STORE", "store backend (mongo or memory)",
+		stringSetting(func(c *Config) *string { return &c.Store.Backend })},
+	{"mongo-host", "GIBBER_MONGO_HOST", "mongo host",
+		stringSetting(func(c *Config) *string { return &c.Store.Mongo.Host })},
+	{"mongo-user", "GIBBER_MONGO_USER", "mongo user",
+		stringSetting(func(c *Config) *string { return &c.Store.Mongo.User })},
+	{"mongo-password", "GIBBER_MONGO_PWD", "mongo password",
+		stringSetting(func(c *Config) *string { return &c.Store.Mongo.Password })},
+	{"mongo-db", "GIBBER_MONGO_DB", "mongo database",
+		stringSetting(func(c *Config) *string { return &c.Store.Mongo.Database })},
+	{"mongo-options", "GIBBER_MONGO_OPTS", "mongo connection options",
+		stringSetting(func(c *Config) *string { return &c.Store.Mongo.Options })},
+	{"log-level", "GIBBER_LOG_LEVEL", "minimum level of the logs (debug, info, warn or error)",
+		stringSetting(func(c *Config) *string { return &c.Log.Level })},
+	{"log-format", "GIBBER_LOG_FORMAT", "format of the logs (text or json)",
+		stringSetting(func(c *Config) *string { return &c.Log.Format })},
+	{"log-file", "GIBBER_LOG_FILE", "log file, generated/debug.log if empty, or stdout",
+		stringSetting(func(c *Config) *string { return &c.Log.File })},
+	{"log-max-size", "GIBBER_LOG_MAX_SIZE", "size (MB) after which the log file is rotated, never if 0",
+		intSetting(func(c *Config) *int { return &c.Log.MaxSizeMB })},
+	{"log-max-backups", "GIBBER_LOG_MAX_BACKUPS", "rotated log files kept",
+		intSetting(func(c *Config) *int { return &c.Log.MaxBackups })},
+	{"mail-from", "GIBBER_MAIL_FROM", "sender address of the emails",
+		stringSetting(func(c *Config) *string { return &c.Mail.From })},
+	{"smtp-host", "GIBBER_SMTP_HOST", "SMTP server sending the emails, written to the mail file if empty",
+		stringSetting(func(c *Config) *string { return &c.Mail.SMTPHost })},
+	{"smtp-port", "GIBBER_SMTP_PORT", "SMTP server port",
+		stringSetting(func(c *Config) *string { return &c.Mail.SMTPPort })},
+	{"smtp-user", "GIBBER_SMTP_USER", "SMTP user, no authentication if empty",
+		stringSetting(func(c *Config) *string { return &c.Mail.Username })},
+	{"smtp-password", "GIBBER_SMTP_PWD", "SMTP password",
+		stringSetting(func(c *Config) *string { return &c.Mail.Password })},
+	{"mail-file", "GIBBER_MAIL_FILE", "file the emails are written to when there is no SMTP server",
+		stringSetting(func(c *Config) *string { return &c.Mail.File })},
+	{"max-attempts", "GIBBER_MAX_ATTEMPTS", "attempts to enter a valid email or password before being disconnected",
+		intSetting(func(c *Config) *int { return &c.Security.MaxAttempts })},
+	{"password-min-length", "GIBBER_PASSWORD_MIN_LENGTH", "minimum length of the passwords",
+		intSetting(func(c *Config) *int { return &c.Security.PasswordMinLength })},
+	{"lockout-failures", "GIBBER_LOCKOUT_FAILURES", "failed logins to an account locking it out for a while",
+		intSetting(func(c *Config) *int { return &c.Security.LockoutFailures })},
+	{"ip-lockout-failures", "GIBBER_IP_LOCKOUT_FAILURES", "failed logins from an IP locking it out for a while",
+		intSetting(func(c *Config) *int { return &c.Security.IPLockoutFailures })},
+	{"shutdown-timeout", "GIBBER_SHUTDOWN_TIMEOUT", "time given to the live sessions to end on shutdown",
+		durationSetting(func(c *Config) *time.Duration { return &c.Timeouts.Shutdown.Duration })},
+	{"token-ttl", "GIBBER_TOKEN_TTL", "validity of the REST API tokens",
+		durationSetting(func(c *Config) *time.Duration { return &c.Timeouts.TokenTTL.Duration })},
+	{"reset-token-ttl", "GIBBER_RESET_TOKEN_TTL", "validity of the password reset tokens",
+		durationSetting(func(c *Config) *time.Duration { return &c.Timeouts.ResetTokenTTL.Duration })},
+	{"verification-ttl", "GIBBER_VERIFICATION_TTL", "validity of the email verification codes",
+		durationSetting(func(c *Config) *time.Duration { return &c.Timeouts.VerificationTTL.Duration })},
+	{"session-ttl", "GIBBER_SESSION_TTL", "time for which the sessions can be resumed with their tokens",
+		durationSetting(func(c *Config) *time.Duration { return &c.Timeouts.SessionTTL.Duration })},
+	{"lockout-window", "GIBBER_LOCKOUT_WINDOW", "time in which the failed logins are counted",
+		durationSetting(func(c *Config) *time.Duration { return &c.Timeouts.LockoutWindow.Duration })},
+	{"lockout", "GIBBER_LOCKOUT", "time for which the logins are locked out after too many failures",
+		durationSetting(func(c *Config) *time.Duration { return &c.Timeouts.Lockout.Duration })},
+	{"login-backoff", "GIBBER_LOGIN_BACKOFF", "first delay after the failed logins beyond half of the maximum, " +
+		"doubling afterwards", durationSetting(func(c *Config) *time.Duration { return &c.Timeouts.LoginBackoff.Duration })},
+	{"read-header-timeout", "GIBBER_READ_HEADER_TIMEOUT", "time given to the HTTP clients to send the request headers",
+		durationSetting(func(c *Config) *time.Duration { return &c.Timeouts.ReadHeader.Duration })},
+}
+
+// config file to be loaded, whether the configuration is to be printed, and the account to be unlocked
+const (
+	configFlag      = "config"
+	configEnv       = "GIBBER_CONFIG"
+	printConfigFlag = "print-config"
+	unlockFlag      = "unlock"
+)
+
+// ErrPrintConfig is returned by Load along with the configuration, when it is asked to be printed instead of
+// starting the server
+var ErrPrintConfig = errors.New("configuration to be printed")
+
+// ErrUnlockAccount is returned by Load along with the configuration, when an account (Config.UnlockEmail) is asked
+// to be unlocked instead of starting the server
+var ErrUnlockAccount = errors.New("account to be unlocked")
+
+// Load gives the configuration set through the given command-line arguments (without the program name), the
+// environment variables and the config file, validated. If the flags ask for the configuration to be printed,
+// it is returned along with ErrPrintConfig, and if they ask for an account to be unlocked, along with
+// ErrUnlockAccount.
+func Load(args []string) (*Config, error) {
+	// the flags are first parsed only to find the config file, as they override it
+	configFile, printConfig, unlockEmail, err := parseFlags(Default(), args)
+	if err != nil {
+		return nil, err
+	}
+	if configFile == "" {
+		configFile = os.Getenv(configEnv)
+	}
+
+	c := Default()
+	if configFile != "" {
+		if err = c.loadFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err = c.loadEnv(); err != nil {
+		return nil, err
+	}
+	if _, _, _, err = parseFlags(c, args); err != nil {
+		return nil, err
+	}
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+	if printConfig {
+		return c, ErrPrintConfig
+	}
+	if unlockEmail != "" {
+		c.UnlockEmail = strings.ToLower(unlockEmail)
+		return c, ErrUnlockAccount
+	}
+	return c, nil
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	if err := validPort("port", c.Server.Port, false); err != nil {
+		return err
+	}
+	if err := validPort("ws-port", c.Server.WebSocketPort, true); err != nil {
+		return err
+	}
+	if err := validPort("api-port", c.Server.APIPort, true); err != nil {
+		return err
+	}
+	if err := validPort("metrics-port", c.Server.MetricsPort, true); err != nil {
+		return err
+	}
+	ports := map[string]bool{c.Server.Port: true}
+	for _, port := range []string{c.Server.WebSocketPort, c.Server.APIPort, c.Server.MetricsPort} {
+		if port != "" && ports[port] {
+			return fmt.Errorf("port %s is used by more than one listener", port)
+		}
+		ports[port] = true
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("tls-cert and tls-key are to be set together")
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		return errors.New("tls-client-ca needs tls-cert and tls-key to be set")
+	}
+	switch c.Store.Backend {
+	case mongoStore:
+		if c.Store.Mongo.Host == "" {
+			return errors.New("mongo-host is needed for the mongo store")
+		}
+	case memoryStore:
+	default:
+		return fmt.Errorf("unknown store backend %q", c.Store.Backend)
+	}
+	if _, err := log.ParseLevel(c.Log.Level); err != nil {
+		return err
+	}
+	if c.Log.Format != log.TextFormat && c.Log.Format != log.JSONFormat {
+		return fmt.Errorf("unknown log format %q", c.Log.Format)
+	}
+	if c.Log.MaxSizeMB < 0 || c.Log.MaxBackups < 0 {
+		return errors.New("log-max-size and log-max-backups can't be negative")
+	}
+	if c.Mail.From == "" {
+		return errors.New("mail-from can't be empty")
+	}
+	if c.Mail.SMTPHost != "" {
+		if err := validPort("smtp-port", c.Mail.SMTPPort, false); err != nil {
+			return err
+		}
+	} else if c.Mail.File == "" {
+		return errors.New("mail-file is needed when there is no smtp-host")
+	}
+	if c.Security.MaxAttempts < 1 {
+		return fmt.Errorf("max-attempts should be at least 1, got %d", c.Security.MaxAttempts)
+	}
+	if c.Security.PasswordMinLength < 1 {
+		return fmt.Errorf("password-min-length should be at least 1, got %d", c.Security.PasswordMinLength)
+	}
+	if c.Security.LockoutFailures < 1 || c.Security.IPLockoutFailures < 1 {
+		return fmt.Errorf("lockout-failures and ip-lockout-failures should be at least 1, got %d and %d",
+			c.Security.LockoutFailures, c.Security.IPLockoutFailures)
+	}
+	if c.Timeouts.Shutdown.Duration <= 0 {
+		return fmt.Errorf("shutdown-timeout should be positive, got %s", c.Timeouts.Shutdown)
+	}
+	if c.Timeouts.TokenTTL.Duration <= 0 {
+		return fmt.Errorf("token-ttl should be positive, got %s", c.Timeouts.TokenTTL)
+	}
+	if c.Timeouts.ResetTokenTTL.Duration <= 0 {
+		return fmt.Errorf("reset-token-ttl should be positive, got %s", c.Timeouts.ResetTokenTTL)
+	}
+	if c.Timeouts.VerificationTTL.Duration <= 0 {
+		return fmt.Errorf("verification-ttl should be positive, got %s", c.Timeouts.VerificationTTL)
+	}
+	if c.Timeouts.SessionTTL.Duration <= 0 {
+		return fmt.Errorf("session-ttl should be positive, got %s", c.Timeouts.SessionTTL)
+	}
+	if c.Timeouts.LockoutWindow.Duration <= 0 || c.Timeouts.Lockout.Duration <= 0 {
+		return fmt.Errorf("lockout-window and lockout should be positive, got %s and %s", c.Timeouts.LockoutWindow,
+			c.Timeouts.Lockout)
+	}
+	if c.Timeouts.LoginBackoff.Duration < 0 {
+		return fmt.Errorf("login-backoff can't be negative, got %s", c.Timeouts.LoginBackoff)
+	}
+	if c.Timeouts.ReadHeader.Duration <= 0 {
+		return fmt.Errorf("read-header-timeout should be positive, got %s", c.Timeouts.ReadHeader)
+	}
+	return nil
+}
+
+// LogOptions gives the options of the logs, the configuration being valid
+func (c *Config) LogOptions() log.Options {
+	level, _ := log.ParseLevel(c.Log.Level)
+	return log.Options{
+		Level:      level,
+		Format:     c.Log.Format,
+		File:       c.Log.File,
+		MaxSize:    int64(c.Log.MaxSizeMB) << 20,
+		MaxBackups: c.Log.MaxBackups,
+	}
+}
+
+// Mailer gives the mailer sending the emails, through the SMTP server if any or to the mail file otherwise, the
+// configuration being valid
+func (c *Config) Mailer() mail.Mailer {
+	if c.Mail.SMTPHost == "" {
+		return mail.NewFileMailer(c.Mail.File, c.Mail.From)
+	}
+	return mail.NewSMTPMailer(c.Mail.SMTPHost, c.Mail.SMTPPort, c.Mail.Username, c.Mail.Password, c.Mail.From)
+}
+
+// Print writes the configuration as JSON, with the secrets masked
+func (c *Config) Print(w io.Writer) error {
+	printed := *c
+	if printed.Store.Mongo.Password != "" {
+		printed.Store.Mongo.Password = masked
+	}
+	if printed.Mail.Password != "" {
+		printed.Mail.Password = masked
+	}
+	data, err := json.MarshalIndent(printed, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// loadFile sets the settings present in the given JSON config file
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file %s failed: %s", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields() // a misspelled setting is not silently ignored
+	if err = decoder.Decode(c); err != nil {
+		return fmt.Errorf("parsing config file %s failed: %s", path, err)
+	}
+	return nil
+}
+
+// loadEnv sets the settings having their environment variable set
+func (c *Config) loadEnv() error {
+	for _, s := range settings {
+		value, ok := os.LookupEnv(s.env)
+		if !ok {
+			continue
+		}
+		if err := s.value(c).Set(value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %s", value, s.env, err)
+		}
+	}
+	return nil
+}
+
+// parseFlags sets the settings given as flags in the arguments, giving the config file, whether the
+// configuration is to be printed, and the account to be unlocked if any
+func parseFlags(c *Config, args []string) (configFile string, printConfig bool, unlockEmail string, err error) {
+	fs := flag.NewFlagSet("gibber-server", flag.ContinueOnError)
+	for _, s := range settings {
+		fs.Var(s.value(c), s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	fs.StringVar(&configFile, configFlag, "", fmt.Sprintf("JSON config file (env %s)", configEnv))
+	fs.BoolVar(&printConfig, printConfigFlag, false, "print the effective configuration and exit")
+	fs.StringVar(&unlockEmail, unlockFlag, "", "unlock the account with the given email after failed logins, and exit")
+	err = fs.Parse(args)
+	if err == nil && fs.NArg() > 0 {
+		err = fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return
+}
+
+// validPort checks that the given port (of the given setting) is a valid TCP port, unless optional and empty
+func validPort(name, port string, optional bool) error {
+	if port == "" && optional {
+		return nil
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("%s should be a port number (1-65535), got %q", name, port)
+	}
+	return nil
+}
+
+// stringFlag is a flag.Value setting a string of the configuration
+type stringFlag struct{ p *string }
+
+func (f stringFlag) String() string {
+	if f.p == nil {
+		return ""
+	}
+	return *f.p
+}
+
+func (f stringFlag) Set(s string) error {
+	*f.p = s
+	return nil
+}
+
+// intFlag is a flag.Value setting an integer of the configuration
+type intFlag struct{ p *int }
+
+func (f intFlag) String() string {
+	if f.p == nil {
+		return "0"
+	}
+	return strconv.Itoa(*f.p)
+}
+
+func (f intFlag) Set(s string) (err error) {
+	*f.p, err = strconv.Atoi(s)
+	return
+}
+
+// durationFlag is a flag.Value setting a duration of the configuration
+type durationFlag struct{ p *time.Duration }
+
+func (f durationFlag) String() string {
+	if f.p == nil {
+		return "0s"
+	}
+	return f.p.String()
+}
+
+func (f durationFlag) Set(s string) (err error) {
+	*f.p, err = time.ParseDuration(s)
+	return
+}
+
+// stringSetting binds a setting to the string of the configuration given by the field function
+func stringSetting(field func(c *Config) *string) func(c *Config) flag.Value {
+	return func(c *Config) flag.Value { return stringFlag{field(c)} }
+}
+
+// intSetting binds a setting to the integer of the configuration given by the field function
+func intSetting(field func(c *Config) *int) func(c *Config) flag.Value {
+	return func(c *Config) flag.Value { return intFlag{field(c)} }
+}
+
+// durationSetting binds a setting to the duration of the configuration given by the field function
+func durationSetting(field func(c *Config) *time.Duration) func(c *Config) flag.Value {
+	return func(c *Config) flag.Value { return durationFlag{field(c)} }
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"gibber/mail"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes the given JSON config file in a temporary directory, giving its path
+func writeConfigFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "gibber-config")
+	assert.NoError(t, err, "creating temp dir failed")
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "gibber.json")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600), "writing config file failed")
+	return path
+}
+
+// setEnv sets the given environment variable for the duration of the test
+func setEnv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	assert.NoError(t, os.Setenv(key, value))
+	t.Cleanup(func() {
+		if ok {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
+
+// unsetEnv unsets the environment variables of all the settings for the duration of the test, so that the
+// environment of the test run doesn't leak into it
+func unsetEnv(t *testing.T) {
+	for _, key := range append([]string{configEnv}, settingEnvs()...) {
+		if old, ok := os.LookupEnv(key); ok {
+			_ = os.Unsetenv(key)
+			key := key
+			t.Cleanup(func() { _ = os.Setenv(key, old) })
+		}
+	}
+}
+
+func settingEnvs() (envs []string) {
+	for _, s := range settings {
+		envs = append(envs, s.env)
+	}
+	return
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	unsetEnv(t)
+	c, err := Load([]string{"-store", memoryStore})
+	assert.NoError(t, err)
+	expected := Default()
+	expected.Store.Backend = memoryStore
+	assert.Equal(t, expected, c)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	unsetEnv(t)
+	path := writeConfigFile(t, `{
+		"server": {"host": "0.0.0.0", "port": "7100", "api_port": "7101"},
+		"store": {"backend": "mongo", "mongo": {"host": "file.mongo", "database": "gibber"}},
+		"security": {"max_attempts": 5},
+		"timeouts": {"token_ttl": "1h"}
+	}`)
+	setEnv(t, configEnv, path)
+	setEnv(t, "GIBBER_PORT", "7200")
+	setEnv(t, "GIBBER_MONGO_HOST", "env.mongo")
+	setEnv(t, "GIBBER_MAX_ATTEMPTS", "4")
+
+	c, err := Load([]string{"-port", "7300", "-max-attempts=2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "0.0.0.0", c.Server.Host, "set in the file only")
+	assert.Equal(t, "7101", c.Server.APIPort, "set in the file only")
+	assert.Equal(t, "7300", c.Server.Port, "flag overrides env and file")
+	assert.Equal(t, 2, c.Security.MaxAttempts, "flag overrides env and file")
+	assert.Equal(t, "env.mongo", c.Store.Mongo.Host, "env overrides file")
+	assert.Equal(t, "gibber", c.Store.Mongo.Database, "set in the file only")
+	assert.Equal(t, time.Hour, c.Timeouts.TokenTTL.Duration, "set in the file only")
+	assert.Equal(t, 6, c.Security.PasswordMinLength, "default")
+
+	// the config file flag overrides the env too
+	path = writeConfigFile(t, `{"store": {"backend": "memory"}}`)
+	c, err = Load([]string{"-config", path})
+	assert.NoError(t, err)
+	assert.Equal(t, memoryStore, c.Store.Backend)
+	assert.Equal(t, "7200", c.Server.Port)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	unsetEnv(t)
+	tests := []struct {
+		name string
+		args []string
+		file string
+		err  string
+	}{
+		{"unknown flag", []string{"-unknown"}, "", "flag provided but not defined"},
+		{"extra argument", []string{"-store", "memory", "extra"}, "", "unexpected arguments: extra"},
+		{"bad int", []string{"-max-attempts", "many"}, "", "invalid value"},
+		{"bad duration", []string{"-token-ttl", "1 day"}, "", "invalid value"},
+		{"missing file", []string{"-config", "/nonexistent/gibber.json"}, "", "reading config file"},
+		{"unknown field", nil, `{"server": {"hots": "localhost"}}`, "unknown field"},
+		{"bad file duration", nil, `{"timeouts": {"shutdown": 10}}`, "duration should be a string"},
+		{"bad port", []string{"-store", "memory", "-port", "70000"}, "", "port should be a port number"},
+		{"same ports", []string{"-store", "memory", "-api-port", "7000"}, "", "used by more than one listener"},
+		{"cert without key", []string{"-store", "memory", "-tls-cert", "cert.pem"}, "", "to be set together"},
+		{"client ca without cert", []string{"-store", "memory", "-tls-client-ca", "ca.pem"}, "",
+			"needs tls-cert and tls-key"},
+		{"mongo without host", nil, "", "mongo-host is needed"},
+		{"unknown store", []string{"-store", "redis"}, "", "unknown store backend"},
+		{"bad log level", []string{"-store", "memory", "-log-level", "verbose"}, "", "unknown log level"},
+		{"bad log format", []string{"-store", "memory", "-log-format", "xml"}, "", "unknown log format"},
+		{"no attempts", []string{"-store", "memory", "-max-attempts", "0"}, "", "max-attempts should be at least 1"},
+		{"no password", []string{"-store", "memory", "-password-min-length", "0"}, "",
+			"password-min-length should be at least 1"},
+		{"no shutdown", []string{"-store", "memory", "-shutdown-timeout", "0s"}, "", "shutdown-timeout should be"},
+		{"no token ttl", []string{"-store", "memory", "-token-ttl", "-1h"}, "", "token-ttl should be"},
+		{"bad smtp port", []string{"-store", "memory", "-smtp-host", "smtp.doe.com", "-smtp-port", "mail"}, "",
+			"smtp-port should be a port number"},
+		{"no mail file", []string{"-store", "memory", "-mail-file", ""}, "", "mail-file is needed"},
+		{"no reset token ttl", []string{"-store", "memory", "-reset-token-ttl", "0s"}, "", "reset-token-ttl should be"},
+		{"no verification ttl", []string{"-store", "memory", "-verification-ttl", "0s"}, "",
+			"verification-ttl should be"},
+		{"no session ttl", []string{"-store", "memory", "-session-ttl", "0s"}, "", "session-ttl should be"},
+		{"no lockout failures", []string{"-store", "memory", "-ip-lockout-failures", "0"}, "",
+			"ip-lockout-failures should be at least 1"},
+		{"no lockout", []string{"-store", "memory", "-lockout", "0s"}, "", "lockout should be positive"},
+		{"negative backoff", []string{"-store", "memory", "-login-backoff", "-1s"}, "", "can't be negative"},
+		{"no read header timeout", []string{"-store", "memory", "-read-header-timeout", "0s"}, "",
+			"read-header-timeout should be positive"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			args := test.args
+			if test.file != "" {
+				args = append(args, "-config", writeConfigFile(t, test.file))
+			}
+			_, err := Load(args)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), test.err)
+			}
+		})
+	}
+}
+
+func TestLoad_Help(t *testing.T) {
+	unsetEnv(t)
+	_, err := Load([]string{"-h"})
+	assert.Equal(t, flag.ErrHelp, err)
+}
+
+func TestLoad_Unlock(t *testing.T) {
+	unsetEnv(t)
+	c, err := Load([]string{"-store", memoryStore, "-unlock", "John@Doe.com"})
+	assert.Equal(t, ErrUnlockAccount, err)
+	assert.Equal(t, "john@doe.com", c.UnlockEmail)
+
+	c, err = Load([]string{"-store", memoryStore})
+	assert.NoError(t, err)
+	assert.Empty(t, c.UnlockEmail, "the server is started")
+}
+
+func TestConfig_Mailer(t *testing.T) {
+	c := Default()
+	assert.IsType(t, &mail.FileMailer{}, c.Mailer(), "the emails are written to the file without an SMTP server")
+	c.Mail.SMTPHost = "smtp.doe.com"
+	assert.IsType(t, &mail.SMTPMailer{}, c.Mailer())
+}
+
+func TestLoad_PrintConfig(t *testing.T) {
+	unsetEnv(t)
+	setEnv(t, "GIBBER_MONGO_HOST", "cluster.mongo")
+	setEnv(t, "GIBBER_MONGO_PWD", "secret")
+	setEnv(t, "GIBBER_SMTP_PWD", "secret")
+	c, err := Load([]string{"-print-config"})
+	assert.Equal(t, ErrPrintConfig, err)
+	assert.Equal(t, "secret", c.Store.Mongo.Password, "only the printed password is masked")
+
+	var out bytes.Buffer
+	assert.NoError(t, c.Print(&out))
+	printed := out.String()
+	assert.False(t, strings.Contains(printed, "secret"), "password printed: %s", printed)
+	assert.Contains(t, printed, `"password": "`+masked+`"`)
+	assert.Contains(t, printed, `"host": "cluster.mongo"`)
+	assert.Contains(t, printed, `"shutdown": "10s"`)
+
+	// the printed configuration is a valid config file
+	path := writeConfigFile(t, printed)
+	unsetEnv(t)
+	loaded, err := Load([]string{"-config", path})
+	assert.NoError(t, err)
+	assert.Equal(t, "cluster.mongo", loaded.Store.Mongo.Host)
+	assert.Equal(t, c.Timeouts, loaded.Timeouts)
+}
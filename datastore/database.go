@@ -20,3 +20,8 @@ type DatabaseUpdater interface {
 type DatabaseFinder interface {
 	FindOne(ctx context.Context, filter interface{}, opts ...*options.FindOneOptions) *mongo.SingleResult
 }
+
+// DatabaseLister fetches all the documents from the persistent store matching the given filters
+type DatabaseLister interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+}
@@ -13,9 +13,20 @@ import (
 
 // mongodb query operators
 const (
-	MongoSetOperator  = "$set"
-	MongoPushOperator = "$push"
-	MongoPullOperator = "$pull"
+	MongoSetOperator         = "$set"
+	MongoSetOnInsertOperator = "$setOnInsert"
+	MongoUnsetOperator       = "$unset"
+	MongoPushOperator        = "$push"
+	MongoPullOperator        = "$pull"
+	MongoAddToSetOperator    = "$addToSet"
+	MongoMaxOperator         = "$max"
+	MongoExistsOperator      = "$exists"
+	MongoGtOperator          = "$gt"
+	MongoGteOperator         = "$gte"
+	MongoLtOperator          = "$lt"
+	MongoNeOperator          = "$ne"
+	MongoNinOperator         = "$nin"
+	MongoOrOperator          = "$or"
 )
 
 // various collections to be used by the service, which need to be initialized
@@ -32,47 +43,67 @@ const (
 )
 
 // ErrNoDocUpdate is an error raised when update operation results in no document being updated
+// ErrNoDocFound is an error raised when a lookup matches no document in the store
 var (
 	ErrNoDocUpdate = errors.New("no document updated")
+	ErrNoDocFound  = errors.New("no document found")
 )
 
+// MongoConfig is the mongo instance to connect to, along with the connection options
+type MongoConfig struct {
+	Host     string `json:"host"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Database string `json:"database"`
+	Options  string `json:"options"`
+}
+
 // instead of a generic client, return the target DB handler, to avoid selecting it again and again in each query
+// The connection is only established on the first call to MongoConn, so that the service can run without mongo
+// when some other store is used
 var mongoConn *mongo.Database
 var initMongoConn sync.Once
 
-func init() {
-	initCollections()
+// mongoConfig is the mongo instance to connect to, taken from the GIBBER_MONGO_* env variables unless set
+var mongoConfig *MongoConfig
+
+// SetMongoConfig sets the mongo instance to connect to. It is meant to be called once at the startup, before
+// the connection is established.
+func SetMongoConfig(config MongoConfig) {
+	mongoConfig = &config
 }
 
 // initMongoConnPool initializes a new client, and set the target database handler
 func initMongoConnPool() {
-
-	// ENV params to get details about mongo instance to connect, along with other connection args
-	var (
-		mongoHost     = os.Getenv("GIBBER_MONGO_HOST")
-		mongoUser     = os.Getenv("GIBBER_MONGO_USER")
-		mongoPwd      = os.Getenv("GIBBER_MONGO_PWD")
-		mongoDatabase = os.Getenv("GIBBER_MONGO_DB")
-		mongoOptions  = os.Getenv("GIBBER_MONGO_OPTS")
-	)
+	config := mongoConfig
+	if config == nil {
+		config = &MongoConfig{
+			Host:     os.Getenv("GIBBER_MONGO_HOST"),
+			User:     os.Getenv("GIBBER_MONGO_USER"),
+			Password: os.Getenv("GIBBER_MONGO_PWD"),
+			Database: os.Getenv("GIBBER_MONGO_DB"),
+			Options:  os.Getenv("GIBBER_MONGO_OPTS"),
+		}
+	}
 
 	addressURL := "mongodb+srv://"
-	if mongoUser != "" {
-		addressURL += mongoUser + ":" + mongoPwd + "@"
+	if config.User != "" {
+		addressURL += config.User + ":" + config.Password + "@"
 	}
-	addressURL += mongoHost
-	addressURL += "/" + mongoDatabase
-	if mongoOptions != "" {
-		addressURL += "?" + mongoOptions
+	addressURL += config.Host
+	addressURL += "/" + config.Database
+	if config.Options != "" {
+		addressURL += "?" + config.Options
 	}
 	opts := options.Client().ApplyURI(addressURL)
 	client, err := mongo.Connect(context.Background(), opts)
 	if err != nil {
-		log.Logger().Fatalf("create mongo connection on %s pool failed: %s", addressURL, err)
+		log.Logger().Fatalf("create mongo connection on %s pool failed: %s", config.Host, err)
 	} else {
-		log.Logger().Printf("mongo successfully connected on %s", addressURL)
+		log.Logger().Infof("mongo successfully connected on %s", config.Host)
 	}
 	mongoConn = client.Database("gibber")
+	initCollections()
 }
 
 // MongoConn returns database handler instance of mongo for target database
@@ -83,7 +114,6 @@ func MongoConn() *mongo.Database {
 
 // initCollections create the document collections (if non-existent) to be utilized by the service.
 func initCollections() {
-	mongoConn := MongoConn()
 	collections := []string{
 		UserCollection,
 		UserInvitesCollection,
@@ -93,12 +123,12 @@ func initCollections() {
 	for _, coll := range collections {
 		count, err := mongoConn.Collection(coll).CountDocuments(context.Background(), bson.D{})
 		if err != nil {
-			log.Logger().Printf("%s count fetch failed: %s", coll, err)
+			log.Logger().Errorf("%s count fetch failed: %s", coll, err)
 		}
 		if count == 0 {
 			_, err = mongoConn.Collection(coll).InsertOne(context.Background(), bson.D{})
 			if err != nil {
-				log.Logger().Printf("%s collection creation failed: %s", coll, err)
+				log.Logger().Errorf("%s collection creation failed: %s", coll, err)
 			}
 		}
 	}
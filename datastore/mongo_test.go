@@ -2,10 +2,14 @@ package datastore
 
 import (
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 )
 
 func TestMongoConn(t *testing.T) {
+	if os.Getenv("GIBBER_MONGO_HOST") == "" {
+		t.Skip("GIBBER_MONGO_HOST not set, skipping live mongo test")
+	}
 	conn := MongoConn()
 	assert.NotNil(t, conn, "mongo connection is nil")
 }
@@ -1,55 +1,144 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	repo = "gibber/"
+	fileName = "debug.log"
+	prefix   = "Gibber::Server	"
 )
 
+// Stdout as the log file writes the logs to the standard output
+const Stdout = "stdout"
+
+// formats in which the log entries are written
 const (
-	fileName = "debug.log"
-	prefix   = "Gibber::Server	"
+	TextFormat = "text" // prefix, time, level, caller and message, followed by the fields as key=value
+	JSONFormat = "json" // one object per line
 )
 
+// Level is the severity of a log entry, the entries below the configured level being discarded
+type Level int
+
+// log levels, from the most verbose
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error"}
+
+// String gives the name of the level
+func (l Level) String() string {
+	if l < DebugLevel || l > ErrorLevel {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel gives the level of the given name (debug, info, warn or error)
+func ParseLevel(name string) (Level, error) {
+	for l, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(l), nil
+		}
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", name)
+}
+
+// Options are where and how the logs are written
+type Options struct {
+	Level      Level
+	Format     string // TextFormat (default) or JSONFormat
+	File       string // generated/debug.log in the project root if empty, or Stdout
+	MaxSize    int64  // size in bytes after which the file is rotated, never rotated if 0
+	MaxBackups int    // rotated files kept, as <file>.1 (the latest) to <file>.<MaxBackups>
+}
+
+// options of the logs, these defaults unless set at the startup
+var options = Options{Level: InfoLevel, Format: TextFormat}
+
 // for transaction requests logging
-var logWriter io.Writer
-var logger *log.Logger
+var logger *Log
 var logInit sync.Once
 
+// SetOptions sets where and how the logs are written. It is meant to be called once at the startup, before
+// anything is logged.
+func SetOptions(o Options) {
+	options = o
+}
+
+// Log writes leveled log entries, each tagged with the key/value fields of the logger
+type Log struct {
+	sink   *sink
+	fields []field
+}
+
+// field is a key/value pair tagging a log entry
+type field struct {
+	key   string
+	value interface{}
+}
+
+// sink is where the entries of a logger, and of those derived from it, are written
+type sink struct {
+	mu     sync.Mutex
+	writer io.Writer
+	level  Level
+	json   bool
+}
+
+// newLogger gives a logger writing the entries of the given level or above to the given writer
+func newLogger(w io.Writer, level Level, format string) *Log {
+	return &Log{sink: &sink{writer: w, level: level, json: format == JSONFormat}}
+}
+
 // initLogger initialize the logger to start appending on the log file
 // It creates the logfile if non-existent
 func initLogger() (err error) {
-	projectRootPath := projectRootPath()
-	logDir := projectRootPath + "generated/"
+	if options.Format != "" && options.Format != TextFormat && options.Format != JSONFormat {
+		return fmt.Errorf("unknown log format %q", options.Format)
+	}
+	if options.File == Stdout {
+		logger = newLogger(os.Stdout, options.Level, options.Format)
+		return
+	}
+	txnLogFile := options.File
+	if txnLogFile == "" {
+		txnLogFile = projectRootPath() + "generated/" + fileName
+	}
+	logDir := filepath.Dir(txnLogFile)
 	if _, err = os.Stat(logDir); os.IsNotExist(err) {
-		err = os.Mkdir(logDir, 0755)
+		err = os.MkdirAll(logDir, 0755)
 		if err != nil {
 			err = fmt.Errorf("error creating log directory %s: %s", logDir, err)
 			return
 		}
 	}
-	txnLogFile := logDir + fileName
-	file, err := os.OpenFile(txnLogFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	file, err := openRotatingFile(txnLogFile, options.MaxSize, options.MaxBackups)
 	if err != nil {
 		err = fmt.Errorf("opening log file failed: %s", err)
 		return
 	}
-	logWriter = io.MultiWriter(file)
-	logger = log.New(logWriter, prefix, log.Ldate|log.Ltime|log.Lmicroseconds|log.LUTC|log.Lshortfile)
+	logger = newLogger(file, options.Level, options.Format)
 	return
 }
 
 // Logger gives the logger instance to enable logging events
-func Logger() *log.Logger {
+func Logger() *Log {
 	logInit.Do(func() {
 		if err := initLogger(); err != nil {
 			panic(fmt.Sprintf("error while initializing internal logger: %s", err))
@@ -58,8 +147,259 @@ func Logger() *log.Logger {
 	return logger
 }
 
+// With gives a logger tagging its entries with the given key/value pairs, along with the fields of this one
+func (l *Log) With(keyValues ...interface{}) *Log {
+	fields := make([]field, len(l.fields), len(l.fields)+len(keyValues)/2+1)
+	copy(fields, l.fields)
+	return &Log{sink: l.sink, fields: appendFields(fields, keyValues)}
+}
+
+// Debug logs the message at debug level, tagged with the given key/value pairs
+func (l *Log) Debug(msg string, keyValues ...interface{}) {
+	l.log(DebugLevel, msg, keyValues)
+}
+
+// Info logs the message at info level, tagged with the given key/value pairs
+func (l *Log) Info(msg string, keyValues ...interface{}) {
+	l.log(InfoLevel, msg, keyValues)
+}
+
+// Warn logs the message at warn level, tagged with the given key/value pairs
+func (l *Log) Warn(msg string, keyValues ...interface{}) {
+	l.log(WarnLevel, msg, keyValues)
+}
+
+// Error logs the message at error level, tagged with the given key/value pairs
+func (l *Log) Error(msg string, keyValues ...interface{}) {
+	l.log(ErrorLevel, msg, keyValues)
+}
+
+// Debugf logs the formatted message at debug level
+func (l *Log) Debugf(format string, args ...interface{}) {
+	l.logf(DebugLevel, format, args)
+}
+
+// Infof logs the formatted message at info level
+func (l *Log) Infof(format string, args ...interface{}) {
+	l.logf(InfoLevel, format, args)
+}
+
+// Warnf logs the formatted message at warn level
+func (l *Log) Warnf(format string, args ...interface{}) {
+	l.logf(WarnLevel, format, args)
+}
+
+// Errorf logs the formatted message at error level
+func (l *Log) Errorf(format string, args ...interface{}) {
+	l.logf(ErrorLevel, format, args)
+}
+
+// Fatalf logs the formatted message at error level, and exits the program
+func (l *Log) Fatalf(format string, args ...interface{}) {
+	l.logf(ErrorLevel, format, args)
+	os.Exit(1)
+}
+
+// Enabled tells whether the entries of the given level are written
+func (l *Log) Enabled(level Level) bool {
+	return level >= l.sink.level
+}
+
+// Writer gives the writer the entries are written to
+func (l *Log) Writer() io.Writer {
+	return l.sink.writer
+}
+
+// Prefix gives the prefix of the entries written as text
+func (l *Log) Prefix() string {
+	return prefix
+}
+
+// callerDepth is the number of frames between the caller of a logging method and entry
+const callerDepth = 4
+
+// logf logs the formatted message, if its level is enabled
+func (l *Log) logf(level Level, format string, args []interface{}) {
+	if l.Enabled(level) {
+		l.entry(level, fmt.Sprintf(format, args...), nil)
+	}
+}
+
+// log logs the message along with the given key/value pairs, if its level is enabled
+func (l *Log) log(level Level, msg string, keyValues []interface{}) {
+	if l.Enabled(level) {
+		l.entry(level, msg, keyValues)
+	}
+}
+
+// entry writes an entry, tagged with the fields of the logger followed by the given key/value pairs
+func (l *Log) entry(level Level, msg string, keyValues []interface{}) {
+	fields := l.fields
+	if len(keyValues) > 0 {
+		fields = appendFields(append([]field(nil), l.fields...), keyValues)
+	}
+	caller := "???:0"
+	if _, file, line, ok := runtime.Caller(callerDepth - 1); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	now := time.Now().UTC()
+
+	var buf bytes.Buffer
+	if l.sink.json {
+		writeJSONEntry(&buf, now, level, caller, msg, fields)
+	} else {
+		writeTextEntry(&buf, now, level, caller, msg, fields)
+	}
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	_, _ = l.sink.writer.Write(buf.Bytes())
+}
+
+// writeTextEntry writes an entry as a line of text
+func writeTextEntry(buf *bytes.Buffer, now time.Time, level Level, caller, msg string, fields []field) {
+	buf.WriteString(prefix)
+	buf.WriteString(now.Format("2006-01-02T15:04:05.000000Z"))
+	buf.WriteString(" " + strings.ToUpper(level.String()) + " " + caller + ": ")
+	buf.WriteString(strings.TrimRight(msg, "\n"))
+	for _, f := range fields {
+		buf.WriteString(" " + f.key + "=")
+		value := fmt.Sprint(textValue(f.value))
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+}
+
+// writeJSONEntry writes an entry as a JSON object on a line, the fields following the time, level, caller and
+// message
+func writeJSONEntry(buf *bytes.Buffer, now time.Time, level Level, caller, msg string, fields []field) {
+	buf.WriteString(`{"time":`)
+	writeJSONValue(buf, now.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSONValue(buf, level.String())
+	buf.WriteString(`,"caller":`)
+	writeJSONValue(buf, caller)
+	buf.WriteString(`,"msg":`)
+	writeJSONValue(buf, strings.TrimRight(msg, "\n"))
+	for _, f := range fields {
+		buf.WriteByte(',')
+		writeJSONValue(buf, f.key)
+		buf.WriteByte(':')
+		writeJSONValue(buf, f.value)
+	}
+	buf.WriteString("}\n")
+}
+
+// writeJSONValue writes the value in JSON, errors as their message and anything not encodable as a string
+func writeJSONValue(buf *bytes.Buffer, value interface{}) {
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(data)
+}
+
+// textValue gives the value to be written as text, the hex of the IDs rather than their Go syntax
+func textValue(value interface{}) interface{} {
+	if id, ok := value.(interface{ Hex() string }); ok {
+		return id.Hex()
+	}
+	return value
+}
+
+// appendFields appends the given key/value pairs to the fields. A value without key is appended as "extra".
+func appendFields(fields []field, keyValues []interface{}) []field {
+	for i := 0; i < len(keyValues); i += 2 {
+		if i+1 == len(keyValues) {
+			fields = append(fields, field{"extra", keyValues[i]})
+			break
+		}
+		fields = append(fields, field{fmt.Sprint(keyValues[i]), keyValues[i+1]})
+	}
+	return fields
+}
+
+// rotatingFile is a log file appended to, rotated once it reaches its maximum size
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// openRotatingFile opens the given file to be appended to, rotated after maxSize bytes (never if 0) keeping
+// maxBackups rotated files
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file, creating it if non-existent
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends to the file, rotating it first if the data would take it beyond its maximum size. It is not
+// safe for concurrent use, the sink serializing the writes.
+func (f *rotatingFile) Write(p []byte) (n int, err error) {
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err = f.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = f.file.Write(p)
+	f.size += int64(n)
+	return
+}
+
+// rotate renames the file to <file>.1, shifting the older ones up to <file>.<maxBackups>, and opens a new one
+func (f *rotatingFile) rotate() (err error) {
+	if err = f.file.Close(); err != nil {
+		return
+	}
+	if f.maxBackups < 1 {
+		err = os.Remove(f.path)
+	} else {
+		for i := f.maxBackups - 1; i > 0; i-- {
+			err = os.Rename(f.backup(i), f.backup(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return
+			}
+		}
+		err = os.Rename(f.path, f.backup(1))
+	}
+	if err != nil {
+		return
+	}
+	return f.open()
+}
+
+// backup gives the path of the ith rotated file
+func (f *rotatingFile) backup(i int) string {
+	return f.path + "." + strconv.Itoa(i)
+}
+
+// projectRootPath gives the path to the project root, which is the parent of this package directory
 func projectRootPath() (path string) {
 	_, fileStr, _, _ := runtime.Caller(0)
-	rootPath := strings.Split(filepath.Dir(fileStr), repo)
-	return rootPath[0] + repo
+	return filepath.Dir(filepath.Dir(fileStr)) + string(filepath.Separator)
 }
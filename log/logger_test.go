@@ -1,7 +1,14 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -10,4 +17,75 @@ func TestLogger(t *testing.T) {
 	assert.NotNil(t, lg, "logger initialization failed")
 	assert.NotNil(t, lg.Writer(), "logger writer initialization failed")
 	assert.True(t, len(lg.Prefix()) > 0, "logger prefix initialization failed")
-}
\ No newline at end of file
+}
+func TestLog_Levels(t *testing.T) {
+	var out bytes.Buffer
+	lg := newLogger(&out, WarnLevel, TextFormat)
+	lg.Debugf("debug %d", 1)
+	lg.Info("info")
+	lg.Warnf("warn %d", 3)
+	lg.Error("error")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines), "entries below warn written: %s", out.String())
+	assert.Contains(t, lines[0], " WARN logger_test.go:")
+	assert.True(t, strings.HasSuffix(lines[0], ": warn 3"), "unexpected entry: %s", lines[0])
+	assert.Contains(t, lines[1], " ERROR ")
+	assert.True(t, strings.HasPrefix(lines[1], prefix))
+}
+
+func TestLog_Fields(t *testing.T) {
+	var out bytes.Buffer
+	lg := newLogger(&out, DebugLevel, TextFormat).With("remote", "127.0.0.1:1234", "session", "ab12")
+	lg.With("user", "u1").Info("logged in", "attempt", 2, "note", "with space", "odd")
+	lg.Info("no user")
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.True(t, strings.HasSuffix(lines[0],
+		`logged in remote=127.0.0.1:1234 session=ab12 user=u1 attempt=2 note="with space" extra=odd`),
+		"unexpected entry: %s", lines[0])
+	assert.True(t, strings.HasSuffix(lines[1], "no user remote=127.0.0.1:1234 session=ab12"),
+		"fields of a derived logger leaked: %s", lines[1])
+}
+
+func TestLog_JSON(t *testing.T) {
+	var out bytes.Buffer
+	lg := newLogger(&out, InfoLevel, JSONFormat).With("session", "ab12")
+	lg.Error("request failed", "error", errors.New("boom"), "status", 500)
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &entry), "invalid JSON entry: %s", out.String())
+	assert.Equal(t, "error", entry["level"])
+	assert.Equal(t, "request failed", entry["msg"])
+	assert.Equal(t, "ab12", entry["session"])
+	assert.Equal(t, "boom", entry["error"])
+	assert.Equal(t, float64(500), entry["status"])
+	assert.Contains(t, entry["caller"], "logger_test.go:")
+	assert.NotEmpty(t, entry["time"])
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, WarnLevel, level)
+	_, err = ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gibber-log")
+	assert.NoError(t, err, "creating temp dir failed")
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "gibber.log")
+
+	file, err := openRotatingFile(path, 10, 2)
+	assert.NoError(t, err, "opening log file failed")
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = file.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	for name, content := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		data, err := ioutil.ReadFile(name)
+		assert.NoError(t, err, "reading %s failed", name)
+		assert.Equal(t, content, string(data), "unexpected content of %s", name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "more backups kept than asked for")
+}
@@ -0,0 +1,150 @@
+// Package mail sends the emails of the server (e.g. the password reset tokens) through a pluggable Mailer
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errHeaderInjection tells that a header of the message spans more than a line
+var errHeaderInjection = errors.New("mail header can't hold a line break")
+
+// Message is an email sent to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends the emails
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format gives the message as written on the wire, from the given sender, its body lines ending with CRLF
+func (m Message) format(from string) ([]byte, error) {
+	for _, header := range []string{from, m.To, m.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, errHeaderInjection
+		}
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n")
+	body := strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n")
+	buf.WriteString(body)
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
+
+// SMTPMailer sends the emails through an SMTP server, upgrading the connection to TLS when the server offers it
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth // nil if the server needs no authentication
+}
+
+// NewSMTPMailer gives a mailer sending the emails from the given address through the SMTP server at the given
+// host and port, authenticated with the given credentials unless the username is empty
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: net.JoinHostPort(host, port), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send sends the message through the SMTP server
+func (m *SMTPMailer) Send(msg Message) error {
+	data, err := msg.format(m.from)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, data)
+}
+
+// MemoryMailer keeps the emails in memory instead of sending them, for the tests
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryMailer gives a mailer having sent nothing yet
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send keeps the message
+func (m *MemoryMailer) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages gives the messages sent so far, in the order they were sent
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append(make([]Message, 0, len(m.messages)), m.messages...)
+}
+
+// Last gives the latest message sent to the given recipient, if any
+func (m *MemoryMailer) Last(to string) (msg Message, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+	return
+}
+
+// FileMailer appends the emails to a file instead of sending them, for local development
+type FileMailer struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+// NewFileMailer gives a mailer appending the emails from the given address to the file at the given path, created
+// along with its directory if non-existent
+func NewFileMailer(path, from string) *FileMailer {
+	return &FileMailer{path: path, from: from}
+}
+
+// Send appends the message to the file, followed by a blank line
+func (m *FileMailer) Send(msg Message) (err error) {
+	data, err := msg.format(m.from)
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err = os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+		return
+	}
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if er := f.Close(); err == nil {
+			err = er
+		}
+	}()
+	_, err = f.Write(append(data, '\r', '\n'))
+	return
+}
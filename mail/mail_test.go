@@ -0,0 +1,113 @@
+package mail
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMessage_Format(t *testing.T) {
+	data, err := Message{To: "jane@doe.com", Subject: "Hello", Body: "line 1\nline 2"}.format("gibber@doe.com")
+	assert.NoError(t, err)
+	formatted := string(data)
+	assert.True(t, strings.HasPrefix(formatted, "From: gibber@doe.com\r\nTo: jane@doe.com\r\nSubject: Hello\r\n"),
+		"unexpected headers: %q", formatted)
+	assert.True(t, strings.HasSuffix(formatted, "\r\n\r\nline 1\r\nline 2\r\n"), "unexpected body: %q", formatted)
+
+	_, err = Message{To: "jane@doe.com\r\nBcc: john@doe.com", Subject: "Hello"}.format("gibber@doe.com")
+	assert.Equal(t, errHeaderInjection, err)
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	_, ok := m.Last("jane@doe.com")
+	assert.False(t, ok)
+	assert.NoError(t, m.Send(Message{To: "jane@doe.com", Subject: "first"}))
+	assert.NoError(t, m.Send(Message{To: "john@doe.com", Subject: "other"}))
+	assert.NoError(t, m.Send(Message{To: "jane@doe.com", Subject: "second"}))
+	assert.Len(t, m.Messages(), 3)
+	last, ok := m.Last("jane@doe.com")
+	assert.True(t, ok)
+	assert.Equal(t, "second", last.Subject)
+}
+
+func TestFileMailer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gibber-mail")
+	assert.NoError(t, err, "creating temp dir failed")
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "outbox", "mail.log")
+
+	m := NewFileMailer(path, "gibber@doe.com")
+	assert.NoError(t, m.Send(Message{To: "jane@doe.com", Subject: "first", Body: "token 123"}))
+	assert.NoError(t, m.Send(Message{To: "john@doe.com", Subject: "second", Body: "token 456"}))
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err, "reading mail file failed")
+	written := string(data)
+	assert.Contains(t, written, "To: jane@doe.com\r\n")
+	assert.Contains(t, written, "token 123")
+	assert.True(t, strings.Index(written, "token 123") < strings.Index(written, "token 456"), "appended in order")
+}
+
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "listening failed")
+	defer func() { _ = listener.Close() }()
+	received := make(chan string, 1)
+	go serveSMTP(listener, received)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	m := NewSMTPMailer(host, port, "", "", "gibber@doe.com")
+	assert.NoError(t, m.Send(Message{To: "jane@doe.com", Subject: "Reset", Body: "token 123"}))
+	data := <-received
+	assert.Contains(t, data, "To: jane@doe.com\r\n")
+	assert.Contains(t, data, "Subject: Reset\r\n")
+	assert.Contains(t, data, "token 123")
+}
+
+// serveSMTP serves a single SMTP session on the listener, just enough for a message to be sent, passing on the
+// data received
+func serveSMTP(listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ready")
+	var data strings.Builder
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if inData {
+			if line == ".\r\n" {
+				inData = false
+				received <- data.String()
+				reply("250 OK")
+			} else {
+				data.WriteString(line)
+			}
+			continue
+		}
+		switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			inData = true
+			reply("354 go ahead")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
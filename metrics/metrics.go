@@ -0,0 +1,378 @@
+// Package metrics collects the statistics of the server, exposed over HTTP in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric types, as written in the Prometheus text format
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// LatencyBuckets are the upper bounds (in seconds) of the histogram buckets for latencies, from a
+// sub-millisecond datastore operation to a message delivered after seconds
+var LatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds the metrics to be exposed, in the order they are registered
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is a metric family, made of a series per combination of its label values
+type metric interface {
+	describe() (name, help, kind string)
+	write(w io.Writer) error
+}
+
+// Default is the registry to which the metrics of all the packages are registered
+var Default = NewRegistry()
+
+// NewRegistry gives a registry with no metric
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds the metric to the registry, panicking if its name is already taken as it is a programming error
+func (r *Registry) register(m metric) {
+	name, _, _ := m.describe()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// Expose writes all the metrics in the Prometheus text format
+func (r *Registry) Expose(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		name, help, kind := m.describe()
+		if _, err := fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind); err != nil {
+			return err
+		}
+		if err := m.write(bw); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler gives the HTTP handler exposing the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = r.Expose(w)
+	})
+}
+
+// desc describes a metric family
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// describeAs gives the name, help and given type of the metric family
+func (d *desc) describeAs(kind string) (string, string, string) {
+	return d.name, d.help, kind
+}
+
+// key joins the label values of a series, checking that they match the labels of the family
+func (d *desc) key(labelValues []string) string {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", d.name, d.labels, labelValues))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+// series writes the name of a series along with its labels (and the extra ones, e.g. the bucket bound)
+func (d *desc) series(suffix, key string, extra ...string) string {
+	var pairs []string
+	if len(d.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, d.labels[i]+`="`+escapeLabel(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return d.name + suffix
+	}
+	return d.name + suffix + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a metric family only going up, e.g. the logins
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given labels in the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{desc: desc{name, help, labels}, values: make(map[string]float64)}
+	if len(labels) == 0 {
+		c.values[""] = 0 // a counter without labels is exposed before being incremented
+	}
+	Default.register(c)
+	return c
+}
+
+// Inc increments the counter of the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the given (non-negative) value to the counter of the given label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += value
+}
+
+// Value gives the counter of the given label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) describe() (string, string, string) {
+	return c.describeAs(counterType)
+}
+
+func (c *Counter) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeValues(w, &c.desc, c.values)
+}
+
+// Gauge is a metric family going up and down, e.g. the active connections
+type Gauge struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGauge registers a gauge with the given labels in the default registry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{desc: desc{name, help, labels}, values: make(map[string]float64)}
+	if len(labels) == 0 {
+		g.values[""] = 0
+	}
+	Default.register(g)
+	return g
+}
+
+// Add adds the given value (negative to decrease) to the gauge of the given label values
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[key] += value
+}
+
+// Set sets the gauge of the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[key] = value
+}
+
+// Value gives the gauge of the given label values
+func (g *Gauge) Value(labelValues ...string) float64 {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[key]
+}
+
+func (g *Gauge) describe() (string, string, string) {
+	return g.describeAs(gaugeType)
+}
+
+func (g *Gauge) write(w io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return writeValues(w, &g.desc, g.values)
+}
+
+// gaugeFunc is a gauge without labels, its value taken from a function when exposed
+type gaugeFunc struct {
+	desc
+	value func() float64
+}
+
+// NewGaugeFunc registers a gauge in the default registry, its value given by the function whenever exposed
+func NewGaugeFunc(name, help string, value func() float64) {
+	Default.register(&gaugeFunc{desc: desc{name: name, help: help}, value: value})
+}
+
+func (g *gaugeFunc) describe() (string, string, string) {
+	return g.describeAs(gaugeType)
+}
+
+func (g *gaugeFunc) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", g.name, formatValue(g.value()))
+	return err
+}
+
+// Histogram is a metric family counting the observations in buckets, e.g. the latencies
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+// histogramValue is the histogram of a series
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the given buckets (sorted upper bounds) and labels in the default
+// registry
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name, help, labels}, buckets: buckets, values: make(map[string]*histogramValue)}
+	Default.register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram of the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v := h.values[key]
+	if v == nil {
+		v = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = v
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		v.counts[i]++
+	}
+	v.sum += value
+	v.count++
+}
+
+// ObserveSince adds the time elapsed since the given start, in seconds, to the histogram of the given label values
+func (h *Histogram) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count gives the number of observations in the histogram of the given label values
+func (h *Histogram) Count(labelValues ...string) uint64 {
+	key := h.key(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if v := h.values[key]; v != nil {
+		return v.count
+	}
+	return 0
+}
+
+func (h *Histogram) describe() (string, string, string) {
+	return h.describeAs(histogramType)
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.values) {
+		v := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += v.counts[i]
+			if _, err := fmt.Fprintf(w, "%s %d\n", h.series("_bucket", key, "le", formatValue(bound)),
+				cumulative); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s %d\n%s %s\n%s %d\n", h.series("_bucket", key, "le", "+Inf"), v.count,
+			h.series("_sum", key), formatValue(v.sum), h.series("_count", key), v.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeValues writes a series per label values, sorted for the output to be stable
+func writeValues(w io.Writer, d *desc, values map[string]float64) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(w, "%s %s\n", d.series("", key), formatValue(values[key])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedKeys gives the keys of the histogram series, sorted for the output to be stable
+func sortedKeys(values map[string]*histogramValue) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatValue writes a value as expected by Prometheus
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exposed gives the exposition of the default registry
+func exposed(t *testing.T) string {
+	var out bytes.Buffer
+	assert.NoError(t, Default.Expose(&out), "exposing metrics failed")
+	return out.String()
+}
+
+func TestCounter(t *testing.T) {
+	plain := NewCounter("test_plain_total", "Plain counter.")
+	logins := NewCounter("test_logins_total", "Logins by result.", "result")
+	assert.Contains(t, exposed(t), "# HELP test_plain_total Plain counter.\n# TYPE test_plain_total counter\n"+
+		"test_plain_total 0\n", "counter without labels exposed from the start")
+
+	plain.Inc()
+	logins.Inc("success")
+	logins.Add(2, "failure")
+	logins.Inc(`with "quotes"`)
+	assert.Equal(t, float64(2), logins.Value("failure"))
+	out := exposed(t)
+	assert.Contains(t, out, "test_plain_total 1\n")
+	assert.Contains(t, out, "# TYPE test_logins_total counter\n"+
+		"test_logins_total{result=\"failure\"} 2\n"+
+		"test_logins_total{result=\"success\"} 1\n"+
+		"test_logins_total{result=\"with \\\"quotes\\\"\"} 1\n", "series sorted by label values")
+	assert.Panics(t, func() { logins.Inc() }, "label values not matching the labels")
+	assert.Panics(t, func() { NewCounter("test_plain_total", "Again.") }, "name registered twice")
+}
+
+func TestGauge(t *testing.T) {
+	gauge := NewGauge("test_connections", "Connections.")
+	gauge.Add(3)
+	gauge.Add(-1)
+	assert.Equal(t, float64(2), gauge.Value())
+	NewGaugeFunc("test_online", "Online.", func() float64 { return 7 })
+	out := exposed(t)
+	assert.Contains(t, out, "# TYPE test_connections gauge\ntest_connections 2\n")
+	assert.Contains(t, out, "# TYPE test_online gauge\ntest_online 7\n")
+}
+
+func TestHistogram(t *testing.T) {
+	latency := NewHistogram("test_latency_seconds", "Latency.", []float64{.1, 1}, "operation")
+	latency.Observe(.05, "find")
+	latency.Observe(.5, "find")
+	latency.Observe(5, "find")
+	latency.ObserveSince(time.Now(), "insert")
+	assert.Equal(t, uint64(3), latency.Count("find"))
+	assert.Contains(t, exposed(t), "# TYPE test_latency_seconds histogram\n"+
+		"test_latency_seconds_bucket{operation=\"find\",le=\"0.1\"} 1\n"+
+		"test_latency_seconds_bucket{operation=\"find\",le=\"1\"} 2\n"+
+		"test_latency_seconds_bucket{operation=\"find\",le=\"+Inf\"} 3\n"+
+		"test_latency_seconds_sum{operation=\"find\"} 5.55\n"+
+		"test_latency_seconds_count{operation=\"find\"} 3\n"+
+		"test_latency_seconds_bucket{operation=\"insert\",le=\"0.1\"} 1\n")
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	NewCounter("test_handler_total", "Handler.")
+	server := httptest.NewServer(Default.Handler())
+	defer server.Close()
+
+	res, err := server.Client().Get(server.URL)
+	assert.NoError(t, err)
+	defer func() { _ = res.Body.Close() }()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain; version=0.0.4"))
+	var body bytes.Buffer
+	_, _ = body.ReadFrom(res.Body)
+	assert.Contains(t, body.String(), "test_handler_total 0\n")
+
+	res, err = server.Client().Post(server.URL, "text/plain", nil)
+	assert.NoError(t, err)
+	_ = res.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
@@ -0,0 +1,523 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"gibber/datastore"
+	"gibber/log"
+	"gibber/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// REST API. Each request and response body is a JSON object, a successful response carrying its result in "data",
+// and a failed one its reason in "error" along with the HTTP status. All the endpoints other than registration
+// and login (or password reset) need the token given by those in the header "Authorization: Bearer <token>".
+//
+//	POST   /api/users                           register, giving a token
+//	POST   /api/login                           login, giving a token
+//	POST   /api/password_reset                  mail a password reset token to the user with the given email
+//	PUT    /api/password_reset                  set a new password with the reset token
+//	POST   /api/logout                          revoke the token
+//	GET    /api/profile                         own profile
+//	PATCH  /api/profile                         update name and/or password
+//	POST   /api/verification                    mail a new email verification code
+//	PUT    /api/verification                    verify the email with the mailed code
+//	POST   /api/two_factor                      enroll a TOTP secret, giving its otpauth URI
+//	PUT    /api/two_factor                      turn two-factor authentication on with a code, giving recovery codes
+//	DELETE /api/two_factor                      turn two-factor authentication off with the password and a code
+//	GET    /api/sessions                        live sessions of the user, chat and API ones
+//	DELETE /api/sessions/{session ID}           revoke a session
+//	GET    /api/friends                         friends along with their presence and unread messages
+//	DELETE /api/friends/{user ID}               remove a friend, keeping the chat history
+//	                                            (unless ?delete_history=true)
+//	GET    /api/invitations                     active invitations sent and received
+//	POST   /api/invitations                     send an invitation to the user with the given email
+//	POST   /api/invitations/{user ID}/accept    accept the invitation received from the user
+//	POST   /api/invitations/{user ID}/reject    reject the invitation received from the user
+//	POST   /api/invitations/{user ID}/cancel    cancel the invitation sent to the user
+//	GET    /api/chats/{user ID}                 chat history with a friend, a page at a time
+//	                                            (?before={message ID}&limit={count})
+//	POST   /api/chats/{user ID}                 send a message to a friend
+//	GET    /api/blocks                          users blocked by the user
+//	PUT    /api/blocks/{user ID}                block the user
+//	DELETE /api/blocks/{user ID}                unblock the user
+const apiPrefix = "/api/"
+
+// maximum size of a request body
+const apiMaxBodySize = 1 << 20
+
+// REST API errors, sent back to the client
+var (
+	errInvalidToken     = errors.New("missing or invalid token")
+	errNotFound         = errors.New("not found")
+	errMethodNotAllowed = errors.New("method not allowed")
+	errNoInvitation     = errors.New("no such invitation")
+	errEmptyMessage     = errors.New("empty message can't be sent")
+	errInvalidMessageID = errors.New("invalid message ID")
+	errInvalidLimit     = errors.New("limit should be a positive number")
+	errNoMessage        = errors.New("no such message in the chat")
+)
+
+// apiRequest is a request to the REST API, decoded from its JSON body (if any)
+type apiRequest struct {
+	jsonRequest
+	CurrentPassword string `json:"current_password,omitempty"`
+
+	user    *user.User         // authenticated user, nil for the public endpoints
+	session *user.Session      // API session of the authenticated user
+	target  primitive.ObjectID // user (or session) given in the path, if any
+	query   url.Values         // parameters given in the URL
+	source  string             // remote address of the client
+	log     *log.Log           // tagged with the details of the request
+}
+
+// apiResponse is the body of a REST API response
+type apiResponse struct {
+	Data  interface{} `json:"data,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// apiEndpoint serves a request of the REST API, giving the data of the response
+type apiEndpoint func(req *apiRequest) (data interface{}, err error)
+
+// apiRoute is an endpoint along with what it needs
+type apiRoute struct {
+	endpoint  apiEndpoint
+	public    bool // no token needed
+	created   bool // responds with 201 Created on success
+	hasTarget bool // path ends with a user ID
+}
+
+// StartAPIServer starts the REST API on the given host and port, over TLS if a configuration is given
+// The context is taken to signal that the server is initialized successfully
+func StartAPIServer(host, port string, config *tls.Config, complete context.CancelFunc) error {
+	return defaultServer.startHTTP("REST API", host, port, config, newAPIHandler(), complete)
+}
+
+// newAPIHandler gives the handler routing the REST API requests to their endpoints
+func newAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(apiPrefix+"users", apiMethods{
+		http.MethodPost: {endpoint: apiRegister, public: true, created: true},
+	})
+	mux.Handle(apiPrefix+"login", apiMethods{
+		http.MethodPost: {endpoint: apiLogin, public: true},
+	})
+	mux.Handle(apiPrefix+"password_reset", apiMethods{
+		http.MethodPost: {endpoint: apiRequestReset, public: true},
+		http.MethodPut:  {endpoint: apiResetPassword, public: true},
+	})
+	mux.Handle(apiPrefix+"logout", apiMethods{
+		http.MethodPost: {endpoint: apiLogout},
+	})
+	mux.Handle(apiPrefix+"profile", apiMethods{
+		http.MethodGet:   {endpoint: apiProfile},
+		http.MethodPatch: {endpoint: apiUpdateProfile},
+	})
+	mux.Handle(apiPrefix+"verification", apiMethods{
+		http.MethodPost: {endpoint: apiResendVerification},
+		http.MethodPut:  {endpoint: apiVerifyEmail},
+	})
+	mux.Handle(apiPrefix+"two_factor", apiMethods{
+		http.MethodPost:   {endpoint: apiEnrollTOTP},
+		http.MethodPut:    {endpoint: apiConfirmTOTP},
+		http.MethodDelete: {endpoint: apiDisableTOTP},
+	})
+	mux.Handle(apiPrefix+"sessions", apiMethods{
+		http.MethodGet: {endpoint: apiSessions},
+	})
+	mux.Handle(apiPrefix+"sessions/", apiMethods{
+		http.MethodDelete: {endpoint: apiRevokeSession, hasTarget: true},
+	})
+	mux.Handle(apiPrefix+"friends", apiMethods{
+		http.MethodGet: {endpoint: apiFriends},
+	})
+	mux.Handle(apiPrefix+"friends/", apiMethods{
+		http.MethodDelete: {endpoint: apiRemoveFriend, hasTarget: true},
+	})
+	mux.Handle(apiPrefix+"invitations", apiMethods{
+		http.MethodGet:  {endpoint: apiInvitations},
+		http.MethodPost: {endpoint: apiSendInvitation, created: true},
+	})
+	mux.Handle(apiPrefix+"invitations/", apiActions{
+		"accept": {endpoint: apiAcceptInvitation, hasTarget: true},
+		"reject": {endpoint: apiRejectInvitation, hasTarget: true},
+		"cancel": {endpoint: apiCancelInvitation, hasTarget: true},
+	})
+	mux.Handle(apiPrefix+"chats/", apiMethods{
+		http.MethodGet:  {endpoint: apiChatHistory, hasTarget: true},
+		http.MethodPost: {endpoint: apiSendMessage, hasTarget: true, created: true},
+	})
+	mux.Handle(apiPrefix+"blocks", apiMethods{
+		http.MethodGet: {endpoint: apiBlocked},
+	})
+	mux.Handle(apiPrefix+"blocks/", apiMethods{
+		http.MethodPut:    {endpoint: apiBlock, hasTarget: true},
+		http.MethodDelete: {endpoint: apiUnblock, hasTarget: true},
+	})
+	return mux
+}
+
+// apiMethods routes the requests on a path to the route of their method
+type apiMethods map[string]apiRoute
+
+// ServeHTTP serves the request through the route of its method
+func (m apiMethods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, ok := m[r.Method]
+	if !ok {
+		writeAPIResponse(w, requestLogger(r), http.StatusMethodNotAllowed, nil, errMethodNotAllowed)
+		return
+	}
+	var target string
+	if route.hasTarget { // e.g. /api/chats/{user ID}
+		segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(segments) != 3 {
+			writeAPIResponse(w, requestLogger(r), http.StatusNotFound, nil, errNotFound)
+			return
+		}
+		target = segments[2]
+	}
+	route.serve(w, r, target)
+}
+
+// apiActions routes the POST requests on a user (the path being prefix/{user ID}/{action}) to the route of
+// their action
+type apiActions map[string]apiRoute
+
+// ServeHTTP serves the request through the route of its action
+func (a apiActions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	route, ok := apiRoute{}, false
+	if len(segments) == 4 {
+		route, ok = a[segments[3]]
+	}
+	if !ok {
+		writeAPIResponse(w, requestLogger(r), http.StatusNotFound, nil, errNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeAPIResponse(w, requestLogger(r), http.StatusMethodNotAllowed, nil, errMethodNotAllowed)
+		return
+	}
+	route.serve(w, r, segments[2])
+}
+
+// serve authenticates and decodes the request, and serves it through the endpoint of the route
+func (route apiRoute) serve(w http.ResponseWriter, r *http.Request, target string) {
+	req := &apiRequest{query: r.URL.Query(), source: r.RemoteAddr, log: requestLogger(r)}
+	if r.Body != nil && r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize)).Decode(req); err != nil {
+			writeAPIResponse(w, req.log, http.StatusBadRequest, nil, errMalformedRequest)
+			return
+		}
+	}
+	if !route.public {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		usr, session, err := user.AuthenticateAPI(token)
+		if err == user.ErrInvalidSessionToken {
+			writeAPIResponse(w, req.log, http.StatusUnauthorized, nil, errInvalidToken)
+			return
+		} else if err != nil {
+			writeAPIResponse(w, req.log, http.StatusInternalServerError, nil, err)
+			return
+		}
+		req.log = req.log.With("user", usr.ID)
+		usr.SetLogger(req.log)
+		req.user, req.session = usr, session
+	}
+	if route.hasTarget {
+		userID, err := primitive.ObjectIDFromHex(target)
+		if err != nil {
+			writeAPIResponse(w, req.log, http.StatusBadRequest, nil, errInvalidUserID)
+			return
+		}
+		req.target = userID
+	}
+
+	data, err := route.endpoint(req)
+	status := http.StatusOK
+	if err != nil {
+		status = apiStatus(err)
+	} else if route.created {
+		status = http.StatusCreated
+	}
+	writeAPIResponse(w, req.log, status, data, err)
+}
+
+// apiRegister registers a new user, giving him a token
+func apiRegister(req *apiRequest) (data interface{}, err error) {
+	usr, err := registerJSONUser(req.jsonRequest, req.log)
+	if err != nil {
+		return
+	}
+	token, err := startAPISession(usr, req.source)
+	if err != nil {
+		return
+	}
+	return map[string]interface{}{"user": toJSONUser(usr), "verified": false, "token": token}, nil
+}
+
+// apiLogin authenticates an existing user, giving him a token
+func apiLogin(req *apiRequest) (data interface{}, err error) {
+	usr, lastLogin, err := loginJSONUser(req.jsonRequest, req.source, req.log)
+	if err != nil {
+		return
+	}
+	token, err := startAPISession(usr, req.source)
+	if err != nil {
+		return
+	}
+	return map[string]interface{}{"user": toJSONUser(usr), "last_login": lastLogin, "verified": !usr.Unverified,
+		"token": token}, nil
+}
+
+// apiRequestReset mails a password reset token to the user with the given email, if any
+func apiRequestReset(req *apiRequest) (data interface{}, err error) {
+	return nil, requestJSONReset(req.jsonRequest, req.log)
+}
+
+// apiResetPassword sets a new password for the user to whom the given reset token was issued
+func apiResetPassword(req *apiRequest) (data interface{}, err error) {
+	return nil, resetJSONPassword(req.jsonRequest, req.log)
+}
+
+// apiResendVerification mails a new email verification code to the user
+func apiResendVerification(req *apiRequest) (data interface{}, err error) {
+	return nil, resendJSONVerification(req.user)
+}
+
+// apiVerifyEmail verifies the email of the user with the given code
+func apiVerifyEmail(req *apiRequest) (data interface{}, err error) {
+	return nil, verifyJSONEmail(req.user, req.jsonRequest)
+}
+
+// apiEnrollTOTP enrolls a TOTP secret for the user
+func apiEnrollTOTP(req *apiRequest) (data interface{}, err error) {
+	return enrollJSONTOTP(req.user)
+}
+
+// apiConfirmTOTP turns the two-factor authentication of the user on with the given code
+func apiConfirmTOTP(req *apiRequest) (data interface{}, err error) {
+	return confirmJSONTOTP(req.user, req.jsonRequest)
+}
+
+// apiDisableTOTP turns the two-factor authentication of the user off with the given password and code
+func apiDisableTOTP(req *apiRequest) (data interface{}, err error) {
+	return nil, disableTOTP(req.user, req.Password, req.Code)
+}
+
+// apiLogout revokes the token of the request. The user stays online as long as he has a live session.
+func apiLogout(req *apiRequest) (data interface{}, err error) {
+	if err = revokeJSONSession(req.user, req.session.ID); err == errNoSession { // revoked meanwhile
+		err = nil
+	}
+	return
+}
+
+// apiSessions lists the live sessions of the user, telling the one of the request
+func apiSessions(req *apiRequest) (data interface{}, err error) {
+	return listJSONSessions(req.user, req.session)
+}
+
+// apiRevokeSession logs the user out of the session given in the path, revoking its token
+func apiRevokeSession(req *apiRequest) (data interface{}, err error) {
+	return nil, revokeJSONSession(req.user, req.target)
+}
+
+// apiProfile gives the profile of the user
+func apiProfile(req *apiRequest) (data interface{}, err error) {
+	profile := toJSONUser(req.user)
+	return map[string]interface{}{"user": profile, "last_login": req.user.LastLogin, "verified": !req.user.Unverified,
+		"two_factor": req.user.TwoFactor.Enabled}, nil
+}
+
+// apiUpdateProfile updates the name and/or the password of the user. Changing the password needs the current one,
+// and revokes all the other sessions of the user.
+func apiUpdateProfile(req *apiRequest) (data interface{}, err error) {
+	if req.Password != "" {
+		if err = bcrypt.CompareHashAndPassword([]byte(req.user.Password), []byte(req.CurrentPassword)); err != nil {
+			return nil, errIncorrectPassword
+		}
+		if err = validatePassword(req.Password); err != nil {
+			return
+		}
+		var passwordHash []byte
+		if passwordHash, err = bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost); err != nil {
+			req.log.Errorf("%s", err)
+			return nil, errInternalError
+		}
+		if err = req.user.UpdatePassword(string(passwordHash), req.session.ID); err != nil { // others revoked
+			return nil, errUpdateUserPasswordFailed
+		}
+	}
+	if err = req.user.UpdateName(req.FirstName, req.LastName); err != nil {
+		return nil, errUpdateUserNameFailed
+	}
+	return map[string]interface{}{"user": toJSONUser(req.user)}, nil
+}
+
+// apiFriends lists the friends of the user, along with their presence
+func apiFriends(req *apiRequest) (data interface{}, err error) {
+	return listJSONFriends(req.user)
+}
+
+// apiRemoveFriend ends the friendship with the friend of the path, deleting their chat history if asked to
+func apiRemoveFriend(req *apiRequest) (data interface{}, err error) {
+	deleteHistory := false
+	if d := req.query.Get("delete_history"); d != "" {
+		if deleteHistory, err = strconv.ParseBool(d); err != nil {
+			return nil, errMalformedRequest
+		}
+	}
+	return nil, removeJSONFriend(req.user, req.target, deleteHistory)
+}
+
+// apiInvitations lists the active invitations sent and received by the user
+func apiInvitations(req *apiRequest) (data interface{}, err error) {
+	return listJSONInvitations(req.user)
+}
+
+// apiSendInvitation sends an invitation to the user with the given email
+func apiSendInvitation(req *apiRequest) (data interface{}, err error) {
+	return sendJSONInvite(req.user, req.jsonRequest)
+}
+
+// apiAcceptInvitation accepts the invitation received from the user of the path, who becomes a friend
+func apiAcceptInvitation(req *apiRequest) (data interface{}, err error) {
+	return nil, invitationError(req.user.AddFriend(req.target))
+}
+
+// apiRejectInvitation rejects the invitation received from the user of the path
+func apiRejectInvitation(req *apiRequest) (data interface{}, err error) {
+	return nil, invitationError(req.user.RejectInvitation(req.target))
+}
+
+// apiCancelInvitation cancels the invitation sent to the user of the path
+func apiCancelInvitation(req *apiRequest) (data interface{}, err error) {
+	invitee, err := user.GetUserByID(req.target)
+	if err == datastore.ErrNoDocFound {
+		return nil, errUserNotFound
+	} else if err != nil {
+		return
+	}
+	return nil, invitationError(req.user.CancelInvitation(invitee))
+}
+
+// apiChatHistory gives a page of the messages of the chat with the friend of the path, the oldest first. The page
+// holds the latest messages unless the ones before a given message are asked for, and has the default size unless
+// another one is asked for. The chat is marked as read up to the latest message given.
+func apiChatHistory(req *apiRequest) (data interface{}, err error) {
+	if !isFriend(req.user, req.target) {
+		return nil, errNotFriend
+	}
+	var before primitive.ObjectID
+	if id := req.query.Get("before"); id != "" {
+		if before, err = primitive.ObjectIDFromHex(id); err != nil {
+			return nil, errInvalidMessageID
+		}
+	}
+	limit := 0
+	if l := req.query.Get("limit"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+			return nil, errInvalidLimit
+		}
+	}
+	msgs, err := req.user.GetChatMessages(req.target, before, limit)
+	if err == datastore.ErrNoDocFound {
+		return nil, errNoMessage
+	} else if err != nil {
+		return nil, err
+	}
+	if before.IsZero() && len(msgs) > 0 {
+		if err = req.user.MarkChatRead(req.target, msgs[len(msgs)-1].Timestamp); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+// apiSendMessage sends a message to the friend of the path, giving the message as stored
+func apiSendMessage(req *apiRequest) (data interface{}, err error) {
+	if req.Text = strings.TrimSpace(req.Text); req.Text == "" {
+		return nil, errEmptyMessage
+	}
+	if !isFriend(req.user, req.target) {
+		return nil, errNotFriend
+	}
+	return user.SendMessage(req.user.ID, req.target, req.Text)
+}
+
+// apiBlocked lists the users blocked by the user
+func apiBlocked(req *apiRequest) (data interface{}, err error) {
+	return listJSONBlocked(req.user)
+}
+
+// apiBlock blocks the user of the path, ending the friendship and the active invitations with him
+func apiBlock(req *apiRequest) (data interface{}, err error) {
+	return blockJSONUser(req.user, req.target)
+}
+
+// apiUnblock unblocks the user of the path
+func apiUnblock(req *apiRequest) (data interface{}, err error) {
+	return nil, unblockJSONUser(req.user, req.target)
+}
+
+// invitationError tells that no such invitation is active, when acting on it matches none
+func invitationError(err error) error {
+	if err == datastore.ErrNoDocUpdate {
+		return errNoInvitation
+	}
+	return err
+}
+
+// apiStatus gives the HTTP status for the given error of an endpoint
+func apiStatus(err error) int {
+	if lockedOut(err) {
+		return http.StatusTooManyRequests
+	}
+	switch err {
+	case errInvalidCredentials, errInvalidToken, errInvalidResetToken, errSecondFactorRequired, errInvalidTOTPCode:
+		return http.StatusUnauthorized
+	case errIncorrectPassword, errNotFriend, errUserBlocked, errEmailNotVerified, user.ErrBlocked, user.ErrNotVerified,
+		user.ErrNotGroupMember:
+		return http.StatusForbidden
+	case errUserNotFound, errNoInvitation, errNoMessage, errNotBlocked, errNoSession:
+		return http.StatusNotFound
+	case errUserExists, errAlreadyFriend, errAlreadyVerified, errTwoFactorEnabled, errTwoFactorDisabled:
+		return http.StatusConflict
+	case errMalformedRequest, errInvalidUserID, errInvalidEmail, errEmptyInput, errShortPassword, errEmptyMessage,
+		errInvalidMessageID, errInvalidLimit, errInvalidVerificationCode:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// requestLogger gives the logger of a request, tagging the entries with its details
+func requestLogger(r *http.Request) *log.Log {
+	return log.Logger().With("remote", r.RemoteAddr, "request", r.Method+" "+r.URL.Path)
+}
+
+// writeAPIResponse writes the response with the given status, carrying either the data or the error
+func writeAPIResponse(w http.ResponseWriter, logger *log.Log, status int, data interface{}, err error) {
+	resp := apiResponse{Data: data}
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			logger.Errorf("request failed: %s", err)
+			err = errInternalError // details are not for the client
+		}
+		resp.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err = json.NewEncoder(w).Encode(resp); err != nil {
+		logger.Errorf("writing response failed: %s", err)
+	}
+}
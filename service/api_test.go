@@ -0,0 +1,251 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	user2 "gibber/user"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// apiTestClient calls the REST API of a test server, authenticated with its token if any
+type apiTestClient struct {
+	t      *testing.T
+	server *httptest.Server
+	token  string
+}
+
+// call sends a request with the given body (if not nil) to the API, and gives the status and decoded response
+func (ac *apiTestClient) call(method, path string, body interface{}) (status int, resp apiResponse) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		assert.NoError(ac.t, json.NewEncoder(&reqBody).Encode(body), "encoding request failed")
+	}
+	req, err := http.NewRequest(method, ac.server.URL+path, &reqBody)
+	assert.NoError(ac.t, err, "creating request failed")
+	if ac.token != "" {
+		req.Header.Set("Authorization", "Bearer "+ac.token)
+	}
+	res, err := ac.server.Client().Do(req)
+	if err != nil {
+		ac.t.Fatalf("%s %s failed: %s", method, path, err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	assert.NoError(ac.t, json.NewDecoder(res.Body).Decode(&resp), "decoding response of %s %s failed", method, path)
+	return res.StatusCode, resp
+}
+
+// login logs in the given user, keeping the token for the next calls
+func (ac *apiTestClient) login(email, password string) {
+	status, resp := ac.call(http.MethodPost, "/api/login", map[string]string{"email": email, "password": password})
+	assert.Equal(ac.t, http.StatusOK, status, "login failed: %s", resp.Error)
+	ac.token = resp.Data.(map[string]interface{})["token"].(string)
+}
+
+func TestAPI_RegisterAndProfile(t *testing.T) {
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	ac := &apiTestClient{t: t, server: server}
+
+	email := strings.ToLower("rest" + randomString(15) + "@doe.com")
+	register := map[string]string{"email": email, "password": "short", "first_name": "Rest", "last_name": "Doe"}
+	status, resp := ac.call(http.MethodPost, "/api/users", register)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errShortPassword.Error(), resp.Error)
+	register["password"] = "password"
+	status, resp = ac.call(http.MethodPost, "/api/users", register)
+	assert.Equal(t, http.StatusCreated, status, "registration failed: %s", resp.Error)
+	assert.NotEmpty(t, resp.Data.(map[string]interface{})["token"])
+	status, _ = ac.call(http.MethodPost, "/api/users", register)
+	assert.Equal(t, http.StatusConflict, status, "email already registered")
+
+	status, _ = ac.call(http.MethodGet, "/api/profile", nil)
+	assert.Equal(t, http.StatusUnauthorized, status, "token needed")
+	status, _ = ac.call(http.MethodPost, "/api/login", map[string]string{"email": email, "password": "wrong"})
+	assert.Equal(t, http.StatusUnauthorized, status)
+	ac.login(email, "password")
+	other := &apiTestClient{t: t, server: server}
+	other.login(email, "password")
+
+	status, _ = ac.call(http.MethodPatch, "/api/profile", map[string]string{"first_name": "Restful",
+		"password": "new password", "current_password": "wrong"})
+	assert.Equal(t, http.StatusForbidden, status, "current password needed to change it")
+	status, resp = ac.call(http.MethodPatch, "/api/profile", map[string]string{"first_name": "Restful",
+		"password": "new password", "current_password": "password"})
+	assert.Equal(t, http.StatusOK, status, "updating profile failed: %s", resp.Error)
+	status, _ = other.call(http.MethodGet, "/api/profile", nil)
+	assert.Equal(t, http.StatusUnauthorized, status, "changing the password revokes the other tokens")
+	status, resp = ac.call(http.MethodGet, "/api/profile", nil)
+	assert.Equal(t, http.StatusOK, status)
+	profile := resp.Data.(map[string]interface{})["user"].(map[string]interface{})
+	assert.Equal(t, "Restful", profile["first_name"])
+	assert.Equal(t, email, profile["email"])
+
+	status, _ = ac.call(http.MethodDelete, "/api/profile", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+	status, _ = ac.call(http.MethodPost, "/api/logout", nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = ac.call(http.MethodGet, "/api/profile", nil)
+	assert.Equal(t, http.StatusUnauthorized, status, "token revoked")
+	ac.login(email, "new password")
+}
+
+func TestAPI_InvitationsAndChat(t *testing.T) {
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	sender, receiver := createTestUser(t, "John"), createTestUser(t, "Jane")
+	senderAPI := &apiTestClient{t: t, server: server}
+	senderAPI.login(sender.Email, "password")
+	receiverAPI := &apiTestClient{t: t, server: server}
+	receiverAPI.login(receiver.Email, "password")
+
+	status, _ := senderAPI.call(http.MethodPost, "/api/invitations", map[string]string{"email": "nobody@doe.com"})
+	assert.Equal(t, http.StatusNotFound, status)
+	status, resp := senderAPI.call(http.MethodPost, "/api/invitations", map[string]string{"email": receiver.Email})
+	assert.Equal(t, http.StatusCreated, status, "sending invitation failed: %s", resp.Error)
+	status, resp = receiverAPI.call(http.MethodGet, "/api/invitations", nil)
+	assert.Equal(t, http.StatusOK, status)
+	received := resp.Data.(map[string]interface{})["received"].([]interface{})
+	assert.Equal(t, 1, len(received))
+	assert.Equal(t, sender.ID.Hex(), received[0].(map[string]interface{})["id"])
+
+	chatPath := "/api/chats/" + receiver.ID.Hex()
+	status, _ = senderAPI.call(http.MethodPost, chatPath, map[string]string{"text": "hello"})
+	assert.Equal(t, http.StatusForbidden, status, "only friends can chat")
+	status, _ = receiverAPI.call(http.MethodPost, "/api/invitations/"+sender.ID.Hex()+"/unknown", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = receiverAPI.call(http.MethodPost, "/api/invitations/invalid/accept", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, resp = receiverAPI.call(http.MethodPost, "/api/invitations/"+sender.ID.Hex()+"/accept", nil)
+	assert.Equal(t, http.StatusOK, status, "accepting invitation failed: %s", resp.Error)
+	status, _ = receiverAPI.call(http.MethodPost, "/api/invitations/"+sender.ID.Hex()+"/reject", nil)
+	assert.Equal(t, http.StatusNotFound, status, "invitation already accepted")
+
+	status, resp = senderAPI.call(http.MethodGet, "/api/friends", nil)
+	assert.Equal(t, http.StatusOK, status)
+	friends := resp.Data.([]interface{})
+	assert.Equal(t, 1, len(friends))
+	assert.Equal(t, receiver.Email, friends[0].(map[string]interface{})["email"])
+
+	status, resp = senderAPI.call(http.MethodPost, chatPath, map[string]string{"text": " hello\n"})
+	assert.Equal(t, http.StatusCreated, status, "sending message failed: %s", resp.Error)
+	assert.Equal(t, "hello", resp.Data.(map[string]interface{})["text"], "the message sent is given back, trimmed")
+	assert.NotEmpty(t, resp.Data.(map[string]interface{})["id"])
+	status, _ = senderAPI.call(http.MethodPost, chatPath, map[string]string{"text": " "})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, resp = receiverAPI.call(http.MethodGet, "/api/chats/"+sender.ID.Hex(), nil)
+	assert.Equal(t, http.StatusOK, status)
+	msgs := resp.Data.([]interface{})
+	assert.Equal(t, 1, len(msgs))
+	assert.Equal(t, "hello", msgs[0].(map[string]interface{})["text"])
+	assert.Equal(t, sender.ID.Hex(), msgs[0].(map[string]interface{})["sender"])
+
+	// paging back through the history
+	status, resp = senderAPI.call(http.MethodPost, chatPath, map[string]string{"text": "are you there?"})
+	assert.Equal(t, http.StatusCreated, status, "sending message failed: %s", resp.Error)
+	status, resp = receiverAPI.call(http.MethodGet, "/api/chats/"+sender.ID.Hex()+"?limit=1", nil)
+	assert.Equal(t, http.StatusOK, status)
+	msgs = resp.Data.([]interface{})
+	assert.Equal(t, 1, len(msgs), "a page of the given size")
+	assert.Equal(t, "are you there?", msgs[0].(map[string]interface{})["text"], "latest message")
+	status, resp = receiverAPI.call(http.MethodGet,
+		"/api/chats/"+sender.ID.Hex()+"?before="+msgs[0].(map[string]interface{})["id"].(string), nil)
+	assert.Equal(t, http.StatusOK, status)
+	msgs = resp.Data.([]interface{})
+	assert.Equal(t, 1, len(msgs), "the messages before the given one")
+	assert.Equal(t, "hello", msgs[0].(map[string]interface{})["text"])
+	status, _ = receiverAPI.call(http.MethodGet, "/api/chats/"+sender.ID.Hex()+"?limit=0", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = receiverAPI.call(http.MethodGet, "/api/chats/"+sender.ID.Hex()+"?before=invalid", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = receiverAPI.call(http.MethodGet, "/api/chats/"+sender.ID.Hex()+"?before="+
+		primitive.NewObjectID().Hex(), nil)
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestAPI_CancelInvitation(t *testing.T) {
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	sender, receiver := createTestUser(t, "John"), createTestUser(t, "Jane")
+	assert.NoError(t, sender.SendInvitation(receiver), "sending invitation failed")
+	ac := &apiTestClient{t: t, server: server}
+	ac.login(sender.Email, "password")
+
+	status, resp := ac.call(http.MethodPost, "/api/invitations/"+receiver.ID.Hex()+"/cancel", nil)
+	assert.Equal(t, http.StatusOK, status, "cancelling invitation failed: %s", resp.Error)
+	status, resp = ac.call(http.MethodGet, "/api/invitations", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 0, len(resp.Data.(map[string]interface{})["sent"].([]interface{})))
+}
+
+func TestAPI_RemoveFriend(t *testing.T) {
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	self, friend := createTestFriends(t)
+	ac := &apiTestClient{t: t, server: server}
+	ac.login(self.Email, "password")
+	status, _ := ac.call(http.MethodPost, "/api/invitations", map[string]string{"email": friend.Email})
+	assert.Equal(t, http.StatusConflict, status, "a friend can't be invited")
+	_, err := user2.SendMessage(self.ID, friend.ID, "hello")
+	assert.NoError(t, err, "sending failed")
+
+	status, _ = ac.call(http.MethodDelete, "/api/friends/"+friend.ID.Hex()+"?delete_history=maybe", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, resp := ac.call(http.MethodDelete, "/api/friends/"+friend.ID.Hex()+"?delete_history=true", nil)
+	assert.Equal(t, http.StatusOK, status, "removing friend failed: %s", resp.Error)
+	status, _ = ac.call(http.MethodDelete, "/api/friends/"+friend.ID.Hex(), nil)
+	assert.Equal(t, http.StatusForbidden, status, "no longer a friend")
+	msgs, err := friend.GetChatMessages(self.ID, primitive.NilObjectID, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs, "the history should be deleted")
+	status, _ = ac.call(http.MethodPost, "/api/invitations", map[string]string{"email": friend.Email})
+	assert.Equal(t, http.StatusCreated, status, "the former friend can be invited again")
+}
+
+func TestAPI_Block(t *testing.T) {
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	self, friend := createTestFriends(t)
+	ac := &apiTestClient{t: t, server: server}
+	ac.login(self.Email, "password")
+
+	status, _ := ac.call(http.MethodPut, "/api/blocks/"+self.ID.Hex(), nil)
+	assert.Equal(t, http.StatusBadRequest, status, "the user can't block himself")
+	status, _ = ac.call(http.MethodPut, "/api/blocks/"+primitive.NewObjectID().Hex(), nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	for i := 0; i < 2; i++ {
+		status, resp := ac.call(http.MethodPut, "/api/blocks/"+friend.ID.Hex(), nil)
+		assert.Equal(t, http.StatusOK, status, "blocking failed: %s", resp.Error)
+	}
+	status, resp := ac.call(http.MethodGet, "/api/blocks", nil)
+	assert.Equal(t, http.StatusOK, status)
+	if blocked := resp.Data.([]interface{}); assert.Equal(t, 1, len(blocked)) {
+		assert.Equal(t, friend.Email, blocked[0].(map[string]interface{})["email"])
+	}
+	status, _ = ac.call(http.MethodPost, "/api/chats/"+friend.ID.Hex(), map[string]string{"text": "hi"})
+	assert.Equal(t, http.StatusForbidden, status, "a blocked user is no longer a friend")
+	status, _ = ac.call(http.MethodPost, "/api/invitations", map[string]string{"email": friend.Email})
+	assert.Equal(t, http.StatusForbidden, status, "a blocked user can't be invited")
+
+	blocked := &apiTestClient{t: t, server: server}
+	blocked.login(friend.Email, "password")
+	status, _ = blocked.call(http.MethodPost, "/api/invitations", map[string]string{"email": self.Email})
+	assert.Equal(t, http.StatusNotFound, status, "the blocking user can't be found")
+
+	status, _ = ac.call(http.MethodDelete, "/api/blocks/"+friend.ID.Hex(), nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = ac.call(http.MethodDelete, "/api/blocks/"+friend.ID.Hex(), nil)
+	assert.Equal(t, http.StatusNotFound, status, "the user isn't blocked anymore")
+	status, _ = blocked.call(http.MethodPost, "/api/invitations", map[string]string{"email": self.Email})
+	assert.Equal(t, http.StatusCreated, status, "an unblocked user can invite again")
+}
+
+func TestAPIStatus_PolicyRefusals(t *testing.T) {
+	for _, err := range []error{user2.ErrBlocked, user2.ErrNotVerified, user2.ErrNotGroupMember} {
+		assert.Equal(t, http.StatusForbidden, apiStatus(err), "%s is a refusal, not a failure", err)
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gibber/datastore"
+	"gibber/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"strings"
+)
+
+// block list menu and prompts
+const (
+	blockMenu = "\n0 - Go back to previous menu" +
+		"\n1 - See blocked users" +
+		"\n2 - Block a user" +
+		"\n3 - Unblock a user" +
+		"\n\nEnter a choice: "
+	blockedIndexPrompt = "\nEnter a blocked user's index (\"b\" to go back): "
+	blockConfirmation  = "\nBlock %s? They won't be able to find you, invite you or message you (Y/n): "
+	noBlockedUsersMsg  = "\nYou haven't blocked anyone"
+)
+
+// user options in the block list menu
+const (
+	seeBlockedChoice = 1 + iota
+	blockUserChoice
+	unblockUserChoice
+)
+
+// block list flow errors
+var errFetchBlockedFailed = errors.New("fetch blocked users failed")
+
+// seeBlockList enables the user to see, block and unblock the users kept away from him
+func (c *client) seeBlockList() {
+	for {
+		userInput := c.sendAndReceiveMsg(blockMenu, false, false)
+		if c.Err != nil {
+			return
+		}
+		choice, err := strconv.Atoi(userInput)
+		if err != nil {
+			c.sendMessage(errInvalidInput.Error(), true)
+			continue
+		}
+		switch choice {
+		case exitChoice:
+			return
+		case seeBlockedChoice:
+			c.showBlockedUsers()
+		case blockUserChoice:
+			c.blockUser()
+		case unblockUserChoice:
+			c.unblockUser()
+		default:
+			c.sendMessage(errInvalidInput.Error(), true)
+		}
+	}
+}
+
+// showBlockedUsers displays the users blocked by the user, telling whether there is any to choose from
+func (c *client) showBlockedUsers() (blocked []primitive.ObjectID, ok bool) {
+	blocked, err := c.User.BlockedUsers()
+	if err != nil {
+		c.logger().Errorf("error while fetching blocked users for client %s: %s", (*c.Conn).RemoteAddr(), err)
+		c.Err = errFetchBlockedFailed
+		return
+	}
+	if len(blocked) == 0 {
+		c.sendMessage(noBlockedUsersMsg, true)
+		return
+	}
+	c.showUsers("Blocked Users", blocked)
+	return blocked, true
+}
+
+// blockUser blocks the user with the email given by the user, found as when inviting him
+func (c *client) blockUser() {
+	email := c.sendAndReceiveMsg(emailSearchPrompt, false, false)
+	if c.Err != nil {
+		return
+	}
+	email = strings.ToLower(email)
+	if email == "q" {
+		return
+	}
+	usr, err := findUser(c.User, email) // the unverified users and the ones who blocked him aren't found
+	if err == datastore.ErrNoDocFound {
+		c.sendMessage(fmt.Sprintf("\nNo user found with given email %s", email), true)
+		return
+	} else if err != nil {
+		c.logger().Errorf("fetching user %s failed: %s", email, err)
+		c.sendMessage(fmt.Sprintf("\nBlocking %s failed", email), true)
+		return
+	}
+	c.confirmBlock(usr)
+}
+
+// confirmBlock blocks the given user once the user confirms it
+func (c *client) confirmBlock(usr *user.User) {
+	confirm := c.sendAndReceiveMsg(fmt.Sprintf(blockConfirmation, usr.Email), false, true)
+	if c.Err != nil || (strings.ToLower(confirm) != "y" && confirm != "") {
+		return
+	}
+	if err := c.User.Block(usr.ID); err != nil {
+		c.sendMessage(fmt.Sprintf("\nBlocking %s failed: %s\n", usr.Email, err), true)
+		return
+	}
+	c.sendMessage(fmt.Sprintf("\n%s blocked\n", usr.Email), true)
+}
+
+// unblockUser lets the user choose one of the users he blocked, and unblock him
+func (c *client) unblockUser() {
+	blocked, ok := c.showBlockedUsers()
+	if !ok {
+		return
+	}
+	userInput := c.sendAndReceiveMsg(blockedIndexPrompt, false, false)
+	if c.Err != nil || strings.ToLower(userInput) == "b" {
+		return
+	}
+	idx, err := strconv.Atoi(userInput)
+	if err != nil || idx < 1 || idx > len(blocked) {
+		c.sendMessage(fmt.Sprintf("Invalid choice: %s", userInput), true)
+		return
+	}
+	blockedID := blocked[idx-1]
+	if err = c.User.Unblock(blockedID); err != nil {
+		c.sendMessage(fmt.Sprintf("\nUnblocking failed: %s\n", err), true)
+		return
+	}
+	userProfile, _ := user.UserProfile(blockedID)
+	c.sendMessage(fmt.Sprintf("\n%s unblocked. Send an invitation to be friends again.\n", userProfile), true)
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestClient_BlockList(t *testing.T) {
+	self, friend := createTestFriends(t)
+	conn := dialTestServer(t)
+	defer conn.close()
+	conn.login(self.Email, "password")
+	conn.send("9") // blocked users
+	conn.expect(blockMenu)
+	conn.send("1")
+	conn.expect(noBlockedUsersMsg)
+
+	conn.send("2") // block a user
+	conn.expect(emailSearchPrompt)
+	conn.send(friend.Email)
+	conn.expect("(Y/n): ")
+	conn.send("y")
+	conn.expect(friend.Email + " blocked")
+	conn.send("1")
+	conn.expect("Blocked Users")
+	conn.expect(friend.Email)
+	friends, err := self.SeeFriends()
+	assert.NoError(t, err)
+	assert.Empty(t, friends, "the friendship should end")
+
+	// the blocking user can't be found anymore by the blocked one
+	other := dialTestServer(t)
+	defer other.close()
+	other.login(friend.Email, "password")
+	other.send("3") // send invitation
+	other.expect(emailSearchPrompt)
+	other.send(self.Email)
+	other.expect("No usr found")
+	other.expect(emailSearchPrompt)
+	other.send("q")
+	other.send("9") // nor can it be blocked back, which would tell that it exists
+	other.expect(blockMenu)
+	other.send("2")
+	other.expect(emailSearchPrompt)
+	other.send(self.Email)
+	other.expect("No user found with given email " + self.Email)
+
+	conn.send("3") // unblock a user
+	conn.expect(blockedIndexPrompt)
+	conn.send("1")
+	conn.expect("unblocked")
+	blocked, err := self.BlockedUsers()
+	assert.NoError(t, err)
+	assert.Empty(t, blocked, "the user should be unblocked")
+	conn.send("0")
+	conn.expect(userMenu)
+}
@@ -7,11 +7,11 @@ import (
 	"gibber/log"
 	"gibber/user"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
-	"io"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +19,64 @@ import (
 type client struct {
 	*user.User
 	*Connection
+	chat *chatSession // conversation currently opened by the user, nil if none
+	// guards the chat session, as the events are delivered to the client from another goroutine
+	chatLock sync.Mutex
+	typing   typingTracker // conversations in which the user is typing, told live to the others
+	jsonMode int32         // set to 1 once switched to the JSON-lines protocol, read atomically on shutdown
+	session  *user.Session // of the logged in user, nil till he logs in
+	// the user asked to log out, his session being revoked rather than left resumable on disconnection
+	loggingOut bool
+}
+
+// tagUser tags the entries logged for the connection with the logged in user, the user package logging on his
+// behalf with the same tags
+func (c *client) tagUser() {
+	c.log = c.logger().With("user", c.User.ID)
+	c.User.SetLogger(c.log)
+}
+
+// chatSession is a conversation opened by the user, to which the incoming messages are delivered as they come
+type chatSession struct {
+	peer     primitive.ObjectID // friend in a one-to-one chat
+	peerName string
+	group    primitive.ObjectID // group in a group chat
+	since    time.Time          // messages up to this time are already displayed with the chat history
+	older    user.Cursor        // oldest message displayed, the ones before it being loaded on demand
+	hasOlder bool               // whether there are messages before the oldest one displayed
+	// fetches the page of the history before the given cursor
+	history func(before user.Cursor) (user.ChatPage, error)
+	// advances the read cursor of the user up to the given time, as the messages are displayed
+	markRead func(until time.Time) error
+	// tells the others of the conversation whether the user is typing
+	notifyTyping func(typing bool)
+	// fetches the latest messages of the conversation, to choose the one to edit or delete
+	messages func() ([]user.Message, error)
+	// replaces the text of the given message, sent by the user
+	edit func(messageID primitive.ObjectID, text string) error
+	// deletes the given message, for everyone (if sent by the user) or just for the user
+	remove func(messageID primitive.ObjectID, forEveryone bool) error
+}
+
+// conversation gives the ID of the group of a group chat, or of the friend of a one-to-one chat
+func (s *chatSession) conversation() primitive.ObjectID {
+	if !s.group.IsZero() {
+		return s.group
+	}
+	return s.peer
+}
+
+// concerns checks whether the event occurred in the conversation
+func (s *chatSession) concerns(event user.Event) bool {
+	if !event.Group.IsZero() {
+		return s.group == event.Group
+	}
+	return s.group.IsZero() && s.peer == event.From
+}
+
+// receives checks whether the message event belongs to the conversation, and is not yet displayed
+func (s *chatSession) receives(event user.Event) bool {
+	return event.Timestamp.After(s.since) && s.concerns(event)
 }
 
 // User response messages
@@ -26,8 +84,8 @@ const (
 	welcomeMsg               = "Welcome to Gibber. Hope you have a lot to say today."
 	emailPrompt              = "\nPlease enter your email to continue.\nEmail: "
 	reenterEmailPrompt       = "Please re-enter your email.\nEmail: "
-	passwordPrompt           = "\nYou are already a registered user. Please enter password to continue.\nPassword: "
-	reenterPasswordPrompt    = "\nPlease re-enter your password.\nPassword: "
+	passwordPrompt           = "\nYou are already a registered user. Enter password (\"?\" if forgotten).\nPassword: "
+	reenterPasswordPrompt    = "\nPlease re-enter your password (\"?\" if forgotten).\nPassword: "
 	newUserMsg               = "You are an unregistered user. Please register yourself by providing details.\n"
 	firstNamePrompt          = "First Name: "
 	lastNamePrompt           = "Last Name: "
@@ -39,9 +97,9 @@ const (
 	confirmSetPasswordPrompt = "Confirm Password: "
 	sendInvitationInfo       = "You can search other people uniquely by their email.\n"
 	emailSearchPrompt        = "\nEmail(\"q\" to quit): "
+	friendsListPrompt        = "\nEnter a friend's index to remove him from your friends. Enter 'b' to go back: "
+	invitationActionPrompt   = "\nAccept(a), reject(r), block(x) or go back(b) [a]: "
 	exitingMsg               = "exiting..."
-	incomingMsgPollInterval  = 500 * time.Millisecond
-	passwordMinLength        = 6
 )
 
 // Specific errors related to user flow
@@ -49,11 +107,12 @@ var (
 	errIncorrectPassword          = errors.New("incorrect password")
 	errInvalidEmail               = errors.New("invalid email")
 	errEmptyInput                 = errors.New("empty msg")
-	errShortPassword              = errors.New("password should be at 6 characters long")
+	errShortPassword              = shortPasswordError(limits.PasswordMinLength)
 	errInvalidInput               = errors.New("invalid msg")
 	errFetchReceivedInvitesFailed = errors.New("failed to fetch received invitations")
 	errFetchSentInvitesFailed     = errors.New("failed to fetch sent invitations")
 	errCancelInviteFailed         = errors.New("cancelling invite failed")
+	errRejectInviteFailed         = errors.New("rejecting invite failed")
 	errFetchUserFailed            = errors.New("fetch user details failed")
 	errReadEmailFailed            = errors.New("reading email failed")
 	errReadPasswordFailed         = errors.New("reading password failed")
@@ -65,6 +124,12 @@ var (
 	errUpdateUserPasswordFailed   = errors.New("update user password failed")
 )
 
+// friend removal confirmations
+const (
+	removeFriendConfirmation  = "\nRemove %s from your friends? (y/N): "
+	deleteHistoryConfirmation = "Delete your chat history for both of you too? (y/N): "
+)
+
 // user menus for different scenarios
 const (
 	dashboardHeader = "********************** Welcome to Gibber ************************" +
@@ -77,6 +142,8 @@ const (
 		"\n5 - Change password" +
 		"\n6 - Change Name" +
 		"\n7 - See your profile" +
+		"\n8 - Group chats" +
+		"\n9 - Blocked users" +
 		"\n\nEnter a choice: "
 	invitationMenu = "\n0 - Go back to previous menu" +
 		"\n1 - Active Sent Invites" +
@@ -96,6 +163,8 @@ const (
 	changePasswordChoice
 	changeNameChoice
 	seeProfileChoice
+	groupChatsChoice
+	blockListChoice
 )
 
 // user invites type (choices)
@@ -106,20 +175,32 @@ const (
 	inactiveReceivedInvitesChoice
 )
 
-const chatPrompt = "Type message (press \"enter\" to send, \"q\" to quit): "
+// missed messages summary
+const (
+	missedMessagesHeader = "\n****************** While you were away *****************\n"
+	missedChoicePrompt   = "\nEnter a conversation's index to open it (press \"enter\" to skip): "
+)
+
+// chat session prompt and messages
+const (
+	chatPrompt = "Type message (press \"enter\" to send, \"o\" for older messages, \"e\" to edit, " +
+		"\"d\" to delete, \"q\" to quit): "
+	olderMessagesHeader = "\n------------------- older messages -------------------\n"
+	noOlderMessagesMsg  = "No older messages"
+)
 
 // showWelcomeMessage displays a welcome message to as user logs in
 func (c *client) showWelcomeMessage() {
 	c.sendMessage(welcomeMsg, true)
 	if c.Err != nil {
-		log.Logger().Printf("writing welcome message to client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
+		c.logger().Errorf("writing welcome message to client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
 	}
 }
 
 // authenticate authenticate a client (user) by taking necessary details,
-// email and password currently
-func (c *client) authenticate() {
-	c.promptForEmail()
+// email (already entered once) and password currently
+func (c *client) authenticate(email string) {
+	c.promptForEmail(email)
 	if c.Err != nil {
 		return
 	}
@@ -134,12 +215,12 @@ func (c *client) authenticate() {
 	}
 }
 
-// promptForEmail prompts for a newly connected client for email. It has 2 retries (total 3 times)
-// before closing the client connection
-func (c *client) promptForEmail() {
-	for failureCount := 0; failureCount < 3; failureCount++ {
+// promptForEmail validates the email entered by a newly connected client. It re-prompts till the client has made
+// limits.MaxAttempts attempts, before closing the client connection
+func (c *client) promptForEmail(email string) {
+	for failureCount := 0; failureCount < limits.MaxAttempts; failureCount++ {
 		if failureCount == 0 {
-			c.Email = c.sendAndReceiveMsg(emailPrompt, false, false)
+			c.Email = email
 		} else {
 			c.Email = c.sendAndReceiveMsg(reenterEmailPrompt, false, false)
 		}
@@ -148,10 +229,10 @@ func (c *client) promptForEmail() {
 		}
 		c.Email = strings.ToLower(c.Email) // make email address case insensitive
 		if !user.ValidUserEmail(c.Email) { // check for valid email - regex based
-			log.Logger().Printf("invalid email %s", c.Email)
+			c.logger().Warnf("invalid email %s", c.Email)
 			c.sendMessage(errInvalidEmail.Error(), true)
 			if c.Err != nil {
-				log.Logger().Printf("sending invalid email msg to client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
+				c.logger().Warnf("sending invalid email msg to client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
 				return
 			}
 			c.Err = errInvalidEmail
@@ -166,54 +247,72 @@ func (c *client) promptForEmail() {
 // existingUser checks whether the user already exists in the system, based on the entered email
 func (c *client) existingUser() (exists bool) {
 	_, c.Err = user.GetUserByEmail(c.Email) // if user not exists, it will throw an error
-	if c.Err == mongo.ErrNoDocuments {
+	if c.Err == datastore.ErrNoDocFound {
 		c.Err = nil // resetting the error
 		return
 	}
 	if c.Err != nil { // some other error occurred
-		log.Logger().Printf("existing user check for client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
+		c.logger().Errorf("existing user check for client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
 		return
 	}
 	exists = true
 	return
 }
 
-// loginUser facilitate the user login. It has 3 retry attempts for incorrect credentials before exiting
+// loginUser facilitate the user login. It has limits.MaxAttempts attempts for correct credentials before exiting.
+// Entering forgotPasswordInput in place of the password resets it by email, a successful reset giving the
+// attempts back. A user with two-factor authentication enters his code after the password.
 func (c *client) loginUser() {
-	for failureCount := 0; failureCount < 3; failureCount++ {
-		if failureCount == 0 {
-			c.sendMessage(passwordPrompt, false)
-		} else {
-			c.sendMessage(reenterPasswordPrompt, false)
-		}
+	prompt := passwordPrompt
+	for failureCount := 0; failureCount < limits.MaxAttempts; failureCount++ {
+		c.sendMessage(prompt, false)
+		prompt = reenterPasswordPrompt
 		if c.Err != nil {
-			log.Logger().Printf("user password prompt failed: %s", c.Err)
+			c.logger().Errorf("user password prompt failed: %s", c.Err)
 			continue
 		}
 		password := c.readMessage()
 		if c.Err != nil {
-			log.Logger().Printf("reading user password failed: %s", c.Err)
+			c.logger().Errorf("reading user password failed: %s", c.Err)
+			continue
+		}
+		if password == forgotPasswordInput {
+			if c.resetPassword() {
+				failureCount, prompt = -1, newPasswordLoginPrompt
+			}
 			continue
 		}
 		var lastLogin string
-		lastLogin, c.Err = c.User.LoginUser(password)
+		lastLogin, c.Err = authenticate(c.User, password, (*c.Conn).RemoteAddr().String())
+		if c.Err == user.ErrSecondFactorRequired {
+			lastLogin, c.Err = c.loginSecondFactor()
+		}
 		if c.Err != nil {
-			log.Logger().Printf("user %s authentication failed: %s", c.Email, c.Err)
-			if c.Err == errIncorrectPassword {
+			c.logger().Warnf("user %s authentication failed: %s", c.Email, c.Err)
+			if lockedOut(c.Err) {
+				c.sendMessage(failedLogin+": "+c.Err.Error(), true)
+				break // no use trying again till the lockout ends
+			} else if wrongCredentials(c.Err) {
 				c.sendMessage(failedLogin+": "+errIncorrectPassword.Error(), true)
+			} else if c.Err == errInvalidTOTPCode {
+				c.sendMessage(failedLogin+": "+errInvalidTOTPCode.Error(), true)
 			} else {
 				c.sendMessage(failedLogin+": "+errInternalError.Error(), true)
 			}
 			continue
 		}
-		log.Logger().Printf("user %s successfully logged in", c.Email)
+		c.tagUser()
+		c.logger().Infof("user %s successfully logged in", c.Email)
 		c.sendMessage(fmt.Sprintf(successfulLogin, lastLogin), true)
 		if c.Err != nil {
-			log.Logger().Printf("successful login msg failed to client %s: %s", (*c.Conn).RemoteAddr(), c.Err)
+			c.logger().Errorf("successful login msg failed to client %s: %s", (*c.Conn).RemoteAddr(), c.Err)
+		}
+		if c.Unverified {
+			c.verifyEmail(false)
 		}
 		c.sendMessage(dashboardHeader, true)
 		if c.Err != nil {
-			log.Logger().Printf("dashboard header msg failed to send to client %s: %s", (*c.Conn).RemoteAddr(),
+			c.logger().Errorf("dashboard header msg failed to send to client %s: %s", (*c.Conn).RemoteAddr(),
 				c.Err)
 		}
 		return
@@ -222,31 +321,71 @@ func (c *client) loginUser() {
 	c.Err = errReadPasswordFailed
 }
 
+// showMissedMessages sums up the conversations in which the user received messages since his previous login, and
+// lets him jump into one of them
+func (c *client) showMissedMessages() {
+	missed, err := c.User.MissedMessages(c.User.LastLogin)
+	if err != nil {
+		c.logger().Errorf("fetching missed messages failed: %s", err)
+		return
+	}
+	if len(missed) == 0 {
+		return
+	}
+	c.sendMessage(missedMessagesHeader, true)
+	for idx, conv := range missed {
+		count := "1 new message"
+		if conv.Count > 1 {
+			count = fmt.Sprintf("%d new messages", conv.Count)
+		}
+		name := conv.Name
+		if !conv.Group.IsZero() {
+			name = "group " + conv.Name
+		}
+		c.sendMessage(fmt.Sprintf("%d - %s: %s, latest from %s: %q", idx+1, name, count, conv.Sender,
+			conv.Snippet), true)
+	}
+	userInput := c.sendAndReceiveMsg(missedChoicePrompt, false, true)
+	if c.Err != nil || userInput == "" {
+		return
+	}
+	idx, err := strconv.Atoi(userInput)
+	if err != nil || idx < 1 || idx > len(missed) {
+		c.sendMessage(fmt.Sprintf("Invalid choice: %s", userInput), true)
+		return
+	}
+	if conv := missed[idx-1]; conv.Group.IsZero() {
+		c.starChat(conv.Friend)
+	} else {
+		c.chatInGroup(conv.Group, conv.Name)
+	}
+}
+
 // registerUser registers a new user when a new email is entered
 func (c *client) registerUser() {
 	c.sendMessage(newUserMsg, true)
 	if c.Err != nil {
-		log.Logger().Printf("new user message sending failed: %s", c.Err)
+		c.logger().Errorf("new user message sending failed: %s", c.Err)
 		return
 	}
 
 	firstName := c.sendAndReceiveMsg(firstNamePrompt, false, false)
 	if c.Err != nil {
-		log.Logger().Printf("reading user password failed: %s", c.Err)
+		c.logger().Errorf("reading user password failed: %s", c.Err)
 		return
 	}
 	c.FirstName = firstName
 
 	lastName := c.sendAndReceiveMsg(lastNamePrompt, false, false)
 	if c.Err != nil {
-		log.Logger().Printf("reading user last name failed: %s", c.Err)
+		c.logger().Errorf("reading user last name failed: %s", c.Err)
 		return
 	}
 	c.LastName = lastName
 
 	password := c.sendAndReceiveMsg(setPasswordPrompt, false, false)
 	if c.Err != nil {
-		log.Logger().Printf("reading user new password failed: %s", c.Err)
+		c.logger().Errorf("reading user new password failed: %s", c.Err)
 		return
 	}
 	c.Err = validatePassword(password)
@@ -257,27 +396,30 @@ func (c *client) registerUser() {
 
 	confPassword := c.sendAndReceiveMsg(confirmSetPasswordPrompt, false, false)
 	if c.Err != nil {
-		log.Logger().Printf("reading user confirm password failed: %s", c.Err)
+		c.logger().Errorf("reading user confirm password failed: %s", c.Err)
 		return
 	} else if password != confPassword {
-		log.Logger().Print(errPasswordNotMatched.Error())
+		c.logger().Warnf("%s", errPasswordNotMatched)
 		c.Err = errPasswordNotMatched
 		return
 	}
 	c.Password = password
 
-	_, c.Err = user.CreateUser(c.User)
+	_, c.Err = user.RegisterUser(c.User)
 	if c.Err != nil {
-		log.Logger().Printf("user %s registration failed: %s", c.Email, c.Err)
+		c.logger().Errorf("user %s registration failed: %s", c.Email, c.Err)
 		c.sendMessage(failedRegistration, true)
 		return
 	}
 
-	log.Logger().Printf("user %s successfully regsistered", c.User)
+	c.tagUser()
+	c.logger().Infof("user %s successfully regsistered", c.User)
 	c.sendMessage(successfulRegistration, true)
 	if c.Err != nil {
-		log.Logger().Printf("successful registration msg failed to client %s: %s", (*c.Conn).RemoteAddr(), c.Err)
+		c.logger().Errorf("successful registration msg failed to client %s: %s", (*c.Conn).RemoteAddr(), c.Err)
+		return
 	}
+	c.verifyEmail(true)
 }
 
 // sendAndReceiveMsg combines the sending and receiving of the message from user.
@@ -289,14 +431,14 @@ func (c *client) sendAndReceiveMsg(msgToSend string, newline, emptyInputValid bo
 	}
 	msgRecvd = c.readMessage()
 	if c.Err != nil {
-		log.Logger().Printf("reading failed from client %s: %s", (*c.Conn).RemoteAddr(), c.Err)
+		c.logger().Infof("reading failed from client %s: %s", (*c.Conn).RemoteAddr(), c.Err)
 		return
 	}
 	if !emptyInputValid && msgRecvd == "" {
-		log.Logger().Printf("empty string received from client %s: %s", (*c.Conn).RemoteAddr(), c.Err)
+		c.logger().Infof("empty string received from client %s: %s", (*c.Conn).RemoteAddr(), c.Err)
 		c.sendMessage(errEmptyInput.Error(), true)
 		if c.Err != nil {
-			log.Logger().Printf("sending empty msg msg to client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
+			c.logger().Errorf("sending empty msg msg to client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
 		}
 	}
 	return
@@ -309,8 +451,8 @@ func (c *client) userDashboard() {
 	for !exit {
 		userInput = c.showLandingPage()
 		choice, err := strconv.Atoi(userInput)
-		if c.Err == io.EOF { // connection is closed
-			log.Logger().Printf("connection closed from %s", (*c.Conn).RemoteAddr())
+		if c.Err != nil { // connection is closed, or the session is ended by the server
+			c.logger().Infof("connection closed from %s: %s", (*c.Conn).RemoteAddr(), c.Err)
 			break
 		} else if err != nil {
 			c.sendMessage(errInvalidInput.Error(), true)
@@ -319,9 +461,10 @@ func (c *client) userDashboard() {
 		switch choice {
 		case exitChoice:
 			c.exitClient()
+			c.loggingOut = true
 			exit = true
 		case startChatChoice:
-			c.seeOnlineFriends()
+			c.seeChatFriends()
 		case seeAllFriends:
 			c.seeFriends()
 		case sendInvitationChoice:
@@ -334,6 +477,10 @@ func (c *client) userDashboard() {
 			c.changeName()
 		case seeProfileChoice:
 			c.seePersonalProfile()
+		case groupChatsChoice:
+			c.seeGroups()
+		case blockListChoice:
+			c.seeBlockList()
 		default:
 			c.sendMessage(errInvalidInput.Error(), true)
 			continue
@@ -348,54 +495,260 @@ func (c *client) showLandingPage() string {
 
 // startChat initiates/resumes a chat b/w the current user and the given user
 func (c *client) starChat(friendID primitive.ObjectID) {
-	content, timestamp := c.User.GetChat(friendID)
-	c.sendMessage(content, true)
+	friend, err := user.GetUserByID(friendID)
+	if err != nil {
+		c.logger().Errorf("error fetching user %s details: %s", friendID.Hex(), err)
+		c.Err = errFetchUserFailed
+		return
+	}
+	if err = c.openChat(friend); err != nil {
+		c.sendMessage(fmt.Sprintf("Opening chat with %s failed: %s", friend.FirstName, err), true)
+		return
+	}
+	defer c.closeChat()
+	c.chatLoop(func(text string) error {
+		_, err := user.SendMessage(c.User.ID, friendID, text)
+		return err
+	})
+}
+
+// chatLoop reads the messages typed by the user in the opened chat and sends them, until the user quits. A
+// typing signal line, sent by the client as the user starts typing, is told to the others of the conversation.
+func (c *client) chatLoop(send func(text string) error) {
 	var input string
-	done := make(chan bool)
-	go c.pollIncomingMessages(friendID, done, timestamp)
 	for {
 		c.sendMessage(chatPrompt, false)
 		input = c.readMessage()
+		for c.Err == nil && input == typingSignal {
+			c.signalTyping()
+			input = c.readMessage()
+		}
+		if c.Err != nil {
+			c.stopTyping(true)
+			return
+		}
 		if input == "" {
 			c.sendMessage("Empty message can't be sent!!!", true)
 			continue
 		}
 		if strings.ToLower(input) == "q" {
-			done <- true // kill the incoming message listener
+			c.stopTyping(true)
 			break
 		}
-		err := user.SendMessage(c.User.ID, friendID, input, datastore.MongoConn().Collection(datastore.ChatCollection))
-		if err != nil {
-			log.Logger().Print(err)
+		if strings.ToLower(input) == "o" {
+			c.showOlderMessages()
+			continue
+		}
+		if strings.ToLower(input) == "e" {
+			c.editMessage()
+			continue
+		}
+		if strings.ToLower(input) == "d" {
+			c.deleteMessage()
+			continue
+		}
+		c.stopTyping(false)
+		if err := send(input); err != nil {
+			c.logger().Errorf("sending message failed: %s", err)
+			c.sendMessage(fmt.Sprintf("Sending message failed: %s", err), true)
+			continue
 		}
 		c.sendMessage(fmt.Sprintf("\bYou: %s\n", input), true)
 	}
 }
 
+// openChat displays the latest page of the chat history with the given friend, and starts delivering the incoming
+// messages from him
+func (c *client) openChat(friend *user.User) error {
+	return c.openSession(&chatSession{
+		peer:     friend.ID,
+		peerName: friend.FirstName,
+		history: func(before user.Cursor) (user.ChatPage, error) {
+			return c.User.GetChat(friend.ID, before)
+		},
+		markRead: func(until time.Time) error {
+			return c.User.MarkChatRead(friend.ID, until)
+		},
+		notifyTyping: func(typing bool) {
+			c.User.NotifyTyping(friend.ID, typing)
+		},
+		messages: func() ([]user.Message, error) {
+			return c.User.GetChatMessages(friend.ID, primitive.NilObjectID, 0)
+		},
+		edit: func(messageID primitive.ObjectID, text string) error {
+			_, err := c.User.EditMessage(friend.ID, messageID, text)
+			return err
+		},
+		remove: func(messageID primitive.ObjectID, forEveryone bool) error {
+			return c.User.DeleteMessage(friend.ID, messageID, forEveryone)
+		},
+	})
+}
+
+// openSession displays the latest page of the history of the conversation, and starts delivering its incoming
+// messages. The history is read holding the chat lock, so that a message coming meanwhile is either part of the
+// history or delivered afterwards, but never missed or shown twice. The displayed messages are marked as read.
+func (c *client) openSession(session *chatSession) error {
+	c.chatLock.Lock()
+	page, err := session.history(user.Cursor{})
+	if err != nil {
+		c.chatLock.Unlock()
+		return err
+	}
+	session.since, session.older, session.hasOlder = page.Latest, page.Older, page.HasOlder
+	c.chat = session
+	c.sendMessage(page.Content, true)
+	c.chatLock.Unlock()
+	c.markRead(session, page.Latest)
+	return nil
+}
+
+// markRead advances the read cursor of the user in the conversation up to the latest message displayed
+func (c *client) markRead(session *chatSession, until time.Time) {
+	if err := session.markRead(until); err != nil {
+		c.logger().Errorf("marking messages read failed: %s", err)
+	}
+}
+
+// showOlderMessages displays the page of the opened conversation before the oldest message displayed so far
+func (c *client) showOlderMessages() {
+	c.chatLock.Lock()
+	defer c.chatLock.Unlock()
+	if c.chat == nil {
+		return
+	}
+	if !c.chat.hasOlder {
+		c.sendMessage(noOlderMessagesMsg, true)
+		return
+	}
+	page, err := c.chat.history(c.chat.older)
+	if err != nil {
+		c.logger().Errorf("loading older messages failed: %s", err)
+		c.sendMessage(fmt.Sprintf("Loading older messages failed: %s", err), true)
+		return
+	}
+	c.chat.older, c.chat.hasOlder = page.Older, page.HasOlder
+	c.sendMessage(olderMessagesHeader+page.Content, true)
+}
+
+// signalTyping tells the others of the opened conversation that the user is typing, till he sends the message or
+// the typing times out
+func (c *client) signalTyping() {
+	if session := c.openedChat(); session != nil {
+		c.typing.start(session.conversation(), session.notifyTyping)
+	}
+}
+
+// stopTyping ends the typing of the user in the opened conversation, telling the others if asked to (i.e. unless
+// the message is being sent, which tells them by itself)
+func (c *client) stopTyping(notify bool) {
+	if session := c.openedChat(); session != nil {
+		c.typing.stop(session.conversation(), notify)
+	}
+}
+
+// openedChat gives the conversation currently opened by the user, nil if none
+func (c *client) openedChat() *chatSession {
+	c.chatLock.Lock()
+	defer c.chatLock.Unlock()
+	return c.chat
+}
+
+// closeChat stops delivering the incoming messages of the opened chat
+func (c *client) closeChat() {
+	c.chatLock.Lock()
+	defer c.chatLock.Unlock()
+	c.chat = nil
+}
+
+// deliverEvents pushes the events published for the user to the client, until the events channel is closed
+func (c *client) deliverEvents(events <-chan user.Event) {
+	for event := range events {
+		observeDelivery(event)
+		switch event.Type {
+		case user.MessageEvent, user.GroupMessageEvent:
+			c.deliverMessage(event)
+		case user.ReadEvent:
+			c.deliverReceipt(event)
+		case user.TypingEvent, user.TypingStoppedEvent:
+			c.deliverTyping(event)
+		case user.MessageEditedEvent, user.MessageDeletedEvent:
+			c.deliverChange(event)
+		}
+	}
+}
+
+// deliverMessage displays an incoming message if the conversation it belongs to is opened, marking it as read.
+// Other messages are left to the chat history.
+func (c *client) deliverMessage(event user.Event) {
+	c.chatLock.Lock()
+	session := c.chat
+	if session == nil || !session.receives(event) {
+		c.chatLock.Unlock()
+		return
+	}
+	session.since = event.Timestamp
+	sender := session.peerName
+	if event.Type == user.GroupMessageEvent {
+		sender = event.FromName
+	}
+	_ = c.write(fmt.Sprintf("\n\n%s (%s): %s\n\n", sender, event.Timestamp, event.Text) + chatPrompt)
+	c.chatLock.Unlock()
+	c.markRead(session, event.Timestamp)
+}
+
+// deliverReceipt tells the user that the friend of the opened chat has seen his messages
+func (c *client) deliverReceipt(event user.Event) {
+	c.chatLock.Lock()
+	defer c.chatLock.Unlock()
+	if c.chat == nil || !c.chat.group.IsZero() || c.chat.peer != event.From {
+		return
+	}
+	_ = c.write(fmt.Sprintf("\n\n(seen by %s)\n\n", c.chat.peerName) + chatPrompt)
+}
+
+// deliverTyping tells the user that someone of the opened conversation started, or stopped, typing
+func (c *client) deliverTyping(event user.Event) {
+	c.chatLock.Lock()
+	defer c.chatLock.Unlock()
+	if c.chat == nil || !c.chat.concerns(event) {
+		return
+	}
+	format := "\n\n%s is typing...\n\n"
+	if event.Type == user.TypingStoppedEvent {
+		format = "\n\n%s stopped typing\n\n"
+	}
+	_ = c.write(fmt.Sprintf(format, event.FromName) + chatPrompt)
+}
+
 // sendInvitation sends the invitation to a user
 func (c *client) sendInvitation() {
+	if c.Unverified {
+		c.sendMessage(fmt.Sprintf("\nSending invitations failed: %s", errEmailNotVerified), true)
+		return
+	}
 	c.sendMessage(sendInvitationInfo, true)
 	if c.Err != nil {
-		log.Logger().Printf("error sending invitation prompt to user %s: %s", c.User.Email, c.Err)
+		c.logger().Errorf("error sending invitation prompt to user %s: %s", c.User.Email, c.Err)
 		return
 	}
 	for {
 		email := c.sendAndReceiveMsg(emailSearchPrompt, false, false)
 		if c.Err != nil {
-			continue
+			return
 		}
 		email = strings.ToLower(email)
 		if email == "q" {
 			break
 		}
 		user, err := c.seePublicProfile(email)
-		if err == mongo.ErrNoDocuments { // user not found
+		if err != nil { // user not found
 			continue
 		}
 		c.sendMessage(fmt.Sprintf("Send invite to %s", email), false)
 		confirm := c.sendAndReceiveMsg("Confirm? (Y/n): ", false, true)
 		if c.Err != nil {
-			log.Logger().Println(err)
+			c.logger().Errorf("%s", err)
 			return
 		}
 		if strings.ToLower(confirm) == "y" || confirm == "" {
@@ -404,6 +757,8 @@ func (c *client) sendInvitation() {
 				successMsg := fmt.Sprintf("\nInvitation sent successfully to %s %s (%s)", user.FirstName,
 					user.LastName, user.Email)
 				c.sendMessage(successMsg, true)
+			} else {
+				c.sendMessage(fmt.Sprintf("\nSending invitation to %s failed: %s", user.Email, err), true)
 			}
 		}
 	}
@@ -414,7 +769,7 @@ func (c *client) seeInvitation() {
 	for {
 		userInput := c.sendAndReceiveMsg(invitationMenu, false, false)
 		if c.Err != nil {
-			continue
+			return
 		}
 		choice, err := strconv.Atoi(userInput)
 		if err != nil {
@@ -444,7 +799,7 @@ func (c *client) seeInvitation() {
 func (c *client) seeActiveReceivedInvitations() {
 	invites, err := c.User.GetReceivedInvitations()
 	if err != nil {
-		log.Logger().Printf("error fetching active received invitations for user %s: %s", c.Email, err)
+		c.logger().Errorf("error fetching active received invitations for user %s: %s", c.Email, err)
 		c.Err = errFetchReceivedInvitesFailed
 		return
 	}
@@ -453,18 +808,18 @@ func (c *client) seeActiveReceivedInvitations() {
 		userProfile, _ := user.UserProfile(invite)
 		c.sendMessage(fmt.Sprintf("%d - %s", idx+1, userProfile), true)
 	}
-	userInput := c.sendAndReceiveMsg("\nChoose one to accept or reject(\"b to go back\"): ", false,
+	userInput := c.sendAndReceiveMsg("\nChoose one to accept or reject(\"b\" to go back): ", false,
 		false)
 	if c.Err != nil {
-		log.Logger().Printf("error receiving user invitation msg from client %s: %s", (*c.Conn).RemoteAddr(), err)
+		c.logger().Errorf("error receiving user invitation msg from client %s: %s", (*c.Conn).RemoteAddr(), err)
 		return
 	}
 	if strings.ToLower(userInput) == "b" {
 		return
 	}
 	invitationIdx, err := strconv.Atoi(userInput)
-	if err != nil || invitationIdx < 0 || invitationIdx > len(invites) {
-		log.Logger().Printf("invitation index msg %s parsing failed from client %s: %s", userInput,
+	if err != nil || invitationIdx < 1 || invitationIdx > len(invites) {
+		c.logger().Warnf("invitation index msg %s parsing failed from client %s: %s", userInput,
 			(*c.Conn).RemoteAddr(), userInput)
 		c.Err = errInvalidInput
 		c.sendMessage(fmt.Sprintf("Invalid choice: %s", userInput), true)
@@ -473,7 +828,7 @@ func (c *client) seeActiveReceivedInvitations() {
 	// The user sees 1-based indexing, so reducing one from it
 	inviteeUser, err := user.GetUserByID(invites[invitationIdx-1]) // user who sent this invitation
 	if err != nil {
-		log.Logger().Printf("fetching invitee user %s details failed from client %s: %s", invites[invitationIdx],
+		c.logger().Errorf("fetching invitee user %s details failed from client %s: %s", invites[invitationIdx],
 			(*c.Conn).RemoteAddr(), userInput)
 		c.Err = errInternalError
 		c.sendMessage("Internal error. Try again", true)
@@ -483,22 +838,36 @@ func (c *client) seeActiveReceivedInvitations() {
 	c.sendMessage("\n===== Invitation Details =====\n", true)
 	c.sendMessage(fmt.Sprintf("Name: %s %s", inviteeUser.FirstName, inviteeUser.LastName), true)
 	c.sendMessage(fmt.Sprintf("Email: %s", inviteeUser.Email), true)
-	confirm := c.sendAndReceiveMsg("\nConfirm(Y/n): ", false, true)
+	action := c.sendAndReceiveMsg(invitationActionPrompt, false, true)
 	if c.Err != nil {
 		return
 	}
-	if strings.ToLower(confirm) == "y" || confirm == "" {
+	switch strings.ToLower(action) {
+	case "a", "":
 		err = c.User.AddFriend(inviteeUser.ID)
-		if err != nil {
+		if err == user.ErrNotVerified {
+			c.sendMessage(fmt.Sprintf("\nAdding %s as friend failed: %s\n", inviteeUser.Email, errEmailNotVerified), true)
+		} else if err != nil {
 			c.sendMessage(fmt.Sprintf("\nAdding %s as friend failed\n", inviteeUser.Email), true)
-			log.Logger().Printf("adding %s as friend to %s failed: %s", c.User.Email, inviteeUser.Email, err)
+			c.logger().Errorf("adding %s as friend to %s failed: %s", c.User.Email, inviteeUser.Email, err)
 			c.Err = errInternalError
 		} else {
 			successMsg := fmt.Sprintf("\nAdded %s as friend successfully\n",
 				inviteeUser.FirstName+" "+inviteeUser.LastName)
 			c.sendMessage(successMsg, true)
-			log.Logger().Print(successMsg)
+			c.logger().Infof("added %s as friend", inviteeUser.Email)
 		}
+	case "r":
+		err = c.User.RejectInvitation(inviteeUser.ID)
+		if err != nil {
+			c.sendMessage(fmt.Sprintf("\nRejecting invitation from %s failed\n", inviteeUser.Email), true)
+			c.logger().Errorf("rejecting invitation from %s to %s failed: %s", inviteeUser.Email, c.User.Email, err)
+			c.Err = errRejectInviteFailed
+		} else {
+			c.sendMessage(fmt.Sprintf("\nInvitation from %s rejected\n", inviteeUser.Email), true)
+		}
+	case "x":
+		c.confirmBlock(inviteeUser)
 	}
 }
 
@@ -506,7 +875,7 @@ func (c *client) seeActiveReceivedInvitations() {
 func (c *client) seeActiveSentInvitations() {
 	invites, err := c.User.GetSentInvitations()
 	if err != nil {
-		log.Logger().Printf("error while fetching active sent invitations for user %s: %s", c.Email, err)
+		c.logger().Errorf("error while fetching active sent invitations for user %s: %s", c.Email, err)
 		c.Err = errFetchSentInvitesFailed
 		return
 	}
@@ -517,15 +886,15 @@ func (c *client) seeActiveSentInvitations() {
 	}
 	userInput := c.sendAndReceiveMsg("\nChoose one to cancel(\"b to go back\"): ", false, false)
 	if c.Err != nil {
-		log.Logger().Printf("error while seeing active user invitation sent from client %s: %s", (*c.Conn).RemoteAddr(), err)
+		c.logger().Errorf("error while seeing active user invitation sent from client %s: %s", (*c.Conn).RemoteAddr(), err)
 		return
 	}
 	if strings.ToLower(userInput) == "b" {
 		return
 	}
 	invitationIdx, err := strconv.Atoi(userInput)
-	if err != nil || invitationIdx < 0 || invitationIdx > len(invites) {
-		log.Logger().Printf("invitation index msg %s parsing failed from client %s: %s", userInput,
+	if err != nil || invitationIdx < 1 || invitationIdx > len(invites) {
+		c.logger().Warnf("invitation index msg %s parsing failed from client %s: %s", userInput,
 			(*c.Conn).RemoteAddr(), userInput)
 		c.Err = errInvalidInput
 		c.sendMessage(fmt.Sprintf("Invalid choice: %s", userInput), true)
@@ -535,13 +904,13 @@ func (c *client) seeActiveSentInvitations() {
 	// The user sees 1-based indexing, so reducing one from it
 	inviteeUser, err := user.GetUserByID(invites[invitationIdx-1]) // user who sent this invitation
 	if err != nil {
-		log.Logger().Println(err)
+		c.logger().Errorf("%s", err)
 		return
 	}
 
 	confirm := c.sendAndReceiveMsg("\nConfirm(Y/n): ", false, true)
 	if c.Err != nil {
-		log.Logger().Printf("canceling invitation failed: %s", c.Err)
+		c.logger().Errorf("canceling invitation failed: %s", c.Err)
 		c.sendMessage(fmt.Sprintf("Invalid choice: %s", userInput), true)
 		return
 	}
@@ -549,96 +918,71 @@ func (c *client) seeActiveSentInvitations() {
 		err = c.User.CancelInvitation(inviteeUser)
 		if err != nil {
 			c.sendMessage(fmt.Sprintf("\nCancelling invitation to %s failed\n", inviteeUser.Email), true)
-			log.Logger().Printf("cancelling invitation from %s to %s failed: %s", c.User.Email, inviteeUser.Email, err)
+			c.logger().Errorf("cancelling invitation from %s to %s failed: %s", c.User.Email, inviteeUser.Email, err)
 			c.Err = errCancelInviteFailed
 		} else {
 			c.sendMessage(fmt.Sprintf("\nInvitation to %s successfully cancelled\n", inviteeUser.Email), true)
-			log.Logger().Printf("cancelling invitation from %s to %s succeeded", c.User.Email, inviteeUser.Email)
+			c.logger().Infof("cancelling invitation from %s to %s succeeded", c.User.Email, inviteeUser.Email)
 		}
 	}
 }
 
-// seeInactiveReceivedInvitations displays the list of inactive invitations received from other users
+// seeInactiveReceivedInvitations displays the invitations received from other users, which are no longer active,
+// along with what happened to each one
 func (c *client) seeInactiveReceivedInvitations() {
-	invites, err := c.User.GetSentInvitations()
+	invites, err := c.User.GetInactiveReceivedInvitations()
 	if err != nil {
-		log.Logger().Printf("error while fetching active sent invitations for user %s: %s", c.Email, err)
-		c.Err = errFetchSentInvitesFailed
-		return
-	}
-	c.sendMessage("\n**** Active Sent Invitations ****\n", true)
-	for idx, invite := range invites {
-		userProfile, _ := user.UserProfile(invite)
-		c.sendMessage(fmt.Sprintf("%d - %s", idx+1, userProfile), true)
-	}
-	userInput := c.sendAndReceiveMsg("\nChoose one to cancel(\"b to go back\"): ", false, false)
-	if c.Err != nil {
-		log.Logger().Printf("error while seeing active user invitation sent from client %s: %s", (*c.Conn).RemoteAddr(), err)
-		c.Err = errInternalError
-		return
-	}
-	if strings.ToLower(userInput) == "b" {
-		return
-	}
-	invitationIdx, err := strconv.Atoi(userInput)
-	if err != nil || invitationIdx < 0 || invitationIdx > len(invites) {
-		log.Logger().Printf("invitation index msg %s parsing failed from client %s: %s", userInput,
-			(*c.Conn).RemoteAddr(), userInput)
-		c.Err = errInvalidInput
-		c.sendMessage(fmt.Sprintf("Invalid choice: %s", userInput), true)
+		c.logger().Errorf("error while fetching inactive received invitations for user %s: %s", c.Email, err)
+		c.Err = errFetchReceivedInvitesFailed
 		return
 	}
-	// The user sees 1-based indexing, so reducing one from it
-	inviteeUser, err := user.GetUserByID(invites[invitationIdx-1]) // user who sent this invitation
+	c.showInviteOutcomes("Inactive Received Invitations", invites)
+}
+
+// seeInactiveSentInvitations displays the invitations sent to other users, which are no longer active,
+// along with what happened to each one
+func (c *client) seeInactiveSentInvitations() {
+	invites, err := c.User.GetInactiveSentInvitations()
 	if err != nil {
-		log.Logger().Printf("error fetching user %s details: %s", invites[invitationIdx-1], err)
-		c.Err = errFetchUserFailed
+		c.logger().Errorf("error while fetching inactive sent invitations for user %s: %s", c.Email, err)
+		c.Err = errFetchSentInvitesFailed
 		return
 	}
+	c.showInviteOutcomes("Inactive Sent Invitations", invites)
+}
 
-	confirm := c.sendAndReceiveMsg("\nConfirm(Y/n): ", false, true)
-	if c.Err != nil {
-		log.Logger().Printf("error getting confirmation: %s", c.Err)
-		c.Err = errInvalidInput
-		return
+// showInviteOutcomes displays the given inactive invitations under the given title
+func (c *client) showInviteOutcomes(title string, invites []user.InviteOutcome) {
+	c.sendMessage(fmt.Sprintf("\n**** %s ****\n", title), true)
+	if len(invites) == 0 {
+		c.sendMessage("No invitations", true)
 	}
-	if strings.ToLower(confirm) == "y" || confirm == "" {
-		err = c.User.CancelInvitation(inviteeUser)
-		if err != nil {
-			c.sendMessage(fmt.Sprintf("\nCancelling invitation to %s failed\n", inviteeUser.Email), true)
-			log.Logger().Printf("cancelling invitation from %s to %s failed: %s", c.User.Email, inviteeUser.Email, err)
-			c.Err = errCancelInviteFailed
-		} else {
-			c.sendMessage(fmt.Sprintf("\nInvitation to %s successfully cancelled\n", inviteeUser.Email), true)
-			log.Logger().Printf("cancelling invitation from %s to %s succeeded", c.User.Email, inviteeUser.Email)
-		}
+	for idx, invite := range invites {
+		userProfile, _ := user.UserProfile(invite.UserID)
+		c.sendMessage(fmt.Sprintf("%d - %s => %s on %s", idx+1, userProfile, invite.Status,
+			invite.Timestamp.Format(time.RFC1123)), true)
 	}
 }
 
-// seeInactiveSentInvitations displays the list of inactive invitations sent to other users
-func (c *client) seeInactiveSentInvitations() {
-
-}
-
 // changePassword enables user to change his/her password
 func (c *client) changePassword() {
 	var failureCount int
-	for failureCount = 0; failureCount < 3; failureCount++ {
+	for failureCount = 0; failureCount < limits.MaxAttempts; failureCount++ {
 		currPassword := c.sendAndReceiveMsg("\nEnter your current password: ", false, false)
 		if c.Err != nil {
 			continue
 		}
 		if err := bcrypt.CompareHashAndPassword([]byte(c.Password), []byte(currPassword)); err != nil {
-			log.Logger().Printf("user %s entered incorrect password: %s", c.Email, err)
+			c.logger().Warnf("user %s entered incorrect password: %s", c.Email, err)
 			c.Err = errIncorrectPassword
 			continue
 		}
 		break
 	}
-	if failureCount == 3 {
+	if failureCount == limits.MaxAttempts {
 		return // user unable to enter current password
 	}
-	for failureCount = 0; failureCount < 3; failureCount++ {
+	for failureCount = 0; failureCount < limits.MaxAttempts; failureCount++ {
 		newPassword := c.sendAndReceiveMsg("\nEnter your new password: ", false, false)
 		if c.Err != nil {
 			continue
@@ -653,18 +997,18 @@ func (c *client) changePassword() {
 			continue
 		}
 		if newPassword != confirmNewPassword {
-			log.Logger().Print(errPasswordNotMatched)
+			c.logger().Warnf("%s", errPasswordNotMatched)
 			c.sendMessage(errPasswordNotMatched.Error(), true)
 			c.Err = errPasswordNotMatched
 			continue
 		}
 		passwordHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 		if err != nil {
-			log.Logger().Println(err)
+			c.logger().Errorf("%s", err)
 			c.Err = errInternalError
 			return
 		}
-		err = c.User.UpdatePassword(string(passwordHash))
+		err = c.User.UpdatePassword(string(passwordHash), c.session.ID) // the other sessions are revoked
 		if err != nil {
 			c.Err = errUpdateUserPasswordFailed
 			c.sendMessage("Password update failed. Please try again.\n", true)
@@ -679,20 +1023,20 @@ func (c *client) changePassword() {
 func (c *client) changeName() {
 	newFirstName := c.sendAndReceiveMsg("\nEnter your new first name(enter blank for skip): ", false, true)
 	if c.Err != nil {
-		log.Logger().Printf("error getting entered first name: %s", c.Err)
+		c.logger().Errorf("error getting entered first name: %s", c.Err)
 		return
 	}
 	if newFirstName == "" {
-		log.Logger().Printf("skipping first name change for user %s", c.Email)
+		c.logger().Infof("skipping first name change for user %s", c.Email)
 	}
 
 	newLastName := c.sendAndReceiveMsg("\nEnter your new last name(enter blank for skip): ", false, true)
 	if c.Err != nil {
-		log.Logger().Printf("error getting entered last name: %s", c.Err)
+		c.logger().Errorf("error getting entered last name: %s", c.Err)
 		return
 	}
 	if newLastName == "" {
-		log.Logger().Printf("skipping first name change for user %s", c.Email)
+		c.logger().Infof("skipping first name change for user %s", c.Email)
 	}
 
 	err := c.User.UpdateName(newFirstName, newLastName)
@@ -705,7 +1049,8 @@ func (c *client) changeName() {
 	c.sendMessage("Name successfully updated\n", true)
 }
 
-// seePersonalProfile displays the profile for the current user
+// seePersonalProfile displays the profile for the current user, letting him turn his two-factor authentication
+// on or off
 func (c *client) seePersonalProfile() {
 	details := "\n************ Profile ************ \n"
 	details += fmt.Sprintf("\nFirst Name: %s\n", c.User.FirstName)
@@ -713,24 +1058,40 @@ func (c *client) seePersonalProfile() {
 	details += fmt.Sprintf("Email: %s\n", c.User.Email)
 	details += fmt.Sprintf("Last Login: %s\n", c.User.LastLogin)
 	c.sendMessage(details, true)
+	if c.Err != nil {
+		return
+	}
+	c.manageTwoFactor()
 }
 
 // exitClient displays the exiting message to client
 func (c *client) exitClient() {
 	c.sendMessage(exitingMsg, true)
 	if c.Err != nil {
-		log.Logger().Printf("sending exit msg to client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
+		c.logger().Errorf("sending exit msg to client %s failed: %s", (*c.Conn).RemoteAddr(), c.Err)
 	}
 }
 
-// seePublicProfile allow a client to see other person's basic detail before sending invitation
+// endSession tells the client that the server is going away, and makes the session end as soon as it waits for
+// the next input, letting what it is doing meanwhile finish
+func (c *client) endSession() {
+	if atomic.LoadInt32(&c.jsonMode) == 1 {
+		_ = c.writeJSON(jsonResponse{Type: shutdownResponse, OK: true})
+	} else {
+		_ = c.write(shutdownMsg)
+	}
+	_ = (*c.Conn).SetReadDeadline(time.Now())
+}
+
+// seePublicProfile allow a client to see other person's basic detail before sending invitation. A person who has
+// blocked the client isn't found.
 func (c *client) seePublicProfile(email string) (usr *user.User, err error) {
-	usr, err = user.GetUserByEmail(email)
+	usr, err = findUser(c.User, email)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
+		if err == datastore.ErrNoDocFound {
 			c.sendMessage(fmt.Sprintf("\nNo usr found with given email %s", email), true)
 		}
-		log.Logger().Printf("error while sending no usr found msg: %s", c.Err)
+		c.logger().Errorf("error while sending no usr found msg: %s", c.Err)
 		return
 	}
 	c.sendMessage(fmt.Sprintf("\nusr found => First Name: %s, LastName: %s, Email: %s", usr.FirstName, usr.LastName,
@@ -738,93 +1099,98 @@ func (c *client) seePublicProfile(email string) (usr *user.User, err error) {
 	return
 }
 
-// seeOnlineFriends displays the list of friends (already connected)
-// who are currently logged in the service
-func (c *client) seeOnlineFriends() {
-	friends, err := c.User.SeeFriends()
-	if err != nil {
-		log.Logger().Printf("error while fetching online friends for client %s: %s", (*c.Conn).RemoteAddr(), err)
-		c.Err = errFetchUserFriendsFailed
+// seeChatFriends displays the list of friends (already connected) along with their presence, to choose the one
+// to chat with
+func (c *client) seeChatFriends() {
+	friends, ok := c.showFriends()
+	if !ok {
 		return
 	}
-	c.sendMessage("\n****************** Online Friends List *****************\n", true)
-	for idx, friend := range friends {
-		userProfile, _ := user.UserProfile(friend)
-		c.sendMessage(fmt.Sprintf("%d - %s", idx+1, userProfile), true)
-	}
 	userInput := c.sendAndReceiveMsg("Enter a friend's index to start chat: ", false, false)
 	friendIdx, err := strconv.Atoi(userInput)
-	if err != nil {
-		log.Logger().Printf("error while parsing user msg %s to start chat: %s", userInput, err)
+	if err != nil || friendIdx < 1 || friendIdx > len(friends) {
+		c.logger().Errorf("error while parsing user msg %s to start chat: %v", userInput, err)
 		c.Err = errInvalidInput
 		return
 	}
-	c.starChat(friends[friendIdx-1])
+	c.starChat(friends[friendIdx-1].UserID)
 }
 
-// seeFriends displays the list of friends (already connected) to current user
+// seeFriends displays the list of friends (already connected) to current user, any of whom can be removed
 func (c *client) seeFriends() {
-	friends, err := c.User.SeeFriends()
-	if err != nil {
-		log.Logger().Printf("error while fetching friends for client %s: %s", (*c.Conn).RemoteAddr(), err)
-		c.Err = errFetchUserFriendsFailed
+	friends, ok := c.showFriends()
+	if !ok {
 		return
 	}
-	c.sendMessage("\n****************** Friends List *****************\n", true)
-	for idx, friend := range friends {
-		userProfile, _ := user.UserProfile(friend)
-		c.sendMessage(fmt.Sprintf("%d - %s", idx+1, userProfile), true)
-	}
 	for {
-		userInput := c.sendAndReceiveMsg("\nEnter 'b' to go back: ", false, false)
-		if userInput == "b" {
+		userInput := c.sendAndReceiveMsg(friendsListPrompt, false, false)
+		if userInput == "b" || c.Err != nil {
 			break
 		}
-		c.sendMessage(fmt.Sprintf("Invalid msg: %s", userInput), true)
+		friendIdx, err := strconv.Atoi(userInput)
+		if err != nil || friendIdx < 1 || friendIdx > len(friends) {
+			c.sendMessage(fmt.Sprintf("Invalid msg: %s", userInput), true)
+			continue
+		}
+		c.removeFriend(friends[friendIdx-1].UserID)
+		break
 	}
 }
 
-// logoutUser cleanly logs out the current user and free the resource
-func (c *client) logoutUser() {
-	if c.User.Email != "" {
-		err := c.User.Logout()
-		if err != nil {
-			log.Logger().Printf("error while logging out client %s: %s", c.User.Email, err)
-			c.Err = errLogoutFailed
-		}
+// removeFriend ends the friendship with the given friend once the user confirms it, deleting their chat history
+// too if the user asks for it
+func (c *client) removeFriend(friendID primitive.ObjectID) {
+	userProfile, _ := user.UserProfile(friendID)
+	confirm := c.sendAndReceiveMsg(fmt.Sprintf(removeFriendConfirmation, userProfile), false, true)
+	if c.Err != nil || strings.ToLower(confirm) != "y" {
+		return
+	}
+	deleteHistory := c.sendAndReceiveMsg(deleteHistoryConfirmation, false, true)
+	if c.Err != nil {
+		return
+	}
+	if err := c.User.RemoveFriend(friendID, strings.ToLower(deleteHistory) != "y"); err != nil {
+		c.sendMessage(fmt.Sprintf("\nRemoving friend failed: %s\n", err), true)
+		return
 	}
+	c.sendMessage(fmt.Sprintf("\n%s removed from your friends. Send an invitation to be friends again.\n",
+		userProfile), true)
 }
 
-// pollIncomingMessages checks for any new incoming message from a particular user,
-// received after the given timestamp
-func (c *client) pollIncomingMessages(other primitive.ObjectID, done chan bool, processed time.Time) {
-	otherUser, err := user.GetUserByID(other)
+// showFriends displays the friends of the current user, telling whether each one is online or when he was last
+// seen, and how many of his messages are unread. It tells whether there is any friend to choose from.
+func (c *client) showFriends() (friends []user.Presence, ok bool) {
+	friends, err := c.User.FriendsPresence()
 	if err != nil {
-		log.Logger().Printf("error fetching user %s details: %s", c.User.Email, err)
-		c.Err = errFetchUserFailed
+		c.logger().Errorf("error while fetching friends for client %s: %s", (*c.Conn).RemoteAddr(), err)
+		c.Err = errFetchUserFriendsFailed
 		return
 	}
-	pollTick := time.NewTicker(incomingMsgPollInterval)
-	for {
-		select {
-		case <-done: // clean exit
-			return
-		case <-pollTick.C:
-			incomingMessages, _ := user.FetchIncomingMessages(processed, c.User.ID, other)
-			for _, msg := range incomingMessages {
-				processed = msg.Timestamp
-				c.sendMessage(fmt.Sprintf("\n\n%s (%s): %s\n", otherUser.FirstName, msg.Timestamp, msg.Text),
-					true)
-				c.sendMessage(chatPrompt, false)
-			}
-		}
+	if len(friends) == 0 {
+		c.sendMessage("\nYou have no friends yet. Send an invitation to make one.", true)
+		return
+	}
+	c.sendMessage("\n****************** Friends List *****************\n", true)
+	for idx, friend := range friends {
+		userProfile, _ := user.UserProfile(friend.UserID)
+		unread, _ := c.User.UnreadChatCount(friend.UserID)
+		c.sendMessage(fmt.Sprintf("%d - %s (%s)%s", idx+1, userProfile, friend, unreadInfo(unread)), true)
+	}
+	return friends, true
+}
+
+// unreadInfo tells how many messages of a conversation are unread, if any
+func unreadInfo(unread int) string {
+	if unread == 0 {
+		return ""
 	}
+	return fmt.Sprintf(" [%d unread]", unread)
 }
 
 // validatePassword checks whether the passowrd is an acceptable password or not
 func validatePassword(password string) (err error) {
-	if len(password) < passwordMinLength {
-		log.Logger().Printf("%s password: %s", errShortPassword, password)
+	if len(password) < limits.PasswordMinLength {
+		log.Logger().Debugf("%s", errShortPassword)
 		err = errShortPassword
 	}
 	return
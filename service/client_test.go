@@ -5,6 +5,7 @@ package service
 import (
 	"bufio"
 	"context"
+	"fmt"
 	user2 "gibber/user"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,6 +13,7 @@ import (
 	"log"
 	"math/rand"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -85,6 +87,311 @@ func TestClient(t *testing.T) {
 	// connection closed from server as exit option selected
 }
 
+func TestClient_IncomingMessage(t *testing.T) {
+	user1, user2 := createTestFriends(t)
+
+	conn1 := dialTestServer(t)
+	defer conn1.close()
+	conn1.login(user1.Email, "password")
+	conn1.send("1") // start chat
+	conn1.expect("Enter a friend's index to start chat: ")
+	conn1.send("1")
+	conn1.expect(chatPrompt)
+
+	conn2 := dialTestServer(t)
+	defer conn2.close()
+	conn2.login(user2.Email, "password")
+	conn2.send("1")
+	conn2.expect("Enter a friend's index to start chat: ")
+	conn2.send("1")
+	conn2.expect(chatPrompt)
+	conn2.send("hello there")
+
+	// pushed to the other user without any polling delay
+	conn1.expect(user2.FirstName)
+	conn1.expect("hello there")
+	conn1.send("q")
+	conn2.send("q")
+}
+
+func TestClient_OlderMessages(t *testing.T) {
+	self, friend := createTestFriends(t)
+	for i := 0; i < user2.ChatPageSize+1; i++ {
+		_, err := user2.SendMessage(friend.ID, self.ID, fmt.Sprintf("message %02d", i))
+		assert.NoError(t, err, "sending failed")
+	}
+
+	conn := dialTestServer(t)
+	defer conn.close()
+	conn.login(self.Email, "password")
+	conn.send("1") // start chat
+	conn.expect("Enter a friend's index to start chat: ")
+	conn.send("1")
+	conn.expect(fmt.Sprintf("message %02d", user2.ChatPageSize)) // latest page only
+	conn.expect(chatPrompt)
+	conn.send("o")
+	conn.expect(olderMessagesHeader)
+	conn.expect("message 00")
+	conn.expect(chatPrompt)
+	conn.send("o")
+	conn.expect(noOlderMessagesMsg)
+	conn.send("q")
+}
+
+func TestClient_UnreadAndSeen(t *testing.T) {
+	self, friend := createTestFriends(t)
+	_, err := user2.SendMessage(self.ID, friend.ID, "are you there?")
+	assert.NoError(t, err, "sending failed")
+
+	conn1 := dialTestServer(t)
+	defer conn1.close()
+	conn1.login(self.Email, "password")
+	conn1.send("1") // start chat
+	conn1.expect("Enter a friend's index to start chat: ")
+	conn1.send("1")
+	conn1.expect("are you there?")
+	conn1.expect(chatPrompt) // not seen yet
+
+	conn2 := dialTestServer(t)
+	defer conn2.close()
+	conn2.login(friend.Email, "password")
+	conn2.send("1")
+	conn2.expect("[1 unread]")
+	conn2.expect("Enter a friend's index to start chat: ")
+	conn2.send("1")
+	conn2.expect(chatPrompt)
+
+	// the message is displayed to the friend, and so seen
+	conn1.expect(fmt.Sprintf("(seen by %s)", friend.FirstName))
+	unread, err := friend.UnreadChatCount(self.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, unread, "displayed messages are read")
+	conn1.send("q")
+	conn2.send("q")
+}
+
+func TestClient_Typing(t *testing.T) {
+	self, friend := createTestFriends(t)
+
+	conn1 := dialTestServer(t)
+	defer conn1.close()
+	conn1.login(self.Email, "password")
+	conn1.send("1") // start chat
+	conn1.expect("Enter a friend's index to start chat: ")
+	conn1.send("1")
+	conn1.expect(chatPrompt)
+
+	conn2 := dialTestServer(t)
+	defer conn2.close()
+	conn2.login(friend.Email, "password")
+	conn2.send("1")
+	conn2.expect("Enter a friend's index to start chat: ")
+	conn2.send("1")
+	conn2.expect(chatPrompt)
+
+	conn2.send(typingSignal)
+	conn1.expect(fmt.Sprintf("%s is typing...", friend.FirstName))
+	conn2.send("almost done")
+	conn1.expect("almost done")
+	conn2.expect("You: almost done")
+
+	// typing, then leaving the chat without sending
+	conn2.send(typingSignal)
+	conn1.expect(fmt.Sprintf("%s is typing...", friend.FirstName))
+	conn2.send("q")
+	conn1.expect(fmt.Sprintf("%s stopped typing", friend.FirstName))
+	conn1.send("q")
+}
+
+func TestClient_EditAndDeleteMessage(t *testing.T) {
+	self, friend := createTestFriends(t)
+
+	conn1 := dialTestServer(t)
+	defer conn1.close()
+	conn1.login(self.Email, "password")
+	conn1.send("1") // start chat
+	conn1.expect("Enter a friend's index to start chat: ")
+	conn1.send("1")
+	conn1.expect(chatPrompt)
+
+	conn2 := dialTestServer(t)
+	defer conn2.close()
+	conn2.login(friend.Email, "password")
+	conn2.send("1")
+	conn2.expect("Enter a friend's index to start chat: ")
+	conn2.send("1")
+	conn2.expect(chatPrompt)
+
+	conn1.send("helo")
+	conn2.expect("helo")
+	conn1.send("e")
+	conn1.expect("1 - You")
+	conn1.expect(messageIndexPrompt)
+	conn1.send("1")
+	conn1.expect(editTextPrompt)
+	conn1.send("hello")
+	conn1.expect("You: hello" + user2.EditedMarker)
+	conn2.expect(fmt.Sprintf("%s edited a message", self.FirstName))
+	conn2.expect("hello" + user2.EditedMarker)
+
+	conn1.send("d")
+	conn1.expect(messageIndexPrompt)
+	conn1.send("1")
+	conn1.expect(deleteForAllPrompt)
+	conn1.send("y")
+	conn1.expect(messageDeletedForAllMsg)
+	conn2.expect(fmt.Sprintf("%s deleted a message", self.FirstName))
+	conn2.expect(user2.DeletedMessageText)
+
+	conn2.send("e")
+	conn2.expect(noMessageToEditMsg)
+	conn1.send("q")
+	conn2.send("q")
+}
+
+func TestClient_MissedMessages(t *testing.T) {
+	self, friend := createTestFriends(t)
+	_, err := user2.SendMessage(friend.ID, self.ID, "ping")
+	assert.NoError(t, err, "sending failed")
+	_, err = user2.SendMessage(friend.ID, self.ID, "are you there?")
+	assert.NoError(t, err, "sending failed")
+
+	conn := dialTestServer(t)
+	defer conn.close()
+	conn.expect("Email: ")
+	conn.send(self.Email)
+	conn.expect("Password: ")
+	conn.send("password")
+	conn.expect(missedMessagesHeader)
+	conn.expect(fmt.Sprintf("1 - %s: 2 new messages, latest from %s: \"are you there?\"", friend.FirstName,
+		friend.FirstName))
+	conn.expect(missedChoicePrompt)
+	conn.send("1") // jump into the chat
+	conn.expect("ping")
+	conn.expect(chatPrompt)
+	conn.send("q")
+	conn.expect("Enter a choice: ")
+	conn.send("0")
+}
+
+func TestClient_RejectInvitation(t *testing.T) {
+	sender, receiver := createTestUser(t, "John"), createTestUser(t, "Jane")
+	assert.NoError(t, sender.SendInvitation(receiver), "sending invitation failed")
+
+	conn := dialTestServer(t)
+	defer conn.close()
+	conn.login(receiver.Email, "password")
+	conn.send("4") // see all invitations
+	conn.expect("Enter a choice: ")
+	conn.send("2") // active received invitations
+	conn.expect("1 - John Doe : " + sender.Email)
+	conn.expect("Choose one to accept or reject")
+	conn.send("1")
+	conn.expect(invitationActionPrompt)
+	conn.send("r")
+	conn.expect("Invitation from " + sender.Email + " rejected")
+	conn.expect("Enter a choice: ")
+	conn.send("4") // inactive received invitations
+	conn.expect("1 - John Doe : " + sender.Email + " => rejected on ")
+	conn.expect("Enter a choice: ")
+	conn.send("0")
+	conn.expect("Enter a choice: ")
+	conn.send("0")
+	conn.expect(exitingMsg)
+
+	invites, err := sender.GetInactiveSentInvitations()
+	assert.NoError(t, err, "fetching inactive sent invitations failed")
+	assert.Equal(t, 1, len(invites), "rejection should be seen by the sender")
+}
+
+// testConn is a client connection to the test server, driving the user flow through its prompts
+type testConn struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialTestServer connects a new client to the test server
+func dialTestServer(t *testing.T) *testConn {
+	conn, err := net.Dial("tcp", "localhost:44517")
+	if err != nil {
+		t.Fatalf("unable to connect to tcp server at localhost:44517 : %s", err)
+	}
+	return &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// expect reads from the server until the given text is received, failing the test if it doesn't come in time
+func (tc *testConn) expect(text string) (received string) {
+	return tc.expectAny(text)
+}
+
+// expectAny reads from the server until any of the given texts is received, failing the test if none comes in time
+func (tc *testConn) expectAny(texts ...string) (received string) {
+	_ = tc.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	containsAny := func() bool {
+		for _, text := range texts {
+			if strings.Contains(received, text) {
+				return true
+			}
+		}
+		return false
+	}
+	for !containsAny() {
+		b, err := tc.reader.ReadByte()
+		if err != nil {
+			tc.t.Fatalf("expecting %q failed, received %q: %s", texts, received, err)
+		}
+		received += string(b)
+	}
+	return
+}
+
+// send sends a line of user input to the server
+func (tc *testConn) send(line string) {
+	_, err := tc.conn.Write([]byte(line + "\n"))
+	assert.NoError(tc.t, err, "writing %q failed", line)
+}
+
+// login logs in the given existing user, till the dashboard menu is shown. The summary of the missed messages
+// (if any) is skipped.
+func (tc *testConn) login(email, password string) {
+	tc.expect("Email: ")
+	tc.send(email)
+	tc.expect("Password: ")
+	tc.send(password)
+	tc.expect("Logged In Successfully")
+	if strings.Contains(tc.expectAny("Enter a choice: ", missedChoicePrompt), missedChoicePrompt) {
+		tc.send("")
+		tc.expect("Enter a choice: ")
+	}
+}
+
+// close closes the client connection
+func (tc *testConn) close() {
+	_ = tc.conn.Close()
+}
+
+// createTestUser creates a new user with "password" as password
+func createTestUser(t *testing.T, firstName string) *user2.User {
+	usr := &user2.User{
+		FirstName: firstName,
+		LastName:  "Doe",
+		Email:     strings.ToLower("john" + randomString(20) + "@doe.com"),
+		Password:  "password",
+	}
+	_, err := user2.CreateUser(usr)
+	assert.NoError(t, err, "user creation failed")
+	return usr
+}
+
+// createTestFriends creates two new users who are friends
+func createTestFriends(t *testing.T) (usr1, usr2 *user2.User) {
+	usr1, usr2 = createTestUser(t, "John"), createTestUser(t, "Jane")
+	assert.NoError(t, usr1.SendInvitation(usr2), "sending invitation failed")
+	assert.NoError(t, usr2.AddFriend(usr1.ID), "accepting invitation failed")
+	return
+}
+
 var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
 
 const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
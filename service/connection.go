@@ -5,14 +5,25 @@ import (
 	"gibber/log"
 	"net"
 	"strings"
+	"sync"
 )
 
 // Connection details of the TCP connection b/w client and service
 type Connection struct {
-	Conn   *net.Conn
-	Reader *bufio.Reader
-	Writer *bufio.Writer
-	Err    error
+	Conn      *net.Conn
+	Reader    *bufio.Reader
+	Writer    *bufio.Writer
+	Err       error
+	writeLock sync.Mutex // messages can be pushed to the client while it is served
+	log       *log.Log   // tagged with the details of the connection, the default logger if nil
+}
+
+// logger gives the logger of the connection, tagging the entries with its details
+func (c *Connection) logger() *log.Log {
+	if c.log == nil {
+		return log.Logger()
+	}
+	return c.log
 }
 
 // sendMessage sends a given message to the client using underlying connection write buffer
@@ -20,16 +31,29 @@ func (c *Connection) sendMessage(msg string, newline bool) {
 	if newline {
 		msg += "\n"
 	}
-	_, c.Err = c.Writer.WriteString(msg)
-	if c.Err != nil {
-		log.Logger().Printf("error while writing to %s: %s", (*c.Conn).RemoteAddr(), c.Err)
+	c.Err = c.write(msg)
+}
+
+// write writes and flushes a given message on the connection. It is safe to be called concurrently, and
+// doesn't touch the connection error, so that it can be used to push messages outside of the client flow.
+func (c *Connection) write(msg string) (err error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	_, err = c.Writer.WriteString(msg)
+	if err != nil {
+		c.logger().Errorf("error while writing to %s: %s", (*c.Conn).RemoteAddr(), err)
 		return
 	}
-	c.Err = c.Writer.Flush()
-	if c.Err != nil {
-		log.Logger().Printf("error while flushing data to %s: %s", (*c.Conn).RemoteAddr(), c.Err)
-		return
+	err = c.Writer.Flush()
+	if err != nil {
+		c.logger().Errorf("error while flushing data to %s: %s", (*c.Conn).RemoteAddr(), err)
 	}
+	return
+}
+
+// close closes the connection, making the flow served on it end at its next read
+func (c *Connection) close() {
+	_ = (*c.Conn).Close()
 }
 
 // readMessage reads a single line (until end-of-line) of user input from connection read stream
@@ -37,7 +61,7 @@ func (c *Connection) readMessage() (content string) {
 	content, c.Err = c.Reader.ReadString('\n')
 	content = strings.TrimRight(content, "\n")
 	if c.Err != nil {
-		log.Logger().Printf("error while reading from %s: %s", (*c.Conn).RemoteAddr(), c.Err)
+		c.logger().Errorf("error while reading from %s: %s", (*c.Conn).RemoteAddr(), c.Err)
 	}
 	return
 }
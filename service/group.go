@@ -0,0 +1,300 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gibber/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// group chat menu and prompts
+const (
+	groupMenu = "\n0 - Go back to previous menu" +
+		"\n1 - Open a group chat" +
+		"\n2 - Create a group" +
+		"\n3 - Rename a group" +
+		"\n4 - Add a friend to a group" +
+		"\n5 - Remove a member from a group" +
+		"\n6 - Leave a group" +
+		"\n\nEnter a choice: "
+	groupIndexPrompt       = "\nEnter a group's index (\"b\" to go back): "
+	groupNamePrompt        = "\nGroup name: "
+	groupMembersPrompt     = "\nEnter the indexes of the friends to add, separated by spaces (at least two): "
+	groupFriendPrompt      = "\nEnter a friend's index (\"b\" to go back): "
+	groupMemberPrompt      = "\nEnter a member's index (\"b\" to go back): "
+	groupLeaveConfirmation = "\nLeave the group? (Y/n): "
+)
+
+// user options in the group chats menu
+const (
+	openGroupChoice = 1 + iota
+	createGroupChoice
+	renameGroupChoice
+	addGroupMemberChoice
+	removeGroupMemberChoice
+	leaveGroupChoice
+)
+
+// group flow errors
+var (
+	errFetchGroupsFailed = errors.New("fetch groups failed")
+	errNoGroupChosen     = errors.New("no group chosen")
+)
+
+// seeGroups enables the user to chat in and manage his group conversations
+func (c *client) seeGroups() {
+	for {
+		userInput := c.sendAndReceiveMsg(groupMenu, false, false)
+		if c.Err != nil {
+			return
+		}
+		choice, err := strconv.Atoi(userInput)
+		if err != nil {
+			c.sendMessage(errInvalidInput.Error(), true)
+			continue
+		}
+		switch choice {
+		case exitChoice:
+			return
+		case openGroupChoice:
+			c.openGroupChat()
+		case createGroupChoice:
+			c.createGroup()
+		case renameGroupChoice:
+			c.renameGroup()
+		case addGroupMemberChoice:
+			c.addGroupMember()
+		case removeGroupMemberChoice:
+			c.removeGroupMember()
+		case leaveGroupChoice:
+			c.leaveGroup()
+		default:
+			c.sendMessage(errInvalidInput.Error(), true)
+		}
+	}
+}
+
+// openGroupChat lets the user choose one of his groups, and chat in it
+func (c *client) openGroupChat() {
+	if group := c.chooseGroup(); group != nil {
+		c.chatInGroup(group.ID, group.Name)
+	}
+}
+
+// chatInGroup displays the conversation of the given group, and lets the user chat in it till he quits
+func (c *client) chatInGroup(groupID primitive.ObjectID, name string) {
+	err := c.openSession(&chatSession{
+		group: groupID,
+		history: func(before user.Cursor) (user.ChatPage, error) {
+			return c.User.GetGroupChat(groupID, before)
+		},
+		markRead: func(until time.Time) error {
+			return c.User.MarkGroupRead(groupID, until)
+		},
+		notifyTyping: func(typing bool) {
+			if err := c.User.NotifyGroupTyping(groupID, typing); err != nil {
+				c.logger().Errorf("notifying typing in group %s failed: %s", groupID.Hex(), err)
+			}
+		},
+		messages: func() ([]user.Message, error) {
+			return c.User.GetGroupMessages(groupID, primitive.NilObjectID, 0)
+		},
+		edit: func(messageID primitive.ObjectID, text string) error {
+			_, err := c.User.EditGroupMessage(groupID, messageID, text)
+			return err
+		},
+		remove: func(messageID primitive.ObjectID, forEveryone bool) error {
+			return c.User.DeleteGroupMessage(groupID, messageID, forEveryone)
+		},
+	})
+	if err != nil {
+		c.sendMessage(fmt.Sprintf("Opening group %s failed: %s", name, err), true)
+		return
+	}
+
+	defer c.closeChat()
+	c.chatLoop(func(text string) error {
+		_, err := c.User.SendGroupMessage(groupID, text)
+		return err
+	})
+}
+
+// createGroup creates a new group with the friends chosen by the user
+func (c *client) createGroup() {
+	name := c.sendAndReceiveMsg(groupNamePrompt, false, false)
+	if c.Err != nil || name == "" {
+		return
+	}
+	friends, err := c.User.SeeFriends()
+	if err != nil {
+		c.sendMessage("You need friends to create a group", true)
+		return
+	}
+	c.showUsers("Friends List", friends)
+	userInput := c.sendAndReceiveMsg(groupMembersPrompt, false, false)
+	if c.Err != nil {
+		return
+	}
+	memberIDs := make([]primitive.ObjectID, 0)
+	for _, field := range strings.Fields(userInput) {
+		idx, err := strconv.Atoi(field)
+		if err != nil || idx < 1 || idx > len(friends) {
+			c.sendMessage(fmt.Sprintf("Invalid choice: %s", field), true)
+			return
+		}
+		memberIDs = append(memberIDs, friends[idx-1])
+	}
+	group, err := c.User.CreateGroup(name, memberIDs)
+	if err != nil {
+		c.logger().Errorf("creating group %s by user %s failed: %s", name, c.Email, err)
+		c.sendMessage(fmt.Sprintf("Creating group failed: %s", err), true)
+		return
+	}
+	c.sendMessage(fmt.Sprintf("\nGroup %s created with %d members\n", group.Name, len(group.Members)), true)
+}
+
+// renameGroup renames one of the groups of the user
+func (c *client) renameGroup() {
+	group := c.chooseGroup()
+	if group == nil {
+		return
+	}
+	name := c.sendAndReceiveMsg(groupNamePrompt, false, false)
+	if c.Err != nil || name == "" {
+		return
+	}
+	c.reportGroupChange(c.User.RenameGroup(group.ID, name), fmt.Sprintf("Group %s renamed to %s", group.Name, name))
+}
+
+// addGroupMember adds one of the friends of the user, who isn't yet a member, to one of his groups
+func (c *client) addGroupMember() {
+	group := c.chooseGroup()
+	if group == nil {
+		return
+	}
+	friends, err := c.User.SeeFriends()
+	if err != nil {
+		c.sendMessage("You need friends to add to the group", true)
+		return
+	}
+	candidates := make([]primitive.ObjectID, 0, len(friends))
+	for _, friend := range friends {
+		if !group.IsMember(friend) {
+			candidates = append(candidates, friend)
+		}
+	}
+	friendID, ok := c.chooseUser("Friends not in "+group.Name, candidates, groupFriendPrompt)
+	if !ok {
+		return
+	}
+	c.reportGroupChange(c.User.AddGroupMember(group.ID, friendID), "Friend added to "+group.Name)
+}
+
+// removeGroupMember removes one of the members (other than himself) from a group owned by the user
+func (c *client) removeGroupMember() {
+	group := c.chooseGroup()
+	if group == nil {
+		return
+	}
+	members := make([]primitive.ObjectID, 0, len(group.Members))
+	for _, member := range group.Members {
+		if member.UserID != c.User.ID {
+			members = append(members, member.UserID)
+		}
+	}
+	memberID, ok := c.chooseUser("Members of "+group.Name, members, groupMemberPrompt)
+	if !ok {
+		return
+	}
+	c.reportGroupChange(c.User.RemoveGroupMember(group.ID, memberID), "Member removed from "+group.Name)
+}
+
+// leaveGroup removes the user from one of his groups
+func (c *client) leaveGroup() {
+	group := c.chooseGroup()
+	if group == nil {
+		return
+	}
+	confirm := c.sendAndReceiveMsg(groupLeaveConfirmation, false, true)
+	if c.Err != nil {
+		return
+	}
+	if strings.ToLower(confirm) == "y" || confirm == "" {
+		c.reportGroupChange(c.User.LeaveGroup(group.ID), "You left "+group.Name)
+	}
+}
+
+// chooseGroup lists the groups of the user, and gives the one he chooses. It gives nil if the user goes back,
+// or his choice is invalid.
+func (c *client) chooseGroup() *user.Group {
+	groups, err := c.User.Groups()
+	if err != nil {
+		c.logger().Errorf("error while fetching groups for client %s: %s", (*c.Conn).RemoteAddr(), err)
+		c.Err = errFetchGroupsFailed
+		return nil
+	}
+	if len(groups) == 0 {
+		c.sendMessage("\nYou are not a member of any group", true)
+		return nil
+	}
+	c.sendMessage("\n****************** Groups List *****************\n", true)
+	for idx, group := range groups {
+		unread, _ := c.User.UnreadGroupCount(group)
+		c.sendMessage(fmt.Sprintf("%d - %s (%d members)%s", idx+1, group.Name, len(group.Members),
+			unreadInfo(unread)), true)
+	}
+	userInput := c.sendAndReceiveMsg(groupIndexPrompt, false, false)
+	if c.Err != nil || strings.ToLower(userInput) == "b" {
+		return nil
+	}
+	groupIdx, err := strconv.Atoi(userInput)
+	if err != nil || groupIdx < 1 || groupIdx > len(groups) {
+		c.logger().Warnf("group index msg %s parsing failed from client %s", userInput, (*c.Conn).RemoteAddr())
+		c.Err = errNoGroupChosen
+		c.sendMessage(fmt.Sprintf("Invalid choice: %s", userInput), true)
+		return nil
+	}
+	return groups[groupIdx-1]
+}
+
+// chooseUser lists the given users under the given title, and gives the one chosen by the user
+func (c *client) chooseUser(title string, userIDs []primitive.ObjectID, prompt string) (userID primitive.ObjectID,
+	ok bool) {
+	if len(userIDs) == 0 {
+		c.sendMessage(fmt.Sprintf("\nNo one to choose from: %s", title), true)
+		return
+	}
+	c.showUsers(title, userIDs)
+	userInput := c.sendAndReceiveMsg(prompt, false, false)
+	if c.Err != nil || strings.ToLower(userInput) == "b" {
+		return
+	}
+	idx, err := strconv.Atoi(userInput)
+	if err != nil || idx < 1 || idx > len(userIDs) {
+		c.sendMessage(fmt.Sprintf("Invalid choice: %s", userInput), true)
+		return
+	}
+	return userIDs[idx-1], true
+}
+
+// showUsers lists the profiles of the given users under the given title
+func (c *client) showUsers(title string, userIDs []primitive.ObjectID) {
+	c.sendMessage(fmt.Sprintf("\n****************** %s *****************\n", title), true)
+	for idx, userID := range userIDs {
+		userProfile, _ := user.UserProfile(userID)
+		c.sendMessage(fmt.Sprintf("%d - %s", idx+1, userProfile), true)
+	}
+}
+
+// reportGroupChange tells the user whether the change he asked for a group succeeded
+func (c *client) reportGroupChange(err error, successMsg string) {
+	if err != nil {
+		c.logger().Errorf("group change by user %s failed: %s", c.Email, err)
+		c.sendMessage(fmt.Sprintf("\nGroup update failed: %s\n", err), true)
+		return
+	}
+	c.sendMessage("\n"+successMsg+"\n", true)
+}
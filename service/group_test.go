@@ -0,0 +1,44 @@
+package service
+
+import (
+	user2 "gibber/user"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestClient_GroupMessage(t *testing.T) {
+	owner, friend1 := createTestFriends(t)
+	friend2 := createTestUser(t, "Jim")
+	assert.NoError(t, owner.SendInvitation(friend2), "sending invitation failed")
+	assert.NoError(t, friend2.AddFriend(owner.ID), "accepting invitation failed")
+	_, err := owner.CreateGroup("friends", []primitive.ObjectID{friend1.ID, friend2.ID})
+	assert.NoError(t, err, "group creation failed")
+
+	conns := make([]*testConn, 0, 3)
+	for _, usr := range []*user2.User{owner, friend1, friend2} {
+		conn := dialTestServer(t)
+		defer conn.close()
+		conn.login(usr.Email, "password")
+		conn.send("8") // group chats
+		conn.expect("Enter a choice: ")
+		conn.send("1") // open a group chat
+		conn.expect("1 - friends (3 members)")
+		conn.expect(groupIndexPrompt)
+		conn.send("1")
+		conn.expect(chatPrompt)
+		conns = append(conns, conn)
+	}
+
+	conns[1].send("hello group")
+	conns[1].expect("You: hello group")
+
+	// pushed to all the other members, with the sender's name
+	for _, conn := range []*testConn{conns[0], conns[2]} {
+		conn.expect(friend1.FirstName)
+		conn.expect("hello group")
+	}
+	for _, conn := range conns {
+		conn.send("q")
+	}
+}
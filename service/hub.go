@@ -0,0 +1,71 @@
+package service
+
+import (
+	"gibber/log"
+	"gibber/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+)
+
+// number of events buffered for a session before dropping the new ones
+const sessionEventBuffer = 64
+
+// hub delivers the events published by the user package to the live sessions of the connected users
+type hub struct {
+	mu       sync.RWMutex
+	sessions map[primitive.ObjectID]map[*subscription]bool // user ID => live sessions
+}
+
+// subscription is a live session registered in the hub to receive the events of a user
+type subscription struct {
+	userID primitive.ObjectID
+	events chan user.Event
+}
+
+// deliveryHub is the hub shared by all the clients connected to this server
+var deliveryHub = newHub()
+
+// newHub gives a hub with no session registered
+func newHub() *hub {
+	return &hub{sessions: make(map[primitive.ObjectID]map[*subscription]bool)}
+}
+
+// subscribe registers a new live session for the given user
+func (h *hub) subscribe(userID primitive.ObjectID) *subscription {
+	sub := &subscription{userID: userID, events: make(chan user.Event, sessionEventBuffer)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions[userID] == nil {
+		h.sessions[userID] = make(map[*subscription]bool)
+	}
+	h.sessions[userID][sub] = true
+	return sub
+}
+
+// unsubscribe removes the session from the hub, closing its events channel
+func (h *hub) unsubscribe(sub *subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.sessions[sub.userID][sub] {
+		return // already removed
+	}
+	delete(h.sessions[sub.userID], sub)
+	if len(h.sessions[sub.userID]) == 0 {
+		delete(h.sessions, sub.userID)
+	}
+	close(sub.events)
+}
+
+// Publish pushes the event to all the live sessions of the given user. It never blocks, so a session
+// not keeping up with its events loses the new ones.
+func (h *hub) Publish(to primitive.ObjectID, event user.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.sessions[to] {
+		select {
+		case sub.events <- event:
+		default:
+			log.Logger().Warnf("event %s for user %s dropped as session buffer is full", event.Type, to.Hex())
+		}
+	}
+}
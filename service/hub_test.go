@@ -0,0 +1,34 @@
+package service
+
+import (
+	"gibber/user"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestHub(t *testing.T) {
+	h := newHub()
+	userID := primitive.NewObjectID()
+	sub1, sub2 := h.subscribe(userID), h.subscribe(userID)
+
+	event := user.Event{Type: user.MessageEvent, From: primitive.NewObjectID(), Text: "hello"}
+	h.Publish(userID, event)
+	assert.Equal(t, event, <-sub1.events, "event should be delivered to first session")
+	assert.Equal(t, event, <-sub2.events, "event should be delivered to second session")
+
+	h.Publish(primitive.NewObjectID(), event) // no session of this user
+	assert.Equal(t, 0, len(sub1.events), "event of other user should not be delivered")
+
+	h.unsubscribe(sub1)
+	h.unsubscribe(sub1) // closing twice is harmless
+	_, open := <-sub1.events
+	assert.False(t, open, "events channel should be closed on unsubscribe")
+
+	for i := 0; i < sessionEventBuffer+1; i++ {
+		h.Publish(userID, event) // never blocks, even if the session is not reading
+	}
+	assert.Equal(t, sessionEventBuffer, len(sub2.events), "events beyond buffer should be dropped")
+	h.unsubscribe(sub2)
+	assert.Equal(t, 0, len(h.sessions), "no session should be left")
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"fmt"
+	"gibber/user"
+	"time"
+)
+
+// Limits are the security limits and timeouts applied to the clients
+type Limits struct {
+	MaxAttempts       int                // to enter a valid email or password before being disconnected
+	PasswordMinLength int                // for the passwords set by the users
+	TokenTTL          time.Duration      // for the REST API tokens to be valid
+	ResetTokenTTL     time.Duration      // for the password reset tokens to be valid
+	VerificationTTL   time.Duration      // for the email verification codes to be valid
+	SessionTTL        time.Duration      // for the sessions to be resumable with their tokens
+	ReadHeaderTimeout time.Duration      // for the HTTP clients to send the headers of a request
+	AccountLogins     user.LoginThrottle // throttling the failed logins to an account, wherever they come from
+	SourceLogins      user.LoginThrottle // throttling the failed logins from an IP, whichever the accounts
+}
+
+// limits applied to the clients, these defaults unless set at the startup
+var limits = Limits{
+	MaxAttempts:       3,
+	PasswordMinLength: 6,
+	TokenTTL:          24 * time.Hour,
+	ResetTokenTTL:     time.Hour,
+	VerificationTTL:   24 * time.Hour,
+	SessionTTL:        7 * 24 * time.Hour,
+	ReadHeaderTimeout: 10 * time.Second,
+	AccountLogins: user.LoginThrottle{
+		MaxFailures: 5,
+		Window:      15 * time.Minute,
+		Lockout:     15 * time.Minute,
+		Backoff:     time.Second,
+	},
+	SourceLogins: user.LoginThrottle{
+		MaxFailures: 20, // several users may share the IP
+		Window:      15 * time.Minute,
+		Lockout:     15 * time.Minute,
+		Backoff:     time.Second,
+	},
+}
+
+// SetLimits sets the limits applied to the clients. It is meant to be called once at the startup, before any
+// client is served.
+func SetLimits(l Limits) {
+	limits = l
+	user.SetLoginThrottle(l.AccountLogins)
+	errShortPassword = shortPasswordError(l.PasswordMinLength)
+}
+
+// shortPasswordError gives the error telling the minimum length of the passwords
+func shortPasswordError(minLength int) error {
+	return fmt.Errorf("password should be at least %d characters long", minLength)
+}
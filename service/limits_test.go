@@ -0,0 +1,18 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestSetLimits(t *testing.T) {
+	defaults := limits
+	defer SetLimits(defaults)
+
+	SetLimits(Limits{MaxAttempts: 5, PasswordMinLength: 10, TokenTTL: time.Hour})
+	assert.Equal(t, 5, limits.MaxAttempts)
+	assert.Equal(t, "password should be at least 10 characters long", errShortPassword.Error())
+	assert.Equal(t, errShortPassword, validatePassword("password"))
+	assert.NoError(t, validatePassword("long password"))
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	"gibber/user"
+	"strconv"
+	"strings"
+)
+
+// message edition prompts
+const (
+	messageIndexPrompt      = "\nEnter a message's index (\"b\" to go back): "
+	editTextPrompt          = "New text: "
+	deleteForAllPrompt      = "\nDelete for everyone? (y/N): "
+	noMessageToEditMsg      = "No message of yours to edit"
+	noMessageToDeleteMsg    = "No message to delete"
+	messageDeletedForAllMsg = "Message deleted for everyone"
+	messageDeletedForMeMsg  = "Message deleted for you"
+)
+
+// editMessage lets the user choose one of his latest messages in the opened conversation, and replace its text
+func (c *client) editMessage() {
+	session := c.openedChat()
+	if session == nil {
+		return
+	}
+	msg, ok := c.chooseMessage(session, func(msg user.Message) bool {
+		return msg.Sender == c.User.ID && !msg.Deleted
+	}, noMessageToEditMsg)
+	if !ok {
+		return
+	}
+	text := c.sendAndReceiveMsg(editTextPrompt, false, false)
+	if c.Err != nil || text == "" {
+		return
+	}
+	if err := session.edit(msg.ID, text); err != nil {
+		c.logger().Errorf("editing message %s failed: %s", msg.ID.Hex(), err)
+		c.sendMessage(fmt.Sprintf("Editing message failed: %s", err), true)
+		return
+	}
+	c.sendMessage(fmt.Sprintf("\bYou: %s\n", user.FormatText(text, true, false)), true)
+}
+
+// deleteMessage lets the user choose one of the latest messages in the opened conversation, and delete it just for
+// him, or for everyone if he sent it
+func (c *client) deleteMessage() {
+	session := c.openedChat()
+	if session == nil {
+		return
+	}
+	msg, ok := c.chooseMessage(session, func(user.Message) bool { return true }, noMessageToDeleteMsg)
+	if !ok {
+		return
+	}
+	forEveryone := false
+	if msg.Sender == c.User.ID && !msg.Deleted {
+		answer := c.sendAndReceiveMsg(deleteForAllPrompt, false, true)
+		if c.Err != nil {
+			return
+		}
+		forEveryone = strings.ToLower(answer) == "y"
+	}
+	if err := session.remove(msg.ID, forEveryone); err != nil {
+		c.logger().Errorf("deleting message %s failed: %s", msg.ID.Hex(), err)
+		c.sendMessage(fmt.Sprintf("Deleting message failed: %s", err), true)
+		return
+	}
+	if forEveryone {
+		c.sendMessage(messageDeletedForAllMsg, true)
+	} else {
+		c.sendMessage(messageDeletedForMeMsg, true)
+	}
+}
+
+// chooseMessage lists the latest messages of the conversation which can be chosen, and gives the one chosen by
+// the user
+func (c *client) chooseMessage(session *chatSession, choosable func(msg user.Message) bool,
+	noneMsg string) (chosen user.Message, ok bool) {
+	msgs, err := session.messages()
+	if err != nil {
+		c.logger().Errorf("fetching latest messages failed: %s", err)
+		c.sendMessage(fmt.Sprintf("Fetching messages failed: %s", err), true)
+		return
+	}
+	var choices []user.Message
+	for _, msg := range msgs {
+		if choosable(msg) {
+			choices = append(choices, msg)
+		}
+	}
+	if len(choices) == 0 {
+		c.sendMessage(noneMsg, true)
+		return
+	}
+	c.sendMessage("", true)
+	for idx, msg := range choices {
+		sender := session.peerName
+		if msg.Sender == c.User.ID {
+			sender = "You"
+		} else if !session.group.IsZero() {
+			sender = "Member"
+			if usr, err := user.GetUserByID(msg.Sender); err == nil {
+				sender = usr.FirstName
+			}
+		}
+		c.sendMessage(fmt.Sprintf("%d - %s (%s): %s", idx+1, sender, msg.Timestamp,
+			user.FormatText(msg.Text, msg.Edited, msg.Deleted)), true)
+	}
+	userInput := c.sendAndReceiveMsg(messageIndexPrompt, false, false)
+	if c.Err != nil || strings.ToLower(userInput) == "b" {
+		return
+	}
+	idx, err := strconv.Atoi(userInput)
+	if err != nil || idx < 1 || idx > len(choices) {
+		c.sendMessage(fmt.Sprintf("Invalid choice: %s", userInput), true)
+		return
+	}
+	return choices[idx-1], true
+}
+
+// deliverChange tells the user that a message of the opened conversation was edited, or deleted, by its sender
+func (c *client) deliverChange(event user.Event) {
+	c.chatLock.Lock()
+	defer c.chatLock.Unlock()
+	if c.chat == nil || !c.chat.concerns(event) {
+		return
+	}
+	sender := c.chat.peerName
+	if !event.Group.IsZero() {
+		sender = event.FromName
+	}
+	action, deleted := "edited", event.Type == user.MessageDeletedEvent
+	if deleted {
+		action = "deleted"
+	}
+	_ = c.write(fmt.Sprintf("\n\n%s %s a message (%s): %s\n\n", sender, action, event.Timestamp,
+		user.FormatText(event.Text, true, deleted)) + chatPrompt)
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"gibber/metrics"
+	"gibber/user"
+	"net/http"
+)
+
+// path on which the metrics are exposed
+const metricsPath = "/metrics"
+
+// statistics of the sessions served, exposed by the metrics endpoint along with those of the user package
+var (
+	connectionsActive = metrics.NewGauge("gibber_connections_active",
+		"Client connections being served (TCP, TLS and WebSocket).")
+	messageDeliverySeconds = metrics.NewHistogram("gibber_message_delivery_seconds",
+		"Latency in seconds from a message being sent to it being delivered to a live session of the receiver.",
+		metrics.LatencyBuckets)
+)
+
+func init() {
+	metrics.NewGaugeFunc("gibber_users_online", "Users logged in on this server.", func() float64 {
+		return float64(onlineUsers.count())
+	})
+}
+
+// StartMetricsServer starts the HTTP endpoint exposing the metrics in the Prometheus text format on the given
+// host and port, over TLS if a configuration is given
+// The context is taken to signal that the server is initialized successfully
+func StartMetricsServer(host, port string, config *tls.Config, complete context.CancelFunc) error {
+	return defaultServer.startHTTP("metrics", host, port, config, newMetricsHandler(), complete)
+}
+
+// newMetricsHandler gives the handler exposing the metrics
+func newMetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, metrics.Default.Handler())
+	return mux
+}
+
+// observeDelivery records the latency of a message event being delivered to a live session
+func observeDelivery(event user.Event) {
+	if event.Type == user.MessageEvent || event.Type == user.GroupMessageEvent {
+		messageDeliverySeconds.ObserveSince(event.Timestamp)
+	}
+}
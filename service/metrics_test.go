@@ -0,0 +1,70 @@
+package service
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics fetches the metrics from the endpoint, giving the value of each series
+func scrapeMetrics(t *testing.T, server *httptest.Server) map[string]float64 {
+	res, err := server.Client().Get(server.URL + metricsPath)
+	if err != nil {
+		t.Fatalf("scraping metrics failed: %s", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		assert.NoError(t, err, "invalid series %q", line)
+		values[line[:i]] = value
+	}
+	return values
+}
+
+func TestMetricsServer(t *testing.T) {
+	server := httptest.NewServer(newMetricsHandler())
+	defer server.Close()
+	before := scrapeMetrics(t, server)
+	usr1, usr2 := createTestFriends(t)
+
+	conn1 := dialJSONTestServer(t)
+	defer conn1.close()
+	resp := conn1.request(jsonRequest{Type: loginRequest, Email: usr1.Email, Password: "wrong"})
+	assert.False(t, resp.OK)
+	resp = conn1.request(jsonRequest{Type: loginRequest, Email: usr1.Email, Password: "password"})
+	assert.True(t, resp.OK, "login failed: %s", resp.Error)
+	conn2 := dialJSONTestServer(t)
+	defer conn2.close()
+	resp = conn2.request(jsonRequest{Type: loginRequest, Email: usr2.Email, Password: "password"})
+	assert.True(t, resp.OK, "login failed: %s", resp.Error)
+	resp = conn2.request(jsonRequest{Type: sendMessageRequest, UserID: usr1.ID.Hex(), Text: "measured"})
+	assert.True(t, resp.OK, "sending message failed: %s", resp.Error)
+	conn1.event()
+
+	after := scrapeMetrics(t, server)
+	increased := func(series string, by float64) {
+		assert.True(t, after[series]-before[series] >= by, "%s: %v => %v", series, before[series], after[series])
+	}
+	assert.True(t, after["gibber_connections_active"] >= 2, "connections: %v", after["gibber_connections_active"])
+	assert.True(t, after["gibber_users_online"] >= 2, "online users: %v", after["gibber_users_online"])
+	increased(`gibber_logins_total{result="success"}`, 2)
+	increased(`gibber_logins_total{result="failure"}`, 1)
+	increased(`gibber_messages_sent_total{kind="direct"}`, 1)
+	increased(`gibber_invitations_total{action="accepted"}`, 1)
+	increased("gibber_message_delivery_seconds_count", 1)
+	increased(`gibber_store_operation_seconds_count{operation="push_message"}`, 1)
+	assert.Equal(t, before[`gibber_store_errors_total{operation="find_user_by_email"}`],
+		after[`gibber_store_errors_total{operation="find_user_by_email"}`], "lookups are not failures")
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"gibber/datastore"
+	"gibber/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+// interval at which the presence of the users connected to this server is refreshed, well within the
+// user.PresenceTimeout so that a single missed heartbeat doesn't make a user offline
+const heartbeatInterval = user.PresenceTimeout / 3
+
+// presenceRegistry tracks the sessions connected to this server, and keeps their users online through heartbeats
+type presenceRegistry struct {
+	mu       sync.Mutex
+	sessions map[primitive.ObjectID]*client // session ID => client holding it
+	stop     chan struct{}                  // closed to stop the heartbeats, on shutdown
+	stopOnce sync.Once
+}
+
+// onlineUsers is the presence registry shared by all the clients connected to this server
+var onlineUsers = newPresenceRegistry()
+
+// newPresenceRegistry gives a registry with no user online
+func newPresenceRegistry() *presenceRegistry {
+	return &presenceRegistry{sessions: make(map[primitive.ObjectID]*client), stop: make(chan struct{})}
+}
+
+// join registers a session connected to this server through the given client, of the logged in user. It gives the
+// client which held the session before, if it is resumed while still held by another connection.
+func (r *presenceRegistry) join(sessionID primitive.ObjectID, c *client) (previous *client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous = r.sessions[sessionID]
+	r.sessions[sessionID] = c
+	return
+}
+
+// leave removes a session no longer connected through the given client, unless another client holds it now. It
+// tells whether the client still held the session.
+func (r *presenceRegistry) leave(sessionID primitive.ObjectID, c *client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sessions[sessionID] != c {
+		return false
+	}
+	delete(r.sessions, sessionID)
+	return true
+}
+
+// count gives the number of users having at least a session connected
+func (r *presenceRegistry) count() int {
+	return len(r.online())
+}
+
+// online gives the users having at least a session connected
+func (r *presenceRegistry) online() []primitive.ObjectID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[primitive.ObjectID]bool, len(r.sessions))
+	userIDs := make([]primitive.ObjectID, 0, len(r.sessions))
+	for _, c := range r.sessions {
+		if userID := c.User.ID; !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
+
+// connected gives the sessions connected to this server, along with the clients holding them
+func (r *presenceRegistry) connected() map[primitive.ObjectID]*client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sessions := make(map[primitive.ObjectID]*client, len(r.sessions))
+	for sessionID, c := range r.sessions {
+		sessions[sessionID] = c
+	}
+	return sessions
+}
+
+// heartbeat refreshes the presence of all the connected sessions, at every given interval, till the heartbeats
+// are stopped. The connection of a session revoked meanwhile is closed.
+func (r *presenceRegistry) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		for sessionID, c := range r.connected() {
+			if err := user.Heartbeat(sessionID); err == datastore.ErrNoDocUpdate {
+				c.logger().Infof("session %s revoked, closing the connection", sessionID.Hex())
+				c.close()
+			} // other failures logged already, next beat may succeed
+		}
+	}
+}
+
+// stopHeartbeats stops the heartbeats of the registry, once the server is shut down
+func (r *presenceRegistry) stopHeartbeats() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	user2 "gibber/user"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func TestPresenceRegistry(t *testing.T) {
+	r := newPresenceRegistry()
+	userID, session1, session2 := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	client1, client2 := &client{User: &user2.User{ID: userID}}, &client{User: &user2.User{ID: userID}}
+	assert.Nil(t, r.join(session1, client1))
+	assert.Nil(t, r.join(session2, client2))
+	assert.Equal(t, []primitive.ObjectID{userID}, r.online())
+	assert.Equal(t, 1, r.count())
+	assert.Equal(t, map[primitive.ObjectID]*client{session1: client1, session2: client2}, r.connected())
+
+	assert.True(t, r.leave(session1, client1))
+	assert.Equal(t, []primitive.ObjectID{userID}, r.online(), "user should stay online with a session left")
+	resumed := &client{User: &user2.User{ID: userID}}
+	assert.Equal(t, client2, r.join(session2, resumed), "the client holding the session is given")
+	assert.False(t, r.leave(session2, client2), "the session is held by the resuming client")
+	assert.Equal(t, []primitive.ObjectID{userID}, r.online())
+	assert.True(t, r.leave(session2, resumed))
+	assert.Equal(t, 0, len(r.online()), "user should be offline with no session left")
+}
+
+func TestPresenceRegistry_StopHeartbeats(t *testing.T) {
+	r := newPresenceRegistry()
+	stopped := make(chan struct{})
+	go func() {
+		r.heartbeat(10 * time.Millisecond)
+		close(stopped)
+	}()
+	r.stopHeartbeats()
+	r.stopHeartbeats() // stopping again is harmless
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeats should stop")
+	}
+}
+
+func TestClient_FriendsPresence(t *testing.T) {
+	usr1, usr2 := createTestFriends(t)
+
+	conn2 := dialTestServer(t)
+	conn2.login(usr2.Email, "password")
+
+	conn1 := dialTestServer(t)
+	defer conn1.close()
+	conn1.login(usr1.Email, "password")
+	conn1.send("2") // see all friends
+	conn1.expect(usr2.Email + " (online)")
+	conn1.expect("Enter 'b' to go back: ")
+	conn1.send("b")
+	conn1.expect("Enter a choice: ")
+
+	conn2.send("0") // exit
+	conn2.expect(exitingMsg)
+	conn2.close()
+	for i := 0; i < 100; i++ { // logout completes after the exit message
+		if presence, _ := user2.GetPresence(usr2.ID); !presence.Online {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn1.send("1") // start chat
+	conn1.expect(usr2.Email + " (offline, last seen")
+	conn1.expect("Enter a friend's index to start chat: ")
+}
+
+func TestClient_RemoveFriend(t *testing.T) {
+	usr1, usr2 := createTestFriends(t)
+	conn := dialTestServer(t)
+	defer conn.close()
+	conn.login(usr1.Email, "password")
+	conn.send("2") // see all friends
+	conn.expect(usr2.Email)
+	conn.expect(friendsListPrompt)
+	conn.send("1")
+	conn.expect("(y/N): ")
+	conn.send("y")
+	conn.expect(deleteHistoryConfirmation)
+	conn.send("")
+	conn.expect("removed from your friends")
+	conn.expect("Enter a choice: ")
+
+	friends, err := usr2.SeeFriends()
+	assert.NoError(t, err)
+	assert.Empty(t, friends, "the friendship should end for both")
+	conn.send("2")
+	conn.expect("You have no friends yet")
+}
@@ -0,0 +1,720 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gibber/datastore"
+	"gibber/log"
+	"gibber/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// JSON-lines protocol. A client opts in by sending a handshake request as its very first line, in place of the
+// email asked by the menu, e.g. {"type":"handshake","protocol":"json"}. The lines written by the server before the
+// handshake response are to be skipped. Afterwards, each line sent by the client is a request, answered by a
+// response line of the same type (and ID, if given), and the events for the logged in user are pushed as lines
+// of the type "event". A line of the type "shutdown" tells that the server is going away.
+const (
+	jsonProtocol        = "json"
+	jsonProtocolVersion = 1
+)
+
+// request and response types of the JSON-lines protocol
+const (
+	handshakeRequest        = "handshake"
+	loginRequest            = "login"
+	registerRequest         = "register"
+	logoutRequest           = "logout"
+	friendsRequest          = "friends"
+	invitationsRequest      = "invitations"
+	sendInviteRequest       = "send_invite"
+	acceptInviteRequest     = "accept_invite"
+	rejectInviteRequest     = "reject_invite"
+	sendMessageRequest      = "send_message"
+	sendGroupMessageRequest = "send_group_message"
+	markReadRequest         = "mark_read" // the chat (user_id) or group (group_id) read up to its latest message
+	typingRequest           = "typing"    // the user started typing in the chat (user_id) or group (group_id)
+	editMessageRequest      = "edit_message"
+	deleteMessageRequest    = "delete_message" // for everyone, or just for the user
+	blockedRequest          = "blocked"        // the users blocked by the user
+	blockRequest            = "block"
+	unblockRequest          = "unblock"
+	removeFriendRequest     = "remove_friend"   // the chat history is kept, unless asked to delete it
+	forgotPasswordRequest   = "forgot_password" // a password reset token mailed to the user
+	resetPasswordRequest    = "reset_password"  // a new password set with the reset token
+	verifyEmailRequest      = "verify_email"    // the email of the user verified with the mailed code
+	resendCodeRequest       = "resend_verification"
+	enrollTOTPRequest       = "enroll_totp"  // a TOTP secret generated, given as an otpauth URI
+	confirmTOTPRequest      = "confirm_totp" // the two-factor authentication turned on with a first code
+	disableTOTPRequest      = "disable_totp" // with the password and a code
+	resumeRequest           = "resume"       // a session resumed with its token, in place of a login
+	sessionsRequest         = "sessions"     // the live sessions of the user
+	revokeSessionRequest    = "revoke_session"
+	eventResponse           = "event"
+	shutdownResponse        = "shutdown" // the server is going away, and the connection will be closed
+)
+
+// JSON-lines protocol errors, sent back to the client
+var (
+	errMalformedRequest    = errors.New("malformed request")
+	errUnknownRequest      = errors.New("unknown request type")
+	errUnsupportedProtocol = errors.New("unsupported protocol")
+	errNotLoggedIn         = errors.New("not logged in")
+	errAlreadyLoggedIn     = errors.New("already logged in")
+	errUserExists          = errors.New("user already exists")
+	errInvalidCredentials  = errors.New("invalid email or password")
+	errInvalidUserID       = errors.New("invalid user ID")
+	errUserNotFound        = errors.New("user not found")
+	errNotFriend           = errors.New("not a friend")
+	errNotGroupMember      = errors.New("not a member of the group")
+	errUserBlocked         = errors.New("user is blocked")
+	errNotBlocked          = errors.New("user is not blocked")
+	errAlreadyFriend       = errors.New("already a friend")
+)
+
+// jsonRequest is a request line sent by a client. The fields used depend on the type of the request.
+type jsonRequest struct {
+	ID        string `json:"id,omitempty"` // echoed back in the response, to correlate them
+	Type      string `json:"type"`
+	Protocol  string `json:"protocol,omitempty"`
+	Email     string `json:"email,omitempty"`
+	Password  string `json:"password,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	GroupID   string `json:"group_id,omitempty"`
+	Text      string `json:"text,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Token     string `json:"token,omitempty"` // password reset or session token
+	Code      string `json:"code,omitempty"`  // email verification or two-factor code
+	SessionID string `json:"session_id,omitempty"`
+	// deletes the message for everyone, rather than just for the user
+	ForEveryone bool `json:"for_everyone,omitempty"`
+	// deletes the chat history along with the friendship, rather than keeping it
+	DeleteHistory bool `json:"delete_history,omitempty"`
+}
+
+// jsonResponse is a response (or event) line sent to a client
+type jsonResponse struct {
+	ID    string      `json:"id,omitempty"`
+	Type  string      `json:"type"`
+	OK    bool        `json:"ok"`
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// jsonUser is the public profile of a user
+type jsonUser struct {
+	ID        string     `json:"id"`
+	FirstName string     `json:"first_name"`
+	LastName  string     `json:"last_name"`
+	Email     string     `json:"email"`
+	Online    *bool      `json:"online,omitempty"`    // for friends only
+	LastSeen  *time.Time `json:"last_seen,omitempty"` // for friends only
+	Unread    *int       `json:"unread,omitempty"`    // for friends only, messages not read yet
+}
+
+// jsonInvitations are the active invitations of a user
+type jsonInvitations struct {
+	Sent     []jsonUser `json:"sent"`
+	Received []jsonUser `json:"received"`
+}
+
+// jsonEvent is an event pushed to a logged in user
+type jsonEvent struct {
+	Type      user.EventType `json:"type"`
+	Group     string         `json:"group,omitempty"`
+	From      string         `json:"from"`
+	FromName  string         `json:"from_name,omitempty"`
+	Message   string         `json:"message_id,omitempty"`
+	Text      string         `json:"text,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// isHandshake checks whether the first line sent by a client asks for a protocol other than the menu.
+// An email never starts with a brace, so the two can't be confused.
+func isHandshake(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "{")
+}
+
+// serveJSON serves the client through the JSON-lines protocol, starting with the handshake line already read.
+// It returns when the client logs out or disconnects.
+func (c *client) serveJSON(handshake string) {
+	c.sendMessage("", true) // the handshake response starts on a line of its own, after the email prompt
+	var req jsonRequest
+	if err := json.Unmarshal([]byte(handshake), &req); err != nil || req.Type != handshakeRequest {
+		c.respondJSON(req, nil, errMalformedRequest)
+		return
+	}
+	if req.Protocol != jsonProtocol {
+		c.respondJSON(req, nil, errUnsupportedProtocol)
+		return
+	}
+	c.respondJSON(req, map[string]interface{}{"protocol": jsonProtocol, "version": jsonProtocolVersion}, nil)
+	atomic.StoreInt32(&c.jsonMode, 1)
+	c.logger().Infof("client %s => switched to %s protocol", (*c.Conn).RemoteAddr(), jsonProtocol)
+
+	defer c.logoutUser()
+	defer c.typing.stopAll()
+	var sub *subscription
+	defer func() {
+		if sub != nil {
+			deliveryHub.unsubscribe(sub)
+		}
+	}()
+	for {
+		line := c.readMessage()
+		if c.Err != nil {
+			return
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		req = jsonRequest{}
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			c.respondJSON(req, nil, errMalformedRequest)
+			continue
+		}
+		loggedIn := !c.User.ID.IsZero()
+		data, err := c.handleJSON(req)
+		c.respondJSON(req, data, err)
+		if req.Type == logoutRequest && err == nil {
+			return
+		}
+		if !loggedIn && !c.User.ID.IsZero() { // just logged in
+			sub = deliveryHub.subscribe(c.User.ID)
+			go c.pushJSONEvents(sub.events)
+		}
+	}
+}
+
+// handleJSON serves a single request of the JSON-lines protocol, giving the data of the response
+func (c *client) handleJSON(req jsonRequest) (data interface{}, err error) {
+	switch req.Type {
+	case loginRequest, registerRequest, resumeRequest, forgotPasswordRequest, resetPasswordRequest:
+		if !c.User.ID.IsZero() {
+			return nil, errAlreadyLoggedIn
+		}
+	case handshakeRequest:
+		return nil, errUnknownRequest // only valid as the first line
+	default:
+		if c.User.ID.IsZero() {
+			return nil, errNotLoggedIn
+		}
+	}
+	switch req.Type {
+	case loginRequest:
+		return c.jsonLogin(req)
+	case registerRequest:
+		return c.jsonRegister(req)
+	case resumeRequest:
+		return c.jsonResume(req)
+	case forgotPasswordRequest:
+		return nil, requestJSONReset(req, c.logger())
+	case resetPasswordRequest:
+		return nil, resetJSONPassword(req, c.logger())
+	case logoutRequest:
+		c.loggingOut = true
+		return
+	case sessionsRequest:
+		return listJSONSessions(c.User, c.session)
+	case revokeSessionRequest:
+		var sessionID primitive.ObjectID
+		if sessionID, err = primitive.ObjectIDFromHex(req.SessionID); err != nil {
+			return nil, errMalformedRequest
+		}
+		return nil, revokeJSONSession(c.User, sessionID)
+	case friendsRequest:
+		return listJSONFriends(c.User)
+	case invitationsRequest:
+		return listJSONInvitations(c.User)
+	case verifyEmailRequest:
+		return nil, verifyJSONEmail(c.User, req)
+	case resendCodeRequest:
+		return nil, resendJSONVerification(c.User)
+	case enrollTOTPRequest:
+		return enrollJSONTOTP(c.User)
+	case confirmTOTPRequest:
+		return confirmJSONTOTP(c.User, req)
+	case disableTOTPRequest:
+		return nil, disableTOTP(c.User, req.Password, req.Code)
+	case sendInviteRequest:
+		return sendJSONInvite(c.User, req)
+	case acceptInviteRequest, rejectInviteRequest:
+		var userID primitive.ObjectID
+		if userID, err = primitive.ObjectIDFromHex(req.UserID); err != nil {
+			return nil, errInvalidUserID
+		}
+		if req.Type == acceptInviteRequest {
+			err = c.User.AddFriend(userID)
+		} else {
+			err = c.User.RejectInvitation(userID)
+		}
+		return
+	case sendMessageRequest:
+		if req.Text = strings.TrimSpace(req.Text); req.Text == "" {
+			return nil, errEmptyMessage
+		}
+		var friendID primitive.ObjectID
+		if friendID, err = primitive.ObjectIDFromHex(req.UserID); err != nil {
+			return nil, errInvalidUserID
+		}
+		if !isFriend(c.User, friendID) {
+			return nil, errNotFriend
+		}
+		c.typing.stop(friendID, false)
+		return user.SendMessage(c.User.ID, friendID, req.Text)
+	case sendGroupMessageRequest:
+		if req.Text = strings.TrimSpace(req.Text); req.Text == "" {
+			return nil, errEmptyMessage
+		}
+		var groupID primitive.ObjectID
+		if groupID, err = primitive.ObjectIDFromHex(req.GroupID); err != nil {
+			return nil, errMalformedRequest
+		}
+		c.typing.stop(groupID, false)
+		return c.User.SendGroupMessage(groupID, req.Text)
+	case markReadRequest:
+		return nil, markJSONRead(c.User, req)
+	case typingRequest:
+		return nil, c.jsonTyping(req)
+	case editMessageRequest:
+		return editJSONMessage(c.User, req)
+	case deleteMessageRequest:
+		return nil, deleteJSONMessage(c.User, req)
+	case removeFriendRequest:
+		var friendID primitive.ObjectID
+		if friendID, err = primitive.ObjectIDFromHex(req.UserID); err != nil {
+			return nil, errInvalidUserID
+		}
+		return nil, removeJSONFriend(c.User, friendID, req.DeleteHistory)
+	case blockedRequest:
+		return listJSONBlocked(c.User)
+	case blockRequest, unblockRequest:
+		var userID primitive.ObjectID
+		if userID, err = primitive.ObjectIDFromHex(req.UserID); err != nil {
+			return nil, errInvalidUserID
+		}
+		if req.Type == blockRequest {
+			return blockJSONUser(c.User, userID)
+		}
+		return nil, unblockJSONUser(c.User, userID)
+	default:
+		return nil, errUnknownRequest
+	}
+}
+
+// jsonLogin logs in an existing user
+func (c *client) jsonLogin(req jsonRequest) (data interface{}, err error) {
+	usr, lastLogin, err := loginJSONUser(req, (*c.Conn).RemoteAddr().String(), c.logger())
+	if err != nil {
+		return
+	}
+	token, err := c.startSession(usr)
+	if err != nil {
+		return
+	}
+	return map[string]interface{}{"user": toJSONUser(c.User), "last_login": lastLogin, "verified": !c.Unverified,
+		"session_token": token}, nil
+}
+
+// jsonRegister registers a new user, who gets logged in
+func (c *client) jsonRegister(req jsonRequest) (data interface{}, err error) {
+	usr, err := registerJSONUser(req, c.logger())
+	if err != nil {
+		return
+	}
+	token, err := c.startSession(usr)
+	if err != nil {
+		return
+	}
+	return map[string]interface{}{"user": toJSONUser(c.User), "verified": false, "session_token": token}, nil
+}
+
+// jsonResume resumes the session of the token of the given request, logging in its user
+func (c *client) jsonResume(req jsonRequest) (data interface{}, err error) {
+	if err = c.resumeSession(req.Token); err != nil {
+		return
+	}
+	return map[string]interface{}{"user": toJSONUser(c.User), "last_login": c.LastLogin.Format(time.RFC3339),
+		"verified": !c.Unverified}, nil
+}
+
+// loginJSONUser authenticates the user with the email and password of the given request, giving his last login
+// The user logs with the given logger of the session. The failed logins are throttled by account and by source (the
+// remote address of the client), a locked out login failing with a user.LockedError. A user with two-factor
+// authentication gives his code along, the login failing with errSecondFactorRequired without it.
+func loginJSONUser(req jsonRequest, source string, logger *log.Log) (usr *user.User, lastLogin string, err error) {
+	usr = &user.User{Email: strings.ToLower(req.Email)}
+	usr.SetLogger(logger)
+	lastLogin, err = authenticate(usr, req.Password, source)
+	if err == user.ErrSecondFactorRequired {
+		if req.Code == "" {
+			return nil, "", errSecondFactorRequired
+		}
+		lastLogin, err = authenticateCode(usr, req.Code, source)
+	}
+	if err != nil {
+		logger.Warnf("user %s authentication failed: %s", usr.Email, err)
+		if lockedOut(err) {
+			return nil, "", err
+		} else if err == user.ErrInvalidTOTPCode {
+			return nil, "", errInvalidTOTPCode
+		}
+		return nil, "", errInvalidCredentials
+	}
+	logger.Infof("user %s successfully logged in", usr.Email)
+	return
+}
+
+// registerJSONUser validates the details of the given request, and registers the new user, mailing him the code to
+// verify his email. The user logs with the given logger of the session.
+func registerJSONUser(req jsonRequest, logger *log.Log) (usr *user.User, err error) {
+	usr = &user.User{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     strings.ToLower(req.Email),
+		Password:  req.Password,
+	}
+	usr.SetLogger(logger)
+	if !user.ValidUserEmail(usr.Email) {
+		return nil, errInvalidEmail
+	}
+	if usr.FirstName == "" || usr.LastName == "" {
+		return nil, errEmptyInput
+	}
+	if err = validatePassword(usr.Password); err != nil {
+		return nil, err
+	}
+	if _, err = user.GetUserByEmail(usr.Email); err != datastore.ErrNoDocFound {
+		if err == nil {
+			err = errUserExists
+		}
+		return nil, err
+	}
+	if _, err = user.RegisterUser(usr); err != nil {
+		logger.Errorf("user %s registration failed: %s", usr.Email, err)
+		return nil, errInternalError
+	}
+	logger.Infof("user %s successfully registered", usr.Email)
+	_ = usr.SendVerificationCode(limits.VerificationTTL) // the user may ask for it again
+	return usr, nil
+}
+
+// listJSONFriends lists the friends of the user, along with their presence and the number of their messages
+// not read yet
+func listJSONFriends(usr *user.User) (data interface{}, err error) {
+	presences, err := usr.FriendsPresence()
+	if err != nil {
+		return
+	}
+	friends := make([]jsonUser, 0, len(presences))
+	for _, presence := range presences {
+		friend, _ := user.GetUserByID(presence.UserID)
+		profile := toJSONUser(friend)
+		profile.ID = presence.UserID.Hex()
+		online, lastSeen := presence.Online, presence.LastSeen
+		unread, _ := usr.UnreadChatCount(presence.UserID)
+		profile.Online, profile.LastSeen, profile.Unread = &online, &lastSeen, &unread
+		friends = append(friends, profile)
+	}
+	return friends, nil
+}
+
+// removeJSONFriend ends the friendship of the user with the given friend, deleting their chat history if asked to
+func removeJSONFriend(usr *user.User, friendID primitive.ObjectID, deleteHistory bool) error {
+	if !isFriend(usr, friendID) {
+		return errNotFriend
+	}
+	return usr.RemoveFriend(friendID, !deleteHistory)
+}
+
+// listJSONInvitations lists the active invitations sent and received by the user
+func listJSONInvitations(usr *user.User) (data interface{}, err error) {
+	sent, err := usr.GetSentInvitations()
+	if err != nil {
+		return
+	}
+	received, err := usr.GetReceivedInvitations()
+	if err != nil {
+		return
+	}
+	return jsonInvitations{Sent: toJSONUsers(sent), Received: toJSONUsers(received)}, nil
+}
+
+// sendJSONInvite sends an invitation to the user with the given email
+func sendJSONInvite(usr *user.User, req jsonRequest) (data interface{}, err error) {
+	if usr.Unverified {
+		return nil, errEmailNotVerified
+	}
+	invitee, err := findUser(usr, strings.ToLower(req.Email))
+	if err == datastore.ErrNoDocFound {
+		return nil, errUserNotFound
+	} else if err != nil {
+		return
+	}
+	if blocked, _ := usr.HasBlocked(invitee.ID); blocked {
+		return nil, errUserBlocked
+	}
+	if isFriend(usr, invitee.ID) {
+		return nil, errAlreadyFriend
+	}
+	if err = usr.SendInvitation(invitee); err != nil {
+		return
+	}
+	return toJSONUser(invitee), nil
+}
+
+// listJSONBlocked lists the users blocked by the user
+func listJSONBlocked(usr *user.User) (data interface{}, err error) {
+	blocked, err := usr.BlockedUsers()
+	if err != nil {
+		return
+	}
+	return toJSONUsers(blocked), nil
+}
+
+// blockJSONUser blocks the given user, giving his profile. Blocking a user already blocked does nothing.
+func blockJSONUser(usr *user.User, userID primitive.ObjectID) (data interface{}, err error) {
+	if userID == usr.ID {
+		return nil, errInvalidUserID
+	}
+	blocked, err := user.GetUserByID(userID)
+	if err == datastore.ErrNoDocFound {
+		return nil, errUserNotFound
+	} else if err != nil {
+		return
+	}
+	if already, er := usr.HasBlocked(userID); er != nil {
+		return nil, er
+	} else if !already {
+		if err = usr.Block(userID); err != nil {
+			return
+		}
+	}
+	return toJSONUser(blocked), nil
+}
+
+// unblockJSONUser unblocks the given user
+func unblockJSONUser(usr *user.User, userID primitive.ObjectID) error {
+	if err := usr.Unblock(userID); err == datastore.ErrNoDocUpdate {
+		return errNotBlocked
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// markJSONRead marks the chat with the friend (or the group) of the given request as read up to now, i.e. up to
+// its latest message
+func markJSONRead(usr *user.User, req jsonRequest) (err error) {
+	now := time.Now().UTC()
+	if req.GroupID != "" {
+		var groupID primitive.ObjectID
+		if groupID, err = primitive.ObjectIDFromHex(req.GroupID); err != nil {
+			return errMalformedRequest
+		}
+		return usr.MarkGroupRead(groupID, now)
+	}
+	friendID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		return errInvalidUserID
+	}
+	if !isFriend(usr, friendID) {
+		return errNotFriend
+	}
+	return usr.MarkChatRead(friendID, now)
+}
+
+// editJSONMessage replaces the text of the message of the given request, in the chat with the friend (or in the
+// group) of the request, giving the message as edited
+func editJSONMessage(usr *user.User, req jsonRequest) (data interface{}, err error) {
+	if strings.TrimSpace(req.Text) == "" {
+		return nil, errEmptyMessage
+	}
+	friendID, groupID, messageID, err := jsonMessage(usr, req)
+	if err != nil {
+		return
+	}
+	if groupID.IsZero() {
+		data, err = usr.EditMessage(friendID, messageID, req.Text)
+	} else {
+		data, err = usr.EditGroupMessage(groupID, messageID, req.Text)
+	}
+	return data, messageError(err)
+}
+
+// deleteJSONMessage deletes the message of the given request, in the chat with the friend (or in the group) of the
+// request
+func deleteJSONMessage(usr *user.User, req jsonRequest) error {
+	friendID, groupID, messageID, err := jsonMessage(usr, req)
+	if err != nil {
+		return err
+	}
+	if groupID.IsZero() {
+		return messageError(usr.DeleteMessage(friendID, messageID, req.ForEveryone))
+	}
+	return messageError(usr.DeleteGroupMessage(groupID, messageID, req.ForEveryone))
+}
+
+// jsonMessage gives the message of the given request, along with the friend (or the group) of the request in
+// whose conversation it is
+func jsonMessage(usr *user.User, req jsonRequest) (friendID, groupID, messageID primitive.ObjectID, err error) {
+	if messageID, err = primitive.ObjectIDFromHex(req.MessageID); err != nil {
+		err = errInvalidMessageID
+		return
+	}
+	if req.GroupID != "" {
+		if groupID, err = primitive.ObjectIDFromHex(req.GroupID); err != nil {
+			err = errMalformedRequest
+		}
+		return
+	}
+	if friendID, err = primitive.ObjectIDFromHex(req.UserID); err != nil {
+		err = errInvalidUserID
+	} else if !isFriend(usr, friendID) {
+		err = errNotFriend
+	}
+	return
+}
+
+// messageError tells that no such message is in the conversation, when acting on it matches none
+func messageError(err error) error {
+	if err == datastore.ErrNoDocFound {
+		return errNoMessage
+	}
+	return err
+}
+
+// jsonTyping tells the friend (or the other members of the group) of the given request that the user is typing, till
+// he sends the message or the typing times out
+func (c *client) jsonTyping(req jsonRequest) (err error) {
+	if req.GroupID != "" {
+		var groupID primitive.ObjectID
+		if groupID, err = primitive.ObjectIDFromHex(req.GroupID); err != nil {
+			return errMalformedRequest
+		}
+		if !isGroupMember(c.User, groupID) {
+			return errNotGroupMember
+		}
+		c.typing.start(groupID, func(typing bool) {
+			if err := c.User.NotifyGroupTyping(groupID, typing); err != nil {
+				c.logger().Errorf("notifying typing in group %s failed: %s", groupID.Hex(), err)
+			}
+		})
+		return
+	}
+	friendID, err := primitive.ObjectIDFromHex(req.UserID)
+	if err != nil {
+		return errInvalidUserID
+	}
+	if !isFriend(c.User, friendID) {
+		return errNotFriend
+	}
+	c.typing.start(friendID, func(typing bool) {
+		c.User.NotifyTyping(friendID, typing)
+	})
+	return
+}
+
+// findUser gives the user with the given email, as found by the user. A user who has blocked him, or whose email
+// isn't verified yet, isn't found.
+func findUser(usr *user.User, email string) (found *user.User, err error) {
+	if found, err = user.GetUserByEmail(email); err != nil {
+		return
+	}
+	if found.Unverified {
+		return nil, datastore.ErrNoDocFound
+	}
+	if blocked, er := found.HasBlocked(usr.ID); er != nil {
+		return nil, er
+	} else if blocked {
+		return nil, datastore.ErrNoDocFound
+	}
+	return
+}
+
+// isFriend checks whether the given user is a friend of the user
+func isFriend(usr *user.User, userID primitive.ObjectID) bool {
+	friends, _ := usr.SeeFriends()
+	for _, friend := range friends {
+		if friend == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// isGroupMember checks whether the user is a member of the given group
+func isGroupMember(usr *user.User, groupID primitive.ObjectID) bool {
+	groups, _ := usr.Groups()
+	for _, group := range groups {
+		if group.ID == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// pushJSONEvents pushes the events published for the user to the client, until the events channel is closed
+func (c *client) pushJSONEvents(events <-chan user.Event) {
+	for event := range events {
+		observeDelivery(event)
+		data := jsonEvent{
+			Type:      event.Type,
+			From:      event.From.Hex(),
+			FromName:  event.FromName,
+			Text:      event.Text,
+			Timestamp: event.Timestamp,
+		}
+		if !event.Group.IsZero() {
+			data.Group = event.Group.Hex()
+		}
+		if !event.Message.IsZero() {
+			data.Message = event.Message.Hex()
+		}
+		_ = c.writeJSON(jsonResponse{Type: eventResponse, OK: true, Data: data})
+	}
+}
+
+// respondJSON sends the response to the given request, carrying either the data or the error
+func (c *client) respondJSON(req jsonRequest, data interface{}, err error) {
+	resp := jsonResponse{ID: req.ID, Type: req.Type, OK: err == nil, Data: data}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	if er := c.writeJSON(resp); er != nil {
+		c.Err = er
+	}
+}
+
+// writeJSON writes the response as a single line
+func (c *client) writeJSON(resp jsonResponse) error {
+	line, err := json.Marshal(resp)
+	if err != nil {
+		c.logger().Errorf("encoding %s response to client %s failed: %s", resp.Type, (*c.Conn).RemoteAddr(), err)
+		return err
+	}
+	return c.write(fmt.Sprintf("%s\n", line))
+}
+
+// toJSONUser gives the public profile of the user
+func toJSONUser(usr *user.User) jsonUser {
+	return jsonUser{ID: usr.ID.Hex(), FirstName: usr.FirstName, LastName: usr.LastName, Email: usr.Email}
+}
+
+// toJSONUsers gives the public profiles of the given users
+func toJSONUsers(userIDs []primitive.ObjectID) []jsonUser {
+	users := make([]jsonUser, 0, len(userIDs))
+	for _, userID := range userIDs {
+		usr, _ := user.GetUserByID(userID)
+		profile := toJSONUser(usr)
+		profile.ID = userID.Hex()
+		users = append(users, profile)
+	}
+	return users
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jsonConn is a client connection to the test server, speaking the JSON-lines protocol
+type jsonConn struct {
+	*testConn
+	events []jsonResponse // events received while waiting for a response
+}
+
+// dialJSONTestServer connects a new client to the test server, and switches it to the JSON-lines protocol
+func dialJSONTestServer(t *testing.T) *jsonConn {
+	jc := &jsonConn{testConn: dialTestServer(t)}
+	jc.send(`{"type":"handshake","protocol":"json"}`)
+	resp := jc.response(handshakeRequest, "")
+	assert.True(t, resp.OK, "handshake failed: %s", resp.Error)
+	return jc
+}
+
+// request sends the request, and gives its response
+func (jc *jsonConn) request(req jsonRequest) jsonResponse {
+	line, err := json.Marshal(req)
+	assert.NoError(jc.t, err, "encoding request failed")
+	jc.send(string(line))
+	return jc.response(req.Type, req.ID)
+}
+
+// response reads the lines from the server until the response of the given type and ID comes. Non JSON lines
+// (sent before the handshake) are skipped, and the events are kept aside.
+func (jc *jsonConn) response(reqType, id string) (resp jsonResponse) {
+	for {
+		_ = jc.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		line, err := jc.reader.ReadString('\n')
+		if err != nil {
+			jc.t.Fatalf("expecting %s response failed: %s", reqType, err)
+		}
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		resp = jsonResponse{}
+		assert.NoError(jc.t, json.Unmarshal([]byte(line), &resp), "decoding response %q failed", line)
+		if resp.Type == eventResponse && reqType != eventResponse {
+			jc.events = append(jc.events, resp)
+			continue
+		}
+		if resp.Type == reqType && resp.ID == id {
+			return
+		}
+	}
+}
+
+// event gives the next event pushed by the server
+func (jc *jsonConn) event() jsonResponse {
+	if len(jc.events) == 0 {
+		return jc.response(eventResponse, "")
+	}
+	event := jc.events[0]
+	jc.events = jc.events[1:]
+	return event
+}
+
+func TestClient_JSONProtocol(t *testing.T) {
+	usr1, usr2 := createTestFriends(t)
+
+	conn1 := dialJSONTestServer(t)
+	defer conn1.close()
+	resp := conn1.request(jsonRequest{Type: friendsRequest})
+	assert.False(t, resp.OK)
+	assert.Equal(t, errNotLoggedIn.Error(), resp.Error)
+
+	resp = conn1.request(jsonRequest{ID: "1", Type: loginRequest, Email: usr1.Email, Password: "wrong"})
+	assert.False(t, resp.OK)
+	assert.Equal(t, errInvalidCredentials.Error(), resp.Error)
+	resp = conn1.request(jsonRequest{ID: "2", Type: loginRequest, Email: usr1.Email, Password: "password"})
+	assert.True(t, resp.OK, "login failed: %s", resp.Error)
+
+	resp = conn1.request(jsonRequest{Type: friendsRequest})
+	assert.True(t, resp.OK, "listing friends failed: %s", resp.Error)
+	friends := resp.Data.([]interface{})
+	assert.Equal(t, 1, len(friends))
+	friend := friends[0].(map[string]interface{})
+	assert.Equal(t, usr2.ID.Hex(), friend["id"])
+	assert.Equal(t, usr2.Email, friend["email"])
+
+	conn2 := dialJSONTestServer(t)
+	defer conn2.close()
+	resp = conn2.request(jsonRequest{Type: loginRequest, Email: usr2.Email, Password: "password"})
+	assert.True(t, resp.OK, "login failed: %s", resp.Error)
+	resp = conn2.request(jsonRequest{Type: sendMessageRequest, UserID: usr1.ID.Hex(), Text: "  "})
+	assert.Equal(t, errEmptyMessage.Error(), resp.Error, "blank message should be refused")
+	resp = conn2.request(jsonRequest{Type: typingRequest, UserID: usr1.ID.Hex()})
+	assert.True(t, resp.OK, "signalling typing failed: %s", resp.Error)
+	resp = conn2.request(jsonRequest{Type: sendMessageRequest, UserID: usr1.ID.Hex(), Text: "hello json"})
+	assert.True(t, resp.OK, "sending message failed: %s", resp.Error)
+
+	event := conn1.event().Data.(map[string]interface{})
+	assert.Equal(t, "typing", event["type"])
+	assert.Equal(t, usr2.ID.Hex(), event["from"])
+	assert.Equal(t, usr2.FirstName, event["from_name"])
+	sent := resp.Data.(map[string]interface{})
+	event = conn1.event().Data.(map[string]interface{})
+	assert.Equal(t, "message", event["type"], "the message sent ends the typing")
+	assert.Equal(t, sent["id"], event["message_id"], "the message sent is given with its ID")
+	assert.Equal(t, usr2.ID.Hex(), event["from"])
+	assert.Equal(t, "hello json", event["text"])
+
+	resp = conn1.request(jsonRequest{Type: friendsRequest})
+	assert.True(t, resp.OK, "listing friends failed: %s", resp.Error)
+	assert.Equal(t, float64(1), resp.Data.([]interface{})[0].(map[string]interface{})["unread"])
+	resp = conn1.request(jsonRequest{Type: markReadRequest, UserID: usr2.ID.Hex()})
+	assert.True(t, resp.OK, "marking chat read failed: %s", resp.Error)
+	resp = conn1.request(jsonRequest{Type: friendsRequest})
+	assert.Equal(t, float64(0), resp.Data.([]interface{})[0].(map[string]interface{})["unread"])
+	event = conn2.event().Data.(map[string]interface{})
+	assert.Equal(t, "read", event["type"], "the sender is told that his message is seen")
+	assert.Equal(t, usr1.ID.Hex(), event["from"])
+
+	resp = conn2.request(jsonRequest{Type: editMessageRequest, UserID: usr1.ID.Hex(), MessageID: sent["id"].(string),
+		Text: "hello again"})
+	assert.True(t, resp.OK, "editing message failed: %s", resp.Error)
+	assert.Equal(t, true, resp.Data.(map[string]interface{})["edited"])
+	event = conn1.event().Data.(map[string]interface{})
+	assert.Equal(t, "message_edited", event["type"])
+	assert.Equal(t, sent["id"], event["message_id"])
+	assert.Equal(t, "hello again", event["text"])
+	resp = conn1.request(jsonRequest{Type: deleteMessageRequest, UserID: usr2.ID.Hex(), MessageID: sent["id"].(string),
+		ForEveryone: true})
+	assert.False(t, resp.OK, "only the sender deletes a message for everyone")
+	resp = conn2.request(jsonRequest{Type: deleteMessageRequest, UserID: usr1.ID.Hex(),
+		MessageID: primitive.NewObjectID().Hex(), ForEveryone: true})
+	assert.Equal(t, errNoMessage.Error(), resp.Error)
+	resp = conn2.request(jsonRequest{Type: deleteMessageRequest, UserID: usr1.ID.Hex(), MessageID: sent["id"].(string),
+		ForEveryone: true})
+	assert.True(t, resp.OK, "deleting message failed: %s", resp.Error)
+	event = conn1.event().Data.(map[string]interface{})
+	assert.Equal(t, "message_deleted", event["type"])
+	assert.Equal(t, sent["id"], event["message_id"])
+
+	resp = conn2.request(jsonRequest{Type: "unknown"})
+	assert.Equal(t, errUnknownRequest.Error(), resp.Error)
+	resp = conn2.request(jsonRequest{Type: typingRequest, UserID: usr2.ID.Hex()})
+	assert.Equal(t, errNotFriend.Error(), resp.Error)
+	resp = conn2.request(jsonRequest{Type: typingRequest, UserID: usr1.ID.Hex()})
+	assert.True(t, resp.OK, "signalling typing failed: %s", resp.Error)
+	resp = conn2.request(jsonRequest{Type: logoutRequest})
+	assert.True(t, resp.OK, "logout failed: %s", resp.Error)
+	assert.Equal(t, "typing", conn1.event().Data.(map[string]interface{})["type"])
+	assert.Equal(t, "typing_stopped", conn1.event().Data.(map[string]interface{})["type"],
+		"the typing stops as the session ends")
+}
+
+func TestClient_JSONRegisterAndInvite(t *testing.T) {
+	mailer := useTestMailer(t)
+	inviter := createTestUser(t, "John")
+	conn := dialJSONTestServer(t)
+	defer conn.close()
+
+	email := strings.ToLower("json" + randomString(15) + "@doe.com")
+	resp := conn.request(jsonRequest{Type: registerRequest, Email: email, Password: "short", FirstName: "Jason",
+		LastName: "Doe"})
+	assert.Equal(t, errShortPassword.Error(), resp.Error)
+	resp = conn.request(jsonRequest{Type: registerRequest, Email: email, Password: "password", FirstName: "Jason",
+		LastName: "Doe"})
+	assert.True(t, resp.OK, "registration failed: %s", resp.Error)
+	registered := resp.Data.(map[string]interface{})["user"].(map[string]interface{})
+
+	resp = conn.request(jsonRequest{Type: sendInviteRequest, Email: inviter.Email})
+	assert.Equal(t, errEmailNotVerified.Error(), resp.Error, "only verified users can invite")
+	resp = conn.request(jsonRequest{Type: verifyEmailRequest, Code: mailedCode(t, mailer, email)})
+	assert.True(t, resp.OK, "verifying email failed: %s", resp.Error)
+	resp = conn.request(jsonRequest{Type: sendInviteRequest, Email: inviter.Email})
+	assert.True(t, resp.OK, "sending invite failed: %s", resp.Error)
+	resp = conn.request(jsonRequest{Type: invitationsRequest})
+	assert.True(t, resp.OK, "listing invitations failed: %s", resp.Error)
+	sent := resp.Data.(map[string]interface{})["sent"].([]interface{})
+	assert.Equal(t, 1, len(sent))
+	assert.Equal(t, inviter.Email, sent[0].(map[string]interface{})["email"])
+
+	invitee := createTestUser(t, "Jim")
+	resp = conn.request(jsonRequest{Type: sendMessageRequest, UserID: invitee.ID.Hex(), Text: "hi"})
+	assert.Equal(t, errNotFriend.Error(), resp.Error, "only friends can be messaged")
+	resp = conn.request(jsonRequest{Type: removeFriendRequest, UserID: invitee.ID.Hex()})
+	assert.Equal(t, errNotFriend.Error(), resp.Error, "only friends can be removed")
+	assert.NotEmpty(t, registered["id"])
+
+	resp = conn.request(jsonRequest{Type: blockRequest, UserID: inviter.ID.Hex()})
+	assert.True(t, resp.OK, "blocking failed: %s", resp.Error)
+	resp = conn.request(jsonRequest{Type: blockedRequest})
+	assert.True(t, resp.OK, "listing blocked users failed: %s", resp.Error)
+	assert.Equal(t, 1, len(resp.Data.([]interface{})))
+	resp = conn.request(jsonRequest{Type: invitationsRequest})
+	assert.Equal(t, 0, len(resp.Data.(map[string]interface{})["sent"].([]interface{})),
+		"the invitation to a blocked user should be cancelled")
+	resp = conn.request(jsonRequest{Type: unblockRequest, UserID: inviter.ID.Hex()})
+	assert.True(t, resp.OK, "unblocking failed: %s", resp.Error)
+	resp = conn.request(jsonRequest{Type: unblockRequest, UserID: inviter.ID.Hex()})
+	assert.Equal(t, errNotBlocked.Error(), resp.Error)
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gibber/datastore"
+	"gibber/log"
+	"gibber/user"
+	"strings"
+)
+
+// password reset prompts and messages
+const (
+	forgotPasswordInput    = "?" // entered in place of the password, too short to be one
+	resetTokenSentMsg      = "\nA password reset token has been sent to %s, valid for %s."
+	resetTokenPrompt       = "Reset token (\"q\" to quit): "
+	passwordResetMsg       = "\nPassword reset successfully."
+	newPasswordLoginPrompt = "\nPlease log in with your new password.\nPassword: "
+)
+
+// password reset errors
+var (
+	errInvalidResetToken = errors.New("invalid or expired reset token")
+	errResetFailed       = errors.New("password reset failed")
+)
+
+// resetPassword mails a password reset token to the user, and lets him set a new password once he enters it,
+// telling whether the password was reset
+func (c *client) resetPassword() (reset bool) {
+	if err := user.RequestPasswordReset(c.Email, limits.ResetTokenTTL); err != nil {
+		c.sendMessage(errResetFailed.Error(), true)
+		return
+	}
+	c.sendMessage(fmt.Sprintf(resetTokenSentMsg, c.Email, limits.ResetTokenTTL), true)
+	token := c.sendAndReceiveMsg(resetTokenPrompt, false, false)
+	if c.Err != nil || strings.ToLower(token) == "q" {
+		return
+	}
+	password := c.sendAndReceiveMsg(setPasswordPrompt, false, false)
+	if c.Err != nil {
+		return
+	}
+	if err := validatePassword(password); err != nil {
+		c.sendMessage(err.Error(), true)
+		return
+	}
+	confPassword := c.sendAndReceiveMsg(confirmSetPasswordPrompt, false, false)
+	if c.Err != nil {
+		return
+	} else if password != confPassword {
+		c.sendMessage(errPasswordNotMatched.Error(), true)
+		return
+	}
+	if _, err := user.ResetPassword(token, password); err == user.ErrInvalidResetToken {
+		c.sendMessage(errInvalidResetToken.Error(), true)
+		return
+	} else if err != nil {
+		c.sendMessage(errResetFailed.Error(), true)
+		return
+	}
+	c.logger().Infof("user %s reset his password", c.Email)
+	c.sendMessage(passwordResetMsg, true)
+	return true
+}
+
+// requestJSONReset mails a password reset token to the user with the email of the given request. Whether such a
+// user exists is not told. The user logs with the given logger of the session.
+func requestJSONReset(req jsonRequest, logger *log.Log) error {
+	email := strings.ToLower(req.Email)
+	if !user.ValidUserEmail(email) {
+		return errInvalidEmail
+	}
+	if err := user.RequestPasswordReset(email, limits.ResetTokenTTL); err != nil && err != datastore.ErrNoDocFound {
+		logger.Errorf("password reset request for %s failed: %s", email, err)
+		return errResetFailed
+	}
+	return nil
+}
+
+// resetJSONPassword sets the password of the given request for the user to whom its token was issued
+func resetJSONPassword(req jsonRequest, logger *log.Log) error {
+	if err := validatePassword(req.Password); err != nil {
+		return err
+	}
+	email, err := user.ResetPassword(req.Token, req.Password)
+	if err == user.ErrInvalidResetToken {
+		return errInvalidResetToken
+	} else if err != nil {
+		logger.Errorf("password reset failed: %s", err)
+		return errResetFailed
+	}
+	logger.Infof("user %s reset his password", email)
+	return nil
+}
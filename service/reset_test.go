@@ -0,0 +1,93 @@
+package service
+
+import (
+	"gibber/mail"
+	user2 "gibber/user"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// useTestMailer makes the users' emails kept by a new memory mailer for the duration of the test
+func useTestMailer(t *testing.T) *mail.MemoryMailer {
+	mailer := mail.NewMemoryMailer()
+	user2.SetMailer(mailer)
+	t.Cleanup(func() { user2.SetMailer(mail.NewMemoryMailer()) })
+	return mailer
+}
+
+// mailedToken gives the token last mailed to the given email
+func mailedToken(t *testing.T, mailer *mail.MemoryMailer, email string) string {
+	msg, ok := mailer.Last(email)
+	if !ok {
+		t.Fatalf("no email sent to %s", email)
+	}
+	return regexp.MustCompile(`[0-9a-f]{32}`).FindString(msg.Body)
+}
+
+func TestClient_ResetPassword(t *testing.T) {
+	mailer := useTestMailer(t)
+	usr := createTestUser(t, "Forgetful")
+	conn := dialTestServer(t)
+	defer conn.close()
+
+	conn.expect("Email: ")
+	conn.send(usr.Email)
+	conn.expect("Password: ")
+	conn.send(forgotPasswordInput)
+	conn.expect(resetTokenPrompt)
+	conn.send("wrong token")
+	conn.expect(setPasswordPrompt)
+	conn.send("new password")
+	conn.expect(confirmSetPasswordPrompt)
+	conn.send("new password")
+	conn.expect(errInvalidResetToken.Error())
+
+	conn.expect("Password: ")
+	conn.send(forgotPasswordInput)
+	conn.expect(resetTokenPrompt)
+	conn.send(mailedToken(t, mailer, usr.Email))
+	conn.expect(setPasswordPrompt)
+	conn.send("new password")
+	conn.expect(confirmSetPasswordPrompt)
+	conn.send("new password")
+	conn.expect(passwordResetMsg)
+	conn.expect(newPasswordLoginPrompt)
+	conn.send("new password")
+	conn.expect("Logged In Successfully")
+}
+
+func TestAPI_ResetPassword(t *testing.T) {
+	mailer := useTestMailer(t)
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	ac := &apiTestClient{t: t, server: server}
+	usr := createTestUser(t, "Forgetful")
+	signedIn := &apiTestClient{t: t, server: server}
+	signedIn.login(usr.Email, "password")
+
+	status, _ := ac.call(http.MethodPost, "/api/password_reset", map[string]string{"email": "nobody@doe.com"})
+	assert.Equal(t, http.StatusOK, status, "whether the user exists is not told")
+	status, _ = ac.call(http.MethodPost, "/api/password_reset", map[string]string{"email": usr.Email})
+	assert.Equal(t, http.StatusOK, status)
+	token := mailedToken(t, mailer, usr.Email)
+
+	status, resp := ac.call(http.MethodPut, "/api/password_reset", map[string]string{"token": token, "password": "short"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errShortPassword.Error(), resp.Error)
+	reset := map[string]string{"token": "wrong", "password": "new password"}
+	status, resp = ac.call(http.MethodPut, "/api/password_reset", reset)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, errInvalidResetToken.Error(), resp.Error)
+	reset["token"] = token
+	status, resp = ac.call(http.MethodPut, "/api/password_reset", reset)
+	assert.Equal(t, http.StatusOK, status, "reset failed: %s", resp.Error)
+	status, _ = ac.call(http.MethodPut, "/api/password_reset", reset)
+	assert.Equal(t, http.StatusUnauthorized, status, "a token can be used once")
+	status, _ = signedIn.call(http.MethodGet, "/api/sessions", nil)
+	assert.Equal(t, http.StatusUnauthorized, status, "a password reset revokes the bearer tokens")
+
+	ac.login(usr.Email, "new password")
+}
@@ -3,14 +3,20 @@ package service
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"gibber/log"
 	"gibber/user"
 	"io/ioutil"
 	"net"
+	"net/http"
 	"path/filepath"
 	"runtime"
-	"strings"
+	"sync"
+	"time"
 )
 
 // client connection type
@@ -18,65 +24,313 @@ const connType = "tcp"
 
 const (
 	logoFilePath = "assets/logo.txt"
-	repo         = "gibber/"
 )
 
+// time given to the sessions to end once their connection is forcibly closed, at the end of a shutdown
+const forceCloseGrace = 2 * time.Second
+
+// tlsHandshakeTimeout is the time given to a client connecting over TLS to complete the handshake, for a peer
+// which never does not to hold its connection
+var tlsHandshakeTimeout = 10 * time.Second
+
+// ErrServerClosed is returned by the Start functions once the server is shut down
+var ErrServerClosed = errors.New("gibber: server closed")
+
+// shutdownMsg tells the clients of the menu that the server is going away
+const shutdownMsg = "\n\nServer is shutting down. Please reconnect in a while.\n"
+
+// serverInit guards the services shared by all the listeners to be started once
+var serverInit sync.Once
+
+// server tracks the listeners and the live client sessions, so that they all can be stopped on shutdown
+type server struct {
+	mu          sync.Mutex
+	closing     bool
+	listeners   map[net.Listener]bool
+	httpServers map[*http.Server]bool
+	sessions    map[*client]bool
+	live        sync.WaitGroup // live sessions
+}
+
+// defaultServer is the server started by the Start functions, and stopped by Shutdown
+var defaultServer = newServer()
+
+// newServer gives a server with nothing started
+func newServer() *server {
+	return &server{
+		listeners:   make(map[net.Listener]bool),
+		httpServers: make(map[*http.Server]bool),
+		sessions:    make(map[*client]bool),
+	}
+}
+
 // StartServer starts the chat server and opens it given patterns of hosts and the given port
 // The context is taken to signal that the server is initialized successfully
 func StartServer(host, port string, complete context.CancelFunc) error {
+	return defaultServer.start(host, port, nil, complete)
+}
+
+// StartTLSServer starts the chat server like StartServer, but serving the clients over TLS with the given
+// configuration, so that neither the passwords nor the messages cross the network in clear text
+func StartTLSServer(host, port string, config *tls.Config, complete context.CancelFunc) error {
+	return defaultServer.start(host, port, config, complete)
+}
+
+// Shutdown gracefully stops everything started by the Start functions. It stops accepting new clients, tells the
+// connected ones that the server is going away, and lets each session finish what it is doing (logging out its
+// user) till the given context is done, after which the remaining connections are forcibly closed.
+// The Start functions return ErrServerClosed once it is called.
+func Shutdown(ctx context.Context) error {
+	defer onlineUsers.stopHeartbeats() // once the sessions ended, or were given up on
+	return defaultServer.shutdown(ctx)
+}
+
+// start starts the chat server on the given host and port, over TLS if a configuration is given, and serves
+// the clients till it is shut down
+func (s *server) start(host, port string, config *tls.Config, complete context.CancelFunc) error {
 	defer complete() // mark the context as completed/cancelled
 	address := fmt.Sprintf("%s:%s", host, port)
+	kind := "TCP"
 	listener, err := net.Listen(connType, address)
 	if err != nil {
 		return fmt.Errorf("error in starting listener on host %s and port %s: %s", host, port, err)
 	}
-	log.Logger().Printf("started TCP listener on %s", address)
-	_ = printLogo()
+	if config != nil {
+		kind, listener = "TLS", tls.NewListener(listener, config)
+	}
+	if !s.track(listener, nil) {
+		_ = listener.Close()
+		return ErrServerClosed
+	}
+	log.Logger().Infof("started %s listener on %s", kind, address)
+	initServer()
 	complete() // as the next step is infinite loop
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			if conn.RemoteAddr().String() != "" {
-				log.Logger().Printf("client %s => connection establishment failed", conn.RemoteAddr().String())
+			if s.isClosing() {
+				return ErrServerClosed
 			}
+			log.Logger().Errorf("accepting connection on %s failed: %s", address, err)
 			continue // some error occurred
 		}
-		log.Logger().Printf("client %s => connection established successfully", conn.RemoteAddr().String())
-		go establishClientConnection(&conn)
+		log.Logger().Infof("client %s => connection established successfully", conn.RemoteAddr().String())
+		go s.establishClientConnection(&conn)
+	}
+}
+
+// startHTTP starts an HTTP server (of the given kind, for the logs) serving the given handler on the given
+// host and port, over TLS if a configuration is given
+func (s *server) startHTTP(kind, host, port string, config *tls.Config, handler http.Handler,
+	complete context.CancelFunc) error {
+	defer complete() // mark the context as completed/cancelled
+	address := fmt.Sprintf("%s:%s", host, port)
+	listener, err := net.Listen(connType, address)
+	if err != nil {
+		return fmt.Errorf("error in starting %s listener on host %s and port %s: %s", kind, host, port, err)
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	// a client never done with the headers of its request (e.g. slowloris) doesn't hold its connection
+	httpServer := &http.Server{Handler: handler, ReadHeaderTimeout: limits.ReadHeaderTimeout}
+	if !s.track(nil, httpServer) {
+		_ = listener.Close()
+		return ErrServerClosed
+	}
+	log.Logger().Infof("started %s listener on %s", kind, address)
+	initServer()
+	complete() // as the next step is infinite loop
+	if err = httpServer.Serve(listener); err == http.ErrServerClosed {
+		err = ErrServerClosed
+	}
+	return err
+}
+
+// track registers the listener or the HTTP server to be stopped on shutdown. It tells false if the server
+// is already shutting down.
+func (s *server) track(listener net.Listener, httpServer *http.Server) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	if listener != nil {
+		s.listeners[listener] = true
+	}
+	if httpServer != nil {
+		s.httpServers[httpServer] = true
+	}
+	return true
+}
+
+// isClosing tells whether the server is shutting down
+func (s *server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// join registers a new live session. It tells false if the server is shutting down, and the session is not to
+// be served.
+func (s *server) join(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.sessions[c] = true
+	s.live.Add(1)
+	connectionsActive.Add(1)
+	return true
+}
+
+// leave removes the session once it has ended
+func (s *server) leave(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, c)
+	s.live.Done()
+	connectionsActive.Add(-1)
+}
+
+// shutdown stops accepting new clients, tells the live sessions to end, and waits for them till the context is
+// done, after which their connections are forcibly closed
+func (s *server) shutdown(ctx context.Context) (err error) {
+	s.mu.Lock()
+	s.closing = true
+	for listener := range s.listeners {
+		_ = listener.Close()
+	}
+	httpServers := make([]*http.Server, 0, len(s.httpServers))
+	for httpServer := range s.httpServers {
+		httpServers = append(httpServers, httpServer)
+	}
+	for c := range s.sessions {
+		go c.endSession() // a client not reading doesn't hold up the others
+	}
+	s.mu.Unlock()
+	log.Logger().Infof("shutting down the server")
+
+	for _, httpServer := range httpServers { // waits for the requests in flight
+		if er := httpServer.Shutdown(ctx); er != nil {
+			err = er
+		}
+	}
+	ended := make(chan struct{})
+	go func() {
+		s.live.Wait()
+		close(ended)
+	}()
+	select {
+	case <-ended:
+		log.Logger().Infof("server shut down gracefully")
+		return
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	log.Logger().Warnf("closing %d sessions still live after the shutdown deadline", len(s.sessions))
+	for c := range s.sessions {
+		_ = (*c.Conn).Close()
+	}
+	s.mu.Unlock()
+	select {
+	case <-ended:
+	case <-time.After(forceCloseGrace):
+		log.Logger().Warnf("sessions still live after closing their connections")
+	}
+	return ctx.Err()
+}
+
+// initServer starts the services shared by all the listeners of this process, only once even if many of them
+// (e.g. TCP and WebSocket) are started
+func initServer() {
+	serverInit.Do(func() {
+		user.SetPublisher(deliveryHub)
+		go onlineUsers.heartbeat(heartbeatInterval)
+		_ = printLogo()
+	})
+}
+
+// establishClientConnection serves the client on the given connection as a session of the default server
+func establishClientConnection(conn *net.Conn) {
+	defaultServer.establishClientConnection(conn)
+}
+
+// newSessionID gives a random ID, tagging the entries logged while serving a connection
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
 	}
+	return hex.EncodeToString(b)
 }
 
 // establishClientConnection setups the read and write streams to allow communication b/w server and client
 // It passes the flow to the user back by showing landing page (dashboard)
-func establishClientConnection(conn *net.Conn) {
+func (s *server) establishClientConnection(conn *net.Conn) {
 	// when connection is closed from client, the resource need to be released
 	defer closeClientConnection(conn)
+	if tlsConn, ok := (*conn).(*tls.Conn); ok {
+		// handshake upfront, so that a client failing it (e.g. without a valid certificate) is not served at all
+		_ = tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			log.Logger().Warnf("client %s => TLS handshake failed: %s", tlsConn.RemoteAddr(), err)
+			return
+		}
+		_ = tlsConn.SetDeadline(time.Time{})
+	}
 	client := &client{}
 	client.User = &user.User{}
 	client.Connection = &Connection{}
 	client.Conn = conn
+	client.log = log.Logger().With("remote", (*conn).RemoteAddr().String(), "session", newSessionID())
+	client.User.SetLogger(client.log)
 
 	client.Reader = bufio.NewReader(*client.Conn)
 	client.Writer = bufio.NewWriter(*client.Conn)
 
+	if !s.join(client) {
+		client.sendMessage(shutdownMsg, false)
+		return
+	}
+	defer s.leave(client) // after everything else, the user being logged out included
+
 	client.showWelcomeMessage()
 	if client.Err != nil {
 		return
 	}
 
-	client.authenticate()
+	// the first line is either the email for the menu, or a handshake for another protocol
+	firstLine := client.sendAndReceiveMsg(emailPrompt, false, false)
+	if client.Err != nil {
+		return
+	}
+	if isHandshake(firstLine) {
+		client.serveJSON(firstLine)
+		return
+	}
+
+	client.login(firstLine)
 	defer client.logoutUser()
 	if client.Err != nil {
 		return
 	}
 
+	// the messages for the user are pushed to this session as they come, till it is closed
+	sub := deliveryHub.subscribe(client.User.ID)
+	defer deliveryHub.unsubscribe(sub)
+	go client.deliverEvents(sub.events)
+
+	client.showMissedMessages()
 	client.userDashboard()
 }
 
 // closeClientConnection safely closes the connection when the client exits or gets disconnected
 // to avoid any memory leak
 func closeClientConnection(conn *net.Conn) {
-	log.Logger().Printf("client %s => closing connection from internal", (*conn).RemoteAddr().String())
+	log.Logger().Infof("client %s => closing connection from internal", (*conn).RemoteAddr().String())
 	_ = (*conn).Close()
 }
 
@@ -85,10 +339,10 @@ func printLogo() (err error) {
 	filePath := projectRootPath() + logoFilePath
 	logoData, err := ioutil.ReadFile(filePath)
 	if err != nil {
-		log.Logger().Printf("reading logo file %s filed failed: %s", filePath, err)
+		log.Logger().Errorf("reading logo file %s filed failed: %s", filePath, err)
 		return
 	}
-	log.Logger().Println(string(logoData[:]))
+	log.Logger().Debugf("%s", logoData)
 	return
 }
 
@@ -96,6 +350,5 @@ func printLogo() (err error) {
 // Useful for navigating files inside the repo
 func projectRootPath() (path string) {
 	_, fileStr, _, _ := runtime.Caller(0)
-	rootPath := strings.Split(filepath.Dir(fileStr), repo)
-	return rootPath[0] + repo
+	return filepath.Dir(filepath.Dir(fileStr)) + string(filepath.Separator)
 }
@@ -2,12 +2,27 @@ package service
 
 import (
 	"context"
+	"gibber/user"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"net"
+	"os"
 	"testing"
 	"time"
 )
 
+// TestMain runs the service tests on the in-memory store, unless some other store is selected through GIBBER_STORE
+func TestMain(m *testing.M) {
+	if backend := os.Getenv("GIBBER_STORE"); backend != "" {
+		store, err := user.NewStore(backend)
+		if err != nil {
+			panic(err)
+		}
+		user.SetStore(store)
+	}
+	os.Exit(m.Run())
+}
+
 func TestStartServer(t *testing.T) {
 	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
 	go func(f context.CancelFunc) {
@@ -23,6 +38,29 @@ func TestStartServer(t *testing.T) {
 	establishClientConnection(&conn)
 }
 
+func TestServer_HTTPReadHeaderTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { limits.ReadHeaderTimeout = timeout }(limits.ReadHeaderTimeout)
+	limits.ReadHeaderTimeout = 200 * time.Millisecond
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	go func() {
+		_ = newServer().startHTTP("REST API", "localhost", "44524", nil, newAPIHandler(), cancelFunc)
+	}()
+	<-ctx.Done()
+
+	conn, err := net.Dial("tcp", "localhost:44524")
+	if err != nil {
+		t.Fatalf("unable to connect to http server at localhost:44524 : %s", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Write([]byte("GET /api/profile HTTP/1.1\r\nHost: localhost\r\n")) // never ending the headers
+	assert.NoError(t, err, "writing the request failed")
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = ioutil.ReadAll(conn)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("connection should be closed once the headers time out")
+	}
+}
+
 func TestPrintLogo(t *testing.T) {
 	assert.NoError(t, nil, printLogo(), "printing logo resulted in error")
 }
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gibber/datastore"
+	"gibber/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+	"time"
+)
+
+// session prompts and messages
+const (
+	sessionTokenMsg = "\nTo resume this session from a new connection, enter this token in place of your email " +
+		"(valid till %s, unless you exit):\n%s"
+	sessionResumedMsg = "\nSession resumed. Welcome back %s!"
+)
+
+// session errors
+var (
+	errInvalidSessionToken = errors.New("invalid or expired session token")
+	errSessionFailed       = errors.New("starting session failed")
+	errNoSession           = errors.New("no such session")
+)
+
+// sessionTokenPattern matches a session token, entered in place of the email to resume the session
+var sessionTokenPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// isSessionToken checks whether the line entered in place of the email is a session token
+func isSessionToken(line string) bool {
+	return sessionTokenPattern.MatchString(line)
+}
+
+// jsonSession is a session of the user, as listed to him
+type jsonSession struct {
+	user.Session
+	Current bool `json:"current"` // the session of the connection listing them
+}
+
+// login logs in the user with the first line he entered: either the token of a session to resume, or his email to
+// authenticate him and start a new session, whose token he is told
+func (c *client) login(firstLine string) {
+	if isSessionToken(firstLine) {
+		err := c.resumeSession(firstLine)
+		if err == nil {
+			c.sendMessage(fmt.Sprintf(sessionResumedMsg, c.FirstName), true)
+			c.sendMessage(dashboardHeader, true)
+			return
+		}
+		c.sendMessage(err.Error(), true)
+		if firstLine = c.sendAndReceiveMsg(emailPrompt, false, false); c.Err != nil {
+			return
+		}
+	}
+	c.authenticate(firstLine)
+	if c.Err != nil {
+		return
+	}
+	token, err := c.startSession(c.User)
+	if err != nil {
+		c.sendMessage(err.Error(), true)
+		c.Err = err
+		return
+	}
+	c.sendMessage(fmt.Sprintf(sessionTokenMsg, c.session.ExpiresAt.Format(time.RFC1123), token), true)
+}
+
+// startSession starts a new session of the given authenticated user, who gets served by this client, giving its
+// token. The logs of the client are tagged with the user before the session is shared with other connections.
+func (c *client) startSession(usr *user.User) (token string, err error) {
+	session, token, err := usr.StartSession(user.ChatSession, limits.SessionTTL, (*c.Conn).RemoteAddr().String())
+	if err != nil {
+		return "", errSessionFailed
+	}
+	if c.User != usr { // not tagged yet, logged in through the JSON protocol
+		c.User = usr
+		c.tagUser()
+	}
+	c.connect(session)
+	return
+}
+
+// startAPISession starts a new API session of the given authenticated user, from the given source, giving the
+// token authenticating his requests
+func startAPISession(usr *user.User, source string) (token string, err error) {
+	if _, token, err = usr.StartSession(user.APISession, limits.TokenTTL, source); err != nil {
+		return "", errSessionFailed
+	}
+	return
+}
+
+// resumeSession resumes the session of the given token, served by this client, logging in its user
+func (c *client) resumeSession(token string) error {
+	usr, session, err := user.ResumeSession(token, (*c.Conn).RemoteAddr().String())
+	if err == user.ErrInvalidSessionToken {
+		c.logger().Warnf("client %s => invalid session token", (*c.Conn).RemoteAddr())
+		return errInvalidSessionToken
+	} else if err != nil {
+		return errInternalError
+	}
+	c.User = usr
+	c.tagUser()
+	c.logger().Infof("user %s successfully resumed session %s", c.Email, session.ID.Hex())
+	c.connect(session)
+	return nil
+}
+
+// connect makes the user online through the given session, served by this client, whose logs are to be tagged
+// with the user already (the heartbeats and a connection resuming the session log through them). A connection
+// still holding the session, resumed from this one, is closed: the client was likely disconnected without its
+// connection noticing it yet.
+func (c *client) connect(session *user.Session) {
+	c.session = session
+	if previous := onlineUsers.join(session.ID, c); previous != nil {
+		previous.logger().Infof("session %s resumed from %s, closing the connection", session.ID.Hex(),
+			(*c.Conn).RemoteAddr())
+		previous.close()
+	}
+}
+
+// logoutUser ends the session of the user served by this client, if any. It is revoked if the user logged out,
+// and stays resumable till it expires otherwise (e.g. on a dropped connection). It is left as is if it was resumed
+// from another connection meanwhile. The user stays online as long as he has another live session.
+func (c *client) logoutUser() {
+	if c.session == nil { // not logged in
+		return
+	}
+	if !onlineUsers.leave(c.session.ID, c) {
+		c.session = nil
+		return
+	}
+	var err error
+	if c.loggingOut {
+		if err = c.User.Logout(c.session.ID); err == datastore.ErrNoDocUpdate { // revoked meanwhile
+			err = nil
+		}
+	} else {
+		err = c.User.Disconnect(c.session.ID)
+	}
+	if err != nil {
+		c.logger().Errorf("error while logging out client %s: %s", c.User.Email, err)
+		c.Err = errLogoutFailed
+	}
+	c.session = nil
+}
+
+// listJSONSessions lists the live sessions of the user, telling the given current one
+func listJSONSessions(usr *user.User, current *user.Session) (data interface{}, err error) {
+	sessions, err := usr.Sessions()
+	if err != nil {
+		return nil, errInternalError
+	}
+	list := make([]jsonSession, len(sessions))
+	for i, session := range sessions {
+		list[i] = jsonSession{Session: session, Current: current != nil && session.ID == current.ID}
+	}
+	return map[string]interface{}{"sessions": list}, nil
+}
+
+// revokeJSONSession logs the user out of the given session, revoking its token
+func revokeJSONSession(usr *user.User, sessionID primitive.ObjectID) error {
+	err := usr.Logout(sessionID)
+	if err == datastore.ErrNoDocUpdate {
+		return errNoSession
+	} else if err != nil {
+		return errLogoutFailed
+	}
+	return nil
+}
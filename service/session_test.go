@@ -0,0 +1,230 @@
+package service
+
+import (
+	user2 "gibber/user"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// sessionTokenFound matches the session token told to the user at login
+var sessionTokenFound = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// waitSessions waits for the sessions of the user to satisfy the given condition, as the session of a closed
+// connection ends after it, failing the test if they don't in time
+func waitSessions(t *testing.T, usr *user2.User, done func(sessions []user2.Session) bool) {
+	for i := 0; i < 100; i++ {
+		sessions, err := usr.Sessions()
+		assert.NoError(t, err, "fetching sessions failed")
+		if done(sessions) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("sessions of %s not as expected in time", usr.Email)
+}
+
+func TestClient_ResumeSession(t *testing.T) {
+	usr := createTestUser(t, "Resumer")
+	conn := dialTestServer(t)
+	conn.expect("Email: ")
+	conn.send(usr.Email)
+	conn.expect("Password: ")
+	conn.send("password")
+	token := sessionTokenFound.FindString(conn.expect("Enter a choice: "))
+	assert.NotEmpty(t, token, "no session token told")
+	conn.close() // dropped, the session staying resumable
+	waitSessions(t, usr, func(sessions []user2.Session) bool { return len(sessions) == 1 && !sessions[0].Connected })
+
+	conn = dialTestServer(t)
+	conn.expect("Email: ")
+	conn.send(token)
+	conn.expect("Session resumed. Welcome back Resumer!")
+	conn.expect("Enter a choice: ")
+	presence, err := user2.GetPresence(usr.ID)
+	assert.NoError(t, err, "fetching presence failed")
+	assert.True(t, presence.Online, "user should be online through the resumed session")
+	conn.send("0") // exit, revoking the session
+	conn.expect(exitingMsg)
+	conn.close()
+	waitSessions(t, usr, func(sessions []user2.Session) bool { return len(sessions) == 0 })
+
+	conn = dialTestServer(t)
+	defer conn.close()
+	conn.expect("Email: ")
+	conn.send(token)
+	conn.expect(errInvalidSessionToken.Error())
+	conn.expect("Email: ")
+	conn.send(usr.Email)
+	conn.expect("Password: ")
+	conn.send("password")
+	conn.expect("Logged In Successfully")
+}
+
+func TestClient_ChangePassword_RevokesSessions(t *testing.T) {
+	usr := createTestUser(t, "Changer")
+	_, apiToken, err := usr.StartSession(user2.APISession, time.Hour, "10.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	conn := dialTestServer(t)
+	defer conn.close()
+	conn.login(usr.Email, "password")
+	conn.send("5") // change password
+	conn.expect("Enter your current password: ")
+	conn.send("password")
+	conn.expect("Enter your new password: ")
+	conn.send("new password")
+	conn.expect("Confirm your new password: ")
+	conn.send("new password")
+	conn.expect("Password successfully updated")
+
+	_, _, err = user2.AuthenticateAPI(apiToken)
+	assert.Equal(t, user2.ErrInvalidSessionToken, err, "changing the password revokes the other sessions")
+	sessions, err := usr.Sessions()
+	assert.NoError(t, err, "fetching sessions failed")
+	assert.Equal(t, 1, len(sessions), "the session changing the password goes on")
+}
+
+func TestClient_JSONSessions(t *testing.T) {
+	usr := createTestUser(t, "Resumer")
+	conn1 := dialJSONTestServer(t)
+	resp := conn1.request(jsonRequest{Type: loginRequest, Email: usr.Email, Password: "password"})
+	assert.True(t, resp.OK, "login failed: %s", resp.Error)
+	token, _ := resp.Data.(map[string]interface{})["session_token"].(string)
+	assert.NotEmpty(t, token, "no session token given")
+	resp = conn1.request(jsonRequest{Type: sessionsRequest})
+	assert.True(t, resp.OK, "listing sessions failed: %s", resp.Error)
+	sessions := resp.Data.(map[string]interface{})["sessions"].([]interface{})
+	assert.Equal(t, 1, len(sessions))
+	assert.Equal(t, true, sessions[0].(map[string]interface{})["current"])
+	conn1.close()
+	waitSessions(t, usr, func(sessions []user2.Session) bool { return len(sessions) == 1 && !sessions[0].Connected })
+
+	conn2 := dialJSONTestServer(t)
+	defer conn2.close()
+	resp = conn2.request(jsonRequest{Type: resumeRequest, Token: "wrong"})
+	assert.False(t, resp.OK)
+	assert.Equal(t, errInvalidSessionToken.Error(), resp.Error)
+	resp = conn2.request(jsonRequest{Type: resumeRequest, Token: token})
+	assert.True(t, resp.OK, "resuming session failed: %s", resp.Error)
+	resp = conn2.request(jsonRequest{Type: resumeRequest, Token: token})
+	assert.Equal(t, errAlreadyLoggedIn.Error(), resp.Error)
+	resp = conn2.request(jsonRequest{Type: friendsRequest})
+	assert.True(t, resp.OK, "resumed session not logged in: %s", resp.Error)
+	resp = conn2.request(jsonRequest{Type: revokeSessionRequest, SessionID: usr.ID.Hex()})
+	assert.Equal(t, errNoSession.Error(), resp.Error)
+	resp = conn2.request(jsonRequest{Type: logoutRequest})
+	assert.True(t, resp.OK, "logout failed: %s", resp.Error)
+	waitSessions(t, usr, func(sessions []user2.Session) bool { return len(sessions) == 0 })
+
+	conn3 := dialJSONTestServer(t)
+	defer conn3.close()
+	resp = conn3.request(jsonRequest{Type: resumeRequest, Token: token})
+	assert.Equal(t, errInvalidSessionToken.Error(), resp.Error, "session should be revoked at logout")
+}
+
+func TestAPI_Sessions(t *testing.T) {
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	ac := &apiTestClient{t: t, server: server}
+	usr := createTestUser(t, "Resumer")
+	ac.login(usr.Email, "password")
+	session, token, err := usr.StartSession(user2.ChatSession, time.Hour, "127.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+
+	status, resp := ac.call(http.MethodGet, "/api/sessions", nil)
+	assert.Equal(t, http.StatusOK, status, "listing sessions failed: %s", resp.Error)
+	sessions := resp.Data.(map[string]interface{})["sessions"].([]interface{})
+	if assert.Equal(t, 2, len(sessions), "the API session is listed along with the chat one") {
+		apiSession, chatSession := sessions[0].(map[string]interface{}), sessions[1].(map[string]interface{})
+		assert.Equal(t, user2.APISession, apiSession["kind"])
+		assert.Equal(t, true, apiSession["current"], "the session of the request is the current one")
+		assert.Equal(t, session.ID.Hex(), chatSession["id"])
+	}
+
+	status, resp = ac.call(http.MethodDelete, "/api/sessions/"+session.ID.Hex(), nil)
+	assert.Equal(t, http.StatusOK, status, "revoking session failed: %s", resp.Error)
+	status, _ = ac.call(http.MethodDelete, "/api/sessions/"+session.ID.Hex(), nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	_, _, err = user2.ResumeSession(token, "127.0.0.1:1234")
+	assert.Equal(t, user2.ErrInvalidSessionToken, err, "revoked session should not be resumable")
+
+	status, resp = ac.call(http.MethodPost, "/api/logout", nil)
+	assert.Equal(t, http.StatusOK, status, "logout failed: %s", resp.Error)
+	status, _ = ac.call(http.MethodGet, "/api/profile", nil)
+	assert.Equal(t, http.StatusUnauthorized, status, "the token is revoked at logout")
+	remaining, err := usr.Sessions()
+	assert.NoError(t, err)
+	assert.Empty(t, remaining, "the API session ends at logout")
+}
+
+func TestClient_JSONRevokeAPISession(t *testing.T) {
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	ac := &apiTestClient{t: t, server: server}
+	usr := createTestUser(t, "Resumer")
+	ac.login(usr.Email, "password")
+	apiSessions, err := usr.Sessions()
+	assert.NoError(t, err)
+
+	conn := dialJSONTestServer(t)
+	defer conn.close()
+	resp := conn.request(jsonRequest{Type: loginRequest, Email: usr.Email, Password: "password"})
+	assert.True(t, resp.OK, "login failed: %s", resp.Error)
+	resp = conn.request(jsonRequest{Type: revokeSessionRequest, SessionID: apiSessions[0].ID.Hex()})
+	assert.True(t, resp.OK, "revoking the API session failed: %s", resp.Error)
+	status, _ := ac.call(http.MethodGet, "/api/profile", nil)
+	assert.Equal(t, http.StatusUnauthorized, status, "a revoked API token is refused")
+}
+
+func TestClient_ResumeHeldSession(t *testing.T) {
+	usr := createTestUser(t, "Resumer")
+	conn1 := dialJSONTestServer(t)
+	defer conn1.close()
+	resp := conn1.request(jsonRequest{Type: loginRequest, Email: usr.Email, Password: "password"})
+	assert.True(t, resp.OK, "login failed: %s", resp.Error)
+	token := resp.Data.(map[string]interface{})["session_token"].(string)
+
+	conn2 := dialJSONTestServer(t) // resumed while the first connection still holds the session
+	defer conn2.close()
+	resp = conn2.request(jsonRequest{Type: resumeRequest, Token: token})
+	assert.True(t, resp.OK, "resuming session failed: %s", resp.Error)
+	_ = conn1.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err := conn1.reader.ReadString('\n')
+	assert.Error(t, err, "the connection which held the session should be closed")
+
+	time.Sleep(50 * time.Millisecond) // for the closed connection to end its flow
+	sessions, err := usr.Sessions()
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(sessions)) {
+		assert.True(t, sessions[0].Connected, "the session stays connected through the new connection")
+	}
+	presence, err := user2.GetPresence(usr.ID)
+	assert.NoError(t, err)
+	assert.True(t, presence.Online, "user should stay online through the new connection")
+	resp = conn2.request(jsonRequest{Type: friendsRequest})
+	assert.True(t, resp.OK, "the new connection should be served: %s", resp.Error)
+}
+
+func TestPresenceRegistry_RevokedHeartbeat(t *testing.T) {
+	usr := createTestUser(t, "Revoked")
+	conn := dialJSONTestServer(t)
+	defer conn.close()
+	resp := conn.request(jsonRequest{Type: loginRequest, Email: usr.Email, Password: "password"})
+	assert.True(t, resp.OK, "login failed: %s", resp.Error)
+	assert.NoError(t, usr.LogoutAll(), "revoking the sessions failed")
+
+	r := newPresenceRegistry()
+	for sessionID, c := range onlineUsers.connected() {
+		if c.User.ID == usr.ID {
+			r.join(sessionID, c)
+		}
+	}
+	go r.heartbeat(10 * time.Millisecond)
+	defer r.stopHeartbeats()
+	_ = conn.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err := conn.reader.ReadString('\n')
+	assert.Error(t, err, "the connection of a revoked session should be closed")
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"gibber/user"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServer_Shutdown(t *testing.T) {
+	s := newServer()
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.start("localhost", "44521", nil, cancelFunc)
+	}()
+	<-ctx.Done()
+
+	menuUser, jsonUser := createTestUser(t, "John"), createTestUser(t, "Jane")
+	conn, err := net.Dial("tcp", "localhost:44521")
+	assert.NoError(t, err, "connection unsuccessful")
+	menuConn := &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	defer menuConn.close()
+	menuConn.login(menuUser.Email, "password")
+
+	conn, err = net.Dial("tcp", "localhost:44521")
+	assert.NoError(t, err, "connection unsuccessful")
+	jc := &jsonConn{testConn: &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}}
+	defer jc.close()
+	jc.send(`{"type":"handshake","protocol":"json"}`)
+	jc.response(handshakeRequest, "")
+	resp := jc.request(jsonRequest{Type: loginRequest, Email: jsonUser.Email, Password: "password"})
+	assert.True(t, resp.OK, "login failed: %s", resp.Error)
+
+	for _, usr := range []*user.User{menuUser, jsonUser} {
+		presence, err := user.GetPresence(usr.ID)
+		assert.NoError(t, err, "fetching presence failed")
+		assert.True(t, presence.Online, "user should be online before the shutdown")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	assert.NoError(t, s.shutdown(shutdownCtx), "shutdown should be graceful")
+	assert.Equal(t, ErrServerClosed, <-stopped, "server should stop serving")
+
+	menuConn.expect("Server is shutting down")
+	_, err = menuConn.reader.ReadString('\n')
+	for err == nil {
+		_, err = menuConn.reader.ReadString('\n')
+	}
+	assert.Equal(t, io.EOF, err, "connection should be closed by the server")
+	assert.True(t, jc.response(shutdownResponse, "").OK)
+
+	for _, usr := range []*user.User{menuUser, jsonUser} {
+		presence, err := user.GetPresence(usr.ID)
+		assert.NoError(t, err, "fetching presence failed")
+		assert.False(t, presence.Online, "user should be logged out by the shutdown")
+	}
+	_, err = net.Dial("tcp", "localhost:44521")
+	assert.Error(t, err, "no new client should be accepted")
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	s := newServer()
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	go func() {
+		_ = s.start("localhost", "44522", nil, cancelFunc)
+	}()
+	<-ctx.Done()
+
+	conn, err := net.Dial("tcp", "localhost:44522")
+	assert.NoError(t, err, "connection unsuccessful")
+	tc := &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	defer tc.close()
+	tc.expect("Email: ")
+
+	expired, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, s.shutdown(expired), "deadline already passed")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Equal(t, 0, len(s.sessions), "sessions should be ended by closing their connections")
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"gibber/datastore"
+	"gibber/user"
+	"golang.org/x/crypto/bcrypt"
+	"net"
+	"sync"
+	"time"
+)
+
+// sweepThreshold is the number of sources tracked beyond which the stale ones are swept
+const sweepThreshold = 1024
+
+// sourceThrottle tracks the failed logins from each source IP, whichever the accounts, server-wide
+type sourceThrottle struct {
+	mu       sync.Mutex
+	failures map[string]*user.LoginFailures
+}
+
+// loginSources throttles the failed logins from each source IP
+var loginSources = newSourceThrottle()
+
+// newSourceThrottle creates a throttle tracking no source yet
+func newSourceThrottle() *sourceThrottle {
+	return &sourceThrottle{failures: make(map[string]*user.LoginFailures)}
+}
+
+// check fails with a user.LockedError if the logins from the given source are locked out at the given time
+func (s *sourceThrottle) check(source string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.failures[source]; ok {
+		return f.Check(now)
+	}
+	return nil
+}
+
+// fail records a failed login from the given source at the given time
+func (s *sourceThrottle) fail(source string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.failures[source]
+	if !ok {
+		if len(s.failures) >= sweepThreshold {
+			s.sweep(now)
+		}
+		f = &user.LoginFailures{}
+		s.failures[source] = f
+	}
+	limits.SourceLogins.Fail(f, now)
+}
+
+// sweep forgets the sources whose failed logins are out of the window and no longer lock them out
+func (s *sourceThrottle) sweep(now time.Time) {
+	for source, f := range s.failures {
+		if now.Sub(f.Since) > limits.SourceLogins.Window && f.Check(now) == nil {
+			delete(s.failures, source)
+		}
+	}
+}
+
+// authenticate logs in the given user with the given password, unless the logins from the given source (the
+// remote address of the client) are locked out. A wrong email or password counts against the source.
+func authenticate(usr *user.User, password, source string) (lastLogin string, err error) {
+	ip := sourceIP(source)
+	now := time.Now()
+	if err = loginSources.check(ip, now); err != nil {
+		return
+	}
+	lastLogin, err = usr.LoginUser(password)
+	if wrongCredentials(err) {
+		loginSources.fail(ip, now)
+	}
+	return
+}
+
+// authenticateCode completes the login of the given user, whose password authenticate checked, with the given
+// two-factor code, counting a wrong code as a failed login from the source
+func authenticateCode(usr *user.User, code, source string) (lastLogin string, err error) {
+	ip := sourceIP(source)
+	now := time.Now()
+	if err = loginSources.check(ip, now); err != nil {
+		return
+	}
+	lastLogin, err = usr.LoginSecondFactor(code)
+	if err == user.ErrInvalidTOTPCode {
+		loginSources.fail(ip, now)
+	}
+	return
+}
+
+// wrongCredentials checks whether the login failed for an unknown email or an incorrect password
+func wrongCredentials(err error) bool {
+	return err == bcrypt.ErrMismatchedHashAndPassword || err == datastore.ErrNoDocFound
+}
+
+// lockedOut tells whether the login failed as the account or the source is locked out
+func lockedOut(err error) bool {
+	_, ok := err.(*user.LockedError)
+	return ok
+}
+
+// sourceIP gives the IP of the given remote address, the address itself if it has no port
+func sourceIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
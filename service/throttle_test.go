@@ -0,0 +1,80 @@
+package service
+
+import (
+	user2 "gibber/user"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPI_LoginLockout(t *testing.T) {
+	defaults := limits
+	defer SetLimits(defaults)
+	throttle := user2.LoginThrottle{MaxFailures: 2, Window: time.Hour, Lockout: time.Hour}
+	SetLimits(Limits{MaxAttempts: 3, PasswordMinLength: 6, TokenTTL: time.Hour, AccountLogins: throttle,
+		SourceLogins: user2.LoginThrottle{MaxFailures: 4, Window: time.Hour, Lockout: time.Hour}})
+	loginSources = newSourceThrottle()
+	defer func() { loginSources = newSourceThrottle() }()
+
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	ac := &apiTestClient{t: t, server: server}
+	login := func(email, password string) (int, apiResponse) {
+		return ac.call(http.MethodPost, "/api/login", map[string]string{"email": email, "password": password})
+	}
+
+	// too many failures lock the account out, whatever the password
+	locked := createTestUser(t, "Locked")
+	for i := 0; i < 2; i++ {
+		status, resp := login(locked.Email, "wrong")
+		assert.Equal(t, http.StatusUnauthorized, status)
+		assert.Equal(t, errInvalidCredentials.Error(), resp.Error)
+	}
+	status, resp := login(locked.Email, "password")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.Contains(t, resp.Error, "too many failed logins, try again after")
+
+	// too many failures from the same IP lock it out, whichever the accounts
+	other := createTestUser(t, "Other")
+	status, _ = login(other.Email, "password")
+	assert.Equal(t, http.StatusOK, status, "other accounts aren't locked out")
+	for i := 0; i < 2; i++ {
+		status, _ = login("unknown"+randomString(10)+"@doe.com", "password")
+		assert.Equal(t, http.StatusUnauthorized, status)
+	}
+	status, resp = login(other.Email, "password")
+	assert.Equal(t, http.StatusTooManyRequests, status, "the IP should be locked out")
+	assert.Contains(t, resp.Error, "too many failed logins")
+
+	loginSources = newSourceThrottle()
+	assert.NoError(t, user2.UnlockAccount(locked.Email), "unlocking failed")
+	ac.login(locked.Email, "password")
+}
+
+func TestClient_LoginLockout(t *testing.T) {
+	defaults := limits
+	defer SetLimits(defaults)
+	throttle := user2.LoginThrottle{MaxFailures: 2, Window: time.Hour, Lockout: time.Hour}
+	SetLimits(Limits{MaxAttempts: 3, PasswordMinLength: 6, TokenTTL: time.Hour, AccountLogins: throttle,
+		SourceLogins: defaults.SourceLogins})
+	loginSources = newSourceThrottle()
+	defer func() { loginSources = newSourceThrottle() }()
+
+	usr := createTestUser(t, "Locked")
+	conn := dialTestServer(t)
+	defer conn.close()
+	conn.expect("Email: ")
+	conn.send(usr.Email)
+	conn.expect("Password: ")
+	conn.send("wrong")
+	conn.expect(failedLogin + ": " + errIncorrectPassword.Error())
+	conn.expect("Password: ")
+	conn.send("wrong")
+	conn.expect(failedLogin + ": " + errIncorrectPassword.Error())
+	conn.expect("Password: ")
+	conn.send("password")
+	conn.expect(failedLogin + ": too many failed logins, try again after")
+	conn.expect(exitingMsg)
+}
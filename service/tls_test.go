@@ -0,0 +1,155 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// selfSignedCertificate generates a certificate for localhost signed by itself, usable by both the server and
+// the clients, and writes it along with its key as PEM files in the given directory
+func selfSignedCertificate(t *testing.T, dir string) (certFile, keyFile string, cert tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "generating key failed")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "localhost", Organization: []string{"Gibber Test"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err, "creating certificate failed")
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err, "encoding key failed")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600), "writing certificate failed")
+	assert.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600), "writing key failed")
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	assert.NoError(t, err, "loading generated certificate failed")
+	return
+}
+
+// startTestTLSServer starts a TLS server on the given port, with a freshly generated self-signed certificate,
+// requiring the clients to present it too if asked. It gives the configuration for the clients to connect.
+func startTestTLSServer(t *testing.T, port string, clientAuth bool) *tls.Config {
+	dir, err := ioutil.TempDir("", "gibber-tls")
+	assert.NoError(t, err, "creating certificate directory failed")
+	defer func() { _ = os.RemoveAll(dir) }()
+	certFile, keyFile, cert := selfSignedCertificate(t, dir)
+
+	clientCAFile := ""
+	if clientAuth {
+		clientCAFile = certFile
+	}
+	config, err := TLSConfig(certFile, keyFile, clientCAFile)
+	assert.NoError(t, err, "loading TLS configuration failed")
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	go func() {
+		_ = StartTLSServer("localhost", port, config, cancelFunc)
+	}()
+	<-ctx.Done()
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.NoError(t, err, "parsing generated certificate failed")
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+	return &tls.Config{RootCAs: roots, ServerName: "localhost", Certificates: []tls.Certificate{cert}}
+}
+
+func TestStartTLSServer(t *testing.T) {
+	clientConfig := startTestTLSServer(t, "44518", false)
+	usr := createTestUser(t, "John")
+
+	conn, err := tls.Dial("tcp", "localhost:44518", clientConfig)
+	if err != nil {
+		t.Fatalf("unable to connect to tls server at localhost:44518 : %s", err)
+	}
+	tc := &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	defer tc.close()
+	tc.login(usr.Email, "password")
+	tc.send("0")
+	tc.expect(exitingMsg)
+}
+
+func TestStartTLSServer_ClientCertificate(t *testing.T) {
+	clientConfig := startTestTLSServer(t, "44519", true)
+
+	anonymous := clientConfig.Clone()
+	anonymous.Certificates = nil
+	conn, err := tls.Dial("tcp", "localhost:44519", anonymous)
+	if err == nil { // the rejection may only surface on the first read with TLS 1.3
+		_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		_, err = bufio.NewReader(conn).ReadString('\n')
+		_ = conn.Close()
+	}
+	assert.Error(t, err, "a client without certificate should be refused")
+
+	conn, err = tls.Dial("tcp", "localhost:44519", clientConfig)
+	if err != nil {
+		t.Fatalf("unable to connect to tls server at localhost:44519 : %s", err)
+	}
+	tc := &testConn{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	defer tc.close()
+	tc.expect(welcomeMsg)
+}
+
+func TestStartTLSServer_HandshakeTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { tlsHandshakeTimeout = timeout }(tlsHandshakeTimeout)
+	tlsHandshakeTimeout = 200 * time.Millisecond
+	startTestTLSServer(t, "44523", false)
+
+	conn, err := net.Dial("tcp", "localhost:44523") // never starting the handshake
+	if err != nil {
+		t.Fatalf("unable to connect to tls server at localhost:44523 : %s", err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		t.Fatal("connection should be closed once the handshake times out")
+	}
+	assert.Error(t, err, "connection should be closed once the handshake times out")
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gibber-tls")
+	assert.NoError(t, err, "creating certificate directory failed")
+	defer func() { _ = os.RemoveAll(dir) }()
+	certFile, keyFile, _ := selfSignedCertificate(t, dir)
+
+	config, err := TLSConfig(certFile, keyFile, "")
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+	config, err = TLSConfig(certFile, keyFile, certFile)
+	assert.NoError(t, err)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	_, err = TLSConfig(certFile, filepath.Join(dir, "missing.pem"), "")
+	assert.Error(t, err, "missing key should fail")
+	_, err = TLSConfig(certFile, keyFile, keyFile)
+	assert.Error(t, err, "client CA file without certificate should fail")
+}
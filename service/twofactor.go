@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gibber/user"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// two-factor authentication prompts and messages
+const (
+	twoFactorCodePrompt    = "Two-factor code (or a recovery code): "
+	enableTwoFactorPrompt  = "\nTwo-factor authentication is off. Turn it on? (y/N): "
+	disableTwoFactorPrompt = "\nTwo-factor authentication is on. Turn it off? (y/N): "
+	totpURIMsg             = "\nAdd this key to your authenticator app (or scan it as a QR code):\n%s"
+	confirmTOTPPrompt      = "Code shown by the app (\"q\" to quit): "
+	recoveryCodesMsg       = "\nTwo-factor authentication is on. Keep these recovery codes safe, each can be used " +
+		"once in place of a code:\n%s"
+	twoFactorDisabledMsg = "\nTwo-factor authentication is off."
+)
+
+// two-factor authentication errors
+var (
+	errSecondFactorRequired = errors.New("two-factor code required")
+	errInvalidTOTPCode      = errors.New("invalid two-factor code")
+	errTwoFactorEnabled     = errors.New("two-factor authentication already enabled")
+	errTwoFactorDisabled    = errors.New("two-factor authentication not enabled")
+	errTwoFactorFailed      = errors.New("two-factor authentication setup failed")
+)
+
+// loginSecondFactor completes the login of the user, whose password was checked, with his two-factor code. It has
+// limits.MaxAttempts attempts for a correct code, failing with errInvalidTOTPCode after them.
+func (c *client) loginSecondFactor() (lastLogin string, err error) {
+	for failureCount := 0; failureCount < limits.MaxAttempts; failureCount++ {
+		code := c.sendAndReceiveMsg(twoFactorCodePrompt, false, false)
+		if c.Err != nil {
+			return "", c.Err
+		}
+		lastLogin, err = authenticateCode(c.User, code, (*c.Conn).RemoteAddr().String())
+		if err != user.ErrInvalidTOTPCode {
+			return
+		}
+		c.sendMessage(errInvalidTOTPCode.Error(), true)
+	}
+	return "", errInvalidTOTPCode
+}
+
+// manageTwoFactor lets the user turn his two-factor authentication on, or off after re-authenticating
+func (c *client) manageTwoFactor() {
+	prompt := enableTwoFactorPrompt
+	if c.TwoFactor.Enabled {
+		prompt = disableTwoFactorPrompt
+	}
+	confirm := c.sendAndReceiveMsg(prompt, false, true)
+	if c.Err != nil || strings.ToLower(confirm) != "y" {
+		return
+	}
+	if c.TwoFactor.Enabled {
+		c.disableTwoFactor()
+	} else {
+		c.enableTwoFactor()
+	}
+}
+
+// enableTwoFactor enrolls a TOTP secret for the user, turning the two-factor authentication on once he enters a
+// code generated with it
+func (c *client) enableTwoFactor() {
+	uri, err := c.User.EnrollTOTP()
+	if err != nil {
+		c.sendMessage(errTwoFactorFailed.Error(), true)
+		return
+	}
+	c.sendMessage(fmt.Sprintf(totpURIMsg, uri), true)
+	for failureCount := 0; failureCount < limits.MaxAttempts; failureCount++ {
+		code := c.sendAndReceiveMsg(confirmTOTPPrompt, false, false)
+		if c.Err != nil || strings.ToLower(code) == "q" {
+			return
+		}
+		recoveryCodes, err := c.User.ConfirmTOTP(code)
+		if err == user.ErrInvalidTOTPCode {
+			c.sendMessage(errInvalidTOTPCode.Error(), true)
+			continue
+		} else if err != nil {
+			c.sendMessage(errTwoFactorFailed.Error(), true)
+			return
+		}
+		c.sendMessage(fmt.Sprintf(recoveryCodesMsg, strings.Join(recoveryCodes, "\n")), true)
+		return
+	}
+}
+
+// disableTwoFactor turns the two-factor authentication of the user off, once he re-enters his password and a code
+func (c *client) disableTwoFactor() {
+	password := c.sendAndReceiveMsg("\nEnter your current password: ", false, false)
+	if c.Err != nil {
+		return
+	}
+	code := c.sendAndReceiveMsg(twoFactorCodePrompt, false, false)
+	if c.Err != nil {
+		return
+	}
+	if err := disableTOTP(c.User, password, code); err != nil {
+		c.sendMessage(err.Error(), true)
+		return
+	}
+	c.sendMessage(twoFactorDisabledMsg, true)
+}
+
+// disableTOTP turns the two-factor authentication of the user off, once he re-authenticates with the given
+// password and code
+func disableTOTP(usr *user.User, password, code string) error {
+	err := usr.DisableTOTP(password, code)
+	switch {
+	case err == nil:
+		return nil
+	case lockedOut(err):
+		return err
+	case err == bcrypt.ErrMismatchedHashAndPassword:
+		return errIncorrectPassword
+	case err == user.ErrInvalidTOTPCode:
+		return errInvalidTOTPCode
+	case err == user.ErrTwoFactorDisabled:
+		return errTwoFactorDisabled
+	default:
+		return errTwoFactorFailed
+	}
+}
+
+// enrollJSONTOTP enrolls a TOTP secret for the user, giving its otpauth URI
+func enrollJSONTOTP(usr *user.User) (data interface{}, err error) {
+	uri, err := usr.EnrollTOTP()
+	if err == user.ErrTwoFactorEnabled {
+		return nil, errTwoFactorEnabled
+	} else if err != nil {
+		return nil, errTwoFactorFailed
+	}
+	return map[string]interface{}{"uri": uri}, nil
+}
+
+// confirmJSONTOTP turns the two-factor authentication of the user on with the code of the given request, giving
+// the recovery codes
+func confirmJSONTOTP(usr *user.User, req jsonRequest) (data interface{}, err error) {
+	recoveryCodes, err := usr.ConfirmTOTP(req.Code)
+	switch err {
+	case nil:
+		return map[string]interface{}{"recovery_codes": recoveryCodes}, nil
+	case user.ErrInvalidTOTPCode:
+		return nil, errInvalidTOTPCode
+	case user.ErrTwoFactorEnabled:
+		return nil, errTwoFactorEnabled
+	case user.ErrTwoFactorDisabled:
+		return nil, errTwoFactorDisabled // not enrolled
+	default:
+		return nil, errTwoFactorFailed
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	user2 "gibber/user"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// recoveryCodePattern matches the recovery codes given when turning the two-factor authentication on
+var recoveryCodePattern = regexp.MustCompile(`[0-9a-f]{5}-[0-9a-f]{5}`)
+
+// totpSecret gives the secret of the given otpauth URI
+func totpSecret(t *testing.T, uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("invalid otpauth URI %q: %s", uri, err)
+	}
+	return parsed.Query().Get("secret")
+}
+
+func TestClient_TwoFactor(t *testing.T) {
+	usr := createTestUser(t, "Careful")
+	conn := dialTestServer(t)
+	defer conn.close()
+
+	conn.login(usr.Email, "password")
+	conn.send("7")
+	conn.expect(enableTwoFactorPrompt)
+	conn.send("y")
+	received := conn.expect(confirmTOTPPrompt)
+	uri := regexp.MustCompile(`otpauth://\S+`).FindString(received)
+	assert.NotEmpty(t, uri, "no otpauth URI shown")
+	conn.send("000000")
+	conn.expect(errInvalidTOTPCode.Error())
+	code, _ := user2.TOTPCode(totpSecret(t, uri), time.Now())
+	conn.send(code)
+	recoveryCodes := recoveryCodePattern.FindAllString(conn.expect("Enter a choice: "), -1)
+	assert.Equal(t, 10, len(recoveryCodes))
+	conn.send("0")
+	conn.close()
+
+	conn = dialTestServer(t)
+	conn.expect("Email: ")
+	conn.send(usr.Email)
+	conn.expect("Password: ")
+	conn.send("password")
+	conn.expect(twoFactorCodePrompt)
+	conn.send("000000")
+	conn.expect(errInvalidTOTPCode.Error())
+	conn.send(recoveryCodes[0])
+	conn.expect("Logged In Successfully")
+	conn.expect("Enter a choice: ")
+
+	conn.send("7")
+	conn.expect(disableTwoFactorPrompt)
+	conn.send("y")
+	conn.expect("Enter your current password: ")
+	conn.send("password")
+	conn.expect(twoFactorCodePrompt)
+	conn.send(recoveryCodes[0])
+	conn.expect(errInvalidTOTPCode.Error())
+	conn.expect("Enter a choice: ")
+	conn.send("7")
+	conn.expect(disableTwoFactorPrompt)
+	conn.send("y")
+	conn.expect("Enter your current password: ")
+	conn.send("password")
+	conn.expect(twoFactorCodePrompt)
+	conn.send(recoveryCodes[1])
+	conn.expect(twoFactorDisabledMsg)
+	conn.close()
+
+	conn = dialTestServer(t)
+	defer conn.close()
+	conn.login(usr.Email, "password")
+}
+
+func TestAPI_TwoFactor(t *testing.T) {
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	ac := &apiTestClient{t: t, server: server}
+	usr := createTestUser(t, "Careful")
+	ac.login(usr.Email, "password")
+
+	status, _ := ac.call(http.MethodPut, "/api/two_factor", map[string]string{"code": "000000"})
+	assert.Equal(t, http.StatusConflict, status, "not enrolled yet")
+	status, resp := ac.call(http.MethodPost, "/api/two_factor", nil)
+	assert.Equal(t, http.StatusOK, status, "enrollment failed: %s", resp.Error)
+	secret := totpSecret(t, resp.Data.(map[string]interface{})["uri"].(string))
+	code, _ := user2.TOTPCode(secret, time.Now())
+	status, resp = ac.call(http.MethodPut, "/api/two_factor", map[string]string{"code": code})
+	assert.Equal(t, http.StatusOK, status, "confirmation failed: %s", resp.Error)
+	recoveryCodes := resp.Data.(map[string]interface{})["recovery_codes"].([]interface{})
+	assert.Equal(t, 10, len(recoveryCodes))
+	_, resp = ac.call(http.MethodGet, "/api/profile", nil)
+	assert.Equal(t, true, resp.Data.(map[string]interface{})["two_factor"])
+
+	login := map[string]string{"email": usr.Email, "password": "password"}
+	status, resp = ac.call(http.MethodPost, "/api/login", login)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, errSecondFactorRequired.Error(), resp.Error)
+	login["code"] = "000000"
+	_, resp = ac.call(http.MethodPost, "/api/login", login)
+	assert.Equal(t, errInvalidTOTPCode.Error(), resp.Error)
+	login["code"] = recoveryCodes[0].(string)
+	status, resp = ac.call(http.MethodPost, "/api/login", login)
+	assert.Equal(t, http.StatusOK, status, "two-factor login failed: %s", resp.Error)
+
+	disable := map[string]string{"password": "wrong", "code": recoveryCodes[1].(string)}
+	status, resp = ac.call(http.MethodDelete, "/api/two_factor", disable)
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Equal(t, errIncorrectPassword.Error(), resp.Error)
+	disable["password"] = "password"
+	status, resp = ac.call(http.MethodDelete, "/api/two_factor", disable)
+	assert.Equal(t, http.StatusOK, status, "disabling failed: %s", resp.Error)
+	ac.login(usr.Email, "password")
+}
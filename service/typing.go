@@ -0,0 +1,86 @@
+package service
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"time"
+)
+
+// typingTimeout is the time after which a user who signalled typing, without sending the message, is taken to
+// have stopped
+var typingTimeout = 5 * time.Second
+
+// typingSignal is the line sent by a client in an opened chat, before the line of the message, to tell that the
+// user started typing it. The ASCII SYN control character can't be part of a typed message.
+const typingSignal = "\x16"
+
+// typingTracker tracks the conversations (friends or groups) in which the user of a session is typing. The others
+// are told when he starts, and when he stops without sending the message; a message sent tells them by itself.
+type typingTracker struct {
+	mu     sync.Mutex
+	states map[primitive.ObjectID]*typingState // friend or group ID => typing state
+}
+
+// typingState is the typing of the user in a conversation, ending on timeout unless signalled again
+type typingState struct {
+	timer  *time.Timer
+	notify func(typing bool) // tells the others whether the user is typing
+}
+
+// start marks the user as typing in the conversation, telling the others through notify unless he already was.
+// The typing ends after typingTimeout, unless signalled again meanwhile.
+func (t *typingTracker) start(conversationID primitive.ObjectID, notify func(typing bool)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.states == nil {
+		t.states = make(map[primitive.ObjectID]*typingState)
+	}
+	if prev, typing := t.states[conversationID]; typing {
+		prev.timer.Stop()
+	} else {
+		notify(true)
+	}
+	state := &typingState{notify: notify}
+	t.states[conversationID] = state
+	state.timer = time.AfterFunc(typingTimeout, func() { t.expire(conversationID, state) })
+}
+
+// stop ends the typing of the user in the conversation, telling the others if asked to (i.e. unless the message
+// is sent)
+func (t *typingTracker) stop(conversationID primitive.ObjectID, notify bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, typing := t.states[conversationID]
+	if !typing {
+		return
+	}
+	state.timer.Stop()
+	delete(t.states, conversationID)
+	if notify {
+		state.notify(false)
+	}
+}
+
+// stopAll ends the typing of the user in all the conversations, telling the others, as the session ends
+func (t *typingTracker) stopAll() {
+	t.mu.Lock()
+	conversationIDs := make([]primitive.ObjectID, 0, len(t.states))
+	for conversationID := range t.states {
+		conversationIDs = append(conversationIDs, conversationID)
+	}
+	t.mu.Unlock()
+	for _, conversationID := range conversationIDs {
+		t.stop(conversationID, true)
+	}
+}
+
+// expire ends the typing which timed out, unless it was signalled again meanwhile
+func (t *typingTracker) expire(conversationID primitive.ObjectID, state *typingState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.states[conversationID] != state {
+		return
+	}
+	delete(t.states, conversationID)
+	state.notify(false)
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"testing"
+	"time"
+)
+
+// typingRecorder records the notifications of a typing tracker
+type typingRecorder struct {
+	mu       sync.Mutex
+	notified []bool
+}
+
+func (r *typingRecorder) notify(typing bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notified = append(r.notified, typing)
+}
+
+func (r *typingRecorder) get() []bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]bool(nil), r.notified...)
+}
+
+func TestTypingTracker(t *testing.T) {
+	prev := typingTimeout
+	typingTimeout = 50 * time.Millisecond
+	defer func() { typingTimeout = prev }()
+	var tracker typingTracker
+	conversationID := primitive.NewObjectID()
+
+	// signalled again while typing, told once
+	sent := &typingRecorder{}
+	tracker.start(conversationID, sent.notify)
+	tracker.start(conversationID, sent.notify)
+	assert.Equal(t, []bool{true}, sent.get())
+	tracker.stop(conversationID, false) // the message sent tells it by itself
+	time.Sleep(2 * typingTimeout)
+	assert.Equal(t, []bool{true}, sent.get(), "no timeout once stopped")
+
+	// not sent, the typing times out
+	expired := &typingRecorder{}
+	tracker.start(conversationID, expired.notify)
+	assert.Eventually(t, func() bool { return len(expired.get()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []bool{true, false}, expired.get())
+
+	// the session ends, the typing stops in all the conversations
+	ended := &typingRecorder{}
+	tracker.start(conversationID, ended.notify)
+	tracker.start(primitive.NewObjectID(), ended.notify)
+	tracker.stopAll()
+	assert.Equal(t, []bool{true, true, false, false}, ended.get())
+	time.Sleep(2 * typingTimeout)
+	assert.Equal(t, 4, len(ended.get()), "no timeout once stopped")
+}
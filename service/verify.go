@@ -0,0 +1,83 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"gibber/user"
+	"strings"
+)
+
+// email verification prompts and messages
+const (
+	verificationCodeSentMsg = "\nA verification code has been sent to %s."
+	unverifiedEmailMsg      = "\nYour email %s is not verified yet. Till it is, you can't invite anyone, nor be found " +
+		"by others."
+	verificationCodePrompt = "Verification code (\"r\" to resend it, \"s\" to skip for now): "
+	emailVerifiedMsg       = "\nEmail verified"
+)
+
+// email verification errors
+var (
+	errEmailNotVerified        = errors.New("email not verified")
+	errInvalidVerificationCode = errors.New("invalid or expired verification code")
+	errSendCodeFailed          = errors.New("sending verification code failed")
+	errAlreadyVerified         = errors.New("email already verified")
+)
+
+// verifyEmail lets the user verify his email with the code mailed to him, sending him one first if asked. He may
+// skip it, to verify it on his next login.
+func (c *client) verifyEmail(sendCode bool) {
+	c.sendMessage(fmt.Sprintf(unverifiedEmailMsg, c.Email), true)
+	for {
+		if sendCode {
+			if err := c.User.SendVerificationCode(limits.VerificationTTL); err != nil {
+				c.sendMessage(errSendCodeFailed.Error(), true)
+			} else {
+				c.sendMessage(fmt.Sprintf(verificationCodeSentMsg, c.Email), true)
+			}
+		}
+		code := c.sendAndReceiveMsg(verificationCodePrompt, false, false)
+		if c.Err != nil {
+			return
+		}
+		switch strings.ToLower(code) {
+		case "":
+			sendCode = false // told already
+		case "s":
+			return
+		case "r":
+			sendCode = true
+		default:
+			sendCode = false
+			if err := c.User.VerifyEmail(code); err == user.ErrInvalidVerificationCode {
+				c.sendMessage(errInvalidVerificationCode.Error(), true)
+			} else if err != nil {
+				c.sendMessage(errInternalError.Error(), true)
+			} else {
+				c.sendMessage(emailVerifiedMsg, true)
+				return
+			}
+		}
+	}
+}
+
+// verifyJSONEmail verifies the email of the user with the code of the given request
+func verifyJSONEmail(usr *user.User, req jsonRequest) error {
+	if err := usr.VerifyEmail(req.Code); err == user.ErrInvalidVerificationCode {
+		return errInvalidVerificationCode
+	} else if err != nil {
+		return errInternalError
+	}
+	return nil
+}
+
+// resendJSONVerification mails a new verification code to the user, unless his email is already verified
+func resendJSONVerification(usr *user.User) error {
+	if !usr.Unverified {
+		return errAlreadyVerified
+	}
+	if err := usr.SendVerificationCode(limits.VerificationTTL); err != nil {
+		return errSendCodeFailed
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"gibber/mail"
+	user2 "gibber/user"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// mailedCode gives the verification code last mailed to the given email
+func mailedCode(t *testing.T, mailer *mail.MemoryMailer, email string) string {
+	msg, ok := mailer.Last(email)
+	if !ok {
+		t.Fatalf("no email sent to %s", email)
+	}
+	return regexp.MustCompile(`\b[0-9]{6}\b`).FindString(msg.Body)
+}
+
+func TestClient_VerifyEmail(t *testing.T) {
+	mailer := useTestMailer(t)
+	email := strings.ToLower("verify" + randomString(15) + "@doe.com")
+	conn := dialTestServer(t)
+	defer conn.close()
+
+	conn.expect("Email: ")
+	conn.send(email)
+	conn.expect(firstNamePrompt)
+	conn.send("Vera")
+	conn.expect(lastNamePrompt)
+	conn.send("Doe")
+	conn.expect(setPasswordPrompt)
+	conn.send("password")
+	conn.expect(confirmSetPasswordPrompt)
+	conn.send("password")
+	conn.expect(successfulRegistration)
+	conn.expect(verificationCodePrompt)
+	code := mailedCode(t, mailer, email)
+	conn.send("r")
+	conn.expect(verificationCodePrompt)
+	assert.NotEqual(t, code, mailedCode(t, mailer, email), "a resent code replaces the previous one")
+	conn.send(code)
+	conn.expect(errInvalidVerificationCode.Error())
+	conn.send(mailedCode(t, mailer, email))
+	conn.expect(emailVerifiedMsg)
+	conn.expect("Enter a choice: ")
+
+	usr, err := user2.GetUserByEmail(email)
+	assert.NoError(t, err)
+	assert.False(t, usr.Unverified, "the email should be verified")
+}
+
+func TestAPI_VerifyEmail(t *testing.T) {
+	mailer := useTestMailer(t)
+	server := httptest.NewServer(newAPIHandler())
+	defer server.Close()
+	ac := &apiTestClient{t: t, server: server}
+	inviter := createTestUser(t, "Verified")
+
+	email := strings.ToLower("verify" + randomString(15) + "@doe.com")
+	status, resp := ac.call(http.MethodPost, "/api/users", map[string]string{"email": email, "password": "password",
+		"first_name": "Vera", "last_name": "Doe"})
+	assert.Equal(t, http.StatusCreated, status, "registration failed: %s", resp.Error)
+	assert.Equal(t, false, resp.Data.(map[string]interface{})["verified"])
+	ac.token = resp.Data.(map[string]interface{})["token"].(string)
+
+	status, _ = ac.call(http.MethodPost, "/api/invitations", map[string]string{"email": inviter.Email})
+	assert.Equal(t, http.StatusForbidden, status, "only verified users can invite")
+	other := &apiTestClient{t: t, server: server}
+	other.login(inviter.Email, "password")
+	status, _ = other.call(http.MethodPost, "/api/invitations", map[string]string{"email": email})
+	assert.Equal(t, http.StatusNotFound, status, "unverified users can't be found")
+
+	status, _ = ac.call(http.MethodPut, "/api/verification", map[string]string{"code": "wrong"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = ac.call(http.MethodPost, "/api/verification", nil)
+	assert.Equal(t, http.StatusOK, status)
+	status, resp = ac.call(http.MethodPut, "/api/verification", map[string]string{"code": mailedCode(t, mailer, email)})
+	assert.Equal(t, http.StatusOK, status, "verification failed: %s", resp.Error)
+	status, _ = ac.call(http.MethodPost, "/api/verification", nil)
+	assert.Equal(t, http.StatusConflict, status, "the email is verified already")
+	status, _ = ac.call(http.MethodPost, "/api/invitations", map[string]string{"email": inviter.Email})
+	assert.Equal(t, http.StatusCreated, status)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"gibber/log"
+	"github.com/gorilla/websocket"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// path on which the HTTP listener upgrades the requests to WebSocket
+const webSocketPath = "/ws"
+
+// upgrader upgrades the HTTP requests of the browsers to WebSocket. Only the pages served from the same origin
+// as the gateway are allowed to connect.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// StartWebSocketServer starts a WebSocket gateway for the browser clients on the given host and port, over TLS
+// if a configuration is given. Each WebSocket session is served the same way as a TCP client: each text message
+// sent by the browser is a line of input, and each text message received is some output of the server.
+// The context is taken to signal that the server is initialized successfully
+func StartWebSocketServer(host, port string, config *tls.Config, complete context.CancelFunc) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(webSocketPath, defaultServer.serveWebSocket)
+	return defaultServer.startHTTP("WebSocket", host, port, config, mux, complete)
+}
+
+// serveWebSocket upgrades the request to WebSocket, and serves the session till it is closed
+func (s *server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Logger().Errorf("client %s => websocket upgrade failed: %s", r.RemoteAddr, err)
+		return // upgrader has already replied with the error
+	}
+	log.Logger().Infof("client %s => websocket connection established successfully", ws.RemoteAddr())
+	var conn net.Conn = &wsConn{ws: ws}
+	s.establishClientConnection(&conn)
+}
+
+// wsConn adapts a WebSocket session to a net.Conn, so that it can be served by the line based client flow.
+// Each message read is a line, ended by a newline if the browser didn't, and each write is sent as a message.
+type wsConn struct {
+	ws        *websocket.Conn
+	pending   bytes.Buffer // rest of the last message read
+	writeLock sync.Mutex   // a WebSocket supports a single writer at a time
+}
+
+// Read reads the messages sent by the browser as a stream of lines
+func (c *wsConn) Read(b []byte) (n int, err error) {
+	for c.pending.Len() == 0 {
+		var msgType int
+		var msg []byte
+		msgType, msg, err = c.ws.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				err = io.EOF // closed by the browser, like a TCP client disconnecting
+			}
+			return
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
+		c.pending.Write(msg)
+		if !bytes.HasSuffix(msg, []byte("\n")) {
+			c.pending.WriteByte('\n')
+		}
+	}
+	return c.pending.Read(b)
+}
+
+// Write sends the given data as a single text message
+func (c *wsConn) Write(b []byte) (n int, err error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if err = c.ws.WriteMessage(websocket.TextMessage, b); err != nil {
+		return
+	}
+	return len(b), nil
+}
+
+// Close closes the WebSocket session, telling the browser first
+func (c *wsConn) Close() error {
+	c.writeLock.Lock()
+	_ = c.ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeLock.Unlock()
+	return c.ws.Close()
+}
+
+// LocalAddr gives the local address of the underlying connection
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+// RemoteAddr gives the address of the browser
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+// SetDeadline sets both the read and the write deadlines
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for the messages to be read
+func (c *wsConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for the messages to be sent
+func (c *wsConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+const webSocketTestURL = "ws://localhost:44520" + webSocketPath
+
+func init() {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
+	go func() {
+		_ = StartWebSocketServer("localhost", "44520", nil, cancelFunc)
+	}()
+	<-ctx.Done() // wait till the gateway is initialized completely
+}
+
+// wsTestConn is a browser connected to the test gateway, driving the user flow through its prompts
+type wsTestConn struct {
+	t        *testing.T
+	ws       *websocket.Conn
+	received string // received but not yet expected
+}
+
+// dialWebSocketTestServer connects a new browser to the test gateway
+func dialWebSocketTestServer(t *testing.T) *wsTestConn {
+	ws, _, err := websocket.DefaultDialer.Dial(webSocketTestURL, nil)
+	if err != nil {
+		t.Fatalf("unable to connect to websocket gateway at %s : %s", webSocketTestURL, err)
+	}
+	return &wsTestConn{t: t, ws: ws}
+}
+
+// expect reads the messages from the gateway until the given text is received, failing the test if it doesn't
+// come in time
+func (wc *wsTestConn) expect(text string) {
+	_ = wc.ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for !strings.Contains(wc.received, text) {
+		_, msg, err := wc.ws.ReadMessage()
+		if err != nil {
+			wc.t.Fatalf("expecting %q failed, received %q: %s", text, wc.received, err)
+		}
+		wc.received += string(msg)
+	}
+	wc.received = wc.received[strings.Index(wc.received, text)+len(text):]
+}
+
+// send sends a line of user input as a message, without any newline as a browser would
+func (wc *wsTestConn) send(line string) {
+	assert.NoError(wc.t, wc.ws.WriteMessage(websocket.TextMessage, []byte(line)), "writing %q failed", line)
+}
+
+// close closes the browser connection
+func (wc *wsTestConn) close() {
+	_ = wc.ws.Close()
+}
+
+func TestWebSocket_RegisterAndLogin(t *testing.T) {
+	email := strings.ToLower("web" + randomString(15) + "@doe.com")
+	wc := dialWebSocketTestServer(t)
+	wc.expect(welcomeMsg)
+	wc.expect("Email: ")
+	wc.send(email)
+	wc.expect(firstNamePrompt)
+	wc.send("Webb")
+	wc.expect(lastNamePrompt)
+	wc.send("Doe")
+	wc.expect(setPasswordPrompt)
+	wc.send("password")
+	wc.expect(confirmSetPasswordPrompt)
+	wc.send("password")
+	wc.expect(successfulRegistration)
+	wc.close()
+
+	wc = dialWebSocketTestServer(t)
+	defer wc.close()
+	wc.expect("Email: ")
+	wc.send(email)
+	wc.expect("Password: ")
+	wc.send("password")
+	wc.expect("Logged In Successfully")
+	wc.expect(verificationCodePrompt)
+	wc.send("s") // verified later
+	wc.expect("Enter a choice: ")
+	wc.send("0")
+	wc.expect(exitingMsg)
+}
+
+func TestWebSocket_ChatWithTCPClient(t *testing.T) {
+	webUser, tcpUser := createTestFriends(t)
+
+	wc := dialWebSocketTestServer(t)
+	defer wc.close()
+	wc.expect("Email: ")
+	wc.send(webUser.Email)
+	wc.expect("Password: ")
+	wc.send("password")
+	wc.expect("Enter a choice: ")
+	wc.send("1") // start chat
+	wc.expect("Enter a friend's index to start chat: ")
+	wc.send("1")
+	wc.expect(chatPrompt)
+
+	tc := dialTestServer(t)
+	defer tc.close()
+	tc.login(tcpUser.Email, "password")
+	tc.send("1")
+	tc.expect("Enter a friend's index to start chat: ")
+	tc.send("1")
+	tc.expect(chatPrompt)
+
+	tc.send("hello browser")
+	wc.expect(tcpUser.FirstName)
+	wc.expect("hello browser")
+
+	wc.send("hello terminal")
+	tc.expect(webUser.FirstName)
+	tc.expect("hello terminal")
+	wc.send("q")
+	tc.send("q")
+}
@@ -0,0 +1,172 @@
+package user
+
+import (
+	"errors"
+	"gibber/datastore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// block list document collection name and fields
+const (
+	blocksCollection = "blocks"
+	blockedField     = "blocked_ids"
+)
+
+// block list errors
+var (
+	ErrBlocked        = errors.New("blocked")
+	errBlockSelf      = errors.New("can't block yourself")
+	errAlreadyBlocked = errors.New("user already blocked")
+)
+
+// blocks lists the users blocked by a given user
+type blocks struct {
+	ID         primitive.ObjectID   `bson:"_id" json:"-"`
+	UserID     primitive.ObjectID   `bson:"user_id" json:"user_id"`
+	BlockedIDs []primitive.ObjectID `bson:"blocked_ids" json:"blocked_ids"`
+}
+
+// clone gives a deep copy of the block list
+func (b *blocks) clone() *blocks {
+	c := *b
+	c.BlockedIDs = append(make([]primitive.ObjectID, 0, len(b.BlockedIDs)), b.BlockedIDs...)
+	return &c
+}
+
+// Block adds the given user to the block list of the current user. Their friendship (if any) ends, and the
+// invitations still active b/w them are cancelled or rejected. Till unblocked, the blocked user can't invite the
+// current user anymore, nor send him messages, not even in the groups they share.
+func (u *User) Block(userID primitive.ObjectID) (err error) {
+	if userID == u.ID {
+		return errBlockSelf
+	}
+	err = store.Transaction(func(tx Store) (er error) {
+		if er = tx.PushBlock(u.ID, userID); er != nil {
+			if er == datastore.ErrNoDocUpdate {
+				er = errAlreadyBlocked
+			}
+			return
+		}
+		if er = unfriend(tx, u.ID, userID); er != nil {
+			return
+		}
+		return dropInvitations(tx, u.ID, userID)
+	})
+	if err != nil {
+		u.logger().Errorf("blocking %s by %s failed: %s", userID.Hex(), u.Email, err)
+		return
+	}
+	u.logger().Infof("user %s blocked %s", u.Email, userID.Hex())
+	return
+}
+
+// Unblock removes the given user from the block list of the current user. Their friendship isn't restored, they
+// need to be invited again. It fails with datastore.ErrNoDocUpdate if the user isn't blocked.
+func (u *User) Unblock(userID primitive.ObjectID) (err error) {
+	if err = store.PullBlock(u.ID, userID); err != nil {
+		u.logger().Errorf("unblocking %s by %s failed: %s", userID.Hex(), u.Email, err)
+		return
+	}
+	u.logger().Infof("user %s unblocked %s", u.Email, userID.Hex())
+	return
+}
+
+// BlockedUsers gives the users blocked by the current user, in the order they were blocked
+func (u *User) BlockedUsers() (blocked []primitive.ObjectID, err error) {
+	return blockedBy(u.ID)
+}
+
+// HasBlocked checks whether the current user has blocked the given user
+func (u *User) HasBlocked(userID primitive.ObjectID) (bool, error) {
+	return isBlocked(u.ID, userID)
+}
+
+// notBlocking gives the given users except the ones who blocked the current user, so that what he sends doesn't
+// reach them. A user whose block list can't be fetched is left out as well.
+func (u *User) notBlocking(userIDs []primitive.ObjectID) []primitive.ObjectID {
+	recipients := make([]primitive.ObjectID, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if blocked, err := isBlocked(userID, u.ID); err != nil {
+			u.logger().Errorf("fetching block list of %s failed: %s", userID.Hex(), err)
+		} else if !blocked {
+			recipients = append(recipients, userID)
+		}
+	}
+	return recipients
+}
+
+// withoutBlocked gives the query leaving out the messages of the users blocked by the current user
+func (u *User) withoutBlocked(query messageQuery) (messageQuery, error) {
+	blocked, err := blockedBy(u.ID)
+	if err != nil {
+		u.logger().Errorf("fetching block list of %s failed: %s", u.Email, err)
+		return query, err
+	}
+	query.blocked = blocked
+	return query, nil
+}
+
+// blockedBy gives the users blocked by the given user, none if he hasn't blocked anyone yet
+func blockedBy(userID primitive.ObjectID) (blocked []primitive.ObjectID, err error) {
+	blockList, err := store.FindBlocks(userID)
+	if err == datastore.ErrNoDocFound {
+		return make([]primitive.ObjectID, 0), nil
+	} else if err != nil {
+		return
+	}
+	return blockList.BlockedIDs, nil
+}
+
+// isBlocked checks whether the given user has blocked the other one
+func isBlocked(userID, otherID primitive.ObjectID) (bool, error) {
+	blocked, err := blockedBy(userID)
+	if err != nil {
+		return false, err
+	}
+	return containsID(blocked, otherID), nil
+}
+
+// eitherBlocked checks whether any of the two given users has blocked the other one
+func eitherBlocked(userID1, userID2 primitive.ObjectID) (bool, error) {
+	blocked, err := isBlocked(userID1, userID2)
+	if err != nil || blocked {
+		return blocked, err
+	}
+	return isBlocked(userID2, userID1)
+}
+
+// unfriend removes the two given users from the friends list of each other, if they are friends
+func unfriend(tx Store, userID1, userID2 primitive.ObjectID) (err error) {
+	if err = pullFriendship(tx, userID1, userID2); err == errNotFriends {
+		return nil
+	}
+	return
+}
+
+// dropInvitations makes the invitations still active b/w the given user and the other one inactive, the ones sent
+// by the user being cancelled and the ones received from the other one being rejected
+func dropInvitations(tx Store, userID, otherID primitive.ObjectID) (err error) {
+	invites, err := tx.FindInvites(userID)
+	if err != nil {
+		return
+	}
+	if containsID(invites.Sent, otherID) {
+		if err = resolveInvitation(tx, userID, otherID, cancelled); err != nil {
+			return
+		}
+	}
+	if containsID(invites.Received, otherID) {
+		err = resolveInvitation(tx, otherID, userID, rejected)
+	}
+	return
+}
+
+// containsID checks whether the given ID is in the list
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package user
+
+import (
+	"gibber/datastore"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+	"time"
+)
+
+func TestUser_Block(t *testing.T) {
+	self, users := createGroupTestUsers(t, 3)
+	friend, invitee, inviter := users[0], users[1], users[2]
+	assert.Equal(t, errBlockSelf, self.Block(self.ID))
+
+	// a friend, an invitee and an inviter, the latter two having been friends only to be invited again
+	for _, other := range []*User{invitee, inviter} {
+		assert.NoError(t, store.Transaction(func(tx Store) error { return unfriend(tx, self.ID, other.ID) }))
+	}
+	assert.NoError(t, self.SendInvitation(invitee), "sending invitation failed")
+	assert.NoError(t, inviter.SendInvitation(self), "sending invitation failed")
+
+	for _, other := range users {
+		assert.NoError(t, self.Block(other.ID), "blocking failed")
+	}
+	assert.Equal(t, errAlreadyBlocked, self.Block(friend.ID))
+	blocked, err := self.BlockedUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []primitive.ObjectID{friend.ID, invitee.ID, inviter.ID}, blocked, "blocked users in order")
+	blocked, err = friend.BlockedUsers()
+	assert.NoError(t, err)
+	assert.Empty(t, blocked, "blocking is one way")
+
+	friends, err := self.SeeFriends()
+	assert.NoError(t, err)
+	assert.Empty(t, friends, "the friendship should end")
+	friends, err = friend.SeeFriends()
+	assert.NoError(t, err)
+	assert.NotContains(t, friends, self.ID, "the friendship should end for both")
+	sent, err := self.GetSentInvitations()
+	assert.NoError(t, err)
+	assert.Empty(t, sent, "the sent invitation should be cancelled")
+	cancelled, err := invitee.GetCanceledSentInvitations()
+	assert.NoError(t, err)
+	assert.Contains(t, cancelled, self.ID)
+	received, err := self.GetReceivedInvitations()
+	assert.NoError(t, err)
+	assert.Empty(t, received, "the received invitation should be rejected")
+	rejected, err := inviter.GetRejectedInvitations()
+	assert.NoError(t, err)
+	assert.Contains(t, rejected, self.ID)
+
+	assert.Equal(t, ErrBlocked, friend.SendInvitation(self), "a blocked user can't invite")
+	assert.Equal(t, ErrBlocked, self.SendInvitation(friend), "a blocked user can't be invited")
+	_, err = SendMessage(friend.ID, self.ID, "hello?")
+	assert.Equal(t, ErrBlocked, err, "a blocked user can't send messages")
+}
+
+func TestUser_BlockInGroup(t *testing.T) {
+	owner, friends := createGroupTestUsers(t, 2)
+	self, blocked := friends[0], friends[1]
+	group, err := owner.CreateGroup("group", []primitive.ObjectID{self.ID, blocked.ID})
+	assert.NoError(t, err, "creating group failed")
+	since := time.Now().UTC().Add(-time.Second)
+	assert.NoError(t, self.Block(blocked.ID), "blocking failed")
+
+	p, restore := recordEvents()
+	defer restore()
+	msg, err := blocked.SendGroupMessage(group.ID, "hello")
+	assert.NoError(t, err, "a blocked user still sends to the group")
+	assert.NoError(t, blocked.NotifyGroupTyping(group.ID, true))
+	_, err = blocked.EditGroupMessage(group.ID, msg.ID, "hello all")
+	assert.NoError(t, err, "editing message failed")
+	assert.Empty(t, p.events[self.ID], "nothing of the blocked user reaches the blocking one")
+	assert.Equal(t, 3, len(p.events[owner.ID]), "the other members get everything")
+
+	msgs, err := self.GetGroupMessages(group.ID, primitive.NilObjectID, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs, "the messages of the blocked user aren't in the history")
+	page, err := self.GetGroupChat(group.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.NotContains(t, page.Content, "hello")
+	unread, err := self.UnreadGroupCount(group)
+	assert.NoError(t, err)
+	assert.Zero(t, unread, "the messages of the blocked user aren't unread")
+	missed, err := self.MissedMessages(since)
+	assert.NoError(t, err)
+	assert.Empty(t, missed, "the messages of the blocked user aren't missed")
+	msgs, err = owner.GetGroupMessages(group.ID, primitive.NilObjectID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(msgs), "the other members read them")
+
+	assert.NoError(t, self.Unblock(blocked.ID), "unblocking failed")
+	msgs, err = self.GetGroupMessages(group.ID, primitive.NilObjectID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(msgs), "the messages show up again once unblocked")
+}
+
+func TestUser_Unblock(t *testing.T) {
+	self, users := createGroupTestUsers(t, 1)
+	other := users[0]
+	assert.Equal(t, datastore.ErrNoDocUpdate, self.Unblock(other.ID), "only a blocked user can be unblocked")
+	assert.NoError(t, self.Block(other.ID), "blocking failed")
+	blocked, err := self.HasBlocked(other.ID)
+	assert.NoError(t, err)
+	assert.True(t, blocked)
+
+	assert.NoError(t, self.Unblock(other.ID), "unblocking failed")
+	blocked, err = self.HasBlocked(other.ID)
+	assert.NoError(t, err)
+	assert.False(t, blocked)
+	friends, err := self.SeeFriends()
+	assert.NoError(t, err)
+	assert.Empty(t, friends, "the friendship isn't restored")
+	assert.NoError(t, other.SendInvitation(self), "an unblocked user can invite again")
+	assert.NoError(t, self.AddFriend(other.ID), "accepting invitation failed")
+}
@@ -1,106 +1,336 @@
 package user
 
 import (
-	"context"
+	"bytes"
 	"fmt"
 	"gibber/datastore"
 	"gibber/log"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"time"
 )
 
-// user document collection name and fields
+// chat document collection name and fields
 const (
 	chatCollection = "chats"
 	chatUser1      = "user_1"
 	chatUser2      = "user_2"
-	chatMessages   = "messages"
 )
 
+// embeddedMessagesField is the field of the chat and group documents in which their messages used to be stored,
+// before each message had a document of its own
+const embeddedMessagesField = "messages"
+
+// message document collection name and fields. Each message is a document of its own, referring to the
+// conversation (chat or group) it belongs to.
+const (
+	messageCollection        = "messages"
+	messageConversationField = "conversation_id"
+	messageSenderField       = "sender"
+	messageTextField         = "text"
+	messageTimestampField    = "timestamp"
+	messageEditedAtField     = "edited_at"
+	messageDeletedField      = "deleted"
+	messageHiddenForField    = "hidden_for"
+)
+
+// ChatPageSize is the number of messages in a page of the chat history
+const ChatPageSize = 20
+
+// MaxChatPageSize is the maximum number of messages fetched at once from the chat history
+const MaxChatPageSize = 100
+
 // message depicts the way in which a chat message is stored in the database
 type message struct {
-	Sender    primitive.ObjectID `json:"sender" bson:"sender"`
-	Text      string             `json:"text" bson:"text"`
-	Timestamp time.Time          `json:"timestamp,omitempty" bson:"timestamp"`
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	Conversation primitive.ObjectID `json:"-" bson:"conversation_id"` // chat or group
+	Sender       primitive.ObjectID `json:"sender" bson:"sender"`
+	Text         string             `json:"text" bson:"text"`
+	Timestamp    time.Time          `json:"timestamp,omitempty" bson:"timestamp"`
+	EditedAt     time.Time          `json:"edited_at,omitempty" bson:"edited_at,omitempty"` // last edit, if any
+	Deleted      bool               `json:"deleted,omitempty" bson:"deleted,omitempty"`     // for everyone
+	// users who deleted the message just for themselves
+	HiddenFor []primitive.ObjectID `json:"-" bson:"hidden_for,omitempty"`
 }
 
-// chat stores the conversation b/w two users
+// Message is a chat message as seen by the users of the chat. A deleted message keeps its place in the
+// conversation, without its text.
+type Message struct {
+	ID        primitive.ObjectID `json:"id"`
+	Sender    primitive.ObjectID `json:"sender"`
+	Text      string             `json:"text"`
+	Timestamp time.Time          `json:"timestamp"`
+	Edited    bool               `json:"edited,omitempty"`
+	Deleted   bool               `json:"deleted,omitempty"`
+}
+
+// chat stores the conversation b/w two users, its messages referring to it
 type chat struct {
-	ID       primitive.ObjectID `json:"-" bson:"_id"`
-	User1    primitive.ObjectID `json:"user_1" bson:"user_1"`
-	User2    primitive.ObjectID `json:"user_2" bson:"user_2"`
-	Messages []message          `json:"messages" bson:"messages"`
+	ID    primitive.ObjectID `json:"-" bson:"_id"`
+	User1 primitive.ObjectID `json:"user_1" bson:"user_1"`
+	User2 primitive.ObjectID `json:"user_2" bson:"user_2"`
+}
+
+// Cursor is the position of a message in a conversation, the messages being ordered by timestamp and then by ID.
+// The zero cursor is past the latest message.
+type Cursor struct {
+	Timestamp time.Time
+	ID        primitive.ObjectID
+}
+
+// ChatPage is a page of the history of a conversation
+type ChatPage struct {
+	Content  string    // formatted messages, the oldest first
+	Latest   time.Time // timestamp of the latest message of the page, zero if it has none
+	Older    Cursor    // position of the oldest message of the page, to fetch the page before it
+	HasOlder bool      // whether there are messages before the page
+}
+
+// messageQuery selects the latest messages of a conversation
+type messageQuery struct {
+	since   time.Time          // only the messages sent at or after it, if non-zero
+	after   time.Time          // only the messages sent after it, if non-zero
+	before  Cursor             // only the messages before it, if non-zero
+	notFrom primitive.ObjectID // only the messages not sent by this user, if non-zero
+	// only the messages this user didn't delete just for himself, if non-zero
+	visibleTo primitive.ObjectID
+	blocked   []primitive.ObjectID // only the messages not sent by these users (blocked by the reader), if any
+	limit     int                  // at most these many messages (the latest ones), all if zero
 }
 
 // FetchIncomingMessages fetches the incoming messages for the given user from the other user
 // that came after given timestamp
 func FetchIncomingMessages(timestamp time.Time, self, other primitive.ObjectID) (msgs []message, err error) {
-	chat, err := getChatByUserIDs(self, other, datastore.MongoConn().Collection(chatCollection))
+	chat, err := store.FindChat(self, other)
+	if err != nil {
+		log.Logger().Errorf("error fetching chat for user %s: %s", self, err)
+		return
+	}
+	all, err := store.FindMessages(chat.ID, messageQuery{since: timestamp})
 	if err != nil {
-		log.Logger().Printf("error fetching chat for user %s: %s", self, err)
+		log.Logger().Errorf("error fetching messages of chat %s: %s", chat.ID.Hex(), err)
 		return
 	}
 	msgs = make([]message, 0)
-	for _, msg := range chat.Messages {
-		if msg.Timestamp.After(timestamp) && msg.Sender.String() == other.String() {
+	for _, msg := range all {
+		if msg.Timestamp.After(timestamp) && msg.Sender == other {
 			msgs = append(msgs, msg)
-			timestamp = msg.Timestamp
 		}
 	}
 	return
 }
 
-// SendMessage sends a given message from sender to receiver
-func SendMessage(sender, receiver primitive.ObjectID, text string, updater datastore.DatabaseUpdater) (err error) {
+// SendMessage sends a given message from sender to receiver, and pushes it to the live sessions of the receiver.
+// The message is given as stored, its ID referring to it from then on. It fails if the receiver has blocked the
+// sender.
+func SendMessage(sender, receiver primitive.ObjectID, text string) (sent Message, err error) {
 	msg := message{
+		ID:        primitive.NewObjectID(),
 		Sender:    sender,
 		Text:      text,
-		Timestamp: time.Now().UTC(),
-	}
-	if sender.Hex() > receiver.Hex() { // ordering IDs
-		sender, receiver = receiver, sender
-	}
-	res, err := updater.UpdateOne(context.Background(),
-		bson.D{
-			{Key: chatUser1, Value: sender},
-			{Key: chatUser2, Value: receiver},
-		},
-		bson.D{
-			{Key: datastore.MongoPushOperator, Value: bson.D{{Key: chatMessages, Value: msg}}},
-		},
-		options.Update().SetUpsert(true))
+		Timestamp: time.Now().UTC().Truncate(time.Millisecond), // precision kept by the store
+	}
+	blocked, err := isBlocked(receiver, sender)
+	if err != nil {
+		log.Logger().Errorf("checking block of %s by %s failed: %s", sender, receiver, err)
+		return
+	}
+	if blocked {
+		err = ErrBlocked
+		return
+	}
+	err = store.PushMessage(sender, receiver, msg)
+	if err != nil {
+		log.Logger().Errorf("error while sending msg from %s to %s: %s", sender, receiver, err)
+		return
+	}
+	messagesSentTotal.Inc(directMessage)
+	publisher.Publish(receiver, Event{Type: MessageEvent, From: sender, Message: msg.ID, Text: text,
+		Timestamp: msg.Timestamp})
+	return msg.public(), nil
+}
+
+// GetChat fetches the page of the chat b/w the current user and the given user before the given cursor, the
+// latest page for the zero cursor. The content of the latest page starts with a header naming the friend, and the
+// messages of the current user which the friend has read are marked as seen.
+func (u *User) GetChat(friendID primitive.ObjectID, before Cursor) (page ChatPage, err error) {
+	friend, err := GetUserByID(friendID)
+	if err != nil {
+		u.logger().Errorf("fetching user %s failed: %s", friendID.Hex(), err)
+		return
+	}
+	if before.IsZero() {
+		page.Content = fmt.Sprintf("\n\n******************* chat: %s %s *****************\n\n",
+			friend.FirstName, friend.LastName)
+	}
+	chat, err := store.FindChat(u.ID, friendID)
+	if err == datastore.ErrNoDocFound {
+		return page, nil // no message yet
+	} else if err != nil {
+		u.logger().Errorf("fetching chat b/w %s and %s failed: %s", u.ID.Hex(), friendID.Hex(), err)
+		return
+	}
+	seenUntil, err := readTime(friendID, chat.ID)
+	if err != nil {
+		u.logger().Errorf("fetching read cursor of %s in chat %s failed: %s", friendID.Hex(), chat.ID.Hex(), err)
+		return
+	}
+	history, err := u.historyPage(chat.ID, time.Time{}, before, seenUntil, map[primitive.ObjectID]string{
+		u.ID:      "You",
+		friend.ID: friend.FirstName,
+	})
+	page.Content += history.Content
+	page.Latest, page.Older, page.HasOlder = history.Latest, history.Older, history.HasOlder
+	return
+}
+
+// GetChatMessages fetches up to limit messages of the chat b/w the current user and the given user, sent before
+// the given message (the latest ones if no message is given), the oldest first. The limit defaults to a page and
+// is bounded by MaxChatPageSize. A chat yet to be started has no message, and a message not part of the chat
+// isn't found.
+func (u *User) GetChatMessages(friendID, beforeID primitive.ObjectID, limit int) (msgs []Message, err error) {
+	chat, err := store.FindChat(u.ID, friendID)
+	if err == datastore.ErrNoDocFound && beforeID.IsZero() {
+		return make([]Message, 0), nil
+	}
 	if err != nil {
-		log.Logger().Printf("error while sending msg from %s to %s: %s", sender, receiver, err)
-	} else if res.ModifiedCount+res.UpsertedCount != 1 {
-		log.Logger().Printf("document not created/updated while sending msg from %s to %s", sender, receiver)
-		err = datastore.ErrNoDocUpdate
+		u.logger().Errorf("error fetching chat b/w %s and %s: %s", u.ID.Hex(), friendID.Hex(), err)
+		return make([]Message, 0), err
+	}
+	return u.conversationMessages(chat.ID, time.Time{}, beforeID, limit)
+}
+
+// conversationMessages fetches up to limit messages of the given conversation sent since the given time, and
+// before the given message (the latest ones if no message is given), the oldest first. The messages deleted by
+// the current user just for himself, and the ones of the users he blocked, are left out.
+func (u *User) conversationMessages(conversationID primitive.ObjectID, since time.Time, beforeID primitive.ObjectID,
+	limit int) (msgs []Message, err error) {
+	if limit <= 0 {
+		limit = ChatPageSize
+	} else if limit > MaxChatPageSize {
+		limit = MaxChatPageSize
+	}
+	msgs = make([]Message, 0)
+	query, err := u.withoutBlocked(messageQuery{since: since, visibleTo: u.ID, limit: limit})
+	if err != nil {
+		return
+	}
+	if !beforeID.IsZero() {
+		before, er := store.FindMessage(beforeID)
+		if er == nil && before.Conversation != conversationID {
+			er = datastore.ErrNoDocFound
+		}
+		if er != nil {
+			return msgs, er
+		}
+		query.before = before.cursor()
+	}
+	found, err := store.FindMessages(conversationID, query)
+	if err != nil {
+		u.logger().Errorf("error fetching messages of conversation %s: %s", conversationID.Hex(), err)
+		return
+	}
+	for _, msg := range found {
+		msgs = append(msgs, msg.public())
 	}
 	return
 }
 
-// getChatByUserIDs fetches the chat b/w two users
-// it sorts the user IDs as to avoid storing both combination of userIds in the database
-func getChatByUserIDs(userID1, userID2 primitive.ObjectID, finder datastore.DatabaseFinder) (ch *chat, err error) {
-	ch = &chat{}
-	if userID1.Hex() > userID2.Hex() { // ordering IDs
-		userID1, userID2 = userID2, userID1
+// historyPage fetches the page of the given conversation before the cursor, with the messages sent since the
+// given time only, leaving out the ones deleted by the current user just for himself and the ones of the users he
+// blocked. The messages of the current user up to the seen time (if any) are marked as seen. The senders are named
+// as given, the missing names being fetched (and added) as needed.
+func (u *User) historyPage(conversationID primitive.ObjectID, since time.Time, before Cursor, seenUntil time.Time,
+	senders map[primitive.ObjectID]string) (page ChatPage, err error) {
+	query, err := u.withoutBlocked(messageQuery{since: since, before: before, visibleTo: u.ID, limit: ChatPageSize + 1})
+	if err != nil {
+		return
 	}
-	err = finder.FindOne(context.Background(),
-		bson.D{
-			{Key: chatUser1, Value: userID1},
-			{Key: chatUser2, Value: userID2},
-		}).
-		Decode(ch)
+	msgs, err := store.FindMessages(conversationID, query)
 	if err != nil {
-		log.Logger().Printf("no chat found with user IDs %s and %s", userID1, userID2) // other errors are not expected
+		u.logger().Errorf("fetching messages of conversation %s failed: %s", conversationID.Hex(), err)
+		return
+	}
+	if len(msgs) > ChatPageSize { // one more message fetched to know whether there are older ones
+		page.HasOlder = true
+		msgs = msgs[1:]
+	}
+	for _, msg := range msgs {
+		sender, ok := senders[msg.Sender]
+		if !ok {
+			usr, _ := GetUserByID(msg.Sender)
+			sender = usr.FirstName
+			senders[msg.Sender] = sender
+		}
+		page.Content += printMessage(msg, sender)
+		if msg.Sender == u.ID && !seenUntil.IsZero() && !msg.Timestamp.After(seenUntil) {
+			page.Content += seenMarker
+		}
+		page.Content += "\n"
+	}
+	if len(msgs) > 0 {
+		page.Older = msgs[0].cursor()
+		page.Latest = msgs[len(msgs)-1].Timestamp
 	}
 	return
 }
 
-// printMessage gives the string representation for a given message
+// printMessage gives the string representation for a given message, marking it if edited or deleted
 // TODO: convert it into a Stringify interface and use it
 func printMessage(msg message, sender string) string {
-	return fmt.Sprintf("%s (%s): %s", sender, msg.Timestamp, msg.Text)
+	return fmt.Sprintf("%s (%s): %s", sender, msg.Timestamp, FormatText(msg.Text, !msg.EditedAt.IsZero(), msg.Deleted))
+}
+
+// FormatText gives the text of a message as displayed to the users, marked if edited or replaced if deleted
+func FormatText(text string, edited, deleted bool) string {
+	if deleted {
+		return DeletedMessageText
+	}
+	if edited {
+		return text + EditedMarker
+	}
+	return text
+}
+
+// public gives the message as seen by the users of the conversation
+func (m *message) public() Message {
+	msg := Message{ID: m.ID, Sender: m.Sender, Text: m.Text, Timestamp: m.Timestamp, Edited: !m.EditedAt.IsZero(),
+		Deleted: m.Deleted}
+	if m.Deleted {
+		msg.Text = ""
+	}
+	return msg
+}
+
+// hiddenFor checks whether the given user deleted the message just for himself
+func (m *message) hiddenFor(userID primitive.ObjectID) bool {
+	for _, id := range m.HiddenFor {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// cursor gives the position of the message in its conversation
+func (m *message) cursor() Cursor {
+	return Cursor{Timestamp: m.Timestamp, ID: m.ID}
+}
+
+// IsZero checks whether the cursor is past the latest message
+func (c Cursor) IsZero() bool {
+	return c.Timestamp.IsZero() && c.ID.IsZero()
+}
+
+// comesBefore checks whether the message comes before the given position in its conversation
+func (m *message) comesBefore(c Cursor) bool {
+	if !m.Timestamp.Equal(c.Timestamp) {
+		return m.Timestamp.Before(c.Timestamp)
+	}
+	return bytes.Compare(m.ID[:], c.ID[:]) < 0
+}
+
+// clone gives a deep copy of the chat
+func (c *chat) clone() *chat {
+	cl := *c
+	return &cl
 }
@@ -1,57 +1,28 @@
 package user
 
 import (
-	"context"
-	"errors"
+	"fmt"
 	"gibber/datastore"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"strings"
 	"testing"
 	"time"
 )
 
-var errUpdateFailed = errors.New("update failure")
-
-type databaseUpdateFail struct {
-	// implement DatabaseUpdate interface with failure update operation
-}
-
-func (d *databaseUpdateFail) UpdateOne(ctx context.Context, filter interface{}, update interface{},
-	opts ...*options.UpdateOptions) (res *mongo.UpdateResult, err error) {
-	err = errUpdateFailed
-	return
-}
-
-type databaseUpdateNoEffect struct {
-	// implement DatabaseUpdate interface with failure update operation
-}
-
-func (d *databaseUpdateNoEffect) UpdateOne(ctx context.Context, filter interface{}, update interface{},
-	opts ...*options.UpdateOptions) (res *mongo.UpdateResult, err error) {
-	res = new(mongo.UpdateResult) // default count values (fields) will be zero which is required
-	return
-}
-
-func TestGetChatByUserIDs(t *testing.T) {
-	userId1, userId2 := primitive.NewObjectID(), primitive.NewObjectID()
-	chat, err := getChatByUserIDs(userId1, userId2, datastore.MongoConn().Collection(chatCollection))
-	assert.Equal(t, err, mongo.ErrNoDocuments, "invalid IDs provided so chat should be unavailable")
-	assert.Equal(t, primitive.ObjectID{}.String(), chat.ID.String(), "invalid IDs provided so chat should be unavailable")
-}
-
 func TestSendMessage(t *testing.T) {
 	sender, receiver := primitive.NewObjectID(), primitive.NewObjectID()
-	err := SendMessage(sender, receiver, "test message", datastore.MongoConn().Collection(chatCollection))
+	_, err := SendMessage(sender, receiver, "test message")
 	assert.NoError(t, err, "new document should be created for the chat")
 
-	err = SendMessage(sender, receiver, "test message", new(databaseUpdateFail))
-	assert.Equal(t, errUpdateFailed, err, "operation should fail")
+	_, err = SendMessage(receiver, sender, "test message reply")
+	assert.NoError(t, err, "existing document should be updated for the chat")
 
-	err = SendMessage(sender, receiver, "test message", new(databaseUpdateNoEffect))
-	assert.Equal(t, datastore.ErrNoDocUpdate, err, "operation should fail")
+	ch, err := store.FindChat(sender, receiver)
+	assert.NoError(t, err, "chat should be created")
+	msgs, err := store.FindMessages(ch.ID, messageQuery{})
+	assert.NoError(t, err, "fetching messages failed")
+	assert.Equal(t, 2, len(msgs), "both messages should be in the same chat")
 }
 
 func TestPrintMessage(t *testing.T) {
@@ -77,12 +48,19 @@ func TestPrintMessage(t *testing.T) {
 	assert.True(t, strings.Contains(msgText, other.FirstName), "as other person is the sender")
 	assert.True(t, strings.Contains(msgText, "self message"), "text body of the message")
 	assert.True(t, strings.Contains(msgText, msg2.Timestamp.String()), "timestamp of the message")
+
+	msg2.EditedAt = time.Now().UTC()
+	assert.True(t, strings.HasSuffix(printMessage(*msg2, other.FirstName), "self message"+EditedMarker),
+		"edited message marked")
+	msg2.Deleted, msg2.Text = true, ""
+	assert.True(t, strings.HasSuffix(printMessage(*msg2, other.FirstName), ": "+DeletedMessageText),
+		"deleted message replaced")
 }
 
 func TestFetchIncomingMessages(t *testing.T) {
 	self, other := primitive.NewObjectID(), primitive.NewObjectID()
 	msgs, err := FetchIncomingMessages(time.Now().UTC(), self, other)
-	assert.Equal(t, mongo.ErrNoDocuments, err, "error as invalid users")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "error as invalid users")
 	assert.True(t, len(msgs) == 0, "empty list of messages expected")
 
 	// fetching a genuine chat, so creating users and chat b/w them
@@ -102,11 +80,73 @@ func TestFetchIncomingMessages(t *testing.T) {
 	user2ID, err := CreateUser(user2)
 	assert.NoError(t, err, "user creation failed")
 
-	err = SendMessage(user1ID.(primitive.ObjectID), user2ID.(primitive.ObjectID), "test message",
-		datastore.MongoConn().Collection(datastore.ChatCollection))
+	_, err = SendMessage(user1ID.(primitive.ObjectID), user2ID.(primitive.ObjectID), "test message")
 	assert.NoError(t, err, "new document should be created for the chat")
 
 	msgs, err = FetchIncomingMessages(time.Now().UTC().Add(-time.Minute), user2ID.(primitive.ObjectID), user1ID.(primitive.ObjectID))
 	assert.NoError(t, err, "error fetching just sent message")
 	assert.Equal(t, 1, len(msgs), "a single message is expected")
 }
+
+func TestUser_GetChatMessages(t *testing.T) {
+	self, other := &User{ID: primitive.NewObjectID()}, primitive.NewObjectID()
+	msgs, err := self.GetChatMessages(other, primitive.NilObjectID, 0)
+	assert.NoError(t, err, "a chat yet to be started is not an error")
+	assert.Equal(t, 0, len(msgs), "no message expected")
+
+	_, err = SendMessage(self.ID, other, "hello")
+	assert.NoError(t, err, "sending message failed")
+	_, err = SendMessage(other, self.ID, "hi")
+	assert.NoError(t, err, "sending message failed")
+	msgs, err = self.GetChatMessages(other, primitive.NilObjectID, 0)
+	assert.NoError(t, err, "fetching chat messages failed")
+	assert.Equal(t, 2, len(msgs), "both messages expected")
+	assert.Equal(t, self.ID, msgs[0].Sender, "oldest message first")
+	assert.Equal(t, "hello", msgs[0].Text)
+	assert.Equal(t, other, msgs[1].Sender)
+	assert.Equal(t, "hi", msgs[1].Text)
+
+	msgs, err = self.GetChatMessages(other, msgs[1].ID, 0)
+	assert.NoError(t, err, "fetching older messages failed")
+	assert.Equal(t, 1, len(msgs), "only the message before the given one expected")
+	assert.Equal(t, "hello", msgs[0].Text)
+
+	_, err = self.GetChatMessages(other, primitive.NewObjectID(), 0)
+	assert.Equal(t, datastore.ErrNoDocFound, err, "unknown message")
+	_, err = self.GetChatMessages(primitive.NewObjectID(), msgs[0].ID, 0)
+	assert.Equal(t, datastore.ErrNoDocFound, err, "message of another chat")
+}
+
+func TestUser_GetChat_Pages(t *testing.T) {
+	self, friends := createGroupTestUsers(t, 1)
+	friend := friends[0]
+	page, err := self.GetChat(friend.ID, Cursor{})
+	assert.NoError(t, err, "a chat yet to be started is not an error")
+	assert.True(t, strings.Contains(page.Content, friend.FirstName), "header naming the friend expected")
+	assert.False(t, page.HasOlder, "no message yet")
+	assert.True(t, page.Latest.IsZero(), "no message yet")
+
+	total := ChatPageSize*2 + 5
+	for i := 0; i < total; i++ {
+		_, err = SendMessage(self.ID, friend.ID, fmt.Sprintf("message %03d", i))
+		assert.NoError(t, err, "sending failed")
+	}
+	page, err = self.GetChat(friend.ID, Cursor{})
+	assert.NoError(t, err, "fetching latest page failed")
+	assert.True(t, page.HasOlder, "older messages expected")
+	assert.Equal(t, ChatPageSize, strings.Count(page.Content, "message "), "a page of messages expected")
+	assert.True(t, strings.Contains(page.Content, fmt.Sprintf("message %03d", total-1)), "latest message expected")
+	assert.False(t, page.Latest.IsZero(), "timestamp of the latest message expected")
+
+	// walking back through the history, each message being seen once
+	seen := strings.Count(page.Content, "message ")
+	for page.HasOlder {
+		page, err = self.GetChat(friend.ID, page.Older)
+		assert.NoError(t, err, "fetching older page failed")
+		assert.False(t, strings.Contains(page.Content, "chat:"), "header only on the latest page")
+		seen += strings.Count(page.Content, "message ")
+	}
+	assert.Equal(t, total, seen, "all the messages expected, once")
+	assert.True(t, strings.Contains(page.Content, "message 000"), "the oldest page ends with the first message")
+	assert.Equal(t, 5, strings.Count(page.Content, "message "), "the oldest page has the rest of the messages")
+}
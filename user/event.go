@@ -0,0 +1,53 @@
+package user
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// EventType restricts the kinds of events pushed to the users
+type EventType string
+
+// event types pushed to the live sessions of the users
+const (
+	MessageEvent      EventType = "message"       // a new chat message received
+	GroupMessageEvent EventType = "group_message" // a new message received in a group
+	ReadEvent         EventType = "read"          // the chat read by the friend, up to the timestamp
+	// a user started typing a message in a chat or group, till he sends it or TypingStoppedEvent comes
+	TypingEvent        EventType = "typing"
+	TypingStoppedEvent EventType = "typing_stopped" // a user stopped typing without sending the message
+	// a message of a chat or group edited by its sender, the event carrying the new text
+	MessageEditedEvent  EventType = "message_edited"
+	MessageDeletedEvent EventType = "message_deleted" // a message of a chat or group deleted for everyone
+)
+
+// Event is a notification pushed as it happens to the live sessions of a user, without going through the store
+type Event struct {
+	Type      EventType
+	Group     primitive.ObjectID // group in which the event occurred, if any
+	From      primitive.ObjectID // user who caused the event
+	FromName  string             // first name of the user who caused the event, if known
+	Message   primitive.ObjectID // message the event is about, if any
+	Text      string
+	Timestamp time.Time
+}
+
+// Publisher delivers the events to the live sessions of a user
+type Publisher interface {
+	Publish(to primitive.ObjectID, event Event)
+}
+
+// noPublisher drops all the events, used until some publisher is set
+type noPublisher struct{}
+
+// Publish drops the event
+func (noPublisher) Publish(primitive.ObjectID, Event) {}
+
+// publisher used to notify the users about the events concerning them
+var publisher Publisher = noPublisher{}
+
+// SetPublisher sets the publisher used to notify the users about the events concerning them.
+// It is meant to be called once at the startup, before any user is served.
+func SetPublisher(p Publisher) {
+	publisher = p
+}
@@ -0,0 +1,49 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"testing"
+)
+
+// recordingPublisher records the published events
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events map[primitive.ObjectID][]Event
+}
+
+func (p *recordingPublisher) Publish(to primitive.ObjectID, event Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events[to] = append(p.events[to], event)
+}
+
+// recordEvents sets a recording publisher, till the returned restore function is called
+func recordEvents() (p *recordingPublisher, restore func()) {
+	p = &recordingPublisher{events: make(map[primitive.ObjectID][]Event)}
+	prev := publisher
+	SetPublisher(p)
+	return p, func() { SetPublisher(prev) }
+}
+
+func TestSendMessage_Publish(t *testing.T) {
+	p, restore := recordEvents()
+	defer restore()
+	sender, receiver := primitive.NewObjectID(), primitive.NewObjectID()
+	_, err := SendMessage(sender, receiver, "test message")
+	assert.NoError(t, err, "sending message failed")
+
+	assert.Equal(t, 1, len(p.events[receiver]), "message should be published to receiver")
+	event := p.events[receiver][0]
+	assert.Equal(t, MessageEvent, event.Type)
+	assert.Equal(t, sender, event.From)
+	assert.Equal(t, "test message", event.Text)
+	assert.Equal(t, 0, len(p.events[sender]), "nothing should be published to sender")
+
+	ch, err := store.FindChat(sender, receiver)
+	assert.NoError(t, err, "chat should be stored")
+	msgs, err := store.FindMessages(ch.ID, messageQuery{})
+	assert.NoError(t, err, "message should be stored")
+	assert.Equal(t, msgs[0].Timestamp, event.Timestamp, "published and stored timestamps should match")
+}
@@ -1,6 +1,10 @@
 package user
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"errors"
+	"gibber/datastore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // user document collection name and fields
 const (
@@ -8,9 +12,66 @@ const (
 	friendsField      = "friend_ids"
 )
 
+// friendship errors
+var (
+	errNotFriends     = errors.New("not friends")
+	errAlreadyFriends = errors.New("already friends")
+)
+
 // friends lists all the connected user for a given user
 type friends struct {
 	ID        primitive.ObjectID   `bson:"_id" json:"-"`
 	UserID    primitive.ObjectID   `bson:"user_id" json:"user_id"`
 	FriendIDs []primitive.ObjectID `bson:"friend_ids" json:"friend_ids"`
 }
+
+// clone gives a deep copy of the friends list
+func (f *friends) clone() *friends {
+	c := *f
+	c.FriendIDs = append(make([]primitive.ObjectID, 0, len(f.FriendIDs)), f.FriendIDs...)
+	return &c
+}
+
+// RemoveFriend ends the friendship b/w the current user and the given friend, for both of them. The chat history
+// is deleted for both of them too, unless it is to be kept. The former friend can be invited again afterwards.
+func (u *User) RemoveFriend(friendID primitive.ObjectID, keepHistory bool) (err error) {
+	err = store.Transaction(func(tx Store) (er error) {
+		if er = pullFriendship(tx, u.ID, friendID); er != nil || keepHistory {
+			return
+		}
+		chat, er := tx.FindChat(u.ID, friendID)
+		if er == datastore.ErrNoDocFound {
+			return nil // no message yet
+		} else if er != nil {
+			return
+		}
+		return tx.DeleteMessages(chat.ID)
+	})
+	if err != nil {
+		u.logger().Errorf("removing friend %s of %s failed: %s", friendID.Hex(), u.Email, err)
+		return
+	}
+	u.logger().Infof("user %s removed friend %s (history kept: %t)", u.Email, friendID.Hex(), keepHistory)
+	return
+}
+
+// isFriendOf checks whether the given users are friends
+func isFriendOf(tx Store, userID, otherID primitive.ObjectID) (bool, error) {
+	frnds, err := tx.FindFriends(userID)
+	if err == datastore.ErrNoDocFound {
+		return false, nil // no friend yet
+	} else if err != nil {
+		return false, err
+	}
+	return containsID(frnds.FriendIDs, otherID), nil
+}
+
+// pullFriendship removes the two given users from the friends list of each other, failing if they aren't friends
+func pullFriendship(tx Store, userID1, userID2 primitive.ObjectID) (err error) {
+	if err = tx.PullFriend(userID1, userID2); err == datastore.ErrNoDocUpdate {
+		return errNotFriends
+	} else if err != nil {
+		return
+	}
+	return tx.PullFriend(userID2, userID1)
+}
@@ -2,9 +2,46 @@ package user
 
 import (
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"testing"
 )
 
 func TestNewFriend(t *testing.T) {
 	assert.Equal(t, new(friends), new(friends), "initializing new friends")
 }
+
+func TestUser_RemoveFriend(t *testing.T) {
+	self, friends := createGroupTestUsers(t, 2)
+	kept, dropped := friends[0], friends[1]
+	assert.Equal(t, errAlreadyFriends, self.SendInvitation(kept), "a friend can't be invited")
+	for _, friend := range friends {
+		_, err := SendMessage(self.ID, friend.ID, "hello")
+		assert.NoError(t, err, "sending failed")
+	}
+
+	assert.NoError(t, self.RemoveFriend(kept.ID, true), "removing friend failed")
+	assert.NoError(t, dropped.RemoveFriend(self.ID, false), "removing friend failed")
+	assert.Equal(t, errNotFriends, self.RemoveFriend(kept.ID, true), "no longer friends")
+	for _, friend := range friends {
+		frnds, err := self.SeeFriends()
+		assert.NoError(t, err)
+		assert.NotContains(t, frnds, friend.ID, "the friendship should end")
+		frnds, err = friend.SeeFriends()
+		assert.NoError(t, err)
+		assert.NotContains(t, frnds, self.ID, "the friendship should end for both")
+	}
+
+	msgs, err := kept.GetChatMessages(self.ID, primitive.NilObjectID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(msgs), "the history should be kept")
+	msgs, err = self.GetChatMessages(dropped.ID, primitive.NilObjectID, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs, "the history should be deleted for both")
+
+	// the former friend can be invited again
+	assert.NoError(t, kept.SendInvitation(self), "sending invitation failed")
+	assert.NoError(t, self.AddFriend(kept.ID), "accepting invitation failed")
+	frnds, err := self.SeeFriends()
+	assert.NoError(t, err)
+	assert.Equal(t, []primitive.ObjectID{kept.ID}, frnds)
+}
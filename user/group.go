@@ -0,0 +1,322 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"time"
+)
+
+// group document collection name and fields
+const (
+	groupCollection    = "groups"
+	groupNameField     = "name"
+	groupOwnerField    = "owner"
+	groupMembersField  = "members"
+	groupMemberIDField = "members.user_id"
+)
+
+// a group has more than two participants when created
+const groupMinOtherMember = 2
+
+// group errors
+var (
+	ErrNotGroupMember     = errors.New("not a member of the group")
+	errNotGroupOwner      = errors.New("only the group owner can remove members")
+	errAlreadyGroupMember = errors.New("already a member of the group")
+	errNotFriend          = errors.New("only friends can be added to a group")
+	errEmptyGroupName     = errors.New("group name can't be empty")
+	errGroupTooSmall      = errors.New("a group needs at least two friends besides its creator")
+)
+
+// Group is a named conversation among more than two users, its messages referring to it. Its messages are visible
+// to a member only from the time he joined it.
+type Group struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	Owner     primitive.ObjectID `bson:"owner" json:"owner"` // member who can remove the other members
+	Members   []GroupMember      `bson:"members" json:"members"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// GroupMember is a participant of a group, along with the time he joined it
+type GroupMember struct {
+	UserID   primitive.ObjectID `bson:"user_id" json:"user_id"`
+	JoinedAt time.Time          `bson:"joined_at" json:"joined_at"`
+}
+
+// CreateGroup creates a new group with the given name, having the current user (as owner) and the given
+// friends as members
+func (u *User) CreateGroup(name string, friendIDs []primitive.ObjectID) (group *Group, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errEmptyGroupName
+	}
+	friendIDs = uniqueIDs(friendIDs, u.ID)
+	if len(friendIDs) < groupMinOtherMember {
+		return nil, errGroupTooSmall
+	}
+	for _, friendID := range friendIDs {
+		if err = u.checkFriend(friendID); err != nil {
+			return
+		}
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	group = &Group{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		Owner:     u.ID,
+		Members:   []GroupMember{{UserID: u.ID, JoinedAt: now}},
+		CreatedAt: now,
+	}
+	for _, friendID := range friendIDs {
+		group.Members = append(group.Members, GroupMember{UserID: friendID, JoinedAt: now})
+	}
+	if err = store.InsertGroup(group); err != nil {
+		u.logger().Errorf("creating group %s by user %s failed: %s", name, u.Email, err)
+		return nil, err
+	}
+	u.logger().Infof("group %s (%s) created by user %s", name, group.ID.Hex(), u.Email)
+	return
+}
+
+// Groups fetches the groups of which the current user is a member
+func (u *User) Groups() (groups []*Group, err error) {
+	groups, err = store.FindGroupsByMember(u.ID)
+	if err != nil {
+		u.logger().Errorf("fetching groups of user %s failed: %s", u.Email, err)
+	}
+	return
+}
+
+// GetGroupChat fetches the page of the conversation of the given group before the given cursor (the latest page
+// for the zero cursor), with the messages since the current user joined it only. The content of the latest page
+// starts with a header naming the group.
+func (u *User) GetGroupChat(groupID primitive.ObjectID, before Cursor) (page ChatPage, err error) {
+	group, err := store.FindGroup(groupID)
+	if err != nil {
+		u.logger().Errorf("fetching group %s failed: %s", groupID.Hex(), err)
+		return
+	}
+	member := group.member(u.ID)
+	if member == nil {
+		err = ErrNotGroupMember
+		return
+	}
+	page, err = u.historyPage(groupID, member.JoinedAt, before, time.Time{},
+		map[primitive.ObjectID]string{u.ID: "You"})
+	if err == nil && before.IsZero() {
+		page.Content = fmt.Sprintf("\n\n******************* group: %s *****************\n\n", group.Name) +
+			page.Content
+	}
+	return
+}
+
+// GetGroupMessages fetches up to limit messages of the given group sent before the given message (the latest ones
+// if no message is given), the oldest first, with the messages since the current user joined it only. The limit
+// defaults to a page and is bounded by MaxChatPageSize.
+func (u *User) GetGroupMessages(groupID, beforeID primitive.ObjectID, limit int) (msgs []Message, err error) {
+	group, err := store.FindGroup(groupID)
+	if err != nil {
+		u.logger().Errorf("fetching group %s failed: %s", groupID.Hex(), err)
+		return
+	}
+	member := group.member(u.ID)
+	if member == nil {
+		err = ErrNotGroupMember
+		return
+	}
+	return u.conversationMessages(groupID, member.JoinedAt, beforeID, limit)
+}
+
+// SendGroupMessage sends a given message from the current user to the group, and pushes it to the live sessions
+// of all the other members, but the ones who blocked him. The message is given as stored, its ID referring to it
+// from then on.
+func (u *User) SendGroupMessage(groupID primitive.ObjectID, text string) (sent Message, err error) {
+	group, err := store.FindGroup(groupID)
+	if err != nil {
+		u.logger().Errorf("fetching group %s failed: %s", groupID.Hex(), err)
+		return
+	}
+	if group.member(u.ID) == nil {
+		err = ErrNotGroupMember
+		return
+	}
+	msg := message{
+		ID:           primitive.NewObjectID(),
+		Conversation: groupID,
+		Sender:       u.ID,
+		Text:         text,
+		Timestamp:    time.Now().UTC().Truncate(time.Millisecond), // precision kept by the store
+	}
+	if err = store.InsertMessage(msg); err != nil {
+		u.logger().Errorf("error while sending msg from %s to group %s: %s", u.Email, groupID.Hex(), err)
+		return
+	}
+	messagesSentTotal.Inc(groupMessage)
+	event := Event{
+		Type:      GroupMessageEvent,
+		Group:     groupID,
+		From:      u.ID,
+		FromName:  u.FirstName,
+		Message:   msg.ID,
+		Text:      text,
+		Timestamp: msg.Timestamp,
+	}
+	for _, memberID := range u.notBlocking(group.otherMembers(u.ID)) {
+		publisher.Publish(memberID, event)
+	}
+	return msg.public(), nil
+}
+
+// RenameGroup renames the given group of which the current user is a member
+func (u *User) RenameGroup(groupID primitive.ObjectID, name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errEmptyGroupName
+	}
+	return u.updateGroup(groupID, func(group *Group) (bson.M, error) {
+		return bson.M{groupNameField: name}, nil
+	})
+}
+
+// AddGroupMember adds a friend of the current user to the given group
+func (u *User) AddGroupMember(groupID, friendID primitive.ObjectID) error {
+	if err := u.checkFriend(friendID); err != nil {
+		return err
+	}
+	return u.updateGroup(groupID, func(group *Group) (bson.M, error) {
+		if group.member(friendID) != nil {
+			return nil, errAlreadyGroupMember
+		}
+		member := GroupMember{UserID: friendID, JoinedAt: time.Now().UTC().Truncate(time.Millisecond)}
+		return bson.M{groupMembersField: append(group.Members, member)}, nil
+	})
+}
+
+// RemoveGroupMember removes the given member from the group. Only the owner of the group can do so.
+func (u *User) RemoveGroupMember(groupID, memberID primitive.ObjectID) error {
+	return u.updateGroup(groupID, func(group *Group) (bson.M, error) {
+		if group.Owner != u.ID {
+			return nil, errNotGroupOwner
+		}
+		if group.member(memberID) == nil {
+			return nil, ErrNotGroupMember
+		}
+		return bson.M{groupMembersField: group.withoutMember(memberID)}, nil
+	})
+}
+
+// LeaveGroup removes the current user from the given group. If he owns the group, the ownership passes to the
+// longest standing member left.
+func (u *User) LeaveGroup(groupID primitive.ObjectID) error {
+	return u.updateGroup(groupID, func(group *Group) (bson.M, error) {
+		members := group.withoutMember(u.ID)
+		fields := bson.M{groupMembersField: members}
+		if group.Owner == u.ID && len(members) > 0 {
+			fields[groupOwnerField] = members[0].UserID
+		}
+		return fields, nil
+	})
+}
+
+// IsMember checks whether the given user is a member of the group
+func (g *Group) IsMember(userID primitive.ObjectID) bool {
+	return g.member(userID) != nil
+}
+
+// String representation of a group
+func (g *Group) String() string {
+	return g.ID.String()
+}
+
+// updateGroup atomically applies the change (giving the fields to be set) to the given group, of which the
+// current user must be a member
+func (u *User) updateGroup(groupID primitive.ObjectID, change func(group *Group) (bson.M, error)) (err error) {
+	err = store.Transaction(func(tx Store) (er error) {
+		group, er := tx.FindGroup(groupID)
+		if er != nil {
+			return
+		}
+		if group.member(u.ID) == nil {
+			return ErrNotGroupMember
+		}
+		fields, er := change(group)
+		if er != nil {
+			return
+		}
+		return tx.UpdateGroup(groupID, fields)
+	})
+	if err != nil {
+		u.logger().Errorf("updating group %s by user %s failed: %s", groupID.Hex(), u.Email, err)
+	}
+	return
+}
+
+// checkFriend checks that the given user is a friend of the current user
+func (u *User) checkFriend(userID primitive.ObjectID) error {
+	friendIDs, err := u.SeeFriends()
+	if err != nil {
+		return errNotFriend
+	}
+	for _, friendID := range friendIDs {
+		if friendID == userID {
+			return nil
+		}
+	}
+	return errNotFriend
+}
+
+// member gives the membership details of the given user, nil if he isn't a member
+func (g *Group) member(userID primitive.ObjectID) *GroupMember {
+	for i := range g.Members {
+		if g.Members[i].UserID == userID {
+			return &g.Members[i]
+		}
+	}
+	return nil
+}
+
+// otherMembers gives the IDs of the members of the group except the given user
+func (g *Group) otherMembers(userID primitive.ObjectID) []primitive.ObjectID {
+	memberIDs := make([]primitive.ObjectID, 0, len(g.Members))
+	for _, member := range g.Members {
+		if member.UserID != userID {
+			memberIDs = append(memberIDs, member.UserID)
+		}
+	}
+	return memberIDs
+}
+
+// withoutMember gives the members of the group except the given user
+func (g *Group) withoutMember(userID primitive.ObjectID) []GroupMember {
+	members := make([]GroupMember, 0, len(g.Members))
+	for _, member := range g.Members {
+		if member.UserID != userID {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// clone gives a deep copy of the group
+func (g *Group) clone() *Group {
+	c := *g
+	c.Members = append(make([]GroupMember, 0, len(g.Members)), g.Members...)
+	return &c
+}
+
+// uniqueIDs gives the given IDs without duplicates and without the excluded ID, keeping their order
+func uniqueIDs(ids []primitive.ObjectID, exclude primitive.ObjectID) []primitive.ObjectID {
+	seen := map[primitive.ObjectID]bool{exclude: true}
+	unique := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,113 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createGroupTestUsers creates a user along with the given number of his friends
+func createGroupTestUsers(t *testing.T, friendCount int) (owner *User, friends []*User) {
+	owner = &User{FirstName: "Owner", LastName: "Doe", Email: "john" + randomString(15) + "@doe.com",
+		Password: "password"}
+	_, err := CreateUser(owner)
+	assert.NoError(t, err, "user creation failed")
+	for i := 0; i < friendCount; i++ {
+		friend := &User{FirstName: "Friend" + randomString(3), LastName: "Doe",
+			Email: "john" + randomString(15) + "@doe.com", Password: "password"}
+		_, err = CreateUser(friend)
+		assert.NoError(t, err, "user creation failed")
+		assert.NoError(t, owner.SendInvitation(friend), "sending invitation failed")
+		assert.NoError(t, friend.AddFriend(owner.ID), "accepting invitation failed")
+		friends = append(friends, friend)
+	}
+	return
+}
+
+func TestUser_CreateGroup(t *testing.T) {
+	owner, friends := createGroupTestUsers(t, 2)
+
+	_, err := owner.CreateGroup("  ", []primitive.ObjectID{friends[0].ID, friends[1].ID})
+	assert.Equal(t, errEmptyGroupName, err)
+	_, err = owner.CreateGroup("group", []primitive.ObjectID{friends[0].ID, friends[0].ID, owner.ID})
+	assert.Equal(t, errGroupTooSmall, err, "duplicates and the owner don't count")
+	_, err = owner.CreateGroup("group", []primitive.ObjectID{friends[0].ID, primitive.NewObjectID()})
+	assert.Equal(t, errNotFriend, err)
+
+	group, err := owner.CreateGroup("group", []primitive.ObjectID{friends[0].ID, friends[1].ID})
+	assert.NoError(t, err, "group creation failed")
+	assert.Equal(t, owner.ID, group.Owner)
+	assert.Equal(t, 3, len(group.Members))
+	for _, usr := range append(friends, owner) {
+		groups, err := usr.Groups()
+		assert.NoError(t, err, "fetching groups failed")
+		assert.Equal(t, 1, len(groups), "every member should see the group")
+		assert.Equal(t, group.ID, groups[0].ID)
+	}
+}
+
+func TestUser_SendGroupMessage(t *testing.T) {
+	owner, friends := createGroupTestUsers(t, 3)
+	group, err := owner.CreateGroup("group", []primitive.ObjectID{friends[0].ID, friends[1].ID})
+	assert.NoError(t, err, "group creation failed")
+
+	p, restore := recordEvents()
+	defer restore()
+	_, err = friends[0].SendGroupMessage(group.ID, "hello all")
+	assert.NoError(t, err, "sending group message failed")
+	assert.Equal(t, 0, len(p.events[friends[0].ID]), "nothing should be published to sender")
+	for _, member := range []*User{owner, friends[1]} {
+		assert.Equal(t, 1, len(p.events[member.ID]), "message should be published to other members")
+		event := p.events[member.ID][0]
+		assert.Equal(t, GroupMessageEvent, event.Type)
+		assert.Equal(t, group.ID, event.Group)
+		assert.Equal(t, friends[0].FirstName, event.FromName)
+	}
+	_, err = friends[2].SendGroupMessage(group.ID, "hello")
+	assert.Equal(t, ErrNotGroupMember, err)
+	_, err = friends[2].GetGroupChat(group.ID, Cursor{})
+	assert.Equal(t, ErrNotGroupMember, err)
+
+	// a new member doesn't see the messages sent before he joined (timestamps have millisecond precision)
+	time.Sleep(2 * time.Millisecond)
+	assert.NoError(t, owner.AddGroupMember(group.ID, friends[2].ID), "adding member failed")
+	_, err = owner.SendGroupMessage(group.ID, "welcome")
+	assert.NoError(t, err, "sending group message failed")
+	page, err := friends[2].GetGroupChat(group.ID, Cursor{})
+	assert.NoError(t, err, "fetching group chat failed")
+	assert.False(t, strings.Contains(page.Content, "hello all"), "message before joining shouldn't be visible")
+	assert.True(t, strings.Contains(page.Content, "welcome"), "message after joining should be visible")
+
+	page, err = owner.GetGroupChat(group.ID, Cursor{})
+	assert.NoError(t, err, "fetching group chat failed")
+	assert.True(t, strings.Contains(page.Content, "hello all"), "earlier message should be visible to the owner")
+}
+
+func TestUser_ManageGroup(t *testing.T) {
+	owner, friends := createGroupTestUsers(t, 3)
+	group, err := owner.CreateGroup("group", []primitive.ObjectID{friends[0].ID, friends[1].ID})
+	assert.NoError(t, err, "group creation failed")
+
+	assert.NoError(t, friends[0].RenameGroup(group.ID, "renamed"), "any member can rename the group")
+	assert.Equal(t, ErrNotGroupMember, friends[2].RenameGroup(group.ID, "hijacked"))
+
+	assert.Equal(t, errNotFriend, friends[0].AddGroupMember(group.ID, friends[2].ID),
+		"only friends of the member can be added")
+	assert.NoError(t, owner.AddGroupMember(group.ID, friends[2].ID), "adding member failed")
+	assert.Equal(t, errAlreadyGroupMember, owner.AddGroupMember(group.ID, friends[2].ID))
+
+	assert.Equal(t, errNotGroupOwner, friends[0].RemoveGroupMember(group.ID, friends[1].ID))
+	assert.NoError(t, owner.RemoveGroupMember(group.ID, friends[1].ID), "removing member failed")
+	assert.Equal(t, ErrNotGroupMember, owner.RemoveGroupMember(group.ID, friends[1].ID))
+
+	assert.NoError(t, owner.LeaveGroup(group.ID), "leaving group failed")
+	group, err = store.FindGroup(group.ID)
+	assert.NoError(t, err, "fetching group failed")
+	assert.Equal(t, "renamed", group.Name)
+	assert.Equal(t, friends[0].ID, group.Owner, "ownership should pass to the longest standing member")
+	assert.False(t, group.IsMember(owner.ID))
+	assert.False(t, group.IsMember(friends[1].ID))
+	assert.True(t, group.IsMember(friends[2].ID))
+}
@@ -0,0 +1,109 @@
+package user
+
+import (
+	"fmt"
+	"gibber/datastore"
+	"go.mongodb.org/mongo-driver/bson"
+	"time"
+)
+
+// user document field of the failed logins
+const loginFailuresField = "login_failures"
+
+// LoginThrottle is the policy throttling the failed logins to an account, or from a source. The failed logins
+// beyond half of the maximum delay the next login exponentially, and reaching the maximum within the window locks
+// the logins out.
+type LoginThrottle struct {
+	MaxFailures int           // failed logins within the window locking the logins out
+	Window      time.Duration // in which the failed logins are counted, from the first one
+	Lockout     time.Duration // for which the logins are locked out
+	Backoff     time.Duration // delay after the first failed login beyond half of the maximum, doubling afterwards
+}
+
+// LoginFailures are the recent failed logins to an account, or from a source
+type LoginFailures struct {
+	Count       int       `bson:"count" json:"count"`
+	Since       time.Time `bson:"since" json:"since"`               // first failed login of the window
+	LockedUntil time.Time `bson:"locked_until" json:"locked_until"` // no login is tried till then
+}
+
+// LockedError tells that no login is tried till the given time, after too many failed logins
+type LockedError struct {
+	Until time.Time
+}
+
+// Error tells till when the logins are locked out
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed logins, try again after %s", e.Until.Format(time.RFC1123))
+}
+
+// loginThrottle throttles the failed logins to each account, wherever they come from
+var loginThrottle = LoginThrottle{
+	MaxFailures: 5,
+	Window:      15 * time.Minute,
+	Lockout:     15 * time.Minute,
+	Backoff:     time.Second,
+}
+
+// SetLoginThrottle sets the policy throttling the failed logins to each account. It is meant to be called once at
+// the startup, before any user is served.
+func SetLoginThrottle(t LoginThrottle) {
+	loginThrottle = t
+}
+
+// Fail records a failed login at the given time, delaying or locking out the next login as needed
+func (t LoginThrottle) Fail(f *LoginFailures, now time.Time) {
+	if f.Count == 0 || now.Sub(f.Since) > t.Window {
+		f.Count, f.Since = 0, now
+	}
+	f.Count++
+	if f.Count >= t.MaxFailures {
+		f.LockedUntil = now.Add(t.Lockout)
+		return
+	}
+	if beyond := f.Count - t.MaxFailures/2; beyond > 0 {
+		delay := t.Backoff << uint(beyond-1)
+		if delay < t.Backoff || delay > t.Lockout { // overflown, or beyond the lockout
+			delay = t.Lockout
+		}
+		f.LockedUntil = now.Add(delay)
+	}
+}
+
+// Check fails with a LockedError if the logins are locked out at the given time
+func (f LoginFailures) Check(now time.Time) error {
+	if now.Before(f.LockedUntil) {
+		return &LockedError{Until: f.LockedUntil}
+	}
+	return nil
+}
+
+// UnlockAccount clears the failed logins to the account with the given email, so that its user can log in again
+// straight away
+func UnlockAccount(email string) (err error) {
+	usr, err := GetUserByEmail(email)
+	if err != nil {
+		return
+	}
+	if err = store.UpdateUser(usr.ID, bson.M{loginFailuresField: LoginFailures{}}); err != nil {
+		usr.logger().Errorf("unlocking account %s failed: %s", email, err)
+		return
+	}
+	usr.logger().Infof("account %s unlocked", email)
+	return
+}
+
+// failLogin records a failed login to the account of the given user at the given time
+func (u *User) failLogin(now time.Time) {
+	err := store.Transaction(func(tx Store) error {
+		usr, err := tx.FindUserByEmail(u.Email) // read again, as other sessions may fail meanwhile
+		if err != nil {
+			return err
+		}
+		loginThrottle.Fail(&usr.LoginFailures, now)
+		return tx.UpdateUser(usr.ID, bson.M{loginFailuresField: usr.LoginFailures})
+	})
+	if err != nil && err != datastore.ErrNoDocFound {
+		u.logger().Errorf("recording failed login of %s failed: %s", u.Email, err)
+	}
+}
@@ -0,0 +1,66 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle_Fail(t *testing.T) {
+	throttle := LoginThrottle{MaxFailures: 6, Window: time.Hour, Lockout: 10 * time.Minute, Backoff: time.Second}
+	now := time.Now()
+	var failures LoginFailures
+	for i, delay := range []time.Duration{0, 0, 0, time.Second, 2 * time.Second} {
+		throttle.Fail(&failures, now)
+		assert.Equal(t, i+1, failures.Count)
+		if delay == 0 {
+			assert.NoError(t, failures.Check(now), "failure %d shouldn't delay", i+1)
+		} else {
+			assert.Equal(t, &LockedError{Until: now.Add(delay)}, failures.Check(now), "failure %d", i+1)
+			assert.NoError(t, failures.Check(now.Add(delay)))
+		}
+	}
+	throttle.Fail(&failures, now)
+	assert.Equal(t, &LockedError{Until: now.Add(10 * time.Minute)}, failures.Check(now.Add(time.Minute)),
+		"too many failures should lock out")
+
+	later := now.Add(2 * time.Hour)
+	throttle.Fail(&failures, later)
+	assert.Equal(t, 1, failures.Count, "the failures out of the window are forgotten")
+	assert.Equal(t, later, failures.Since)
+	assert.NoError(t, failures.Check(later))
+
+	throttle.Backoff = time.Hour
+	failures = LoginFailures{Count: 4, Since: now}
+	throttle.Fail(&failures, now)
+	assert.Equal(t, now.Add(10*time.Minute), failures.LockedUntil, "the delay is capped by the lockout")
+}
+
+func TestUser_LoginLockout(t *testing.T) {
+	defaults := loginThrottle
+	defer SetLoginThrottle(defaults)
+	SetLoginThrottle(LoginThrottle{MaxFailures: 3, Window: time.Hour, Lockout: time.Hour})
+
+	usr, _ := createGroupTestUsers(t, 0)
+	login := &User{Email: usr.Email}
+	_, err := login.LoginUser("wrong")
+	assert.Equal(t, bcrypt.ErrMismatchedHashAndPassword, err)
+	_, err = login.LoginUser("password")
+	assert.NoError(t, err, "a success resets the failures")
+	for i := 0; i < 3; i++ {
+		_, err = login.LoginUser("wrong")
+		assert.Equal(t, bcrypt.ErrMismatchedHashAndPassword, err)
+	}
+	stored, err := GetUserByEmail(usr.Email)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, stored.LoginFailures.Count, "the failures are persisted with the user")
+
+	_, err = login.LoginUser("password")
+	if assert.IsType(t, &LockedError{}, err, "the right password shouldn't be checked while locked out") {
+		assert.Contains(t, err.Error(), "too many failed logins, try again after")
+	}
+	assert.NoError(t, UnlockAccount(usr.Email), "unlocking failed")
+	_, err = login.LoginUser("password")
+	assert.NoError(t, err, "an unlocked account should log in")
+}
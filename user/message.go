@@ -0,0 +1,178 @@
+package user
+
+import (
+	"errors"
+	"gibber/datastore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// markers of the messages changed after being sent, as displayed to the users
+const (
+	EditedMarker       = " (edited)"
+	DeletedMessageText = "message deleted"
+)
+
+// message change errors
+var (
+	errNotMessageSender = errors.New("only the sender can change the message")
+	errMessageDeleted   = errors.New("message already deleted")
+)
+
+// conversation is a chat or a group as seen by the current user, along with the users to be told live of the
+// changes of its messages
+type conversation struct {
+	id         primitive.ObjectID
+	group      primitive.ObjectID // set for a group only
+	recipients []primitive.ObjectID
+}
+
+// EditMessage replaces the text of the given message, sent by the current user in the chat with the given friend,
+// and pushes the change to the live sessions of the friend
+func (u *User) EditMessage(friendID, messageID primitive.ObjectID, text string) (edited Message, err error) {
+	conv, err := u.chatConversation(friendID)
+	if err != nil {
+		return
+	}
+	return u.editMessage(conv, messageID, text)
+}
+
+// DeleteMessage deletes the given message of the chat with the given friend, for everyone (only if sent by the
+// current user, the friend being told live) or just for the current user
+func (u *User) DeleteMessage(friendID, messageID primitive.ObjectID, forEveryone bool) error {
+	conv, err := u.chatConversation(friendID)
+	if err != nil {
+		return err
+	}
+	return u.deleteMessage(conv, messageID, forEveryone)
+}
+
+// EditGroupMessage replaces the text of the given message, sent by the current user in the given group, and
+// pushes the change to the live sessions of the other members
+func (u *User) EditGroupMessage(groupID, messageID primitive.ObjectID, text string) (edited Message, err error) {
+	conv, err := u.groupConversation(groupID)
+	if err != nil {
+		return
+	}
+	return u.editMessage(conv, messageID, text)
+}
+
+// DeleteGroupMessage deletes the given message of the given group, for everyone (only if sent by the current user,
+// the other members being told live) or just for the current user
+func (u *User) DeleteGroupMessage(groupID, messageID primitive.ObjectID, forEveryone bool) error {
+	conv, err := u.groupConversation(groupID)
+	if err != nil {
+		return err
+	}
+	return u.deleteMessage(conv, messageID, forEveryone)
+}
+
+// chatConversation gives the chat b/w the current user and the given friend. A chat yet to be started has no
+// message to be found.
+func (u *User) chatConversation(friendID primitive.ObjectID) (conv conversation, err error) {
+	chat, err := store.FindChat(u.ID, friendID)
+	if err != nil {
+		if err != datastore.ErrNoDocFound {
+			u.logger().Errorf("fetching chat b/w %s and %s failed: %s", u.ID.Hex(), friendID.Hex(), err)
+		}
+		return
+	}
+	return conversation{id: chat.ID, recipients: []primitive.ObjectID{friendID}}, nil
+}
+
+// groupConversation gives the given group, of which the current user has to be a member
+func (u *User) groupConversation(groupID primitive.ObjectID) (conv conversation, err error) {
+	group, err := store.FindGroup(groupID)
+	if err != nil {
+		u.logger().Errorf("fetching group %s failed: %s", groupID.Hex(), err)
+		return
+	}
+	if group.member(u.ID) == nil {
+		err = ErrNotGroupMember
+		return
+	}
+	conv = conversation{id: groupID, group: groupID, recipients: group.otherMembers(u.ID)}
+	return
+}
+
+// findMessage gives the given message of the conversation, not found if it belongs to another one or was deleted
+// by the current user just for himself
+func (u *User) findMessage(conv conversation, messageID primitive.ObjectID) (msg *message, err error) {
+	msg, err = store.FindMessage(messageID)
+	if err == nil && (msg.Conversation != conv.id || msg.hiddenFor(u.ID)) {
+		err = datastore.ErrNoDocFound
+	}
+	return
+}
+
+// editMessage replaces the text of the given message of the conversation, sent by the current user
+func (u *User) editMessage(conv conversation, messageID primitive.ObjectID, text string) (edited Message,
+	err error) {
+	msg, err := u.findMessage(conv, messageID)
+	if err != nil {
+		return
+	}
+	if msg.Sender != u.ID {
+		err = errNotMessageSender
+		return
+	}
+	if msg.Deleted {
+		err = errMessageDeleted
+		return
+	}
+	msg.Text, msg.EditedAt = text, time.Now().UTC().Truncate(time.Millisecond)
+	err = store.UpdateMessage(messageID, bson.M{messageTextField: msg.Text, messageEditedAtField: msg.EditedAt})
+	if err != nil {
+		u.logger().Errorf("editing message %s failed: %s", messageID.Hex(), err)
+		return
+	}
+	u.publishChange(conv, MessageEditedEvent, msg)
+	return msg.public(), nil
+}
+
+// deleteMessage deletes the given message of the conversation, for everyone or just for the current user
+func (u *User) deleteMessage(conv conversation, messageID primitive.ObjectID, forEveryone bool) (err error) {
+	msg, err := u.findMessage(conv, messageID)
+	if err != nil {
+		return
+	}
+	if !forEveryone {
+		if err = store.HideMessage(messageID, u.ID); err != nil {
+			u.logger().Errorf("deleting message %s for %s failed: %s", messageID.Hex(), u.Email, err)
+		}
+		return
+	}
+	if msg.Sender != u.ID {
+		return errNotMessageSender
+	}
+	if msg.Deleted {
+		return errMessageDeleted
+	}
+	// the text is dropped, a deleted message only keeping its place in the conversation
+	msg.Text, msg.Deleted = "", true
+	if err = store.UpdateMessage(messageID, bson.M{messageTextField: msg.Text, messageDeletedField: true}); err != nil {
+		u.logger().Errorf("deleting message %s failed: %s", messageID.Hex(), err)
+		return
+	}
+	u.publishChange(conv, MessageDeletedEvent, msg)
+	return
+}
+
+// publishChange pushes the change of the message to the live sessions of the recipients of the conversation, but
+// the ones who blocked the current user. The event carries the timestamp of the message, so that it can be told
+// apart in the conversation.
+func (u *User) publishChange(conv conversation, eventType EventType, msg *message) {
+	event := Event{
+		Type:      eventType,
+		Group:     conv.group,
+		From:      u.ID,
+		FromName:  u.FirstName,
+		Message:   msg.ID,
+		Text:      msg.Text,
+		Timestamp: msg.Timestamp,
+	}
+	for _, recipient := range u.notBlocking(conv.recipients) {
+		publisher.Publish(recipient, event)
+	}
+}
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"gibber/datastore"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"testing"
+)
+
+func TestUser_EditMessage(t *testing.T) {
+	self, friends := createGroupTestUsers(t, 1)
+	friend := friends[0]
+	_, err := self.EditMessage(friend.ID, primitive.NewObjectID(), "edited")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "a chat yet to be started has no message")
+
+	sent, err := SendMessage(self.ID, friend.ID, "helo")
+	assert.NoError(t, err, "sending failed")
+	reply, err := SendMessage(friend.ID, self.ID, "what?")
+	assert.NoError(t, err, "sending failed")
+
+	p, restore := recordEvents()
+	defer restore()
+	edited, err := self.EditMessage(friend.ID, sent.ID, "hello")
+	assert.NoError(t, err, "editing failed")
+	assert.Equal(t, sent.ID, edited.ID, "the message keeps its ID")
+	assert.Equal(t, "hello", edited.Text)
+	assert.True(t, edited.Edited)
+	if assert.Equal(t, 1, len(p.events[friend.ID]), "the edit should be published to the friend") {
+		event := p.events[friend.ID][0]
+		assert.Equal(t, MessageEditedEvent, event.Type)
+		assert.Equal(t, sent.ID, event.Message)
+		assert.Equal(t, "hello", event.Text)
+		assert.Equal(t, sent.Timestamp, event.Timestamp, "the event tells which message it is")
+	}
+
+	page, err := friend.GetChat(self.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(page.Content, "hello"+EditedMarker), "edited message marked in the history")
+	assert.False(t, strings.Contains(page.Content, "helo"), "the former text is gone")
+
+	_, err = self.EditMessage(friend.ID, reply.ID, "what")
+	assert.Equal(t, errNotMessageSender, err, "only own messages can be edited")
+	other, _ := createGroupTestUsers(t, 0)
+	_, err = SendMessage(self.ID, other.ID, "elsewhere")
+	assert.NoError(t, err)
+	_, err = other.EditMessage(self.ID, sent.ID, "hijacked")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "a message of another chat isn't found")
+}
+
+func TestUser_DeleteMessage(t *testing.T) {
+	self, friends := createGroupTestUsers(t, 1)
+	friend := friends[0]
+	sent, err := SendMessage(self.ID, friend.ID, "oops")
+	assert.NoError(t, err, "sending failed")
+	reply, err := SendMessage(friend.ID, self.ID, "spam")
+	assert.NoError(t, err, "sending failed")
+
+	p, restore := recordEvents()
+	defer restore()
+	assert.Equal(t, errNotMessageSender, self.DeleteMessage(friend.ID, reply.ID, true),
+		"only own messages can be deleted for everyone")
+	assert.NoError(t, self.DeleteMessage(friend.ID, sent.ID, true), "deleting for everyone failed")
+	if assert.Equal(t, 1, len(p.events[friend.ID]), "the deletion should be published to the friend") {
+		assert.Equal(t, MessageDeletedEvent, p.events[friend.ID][0].Type)
+		assert.Equal(t, sent.ID, p.events[friend.ID][0].Message)
+	}
+	assert.Equal(t, errMessageDeleted, self.DeleteMessage(friend.ID, sent.ID, true))
+	_, err = self.EditMessage(friend.ID, sent.ID, "too late")
+	assert.Equal(t, errMessageDeleted, err)
+
+	page, err := friend.GetChat(self.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(page.Content, DeletedMessageText), "deleted message marked in the history")
+	assert.False(t, strings.Contains(page.Content, "oops"), "the text is gone for everyone")
+	msgs, err := friend.GetChatMessages(self.ID, primitive.NilObjectID, 0)
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(msgs), "the deleted message keeps its place") {
+		assert.True(t, msgs[0].Deleted)
+		assert.Equal(t, "", msgs[0].Text)
+	}
+
+	// deleted just for the user, the others still see it
+	assert.NoError(t, self.DeleteMessage(friend.ID, reply.ID, false), "deleting for me failed")
+	assert.Equal(t, 1, len(p.events[friend.ID]), "nothing should be published for a deletion for me")
+	page, err = self.GetChat(friend.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(page.Content, "spam"), "the message is gone for the user")
+	page, err = friend.GetChat(self.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(page.Content, "spam"), "the message is still there for the friend")
+	assert.Equal(t, datastore.ErrNoDocFound, self.DeleteMessage(friend.ID, reply.ID, false),
+		"a message deleted for the user isn't found anymore")
+}
+
+func TestUser_EditAndDeleteGroupMessage(t *testing.T) {
+	owner, friends := createGroupTestUsers(t, 3)
+	group, err := owner.CreateGroup("group", []primitive.ObjectID{friends[0].ID, friends[1].ID})
+	assert.NoError(t, err, "group creation failed")
+	sent, err := friends[0].SendGroupMessage(group.ID, "hello al")
+	assert.NoError(t, err, "sending group message failed")
+
+	p, restore := recordEvents()
+	defer restore()
+	_, err = friends[0].EditGroupMessage(group.ID, sent.ID, "hello all")
+	assert.NoError(t, err, "editing failed")
+	for _, member := range []*User{owner, friends[1]} {
+		if assert.Equal(t, 1, len(p.events[member.ID]), "the edit should be published to the other members") {
+			assert.Equal(t, MessageEditedEvent, p.events[member.ID][0].Type)
+			assert.Equal(t, group.ID, p.events[member.ID][0].Group)
+		}
+	}
+	page, err := owner.GetGroupChat(group.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(page.Content, "hello all"+EditedMarker))
+
+	_, err = friends[2].EditGroupMessage(group.ID, sent.ID, "intruder")
+	assert.Equal(t, ErrNotGroupMember, err)
+	assert.Equal(t, errNotMessageSender, owner.DeleteGroupMessage(group.ID, sent.ID, true))
+	assert.NoError(t, friends[0].DeleteGroupMessage(group.ID, sent.ID, true), "deleting failed")
+	assert.Equal(t, MessageDeletedEvent, p.events[owner.ID][1].Type)
+	msgs, err := friends[1].GetGroupMessages(group.ID, primitive.NilObjectID, 0)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(msgs)) {
+		assert.True(t, msgs[0].Deleted)
+	}
+}
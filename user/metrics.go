@@ -0,0 +1,44 @@
+package user
+
+import (
+	"gibber/datastore"
+	"gibber/metrics"
+	"time"
+)
+
+// login results
+const (
+	loginSucceeded = "success"
+	loginFailed    = "failure"
+)
+
+// kinds of messages
+const (
+	directMessage = "direct"
+	groupMessage  = "group"
+)
+
+// statistics of the user operations, exposed by the metrics endpoint
+var (
+	loginsTotal = metrics.NewCounter("gibber_logins_total",
+		"Logins by result (success or failure).", "result")
+	registrationsTotal = metrics.NewCounter("gibber_registrations_total",
+		"Users registered.")
+	invitationsTotal = metrics.NewCounter("gibber_invitations_total",
+		"Invitations by action (sent, accepted, rejected or cancelled).", "action")
+	messagesSentTotal = metrics.NewCounter("gibber_messages_sent_total",
+		"Messages sent by kind (direct or group).", "kind")
+	storeOperationSeconds = metrics.NewHistogram("gibber_store_operation_seconds",
+		"Latency of the datastore operations in seconds, by operation.", metrics.LatencyBuckets, "operation")
+	storeErrorsTotal = metrics.NewCounter("gibber_store_errors_total",
+		"Datastore operations failed, by operation. Lookups and updates matching no document are not failures.",
+		"operation")
+)
+
+// observeStore records the latency of a datastore operation started at the given time, and its failure if any
+func observeStore(operation string, start time.Time, err error) {
+	storeOperationSeconds.ObserveSince(start, operation)
+	if err != nil && err != datastore.ErrNoDocFound && err != datastore.ErrNoDocUpdate {
+		storeErrorsTotal.Inc(operation)
+	}
+}
@@ -0,0 +1,114 @@
+package user
+
+import (
+	"gibber/datastore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+// snippetLength is the number of characters of the latest message shown in the missed messages summary
+const snippetLength = 40
+
+// MissedConversation sums up the messages received in a conversation (chat or group) while the user was away
+type MissedConversation struct {
+	Friend  primitive.ObjectID // friend of a chat, zero for a group
+	Group   primitive.ObjectID // group of a group chat, zero for a chat
+	Name    string             // first name of the friend, or name of the group
+	Count   int                // number of messages received
+	Sender  string             // first name of the sender of the latest message
+	Snippet string             // beginning of the latest message
+	Latest  time.Time          // timestamp of the latest message
+}
+
+// MissedMessages sums up the conversations (chats and groups) in which the current user received messages after
+// the given time (e.g. his previous login), the most recent first
+func (u *User) MissedMessages(since time.Time) (missed []MissedConversation, err error) {
+	missed = make([]MissedConversation, 0)
+	friendIDs, err := u.SeeFriends()
+	if err == datastore.ErrNoDocFound {
+		err = nil // no friend yet
+	} else if err != nil {
+		return
+	}
+	for _, friendID := range friendIDs {
+		chat, er := store.FindChat(u.ID, friendID)
+		if er == datastore.ErrNoDocFound {
+			continue // no message yet
+		} else if er != nil {
+			u.logger().Errorf("fetching chat b/w %s and %s failed: %s", u.ID.Hex(), friendID.Hex(), er)
+			return missed, er
+		}
+		conv, found, er := u.missedIn(chat.ID, time.Time{}, since)
+		if er != nil {
+			return missed, er
+		}
+		if found {
+			friend, er := GetUserByID(friendID)
+			if er != nil {
+				return missed, er
+			}
+			conv.Friend, conv.Name = friendID, friend.FirstName
+			missed = append(missed, conv)
+		}
+	}
+
+	groups, err := u.Groups()
+	if err != nil {
+		return
+	}
+	for _, group := range groups {
+		conv, found, er := u.missedIn(group.ID, group.member(u.ID).JoinedAt, since)
+		if er != nil {
+			return missed, er
+		}
+		if found {
+			conv.Group, conv.Name = group.ID, group.Name
+			missed = append(missed, conv)
+		}
+	}
+	sort.SliceStable(missed, func(i, j int) bool {
+		return missed[i].Latest.After(missed[j].Latest)
+	})
+	return
+}
+
+// missedIn sums up the messages received by the current user in the given conversation after the given time, only
+// the ones sent since the other given time (when the user joined a group) being considered
+func (u *User) missedIn(conversationID primitive.ObjectID, joinedAt, since time.Time) (conv MissedConversation,
+	found bool, err error) {
+	query, err := u.withoutBlocked(messageQuery{since: joinedAt, after: since, notFrom: u.ID, visibleTo: u.ID})
+	if err != nil {
+		return
+	}
+	if conv.Count, err = store.CountMessages(conversationID, query); err != nil || conv.Count == 0 {
+		if err != nil {
+			u.logger().Errorf("counting messages of conversation %s failed: %s", conversationID.Hex(), err)
+		}
+		return
+	}
+	query.limit = 1
+	latest, err := store.FindMessages(conversationID, query)
+	if err != nil || len(latest) == 0 {
+		if err != nil {
+			u.logger().Errorf("fetching messages of conversation %s failed: %s", conversationID.Hex(), err)
+		}
+		return
+	}
+	msg := latest[0]
+	conv.Latest = msg.Timestamp
+	conv.Snippet = snippet(FormatText(msg.Text, false, msg.Deleted))
+	if sender, er := GetUserByID(msg.Sender); er == nil {
+		conv.Sender = sender.FirstName
+	}
+	return conv, true, nil
+}
+
+// snippet gives the beginning of the text, ellipsized if longer than snippetLength characters
+func snippet(text string) string {
+	runes := []rune(text)
+	if len(runes) <= snippetLength {
+		return text
+	}
+	return string(runes[:snippetLength]) + "..."
+}
@@ -0,0 +1,53 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUser_MissedMessages(t *testing.T) {
+	self, friends := createGroupTestUsers(t, 3)
+	missed, err := self.MissedMessages(time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(missed), "nothing received yet")
+
+	_, err = SendMessage(friends[0].ID, self.ID, "before the last login")
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond) // timestamps have millisecond precision
+	since := time.Now().UTC()
+	time.Sleep(2 * time.Millisecond)
+
+	group, err := self.CreateGroup("group", []primitive.ObjectID{friends[1].ID, friends[2].ID})
+	assert.NoError(t, err, "group creation failed")
+	_, err = SendMessage(friends[0].ID, self.ID, "first")
+	assert.NoError(t, err)
+	_, err = SendMessage(self.ID, friends[0].ID, "own messages aren't missed")
+	assert.NoError(t, err)
+	_, err = SendMessage(friends[0].ID, self.ID, "second "+strings.Repeat("long ", 20))
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = friends[2].SendGroupMessage(group.ID, "hello group")
+	assert.NoError(t, err)
+
+	missed, err = self.MissedMessages(since)
+	assert.NoError(t, err)
+	if assert.Equal(t, 2, len(missed), "a chat and a group with missed messages") {
+		assert.Equal(t, group.ID, missed[0].Group, "the most recent first")
+		assert.Equal(t, "group", missed[0].Name)
+		assert.Equal(t, 1, missed[0].Count)
+		assert.Equal(t, friends[2].FirstName, missed[0].Sender, "sender of the latest message")
+		assert.Equal(t, "hello group", missed[0].Snippet)
+
+		assert.Equal(t, friends[0].ID, missed[1].Friend)
+		assert.True(t, missed[1].Group.IsZero())
+		assert.Equal(t, friends[0].FirstName, missed[1].Name)
+		assert.Equal(t, 2, missed[1].Count, "only the messages since the given time")
+		assert.Equal(t, friends[0].FirstName, missed[1].Sender)
+		assert.True(t, strings.HasPrefix(missed[1].Snippet, "second long"))
+		assert.True(t, strings.HasSuffix(missed[1].Snippet, "..."), "long message shortened")
+		assert.Equal(t, snippetLength+len("..."), len(missed[1].Snippet))
+	}
+}
@@ -0,0 +1,113 @@
+package user
+
+import (
+	"fmt"
+	"gibber/datastore"
+	"gibber/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// user and session document presence field
+const lastSeenField = "last_seen"
+
+// PresenceTimeout is the time after which a connected session not heard of (through a heartbeat) is considered
+// disconnected. It makes the presence of the users, whose connections ended without a trace (e.g. a server crash),
+// heal by itself.
+const PresenceTimeout = 90 * time.Second
+
+// Presence depicts whether a user is online, and when he was last seen otherwise
+type Presence struct {
+	UserID   primitive.ObjectID `json:"user_id"`
+	Online   bool               `json:"online"`
+	LastSeen time.Time          `json:"last_seen"` // zero if never seen
+}
+
+// Heartbeat marks the given session as still connected, live on this server. It fails with
+// datastore.ErrNoDocUpdate if the session was revoked, whose connection is to be closed then.
+func Heartbeat(sessionID primitive.ObjectID) (err error) {
+	err = store.UpdateSession(sessionID, bson.M{sessionConnectedField: true, lastSeenField: time.Now().UTC()})
+	if err == datastore.ErrNoDocUpdate {
+		log.Logger().Warnf("heartbeat for revoked session %s", sessionID.Hex())
+	} else if err != nil {
+		log.Logger().Errorf("heartbeat for session %s failed: %s", sessionID.Hex(), err)
+	}
+	return
+}
+
+// GetPresence fetches the presence of the given user, online as long as one of his sessions is connected
+func GetPresence(userID primitive.ObjectID) (presence Presence, err error) {
+	usr, err := store.FindUserByID(userID)
+	if err != nil {
+		log.Logger().Errorf("fetching presence of user %s failed: %s", userID.Hex(), err)
+		return
+	}
+	sessions, err := store.FindSessions(userID)
+	if err != nil {
+		log.Logger().Errorf("fetching sessions of user %s failed: %s", userID.Hex(), err)
+		return
+	}
+	presence = usr.presence(sessions, time.Now().UTC())
+	return
+}
+
+// FriendsPresence fetches the presence of each friend of the current user, in the order of his friends list
+func (u *User) FriendsPresence() (presences []Presence, err error) {
+	friendIDs, err := u.SeeFriends()
+	if err == datastore.ErrNoDocFound { // no friends yet
+		return make([]Presence, 0), nil
+	}
+	if err != nil {
+		return
+	}
+	presences = make([]Presence, 0, len(friendIDs))
+	for _, friendID := range friendIDs {
+		presence, er := GetPresence(friendID)
+		if er != nil {
+			presence = Presence{UserID: friendID} // shown as offline
+		}
+		presences = append(presences, presence)
+	}
+	return
+}
+
+// SeeOnlineFriends fetches the IDs of the friends of the current user, who are currently online
+func (u *User) SeeOnlineFriends() (onlineFriends []primitive.ObjectID, err error) {
+	presences, err := u.FriendsPresence()
+	if err != nil {
+		return
+	}
+	onlineFriends = make([]primitive.ObjectID, 0)
+	for _, presence := range presences {
+		if presence.Online {
+			onlineFriends = append(onlineFriends, presence.UserID)
+		}
+	}
+	return
+}
+
+// String representation of a presence
+func (p Presence) String() string {
+	if p.Online {
+		return "online"
+	}
+	if p.LastSeen.IsZero() {
+		return "offline"
+	}
+	return fmt.Sprintf("offline, last seen %s", p.LastSeen.Format(time.RFC1123))
+}
+
+// presence gives the presence of the user with the given sessions at the given time
+func (u *User) presence(sessions []Session, now time.Time) Presence {
+	presence := Presence{UserID: u.ID, LastSeen: u.LastSeen}
+	for _, session := range sessions {
+		if session.LastSeen.After(presence.LastSeen) {
+			presence.LastSeen = session.LastSeen
+		}
+		if session.Connected && now.Sub(session.LastSeen) < PresenceTimeout && now.Before(session.ExpiresAt) {
+			presence.Online = true
+		}
+	}
+	return presence
+}
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"gibber/datastore"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestUser_FriendsPresence(t *testing.T) {
+	usr, friends := createGroupTestUsers(t, 2)
+	_, _, err := friends[0].StartSession(ChatSession, time.Hour, "127.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	session, _, err := friends[1].StartSession(ChatSession, time.Hour, "127.0.0.1:1235")
+	assert.NoError(t, err, "starting session failed")
+	assert.NoError(t, friends[1].Logout(session.ID), "logout failed")
+
+	presences, err := usr.FriendsPresence()
+	assert.NoError(t, err, "fetching friends presence failed")
+	assert.Equal(t, 2, len(presences))
+	assert.Equal(t, friends[0].ID, presences[0].UserID, "presences should be in the friends order")
+	assert.True(t, presences[0].Online, "a user with a connected session is online")
+	assert.False(t, presences[1].Online, "a logged out user is offline")
+	assert.False(t, presences[1].LastSeen.IsZero(), "a logged out user was last seen at logout")
+	assert.Contains(t, presences[1].String(), "last seen")
+
+	online, err := usr.SeeOnlineFriends()
+	assert.NoError(t, err, "fetching online friends failed")
+	assert.Equal(t, 1, len(online))
+	assert.Equal(t, friends[0].ID, online[0])
+}
+
+func TestHeartbeat(t *testing.T) {
+	usr, _ := createGroupTestUsers(t, 0)
+	session, _, err := usr.StartSession(ChatSession, time.Hour, "127.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+
+	// a session whose connection ended without a trace isn't online after the timeout
+	stale := time.Now().UTC().Add(-PresenceTimeout - time.Second)
+	assert.NoError(t, store.UpdateSession(session.ID, bson.M{lastSeenField: stale}), "updating last seen failed")
+	presence, err := GetPresence(usr.ID)
+	assert.NoError(t, err, "fetching presence failed")
+	assert.False(t, presence.Online, "user not heard of since the timeout should be offline")
+
+	assert.NoError(t, Heartbeat(session.ID), "heartbeat failed")
+	presence, err = GetPresence(usr.ID)
+	assert.NoError(t, err, "fetching presence failed")
+	assert.True(t, presence.Online, "heartbeat should make the user online")
+	assert.Equal(t, "online", presence.String())
+
+	assert.NoError(t, usr.Disconnect(session.ID), "disconnecting failed")
+	presence, err = GetPresence(usr.ID)
+	assert.NoError(t, err, "fetching presence failed")
+	assert.False(t, presence.Online, "a disconnected session doesn't make the user online")
+	assert.NoError(t, usr.Logout(session.ID), "logout failed")
+	assert.Equal(t, datastore.ErrNoDocUpdate, Heartbeat(session.ID), "the session is revoked")
+}
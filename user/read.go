@@ -0,0 +1,118 @@
+package user
+
+import (
+	"gibber/datastore"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// read cursor document collection name and fields
+const (
+	readCursorCollection        = "read_cursors"
+	readCursorUserField         = "user_id"
+	readCursorConversationField = "conversation_id"
+	readCursorUntilField        = "read_until"
+)
+
+// seenMarker follows the messages of the current user which the friend has seen, in the chat history
+const seenMarker = " (seen)"
+
+// readCursor tells up to when a user has read a conversation (chat or group)
+type readCursor struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	UserID       primitive.ObjectID `bson:"user_id"`
+	Conversation primitive.ObjectID `bson:"conversation_id"`
+	ReadUntil    time.Time          `bson:"read_until"` // timestamp of the latest message read
+}
+
+// UnreadChatCount counts the messages sent by the given friend to the current user, which he hasn't read yet
+func (u *User) UnreadChatCount(friendID primitive.ObjectID) (count int, err error) {
+	chat, err := store.FindChat(u.ID, friendID)
+	if err == datastore.ErrNoDocFound {
+		return 0, nil // no message yet
+	} else if err != nil {
+		u.logger().Errorf("fetching chat b/w %s and %s failed: %s", u.ID.Hex(), friendID.Hex(), err)
+		return
+	}
+	return u.unreadCount(chat.ID, time.Time{})
+}
+
+// UnreadGroupCount counts the messages sent by the other members to the given group since the current user
+// joined it, which he hasn't read yet
+func (u *User) UnreadGroupCount(group *Group) (count int, err error) {
+	member := group.member(u.ID)
+	if member == nil {
+		return 0, ErrNotGroupMember
+	}
+	return u.unreadCount(group.ID, member.JoinedAt)
+}
+
+// MarkChatRead advances the read cursor of the current user in the chat with the given friend up to the given
+// time (that of the latest message displayed), and tells the friend that his messages are seen
+func (u *User) MarkChatRead(friendID primitive.ObjectID, until time.Time) (err error) {
+	chat, err := store.FindChat(u.ID, friendID)
+	if err == datastore.ErrNoDocFound {
+		return nil // nothing to read
+	} else if err != nil {
+		u.logger().Errorf("fetching chat b/w %s and %s failed: %s", u.ID.Hex(), friendID.Hex(), err)
+		return
+	}
+	advanced, err := u.markRead(chat.ID, until)
+	if advanced {
+		publisher.Publish(friendID, Event{Type: ReadEvent, From: u.ID, FromName: u.FirstName, Timestamp: until})
+	}
+	return
+}
+
+// MarkGroupRead advances the read cursor of the current user in the given group up to the given time (that of
+// the latest message displayed)
+func (u *User) MarkGroupRead(groupID primitive.ObjectID, until time.Time) (err error) {
+	_, err = u.markRead(groupID, until)
+	return
+}
+
+// unreadCount counts the messages of the conversation since the given time, sent after the read cursor of the
+// current user by others than him and the users he blocked
+func (u *User) unreadCount(conversationID primitive.ObjectID, since time.Time) (count int, err error) {
+	readUntil, err := readTime(u.ID, conversationID)
+	if err != nil {
+		u.logger().Errorf("fetching read cursor of conversation %s failed: %s", conversationID.Hex(), err)
+		return
+	}
+	query, err := u.withoutBlocked(messageQuery{since: since, after: readUntil, notFrom: u.ID})
+	if err != nil {
+		return
+	}
+	count, err = store.CountMessages(conversationID, query)
+	if err != nil {
+		u.logger().Errorf("counting unread messages of conversation %s failed: %s", conversationID.Hex(), err)
+	}
+	return
+}
+
+// markRead advances the read cursor of the current user in the conversation up to the given time, telling
+// whether it moved
+func (u *User) markRead(conversationID primitive.ObjectID, until time.Time) (advanced bool, err error) {
+	if until.IsZero() {
+		return // nothing displayed
+	}
+	err = store.AdvanceReadCursor(u.ID, conversationID, until)
+	if err == datastore.ErrNoDocUpdate {
+		return false, nil // already read further
+	} else if err != nil {
+		u.logger().Errorf("advancing read cursor of conversation %s failed: %s", conversationID.Hex(), err)
+		return
+	}
+	return true, nil
+}
+
+// readTime gives up to when the given user has read the conversation, zero if he hasn't read any of it
+func readTime(userID, conversationID primitive.ObjectID) (time.Time, error) {
+	cursor, err := store.FindReadCursor(userID, conversationID)
+	if err == datastore.ErrNoDocFound {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return cursor.ReadUntil, nil
+}
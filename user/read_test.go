@@ -0,0 +1,81 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUser_UnreadAndSeen(t *testing.T) {
+	self, friends := createGroupTestUsers(t, 1)
+	friend := friends[0]
+	unread, err := friend.UnreadChatCount(self.ID)
+	assert.NoError(t, err, "a chat yet to be started is not an error")
+	assert.Equal(t, 0, unread)
+
+	_, err = SendMessage(self.ID, friend.ID, "first")
+	assert.NoError(t, err, "sending failed")
+	_, err = SendMessage(self.ID, friend.ID, "second")
+	assert.NoError(t, err, "sending failed")
+	_, err = SendMessage(friend.ID, self.ID, "reply")
+	assert.NoError(t, err, "sending failed")
+	unread, err = friend.UnreadChatCount(self.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, unread, "own messages aren't unread")
+	page, err := self.GetChat(friend.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(page.Content, seenMarker), "nothing seen yet")
+
+	p, restore := recordEvents()
+	defer restore()
+	page, err = friend.GetChat(self.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.NoError(t, friend.MarkChatRead(self.ID, page.Latest), "marking read failed")
+	unread, err = friend.UnreadChatCount(self.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, unread, "all the messages displayed are read")
+	if assert.Equal(t, 1, len(p.events[self.ID]), "the sender should be told") {
+		assert.Equal(t, ReadEvent, p.events[self.ID][0].Type)
+		assert.Equal(t, friend.ID, p.events[self.ID][0].From)
+	}
+
+	// the read cursor never moves back
+	assert.NoError(t, friend.MarkChatRead(self.ID, page.Latest.Add(-time.Minute)))
+	assert.Equal(t, 1, len(p.events[self.ID]), "nothing new is seen")
+
+	page, err = self.GetChat(friend.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(page.Content, seenMarker), "the sent messages are seen")
+	page, err = friend.GetChat(self.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, strings.Count(page.Content, seenMarker), "the reply isn't seen yet")
+}
+
+func TestUser_UnreadGroupCount(t *testing.T) {
+	owner, friends := createGroupTestUsers(t, 2)
+	group, err := owner.CreateGroup("group", []primitive.ObjectID{friends[0].ID, friends[1].ID})
+	assert.NoError(t, err, "group creation failed")
+	_, err = friends[0].SendGroupMessage(group.ID, "hello all")
+	assert.NoError(t, err)
+	_, err = owner.SendGroupMessage(group.ID, "hi")
+	assert.NoError(t, err)
+
+	unread, err := friends[1].UnreadGroupCount(group)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, unread)
+	unread, err = owner.UnreadGroupCount(group)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, unread, "own messages aren't unread")
+
+	page, err := friends[1].GetGroupChat(group.ID, Cursor{})
+	assert.NoError(t, err)
+	assert.NoError(t, friends[1].MarkGroupRead(group.ID, page.Latest))
+	unread, err = friends[1].UnreadGroupCount(group)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, unread)
+
+	_, err = (&User{ID: primitive.NewObjectID()}).UnreadGroupCount(group)
+	assert.Equal(t, ErrNotGroupMember, err)
+}
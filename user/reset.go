@@ -0,0 +1,151 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gibber/datastore"
+	"gibber/log"
+	"gibber/mail"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+	"time"
+)
+
+// password reset document collection name and fields
+const (
+	resetCollection     = "password_resets"
+	resetTokenHashField = "token_hash"
+	resetExpiresAtField = "expires_at"
+)
+
+// resetTokenSize is the number of random bytes of a password reset token
+const resetTokenSize = 16
+
+// ErrInvalidResetToken tells that a password reset token is unknown, already used or expired
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// passwordReset is a password reset token issued to a user. Only the hash of the token is stored, so that the
+// stored documents can't be used to reset the passwords.
+type passwordReset struct {
+	ID        primitive.ObjectID `bson:"_id" json:"-"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
+
+// mailer sends the emails to the users, keeping them in memory unless some other mailer is set at startup
+var mailer mail.Mailer = mail.NewMemoryMailer()
+
+// SetMailer sets the mailer sending the emails to the users. It is meant to be called once at the startup,
+// before any user is served.
+func SetMailer(m mail.Mailer) {
+	mailer = m
+}
+
+// RequestPasswordReset issues a password reset token valid for the given time to the user with the given email,
+// and mails it to him. The token replaces any token issued to him before. It fails with datastore.ErrNoDocFound if
+// there is no user with the email, which is not to be told to the client.
+func RequestPasswordReset(email string, ttl time.Duration) (err error) {
+	usr, err := GetUserByEmail(email)
+	if err != nil {
+		return
+	}
+	token, err := newToken(resetTokenSize)
+	if err != nil {
+		usr.logger().Errorf("generating reset token for %s failed: %s", email, err)
+		return
+	}
+	reset := &passwordReset{
+		ID:        primitive.NewObjectID(),
+		UserID:    usr.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().UTC().Add(ttl),
+	}
+	err = store.Transaction(func(tx Store) error {
+		if er := tx.DeleteResets(usr.ID); er != nil && er != datastore.ErrNoDocUpdate {
+			return er
+		}
+		return tx.InsertReset(reset)
+	})
+	if err != nil {
+		usr.logger().Errorf("issuing reset token for %s failed: %s", email, err)
+		return
+	}
+	err = mailer.Send(mail.Message{
+		To:      usr.Email,
+		Subject: "Reset your gibber password",
+		Body: fmt.Sprintf("Hi %s,\n\nEnter this token to set a new password: %s\n\n"+
+			"It can be used once, till %s. If you didn't ask for it, you can ignore this email.\n",
+			usr.FirstName, token, reset.ExpiresAt.Format(time.RFC1123)),
+	})
+	if err != nil {
+		usr.logger().Errorf("mailing reset token to %s failed: %s", email, err)
+		return
+	}
+	usr.logger().Infof("reset token issued to %s", email)
+	return
+}
+
+// ResetPassword sets the given password for the user to whom the given token was issued, giving his email. The
+// token can't be used again, the failed logins to the account are cleared, and his sessions (the REST API ones
+// included) are revoked. It fails with ErrInvalidResetToken if the token is unknown, already used or expired.
+func ResetPassword(token, newPassword string) (email string, err error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return
+	}
+	var usr *User
+	err = store.Transaction(func(tx Store) (er error) {
+		reset, er := tx.FindReset(hashToken(token))
+		if er == datastore.ErrNoDocFound {
+			return ErrInvalidResetToken
+		} else if er != nil {
+			return
+		}
+		if time.Now().After(reset.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+		if er = tx.DeleteResets(reset.UserID); er == datastore.ErrNoDocUpdate {
+			return ErrInvalidResetToken // used meanwhile
+		} else if er != nil {
+			return
+		}
+		if usr, er = tx.FindUserByID(reset.UserID); er != nil {
+			return
+		}
+		if er = tx.DeleteSessions(usr.ID, primitive.NilObjectID); er != nil && er != datastore.ErrNoDocUpdate {
+			return
+		}
+		return tx.UpdateUser(usr.ID, bson.M{
+			userPasswordField:  string(hashedPassword),
+			loginFailuresField: LoginFailures{},
+		})
+	})
+	if err != nil {
+		if err != ErrInvalidResetToken {
+			log.Logger().Errorf("resetting password failed: %s", err)
+		}
+		return
+	}
+	usr.logger().Infof("password reset for %s", usr.Email)
+	return usr.Email, nil
+}
+
+// newToken generates a random secret token (e.g. a password reset token) of the given number of bytes
+func newToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken gives the hash of the secret token (e.g. a password reset token), as stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
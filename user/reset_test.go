@@ -0,0 +1,57 @@
+package user
+
+import (
+	"gibber/datastore"
+	"gibber/mail"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// mailedResetToken gives the password reset token last mailed to the given email
+func mailedResetToken(t *testing.T, email string) string {
+	msg, ok := mailer.(*mail.MemoryMailer).Last(email)
+	if !ok {
+		t.Fatalf("no email sent to %s", email)
+	}
+	return regexp.MustCompile(`[0-9a-f]{32}`).FindString(msg.Body)
+}
+
+func TestResetPassword(t *testing.T) {
+	usr, _ := createGroupTestUsers(t, 0)
+	assert.Equal(t, datastore.ErrNoDocFound, RequestPasswordReset("nobody"+randomString(10)+"@doe.com", time.Hour))
+
+	assert.NoError(t, RequestPasswordReset(usr.Email, time.Hour), "requesting reset failed")
+	replaced := mailedResetToken(t, usr.Email)
+	assert.NoError(t, RequestPasswordReset(usr.Email, time.Hour), "requesting reset again failed")
+	token := mailedResetToken(t, usr.Email)
+	assert.NotEqual(t, replaced, token)
+	_, err := ResetPassword(replaced, "new password")
+	assert.Equal(t, ErrInvalidResetToken, err, "a new token replaces the previous one")
+
+	// a locked out account is unlocked too
+	locked := LoginFailures{Count: 5, LockedUntil: time.Now().Add(time.Hour)}
+	assert.NoError(t, store.UpdateUser(usr.ID, bson.M{loginFailuresField: locked}))
+	email, err := ResetPassword(token, "new password")
+	assert.NoError(t, err, "resetting password failed")
+	assert.Equal(t, usr.Email, email)
+	_, err = ResetPassword(token, "other password")
+	assert.Equal(t, ErrInvalidResetToken, err, "a token can be used once")
+
+	login := &User{Email: usr.Email}
+	_, err = login.LoginUser("password")
+	assert.Error(t, err, "the old password shouldn't work anymore")
+	_, err = login.LoginUser("new password")
+	assert.NoError(t, err, "logging in with the new password failed")
+}
+
+func TestResetPassword_Expired(t *testing.T) {
+	usr, _ := createGroupTestUsers(t, 0)
+	assert.NoError(t, RequestPasswordReset(usr.Email, -time.Second), "requesting reset failed")
+	_, err := ResetPassword(mailedResetToken(t, usr.Email), "new password")
+	assert.Equal(t, ErrInvalidResetToken, err, "an expired token can't be used")
+	_, err = ResetPassword("unknown", "new password")
+	assert.Equal(t, ErrInvalidResetToken, err)
+}
@@ -0,0 +1,218 @@
+package user
+
+import (
+	"errors"
+	"gibber/datastore"
+	"gibber/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// session document collection name and fields
+const (
+	sessionCollection     = "sessions"
+	sessionTokenHashField = "token_hash"
+	sessionExpiresAtField = "expires_at"
+	sessionConnectedField = "connected"
+	sessionRemoteField    = "remote"
+)
+
+// sessionTokenSize is the number of random bytes of a session token
+const sessionTokenSize = 32
+
+// session kinds
+const (
+	ChatSession = "chat" // of a connection to the chat server, resumable from a new one
+	APISession  = "api"  // of the REST API, authenticating each request with its token
+)
+
+// ErrInvalidSessionToken tells that a session token is unknown, revoked or expired
+var ErrInvalidSessionToken = errors.New("invalid or expired session token")
+
+// Session is a login of a user, whose token is valid till it expires, unless he logs out or it is revoked
+// meanwhile. He may resume a chat session from a new connection with it, and an API session authenticates his
+// requests. Only the hash of the token is stored.
+type Session struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"-"`
+	Kind      string             `bson:"kind" json:"kind"` // ChatSession or APISession
+	TokenHash string             `bson:"token_hash" json:"-"`
+	Remote    string             `bson:"remote" json:"remote"` // address of the client which last connected it
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	Connected bool               `bson:"connected" json:"connected"` // served by a live connection, or resumable
+	LastSeen  time.Time          `bson:"last_seen" json:"last_seen"` // last heartbeat while connected
+}
+
+// StartSession starts a new session of the given kind of the current user, from the given remote address. It
+// gives the token of the session, valid for the given time. A chat session starts connected.
+func (u *User) StartSession(kind string, ttl time.Duration, remote string) (session *Session, token string,
+	err error) {
+	token, err = newToken(sessionTokenSize)
+	if err != nil {
+		u.logger().Errorf("generating session token for %s failed: %s", u.Email, err)
+		return
+	}
+	now := time.Now().UTC()
+	session = &Session{
+		ID:        primitive.NewObjectID(),
+		UserID:    u.ID,
+		Kind:      kind,
+		TokenHash: hashToken(token),
+		Remote:    remote,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		Connected: kind == ChatSession,
+		LastSeen:  now,
+	}
+	if err = store.InsertSession(session); err != nil {
+		u.logger().Errorf("starting session of %s failed: %s", u.Email, err)
+		return nil, "", err
+	}
+	u.logger().Infof("%s session %s of %s started", kind, session.ID.Hex(), u.Email)
+	return
+}
+
+// ResumeSession resumes the chat session with the given token from a new connection at the given remote address,
+// giving the user of the session. It fails with ErrInvalidSessionToken if the token is unknown, revoked or
+// expired.
+func ResumeSession(token, remote string) (usr *User, session *Session, err error) {
+	now := time.Now().UTC()
+	err = store.Transaction(func(tx Store) (er error) {
+		if session, er = findSession(tx, token, ChatSession, now); er != nil {
+			return
+		}
+		if usr, er = tx.FindUserByID(session.UserID); er != nil {
+			return
+		}
+		session.Connected, session.Remote, session.LastSeen = true, remote, now
+		return tx.UpdateSession(session.ID, bson.M{sessionConnectedField: true, sessionRemoteField: remote,
+			lastSeenField: now})
+	})
+	if err != nil {
+		if err != ErrInvalidSessionToken {
+			log.Logger().Errorf("resuming session failed: %s", err)
+		}
+		return nil, nil, err
+	}
+	usr.logger().Infof("session %s of %s resumed", session.ID.Hex(), usr.Email)
+	return
+}
+
+// AuthenticateAPI gives the user of the API session with the given token, along with the session. It fails with
+// ErrInvalidSessionToken if the token is unknown, revoked or expired.
+func AuthenticateAPI(token string) (usr *User, session *Session, err error) {
+	if session, err = findSession(store, token, APISession, time.Now().UTC()); err != nil {
+		if err != ErrInvalidSessionToken {
+			log.Logger().Errorf("authenticating API session failed: %s", err)
+		}
+		return nil, nil, err
+	}
+	if usr, err = store.FindUserByID(session.UserID); err != nil {
+		log.Logger().Errorf("fetching user of API session %s failed: %s", session.ID.Hex(), err)
+		return nil, nil, err
+	}
+	return
+}
+
+// findSession gives the unexpired session of the given kind with the given token, failing with
+// ErrInvalidSessionToken if there is none. A session stored without kind is a chat session.
+func findSession(s Store, token, kind string, now time.Time) (*Session, error) {
+	session, err := s.FindSession(hashToken(token))
+	if err == datastore.ErrNoDocFound {
+		return nil, ErrInvalidSessionToken
+	} else if err != nil {
+		return nil, err
+	}
+	if session.Kind == "" {
+		session.Kind = ChatSession
+	}
+	if session.Kind != kind || now.After(session.ExpiresAt) {
+		return nil, ErrInvalidSessionToken
+	}
+	return session, nil
+}
+
+// Sessions gives the sessions of the current user which haven't expired, the oldest first
+func (u *User) Sessions() (sessions []Session, err error) {
+	all, err := store.FindSessions(u.ID)
+	if err != nil {
+		u.logger().Errorf("fetching sessions of %s failed: %s", u.Email, err)
+		return
+	}
+	now := time.Now()
+	sessions = make([]Session, 0, len(all))
+	for _, session := range all {
+		if now.Before(session.ExpiresAt) {
+			sessions = append(sessions, session)
+		}
+	}
+	return
+}
+
+// Disconnect marks the given session of the current user as no longer connected, marking him last seen now. The
+// session stays resumable till it expires.
+func (u *User) Disconnect(sessionID primitive.ObjectID) (err error) {
+	now := time.Now().UTC()
+	err = store.Transaction(func(tx Store) error {
+		er := tx.UpdateSession(sessionID, bson.M{sessionConnectedField: false, lastSeenField: now})
+		if er != nil && er != datastore.ErrNoDocUpdate { // revoked meanwhile otherwise
+			return er
+		}
+		return tx.UpdateUser(u.ID, bson.M{lastSeenField: now})
+	})
+	if err != nil {
+		u.logger().Errorf("disconnecting session %s of %s failed: %s", sessionID.Hex(), u.Email, err)
+	}
+	return
+}
+
+// Logout ends the given session of the current user, revoking its token, and marks him last seen now. His other
+// sessions go on. It fails with datastore.ErrNoDocUpdate if he has no such session.
+func (u *User) Logout(sessionID primitive.ObjectID) (err error) {
+	err = u.endSessions(sessionID)
+	if err != nil && err != datastore.ErrNoDocUpdate {
+		u.logger().Errorf("error while logging out u %s: %s", u.Email, err)
+	}
+	return
+}
+
+// LogoutAll ends all the sessions of the current user, revoking their tokens, and marks him last seen now
+func (u *User) LogoutAll() (err error) {
+	err = u.endSessions(primitive.NilObjectID)
+	if err == datastore.ErrNoDocUpdate { // no session
+		err = nil
+	} else if err != nil {
+		u.logger().Errorf("error while logging out all sessions of %s: %s", u.Email, err)
+	}
+	return
+}
+
+// revokeSessionsBut removes all the sessions of the given user but the given one
+func revokeSessionsBut(tx Store, userID, keepSessionID primitive.ObjectID) error {
+	sessions, err := tx.FindSessions(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == keepSessionID {
+			continue
+		}
+		if err = tx.DeleteSessions(userID, session.ID); err != nil && err != datastore.ErrNoDocUpdate {
+			return err
+		}
+	}
+	return nil
+}
+
+// endSessions removes the given session of the current user, or all of them if the session ID is zero, marking
+// him last seen now
+func (u *User) endSessions(sessionID primitive.ObjectID) error {
+	return store.Transaction(func(tx Store) error {
+		if err := tx.DeleteSessions(u.ID, sessionID); err != nil {
+			return err
+		}
+		return tx.UpdateUser(u.ID, bson.M{lastSeenField: time.Now().UTC()})
+	})
+}
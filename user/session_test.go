@@ -0,0 +1,91 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestUser_Sessions(t *testing.T) {
+	usr, _ := createGroupTestUsers(t, 0)
+	first, token, err := usr.StartSession(ChatSession, time.Hour, "10.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	assert.Len(t, token, 2*sessionTokenSize)
+	second, _, err := usr.StartSession(ChatSession, time.Hour, "10.0.0.2:1234")
+	assert.NoError(t, err, "starting session failed")
+	sessions, err := usr.Sessions()
+	assert.NoError(t, err, "fetching sessions failed")
+	assert.Equal(t, 2, len(sessions))
+	assert.Equal(t, first.ID, sessions[0].ID, "the oldest session comes first")
+
+	_, _, err = ResumeSession("unknown", "10.0.0.3:1234")
+	assert.Equal(t, ErrInvalidSessionToken, err)
+	assert.NoError(t, usr.Disconnect(first.ID), "disconnecting failed")
+	resumed, session, err := ResumeSession(token, "10.0.0.3:1234")
+	assert.NoError(t, err, "resuming session failed")
+	assert.Equal(t, usr.ID, resumed.ID)
+	assert.Equal(t, first.ID, session.ID)
+	assert.True(t, session.Connected)
+	assert.Equal(t, "10.0.0.3:1234", session.Remote)
+
+	assert.NoError(t, usr.Logout(first.ID), "logout failed")
+	_, _, err = ResumeSession(token, "10.0.0.3:1234")
+	assert.Equal(t, ErrInvalidSessionToken, err, "a logged out session can't be resumed")
+	sessions, _ = usr.Sessions()
+	assert.Equal(t, 1, len(sessions), "the other session goes on")
+	assert.Equal(t, second.ID, sessions[0].ID)
+
+	assert.NoError(t, usr.LogoutAll(), "logging out all sessions failed")
+	sessions, _ = usr.Sessions()
+	assert.Empty(t, sessions)
+	assert.NoError(t, usr.LogoutAll(), "no session left is fine")
+}
+
+func TestResumeSession_Expired(t *testing.T) {
+	usr, _ := createGroupTestUsers(t, 0)
+	session, token, err := usr.StartSession(ChatSession, time.Hour, "10.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	expired := time.Now().UTC().Add(-time.Second)
+	assert.NoError(t, store.UpdateSession(session.ID, bson.M{sessionExpiresAtField: expired}))
+	_, _, err = ResumeSession(token, "10.0.0.1:1234")
+	assert.Equal(t, ErrInvalidSessionToken, err, "an expired session can't be resumed")
+	sessions, _ := usr.Sessions()
+	assert.Empty(t, sessions, "an expired session isn't listed")
+}
+
+func TestAuthenticateAPI(t *testing.T) {
+	usr, _ := createGroupTestUsers(t, 0)
+	session, token, err := usr.StartSession(APISession, time.Hour, "10.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	assert.False(t, session.Connected, "an API session is not a connection")
+	authenticated, found, err := AuthenticateAPI(token)
+	assert.NoError(t, err, "authentication failed")
+	assert.Equal(t, usr.ID, authenticated.ID)
+	assert.Equal(t, session.ID, found.ID)
+	_, _, err = ResumeSession(token, "10.0.0.1:1234")
+	assert.Equal(t, ErrInvalidSessionToken, err, "an API session can't be resumed as a chat session")
+
+	_, chatToken, err := usr.StartSession(ChatSession, time.Hour, "10.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	_, _, err = AuthenticateAPI(chatToken)
+	assert.Equal(t, ErrInvalidSessionToken, err, "a chat session doesn't authenticate API requests")
+	assert.NoError(t, usr.Logout(session.ID), "logout failed")
+	_, _, err = AuthenticateAPI(token)
+	assert.Equal(t, ErrInvalidSessionToken, err, "a revoked API session doesn't authenticate")
+}
+
+func TestResetPassword_RevokesSessions(t *testing.T) {
+	usr, _ := createGroupTestUsers(t, 0)
+	_, token, err := usr.StartSession(ChatSession, time.Hour, "10.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	_, apiToken, err := usr.StartSession(APISession, time.Hour, "10.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	assert.NoError(t, RequestPasswordReset(usr.Email, time.Hour), "requesting reset failed")
+	_, err = ResetPassword(mailedResetToken(t, usr.Email), "new password")
+	assert.NoError(t, err, "resetting password failed")
+	_, _, err = ResumeSession(token, "10.0.0.1:1234")
+	assert.Equal(t, ErrInvalidSessionToken, err, "a password reset revokes the sessions")
+	_, _, err = AuthenticateAPI(apiToken)
+	assert.Equal(t, ErrInvalidSessionToken, err, "a password reset revokes the API sessions")
+}
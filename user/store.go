@@ -0,0 +1,147 @@
+package user
+
+import (
+	"fmt"
+	"gibber/datastore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// store backends which can be selected at the service startup
+const (
+	MongoStore  = "mongo"
+	MemoryStore = "memory"
+)
+
+// Store provides the persistence for all the documents managed by the user package i.e. users, invites, friends,
+// chats, messages, read cursors, groups, block lists, password resets and sessions. Lookups matching no document
+// fail with datastore.ErrNoDocFound, and updates matching no document fail with datastore.ErrNoDocUpdate, whatever
+// the backend is.
+type Store interface {
+	UserStore
+	InviteStore
+	FriendStore
+	ChatStore
+	MessageStore
+	ReadStore
+	GroupStore
+	BlockStore
+	ResetStore
+	SessionStore
+
+	// Transaction runs the given function atomically i.e. either all the changes done through tx are persisted,
+	// or none of them are. The error returned by the function (if any) aborts the transaction and is returned as is.
+	Transaction(fn func(tx Store) error) error
+}
+
+// UserStore persists the user documents
+type UserStore interface {
+	InsertUser(user *User) error
+	FindUserByEmail(email string) (*User, error)
+	FindUserByID(userID primitive.ObjectID) (*User, error)
+	UpdateUser(userID primitive.ObjectID, fields bson.M) error // sets the given document fields
+}
+
+// InviteStore persists the invitations sent and received by the users
+type InviteStore interface {
+	CreateInvites(userID primitive.ObjectID) (invitesID primitive.ObjectID, err error) // empty invites data
+	FindInvites(userID primitive.ObjectID) (*userInvites, error)
+	PushInvite(userID primitive.ObjectID, invType inviteType, otherID primitive.ObjectID) error
+	PullInvite(userID primitive.ObjectID, invType inviteType, otherID primitive.ObjectID) error
+	PushInviteOutcome(userID primitive.ObjectID, outcome InviteOutcome) error
+}
+
+// FriendStore persists the friends list of the users
+type FriendStore interface {
+	FindFriends(userID primitive.ObjectID) (*friends, error)
+	PushFriend(userID, friendID primitive.ObjectID) error // creates the friends list if non-existent
+	PullFriend(userID, friendID primitive.ObjectID) error
+}
+
+// ChatStore persists the conversations b/w two users
+type ChatStore interface {
+	FindChat(userID1, userID2 primitive.ObjectID) (*chat, error)
+	// inserts the message in the chat, creating the chat if non-existent
+	PushMessage(userID1, userID2 primitive.ObjectID, msg message) error
+}
+
+// MessageStore persists the messages of the conversations (chats and groups), each message being a document of
+// its own
+type MessageStore interface {
+	InsertMessage(msg message) error
+	FindMessage(messageID primitive.ObjectID) (*message, error)
+	// the latest messages of the conversation matching the query, the oldest first
+	FindMessages(conversationID primitive.ObjectID, query messageQuery) ([]message, error)
+	CountMessages(conversationID primitive.ObjectID, query messageQuery) (int, error) // the limit is ignored
+	UpdateMessage(messageID primitive.ObjectID, fields bson.M) error                  // sets the given document fields
+	// the message deleted just for the given user
+	HideMessage(messageID, userID primitive.ObjectID) error
+	DeleteMessages(conversationID primitive.ObjectID) error // all the messages of the conversation
+}
+
+// ReadStore persists up to when the users have read their conversations
+type ReadStore interface {
+	FindReadCursor(userID, conversationID primitive.ObjectID) (*readCursor, error)
+	// moves the read cursor forward to the given time, creating it if non-existent. It fails with
+	// datastore.ErrNoDocUpdate if the cursor is already there or further.
+	AdvanceReadCursor(userID, conversationID primitive.ObjectID, until time.Time) error
+}
+
+// GroupStore persists the group conversations
+type GroupStore interface {
+	InsertGroup(group *Group) error
+	FindGroup(groupID primitive.ObjectID) (*Group, error)
+	FindGroupsByMember(userID primitive.ObjectID) ([]*Group, error)
+	UpdateGroup(groupID primitive.ObjectID, fields bson.M) error // sets the given document fields
+}
+
+// BlockStore persists the users blocked by each user
+type BlockStore interface {
+	FindBlocks(userID primitive.ObjectID) (*blocks, error)
+	// adds the user to the block list, creating it if non-existent. It fails with datastore.ErrNoDocUpdate if the
+	// user is already blocked.
+	PushBlock(userID, blockedID primitive.ObjectID) error
+	PullBlock(userID, blockedID primitive.ObjectID) error
+}
+
+// ResetStore persists the password reset tokens issued to the users, found by the hash of the token
+type ResetStore interface {
+	InsertReset(reset *passwordReset) error
+	FindReset(tokenHash string) (*passwordReset, error)
+	// removes all the reset tokens of the user. It fails with datastore.ErrNoDocUpdate if he has none.
+	DeleteResets(userID primitive.ObjectID) error
+}
+
+// SessionStore persists the sessions of the users, found by the hash of their token
+type SessionStore interface {
+	InsertSession(session *Session) error
+	FindSession(tokenHash string) (*Session, error)
+	FindSessions(userID primitive.ObjectID) ([]Session, error)       // the oldest first
+	UpdateSession(sessionID primitive.ObjectID, fields bson.M) error // sets the given document fields
+	// removes the given session of the user, or all his sessions if the session ID is zero. It fails with
+	// datastore.ErrNoDocUpdate if there is none.
+	DeleteSessions(userID, sessionID primitive.ObjectID) error
+}
+
+// store used by all the operations of the package, in-memory unless some other store is set at startup. Its
+// operations are instrumented for the metrics.
+var store = instrument(NewMemoryStore())
+
+// SetStore sets the store to be used for all the user operations. It is meant to be called once at the startup,
+// before any user is served.
+func SetStore(s Store) {
+	store = instrument(s)
+}
+
+// NewStore gives a new store for the given backend (MongoStore or MemoryStore)
+func NewStore(backend string) (Store, error) {
+	switch backend {
+	case MongoStore:
+		return NewMongoStore(datastore.MongoConn()), nil
+	case MemoryStore:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
@@ -0,0 +1,849 @@
+package user
+
+import (
+	"fmt"
+	"gibber/datastore"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore is a concurrency safe Store keeping all the documents in the process memory.
+// Nothing survives a restart, so it is meant for tests and local development.
+type memoryStore struct {
+	data *memoryData
+	inTx bool // the store handed over to a transaction, which already holds the lock
+}
+
+// memoryData holds the documents of a memoryStore, guarded by a single lock
+type memoryData struct {
+	mu      sync.Mutex
+	undo    []func() // undoes the changes made by the running transaction, in reverse order, nil if none
+	users   map[primitive.ObjectID]*User
+	emails  map[string]primitive.ObjectID       // user email => user ID
+	invites map[primitive.ObjectID]*userInvites // user ID => invites data
+	friends map[primitive.ObjectID]*friends     // user ID => friends list
+	chats   map[[2]primitive.ObjectID]*chat     // ordered user IDs => chat
+	groups  map[primitive.ObjectID]*Group
+	blocks  map[primitive.ObjectID]*blocks // user ID => block list
+	resets  map[string]*passwordReset      // token hash => password reset
+	// conversation ID => messages, ordered by timestamp and then by ID
+	messages      map[primitive.ObjectID][]message
+	conversations map[primitive.ObjectID]primitive.ObjectID // message ID => conversation ID
+	readCursors   map[[2]primitive.ObjectID]*readCursor     // user ID, conversation ID => read cursor
+	sessions      map[primitive.ObjectID]*Session           // session ID => session
+}
+
+// NewMemoryStore gives a new empty in-memory store
+func NewMemoryStore() Store {
+	return &memoryStore{
+		data: &memoryData{
+			users:   make(map[primitive.ObjectID]*User),
+			emails:  make(map[string]primitive.ObjectID),
+			invites: make(map[primitive.ObjectID]*userInvites),
+			friends: make(map[primitive.ObjectID]*friends),
+			chats:   make(map[[2]primitive.ObjectID]*chat),
+			groups:  make(map[primitive.ObjectID]*Group),
+			blocks:  make(map[primitive.ObjectID]*blocks),
+			resets:  make(map[string]*passwordReset),
+
+			messages:      make(map[primitive.ObjectID][]message),
+			conversations: make(map[primitive.ObjectID]primitive.ObjectID),
+			readCursors:   make(map[[2]primitive.ObjectID]*readCursor),
+			sessions:      make(map[primitive.ObjectID]*Session),
+		},
+	}
+}
+
+// Transaction runs fn holding the store lock, and restores the documents it changed as they were if it fails
+func (s *memoryStore) Transaction(fn func(tx Store) error) (err error) {
+	if s.inTx { // nested transaction is part of the outer one
+		return fn(s)
+	}
+	s.data.mu.Lock()
+	defer s.data.mu.Unlock()
+	s.data.undo = make([]func(), 0)
+	if err = fn(&memoryStore{data: s.data, inTx: true}); err != nil {
+		for i := len(s.data.undo) - 1; i >= 0; i-- {
+			s.data.undo[i]()
+		}
+	}
+	s.data.undo = nil
+	return
+}
+
+// InsertUser stores a new user, the email being unique across the users
+func (s *memoryStore) InsertUser(user *User) error {
+	defer s.lock()()
+	if _, ok := s.data.emails[user.Email]; ok {
+		return fmt.Errorf("user with email %s already exists", user.Email)
+	}
+	s.data.keepUser(user.ID)
+	s.data.users[user.ID] = cloneUser(user)
+	s.data.emails[user.Email] = user.ID
+	return nil
+}
+
+// FindUserByEmail gives the user with the given email
+func (s *memoryStore) FindUserByEmail(email string) (*User, error) {
+	defer s.lock()()
+	userID, ok := s.data.emails[email]
+	if !ok {
+		return nil, datastore.ErrNoDocFound
+	}
+	return cloneUser(s.data.users[userID]), nil
+}
+
+// FindUserByID gives the user with the given ID
+func (s *memoryStore) FindUserByID(userID primitive.ObjectID) (*User, error) {
+	defer s.lock()()
+	user, ok := s.data.users[userID]
+	if !ok {
+		return nil, datastore.ErrNoDocFound
+	}
+	return cloneUser(user), nil
+}
+
+// UpdateUser sets the given fields (named as in the stored document) on the user, the email staying unique
+func (s *memoryStore) UpdateUser(userID primitive.ObjectID, fields bson.M) error {
+	defer s.lock()()
+	user, ok := s.data.users[userID]
+	if !ok {
+		return datastore.ErrNoDocUpdate
+	}
+	updated := &User{}
+	if err := setFields(user, fields, updated); err != nil {
+		return err
+	}
+	if _, ok := s.data.emails[updated.Email]; ok && updated.Email != user.Email {
+		return fmt.Errorf("user with email %s already exists", updated.Email)
+	}
+	s.data.keepUser(userID)
+	if updated.Email != user.Email {
+		delete(s.data.emails, user.Email)
+		s.data.emails[updated.Email] = userID
+	}
+	s.data.users[userID] = updated
+	return nil
+}
+
+// CreateInvites creates the empty invites data for the given user
+func (s *memoryStore) CreateInvites(userID primitive.ObjectID) (invitesID primitive.ObjectID, err error) {
+	defer s.lock()()
+	invitesID = primitive.NewObjectID()
+	s.data.keepInvites(userID)
+	s.data.invites[userID] = &userInvites{
+		ID:        invitesID,
+		UserID:    userID,
+		Sent:      make([]primitive.ObjectID, 0),
+		Received:  make([]primitive.ObjectID, 0),
+		Accepted:  make([]primitive.ObjectID, 0),
+		Rejected:  make([]primitive.ObjectID, 0),
+		Cancelled: make([]primitive.ObjectID, 0),
+		Outcomes:  make([]InviteOutcome, 0),
+	}
+	return
+}
+
+// FindInvites gives the invites data of the given user
+func (s *memoryStore) FindInvites(userID primitive.ObjectID) (*userInvites, error) {
+	defer s.lock()()
+	invites, ok := s.data.invites[userID]
+	if !ok {
+		return nil, datastore.ErrNoDocFound
+	}
+	return invites.clone(), nil
+}
+
+// PushInvite appends the other user to the given invite list of the user
+func (s *memoryStore) PushInvite(userID primitive.ObjectID, invType inviteType, otherID primitive.ObjectID) error {
+	defer s.lock()()
+	invites, ok := s.data.invites[userID]
+	if !ok {
+		return datastore.ErrNoDocUpdate
+	}
+	list := invites.list(invType)
+	if list == nil {
+		return errInvalidInviteType
+	}
+	s.data.keepInvites(userID)
+	*list = append(*list, otherID)
+	return nil
+}
+
+// PullInvite removes the other user from the given invite list of the user
+func (s *memoryStore) PullInvite(userID primitive.ObjectID, invType inviteType, otherID primitive.ObjectID) error {
+	defer s.lock()()
+	invites, ok := s.data.invites[userID]
+	if !ok {
+		return datastore.ErrNoDocUpdate
+	}
+	list := invites.list(invType)
+	if list == nil {
+		return errInvalidInviteType
+	}
+	pulled := removeID(*list, otherID)
+	if len(pulled) == len(*list) {
+		return datastore.ErrNoDocUpdate
+	}
+	s.data.keepInvites(userID)
+	*list = pulled
+	return nil
+}
+
+// PushInviteOutcome appends the outcome of an invitation to the invites data of the user
+func (s *memoryStore) PushInviteOutcome(userID primitive.ObjectID, outcome InviteOutcome) error {
+	defer s.lock()()
+	invites, ok := s.data.invites[userID]
+	if !ok {
+		return datastore.ErrNoDocUpdate
+	}
+	s.data.keepInvites(userID)
+	invites.Outcomes = append(invites.Outcomes, outcome)
+	return nil
+}
+
+// FindFriends gives the friends list of the given user
+func (s *memoryStore) FindFriends(userID primitive.ObjectID) (*friends, error) {
+	defer s.lock()()
+	frnds, ok := s.data.friends[userID]
+	if !ok {
+		return nil, datastore.ErrNoDocFound
+	}
+	return frnds.clone(), nil
+}
+
+// PushFriend appends the friend to the friends list of the user
+func (s *memoryStore) PushFriend(userID, friendID primitive.ObjectID) error {
+	defer s.lock()()
+	s.data.keepFriends(userID)
+	frnds, ok := s.data.friends[userID]
+	if !ok {
+		frnds = &friends{ID: primitive.NewObjectID(), UserID: userID}
+		s.data.friends[userID] = frnds
+	}
+	frnds.FriendIDs = append(frnds.FriendIDs, friendID)
+	return nil
+}
+
+// PullFriend removes the friend from the friends list of the user
+func (s *memoryStore) PullFriend(userID, friendID primitive.ObjectID) error {
+	defer s.lock()()
+	frnds, ok := s.data.friends[userID]
+	if !ok {
+		return datastore.ErrNoDocUpdate
+	}
+	pulled := removeID(frnds.FriendIDs, friendID)
+	if len(pulled) == len(frnds.FriendIDs) {
+		return datastore.ErrNoDocUpdate
+	}
+	s.data.keepFriends(userID)
+	frnds.FriendIDs = pulled
+	return nil
+}
+
+// FindChat gives the chat b/w the two given users
+func (s *memoryStore) FindChat(userID1, userID2 primitive.ObjectID) (*chat, error) {
+	defer s.lock()()
+	ch, ok := s.data.chats[chatKey(userID1, userID2)]
+	if !ok {
+		return nil, datastore.ErrNoDocFound
+	}
+	return ch.clone(), nil
+}
+
+// PushMessage inserts the message in the chat b/w the two given users
+func (s *memoryStore) PushMessage(userID1, userID2 primitive.ObjectID, msg message) error {
+	defer s.lock()()
+	key := chatKey(userID1, userID2)
+	ch, ok := s.data.chats[key]
+	if !ok {
+		ch = &chat{ID: primitive.NewObjectID(), User1: key[0], User2: key[1]}
+		s.data.keepChat(key)
+		s.data.chats[key] = ch
+	}
+	msg.Conversation = ch.ID
+	s.data.insertMessage(msg)
+	return nil
+}
+
+// InsertMessage stores a new message in its conversation
+func (s *memoryStore) InsertMessage(msg message) error {
+	defer s.lock()()
+	s.data.insertMessage(msg)
+	return nil
+}
+
+// FindMessage gives the message with the given ID
+func (s *memoryStore) FindMessage(messageID primitive.ObjectID) (*message, error) {
+	defer s.lock()()
+	msg := s.data.message(messageID)
+	if msg == nil {
+		return nil, datastore.ErrNoDocFound
+	}
+	found := *msg
+	return &found, nil
+}
+
+// FindMessages gives the latest messages of the conversation matching the query, the oldest first
+func (s *memoryStore) FindMessages(conversationID primitive.ObjectID, query messageQuery) ([]message, error) {
+	defer s.lock()()
+	return s.data.findMessages(conversationID, query), nil
+}
+
+// CountMessages counts the messages of the conversation matching the query
+func (s *memoryStore) CountMessages(conversationID primitive.ObjectID, query messageQuery) (int, error) {
+	defer s.lock()()
+	query.limit = 0
+	return len(s.data.findMessages(conversationID, query)), nil
+}
+
+// UpdateMessage sets the given fields (named as in the stored document) on the message
+func (s *memoryStore) UpdateMessage(messageID primitive.ObjectID, fields bson.M) error {
+	defer s.lock()()
+	msg := s.data.message(messageID)
+	if msg == nil {
+		return datastore.ErrNoDocUpdate
+	}
+	updated := message{}
+	if err := setFields(msg, fields, &updated); err != nil {
+		return err
+	}
+	s.data.keepMessages(msg.Conversation)
+	*msg = updated
+	return nil
+}
+
+// HideMessage marks the message as deleted just for the given user
+func (s *memoryStore) HideMessage(messageID, userID primitive.ObjectID) error {
+	defer s.lock()()
+	msg := s.data.message(messageID)
+	if msg == nil {
+		return datastore.ErrNoDocUpdate
+	}
+	if !msg.hiddenFor(userID) {
+		s.data.keepMessages(msg.Conversation)
+		msg.HiddenFor = append(append([]primitive.ObjectID(nil), msg.HiddenFor...), userID)
+	}
+	return nil
+}
+
+// DeleteMessages removes all the messages of the given conversation
+func (s *memoryStore) DeleteMessages(conversationID primitive.ObjectID) error {
+	defer s.lock()()
+	s.data.keepMessages(conversationID)
+	for _, msg := range s.data.messages[conversationID] {
+		delete(s.data.conversations, msg.ID)
+	}
+	delete(s.data.messages, conversationID)
+	return nil
+}
+
+// FindReadCursor gives up to when the given user has read the conversation
+func (s *memoryStore) FindReadCursor(userID, conversationID primitive.ObjectID) (*readCursor, error) {
+	defer s.lock()()
+	cursor, ok := s.data.readCursors[[2]primitive.ObjectID{userID, conversationID}]
+	if !ok {
+		return nil, datastore.ErrNoDocFound
+	}
+	c := *cursor
+	return &c, nil
+}
+
+// AdvanceReadCursor moves the read cursor of the user in the conversation forward to the given time
+func (s *memoryStore) AdvanceReadCursor(userID, conversationID primitive.ObjectID, until time.Time) error {
+	defer s.lock()()
+	key := [2]primitive.ObjectID{userID, conversationID}
+	cursor, ok := s.data.readCursors[key]
+	if !ok {
+		s.data.keepReadCursor(key)
+		s.data.readCursors[key] = &readCursor{ID: primitive.NewObjectID(), UserID: userID,
+			Conversation: conversationID, ReadUntil: until}
+		return nil
+	}
+	if !until.After(cursor.ReadUntil) {
+		return datastore.ErrNoDocUpdate
+	}
+	s.data.keepReadCursor(key)
+	cursor.ReadUntil = until
+	return nil
+}
+
+// InsertGroup stores a new group
+func (s *memoryStore) InsertGroup(group *Group) error {
+	defer s.lock()()
+	s.data.keepGroup(group.ID)
+	s.data.groups[group.ID] = group.clone()
+	return nil
+}
+
+// FindGroup gives the group with the given ID
+func (s *memoryStore) FindGroup(groupID primitive.ObjectID) (*Group, error) {
+	defer s.lock()()
+	group, ok := s.data.groups[groupID]
+	if !ok {
+		return nil, datastore.ErrNoDocFound
+	}
+	return group.clone(), nil
+}
+
+// FindGroupsByMember gives all the groups of which the given user is a member, in creation order
+func (s *memoryStore) FindGroupsByMember(userID primitive.ObjectID) ([]*Group, error) {
+	defer s.lock()()
+	groups := make([]*Group, 0)
+	for _, group := range s.data.groups {
+		if group.IsMember(userID) {
+			groups = append(groups, group.clone())
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID.Hex() < groups[j].ID.Hex()
+	})
+	return groups, nil
+}
+
+// UpdateGroup sets the given fields (named as in the stored document) on the group
+func (s *memoryStore) UpdateGroup(groupID primitive.ObjectID, fields bson.M) error {
+	defer s.lock()()
+	group, ok := s.data.groups[groupID]
+	if !ok {
+		return datastore.ErrNoDocUpdate
+	}
+	updated := &Group{}
+	if err := setFields(group, fields, updated); err != nil {
+		return err
+	}
+	s.data.keepGroup(groupID)
+	s.data.groups[groupID] = updated
+	return nil
+}
+
+// FindBlocks gives the block list of the given user
+func (s *memoryStore) FindBlocks(userID primitive.ObjectID) (*blocks, error) {
+	defer s.lock()()
+	blockList, ok := s.data.blocks[userID]
+	if !ok {
+		return nil, datastore.ErrNoDocFound
+	}
+	return blockList.clone(), nil
+}
+
+// PushBlock appends the user to the block list of the given user, unless already there
+func (s *memoryStore) PushBlock(userID, blockedID primitive.ObjectID) error {
+	defer s.lock()()
+	s.data.keepBlocks(userID)
+	blockList, ok := s.data.blocks[userID]
+	if !ok {
+		blockList = &blocks{ID: primitive.NewObjectID(), UserID: userID}
+		s.data.blocks[userID] = blockList
+	}
+	if containsID(blockList.BlockedIDs, blockedID) {
+		return datastore.ErrNoDocUpdate
+	}
+	blockList.BlockedIDs = append(blockList.BlockedIDs, blockedID)
+	return nil
+}
+
+// PullBlock removes the user from the block list of the given user
+func (s *memoryStore) PullBlock(userID, blockedID primitive.ObjectID) error {
+	defer s.lock()()
+	blockList, ok := s.data.blocks[userID]
+	if !ok {
+		return datastore.ErrNoDocUpdate
+	}
+	pulled := removeID(blockList.BlockedIDs, blockedID)
+	if len(pulled) == len(blockList.BlockedIDs) {
+		return datastore.ErrNoDocUpdate
+	}
+	s.data.keepBlocks(userID)
+	blockList.BlockedIDs = pulled
+	return nil
+}
+
+// InsertReset stores a new password reset
+func (s *memoryStore) InsertReset(reset *passwordReset) error {
+	defer s.lock()()
+	stored := *reset
+	s.data.keepReset(reset.TokenHash)
+	s.data.resets[reset.TokenHash] = &stored
+	return nil
+}
+
+// FindReset gives the password reset with the given token hash
+func (s *memoryStore) FindReset(tokenHash string) (*passwordReset, error) {
+	defer s.lock()()
+	reset, ok := s.data.resets[tokenHash]
+	if !ok {
+		return nil, datastore.ErrNoDocFound
+	}
+	found := *reset
+	return &found, nil
+}
+
+// DeleteResets removes all the password resets of the given user
+func (s *memoryStore) DeleteResets(userID primitive.ObjectID) error {
+	defer s.lock()()
+	deleted := false
+	for tokenHash, reset := range s.data.resets {
+		if reset.UserID == userID {
+			s.data.keepReset(tokenHash)
+			delete(s.data.resets, tokenHash)
+			deleted = true
+		}
+	}
+	if !deleted {
+		return datastore.ErrNoDocUpdate
+	}
+	return nil
+}
+
+// InsertSession stores a new session
+func (s *memoryStore) InsertSession(session *Session) error {
+	defer s.lock()()
+	stored := *session
+	s.data.keepSession(session.ID)
+	s.data.sessions[session.ID] = &stored
+	return nil
+}
+
+// FindSession gives the session with the given token hash
+func (s *memoryStore) FindSession(tokenHash string) (*Session, error) {
+	defer s.lock()()
+	for _, session := range s.data.sessions {
+		if session.TokenHash == tokenHash {
+			found := *session
+			return &found, nil
+		}
+	}
+	return nil, datastore.ErrNoDocFound
+}
+
+// FindSessions gives the sessions of the given user, the oldest first
+func (s *memoryStore) FindSessions(userID primitive.ObjectID) ([]Session, error) {
+	defer s.lock()()
+	sessions := make([]Session, 0)
+	for _, session := range s.data.sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, *session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+// UpdateSession sets the given fields of the session
+func (s *memoryStore) UpdateSession(sessionID primitive.ObjectID, fields bson.M) error {
+	defer s.lock()()
+	session, ok := s.data.sessions[sessionID]
+	if !ok {
+		return datastore.ErrNoDocUpdate
+	}
+	updated := &Session{}
+	if err := setFields(session, fields, updated); err != nil {
+		return err
+	}
+	s.data.keepSession(sessionID)
+	s.data.sessions[sessionID] = updated
+	return nil
+}
+
+// DeleteSessions removes the given session of the user, or all his sessions if the session ID is zero
+func (s *memoryStore) DeleteSessions(userID, sessionID primitive.ObjectID) error {
+	defer s.lock()()
+	deleted := false
+	for id, session := range s.data.sessions {
+		if session.UserID == userID && (sessionID.IsZero() || id == sessionID) {
+			s.data.keepSession(id)
+			delete(s.data.sessions, id)
+			deleted = true
+		}
+	}
+	if !deleted {
+		return datastore.ErrNoDocUpdate
+	}
+	return nil
+}
+
+// lock acquires the store lock (unless inside a transaction which already holds it), and gives the function
+// to release it
+func (s *memoryStore) lock() (unlock func()) {
+	if s.inTx {
+		return func() {}
+	}
+	s.data.mu.Lock()
+	return s.data.mu.Unlock
+}
+
+// keep records how to undo a change about to be made to the documents, if a transaction is running
+func (d *memoryData) keep(undo func()) {
+	if d.undo != nil {
+		d.undo = append(d.undo, undo)
+	}
+}
+
+// keepUser keeps the user as he is (or his absence), along with his email, for a failed transaction to restore him
+func (d *memoryData) keepUser(userID primitive.ObjectID) {
+	if d.undo == nil {
+		return
+	}
+	user, ok := d.users[userID]
+	d.keep(func() {
+		if current, exists := d.users[userID]; exists {
+			delete(d.emails, current.Email)
+		}
+		if !ok {
+			delete(d.users, userID)
+			return
+		}
+		d.users[userID] = user // never changed in place, only replaced
+		d.emails[user.Email] = userID
+	})
+}
+
+// keepInvites keeps the invites data of the user as it is, for a failed transaction to restore it
+func (d *memoryData) keepInvites(userID primitive.ObjectID) {
+	if d.undo == nil {
+		return
+	}
+	invites, ok := d.invites[userID]
+	if ok {
+		invites = invites.clone()
+	}
+	d.keep(func() {
+		if !ok {
+			delete(d.invites, userID)
+			return
+		}
+		d.invites[userID] = invites
+	})
+}
+
+// keepFriends keeps the friends list of the user as it is, for a failed transaction to restore it
+func (d *memoryData) keepFriends(userID primitive.ObjectID) {
+	if d.undo == nil {
+		return
+	}
+	frnds, ok := d.friends[userID]
+	if ok {
+		frnds = frnds.clone()
+	}
+	d.keep(func() {
+		if !ok {
+			delete(d.friends, userID)
+			return
+		}
+		d.friends[userID] = frnds
+	})
+}
+
+// keepChat keeps the chat of the given key as it is, for a failed transaction to restore it
+func (d *memoryData) keepChat(key [2]primitive.ObjectID) {
+	if d.undo == nil {
+		return
+	}
+	ch, ok := d.chats[key]
+	if ok {
+		ch = ch.clone()
+	}
+	d.keep(func() {
+		if !ok {
+			delete(d.chats, key)
+			return
+		}
+		d.chats[key] = ch
+	})
+}
+
+// keepMessages keeps the messages of the conversation as they are, for a failed transaction to restore them
+func (d *memoryData) keepMessages(conversationID primitive.ObjectID) {
+	if d.undo == nil {
+		return
+	}
+	msgs, ok := d.messages[conversationID]
+	msgs = append(make([]message, 0, len(msgs)), msgs...)
+	d.keep(func() {
+		for _, msg := range d.messages[conversationID] {
+			delete(d.conversations, msg.ID)
+		}
+		if !ok {
+			delete(d.messages, conversationID)
+			return
+		}
+		d.messages[conversationID] = msgs
+		for _, msg := range msgs {
+			d.conversations[msg.ID] = conversationID
+		}
+	})
+}
+
+// keepReadCursor keeps the read cursor of the given key as it is, for a failed transaction to restore it
+func (d *memoryData) keepReadCursor(key [2]primitive.ObjectID) {
+	if d.undo == nil {
+		return
+	}
+	cursor, ok := d.readCursors[key]
+	if ok {
+		kept := *cursor
+		cursor = &kept
+	}
+	d.keep(func() {
+		if !ok {
+			delete(d.readCursors, key)
+			return
+		}
+		d.readCursors[key] = cursor
+	})
+}
+
+// keepGroup keeps the group as it is, for a failed transaction to restore it
+func (d *memoryData) keepGroup(groupID primitive.ObjectID) {
+	if d.undo == nil {
+		return
+	}
+	group, ok := d.groups[groupID]
+	d.keep(func() {
+		if !ok {
+			delete(d.groups, groupID)
+			return
+		}
+		d.groups[groupID] = group // never changed in place, only replaced
+	})
+}
+
+// keepBlocks keeps the block list of the user as it is, for a failed transaction to restore it
+func (d *memoryData) keepBlocks(userID primitive.ObjectID) {
+	if d.undo == nil {
+		return
+	}
+	blockList, ok := d.blocks[userID]
+	if ok {
+		blockList = blockList.clone()
+	}
+	d.keep(func() {
+		if !ok {
+			delete(d.blocks, userID)
+			return
+		}
+		d.blocks[userID] = blockList
+	})
+}
+
+// keepReset keeps the password reset of the given token hash as it is, for a failed transaction to restore it
+func (d *memoryData) keepReset(tokenHash string) {
+	if d.undo == nil {
+		return
+	}
+	reset, ok := d.resets[tokenHash]
+	d.keep(func() {
+		if !ok {
+			delete(d.resets, tokenHash)
+			return
+		}
+		d.resets[tokenHash] = reset // never changed in place, only replaced
+	})
+}
+
+// keepSession keeps the session as it is, for a failed transaction to restore it
+func (d *memoryData) keepSession(sessionID primitive.ObjectID) {
+	if d.undo == nil {
+		return
+	}
+	session, ok := d.sessions[sessionID]
+	d.keep(func() {
+		if !ok {
+			delete(d.sessions, sessionID)
+			return
+		}
+		d.sessions[sessionID] = session // never changed in place, only replaced
+	})
+}
+
+// findMessages gives the latest messages of the conversation matching the query, the oldest first
+func (d *memoryData) findMessages(conversationID primitive.ObjectID, query messageQuery) []message {
+	msgs := d.messages[conversationID]
+	found := make([]message, 0)
+	for i := len(msgs) - 1; i >= 0 && (query.limit == 0 || len(found) < query.limit); i-- {
+		msg := msgs[i]
+		if msg.Timestamp.Before(query.since) || (!query.after.IsZero() && !msg.Timestamp.After(query.after)) {
+			break // the older ones don't match either
+		}
+		if (query.before.IsZero() || msg.comesBefore(query.before)) &&
+			(query.notFrom.IsZero() || msg.Sender != query.notFrom) && !containsID(query.blocked, msg.Sender) &&
+			(query.visibleTo.IsZero() || !msg.hiddenFor(query.visibleTo)) {
+			found = append(found, msg)
+		}
+	}
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 { // latest first as walked
+		found[i], found[j] = found[j], found[i]
+	}
+	return found
+}
+
+// message gives the stored message with the given ID, nil if none
+func (d *memoryData) message(messageID primitive.ObjectID) *message {
+	msgs := d.messages[d.conversations[messageID]]
+	for i := range msgs {
+		if msgs[i].ID == messageID {
+			return &msgs[i]
+		}
+	}
+	return nil
+}
+
+// insertMessage adds the message to its conversation, keeping the messages ordered
+func (d *memoryData) insertMessage(msg message) {
+	d.keepMessages(msg.Conversation)
+	msgs := append(d.messages[msg.Conversation], msg)
+	// the message is almost always the latest one, so it is moved back from the end as needed
+	for i := len(msgs) - 1; i > 0 && msgs[i].comesBefore(msgs[i-1].cursor()); i-- {
+		msgs[i], msgs[i-1] = msgs[i-1], msgs[i]
+	}
+	d.messages[msg.Conversation] = msgs
+	d.conversations[msg.ID] = msg.Conversation
+}
+
+// chatKey gives the key of the chat b/w two users, ordering the IDs as the chat is shared by both
+func chatKey(userID1, userID2 primitive.ObjectID) [2]primitive.ObjectID {
+	if userID1.Hex() > userID2.Hex() {
+		userID1, userID2 = userID2, userID1
+	}
+	return [2]primitive.ObjectID{userID1, userID2}
+}
+
+// cloneUser gives a copy of the user which doesn't share any state with the given one
+func cloneUser(user *User) *User {
+	c := *user
+	return &c
+}
+
+// setFields decodes into updated a copy of the document with the given fields set, the same way mongo $set does
+func setFields(doc interface{}, fields bson.M, updated interface{}) (err error) {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return
+	}
+	docMap := bson.M{}
+	if err = bson.Unmarshal(raw, &docMap); err != nil {
+		return
+	}
+	for field, value := range fields {
+		docMap[field] = value
+	}
+	if raw, err = bson.Marshal(docMap); err != nil {
+		return
+	}
+	return bson.Unmarshal(raw, updated)
+}
+
+// removeID gives the list of IDs without any occurrence of the given ID
+func removeID(ids []primitive.ObjectID, id primitive.ObjectID) []primitive.ObjectID {
+	res := make([]primitive.ObjectID, 0, len(ids))
+	for _, i := range ids {
+		if i != id {
+			res = append(res, i)
+		}
+	}
+	return res
+}
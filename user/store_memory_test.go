@@ -0,0 +1,257 @@
+package user
+
+import (
+	"errors"
+	"gibber/datastore"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_User(t *testing.T) {
+	s := NewMemoryStore()
+	user := &User{ID: primitive.NewObjectID(), FirstName: "John", Email: "john@doe.com"}
+	assert.NoError(t, s.InsertUser(user), "user insertion failed")
+	assert.Error(t, s.InsertUser(&User{ID: primitive.NewObjectID(), Email: user.Email}), "email should be unique")
+
+	fetched, err := s.FindUserByEmail(user.Email)
+	assert.NoError(t, err, "user fetch by email failed")
+	assert.Equal(t, user, fetched, "same user should be fetched")
+
+	fetched.FirstName = "Jane"
+	fetched, err = s.FindUserByID(user.ID)
+	assert.NoError(t, err, "user fetch by ID failed")
+	assert.Equal(t, "John", fetched.FirstName, "fetched user should not share state with store")
+
+	_, err = s.FindUserByID(primitive.NewObjectID())
+	assert.Equal(t, datastore.ErrNoDocFound, err, "non-existent user")
+
+	err = s.UpdateUser(user.ID, bson.M{userFirstNameField: "Jane", userEmailField: "jane@doe.com"})
+	assert.NoError(t, err, "user update failed")
+	fetched, err = s.FindUserByEmail("jane@doe.com")
+	assert.NoError(t, err, "user fetch by updated email failed")
+	assert.Equal(t, "Jane", fetched.FirstName, "first name should be updated")
+	_, err = s.FindUserByEmail(user.Email)
+	assert.Equal(t, datastore.ErrNoDocFound, err, "old email should not be found")
+	other := &User{ID: primitive.NewObjectID(), Email: user.Email}
+	assert.NoError(t, s.InsertUser(other), "the old email is free again")
+	err = s.UpdateUser(other.ID, bson.M{userEmailField: "jane@doe.com"})
+	assert.Error(t, err, "email should stay unique")
+	fetched, _ = s.FindUserByEmail("jane@doe.com")
+	assert.Equal(t, user.ID, fetched.ID, "the email stays with its user")
+
+	err = s.UpdateUser(primitive.NewObjectID(), bson.M{userFirstNameField: "Jane"})
+	assert.Equal(t, datastore.ErrNoDocUpdate, err, "non-existent user update")
+}
+
+func TestMemoryStore_Invites(t *testing.T) {
+	s := NewMemoryStore()
+	userID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.PushInvite(userID, sent, otherID), "no invites data yet")
+
+	_, err := s.CreateInvites(userID)
+	assert.NoError(t, err, "invites creation failed")
+	assert.NoError(t, s.PushInvite(userID, sent, otherID), "push invite failed")
+	assert.Equal(t, errInvalidInviteType, s.PushInvite(userID, "invalid", otherID), "invalid invite type")
+
+	invites, err := s.FindInvites(userID)
+	assert.NoError(t, err, "invites fetch failed")
+	assert.Equal(t, []primitive.ObjectID{otherID}, invites.Sent, "invite should be pushed")
+
+	assert.NoError(t, s.PullInvite(userID, sent, otherID), "pull invite failed")
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.PullInvite(userID, sent, otherID), "invite already pulled")
+}
+
+func TestMemoryStore_Blocks(t *testing.T) {
+	s := NewMemoryStore()
+	userID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+	_, err := s.FindBlocks(userID)
+	assert.Equal(t, datastore.ErrNoDocFound, err, "no block list yet")
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.PullBlock(userID, otherID), "no block list yet")
+
+	assert.NoError(t, s.PushBlock(userID, otherID), "push block failed")
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.PushBlock(userID, otherID), "user already blocked")
+	blockList, err := s.FindBlocks(userID)
+	assert.NoError(t, err, "block list fetch failed")
+	assert.Equal(t, []primitive.ObjectID{otherID}, blockList.BlockedIDs, "block should be pushed")
+
+	assert.NoError(t, s.PullBlock(userID, otherID), "pull block failed")
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.PullBlock(userID, otherID), "block already pulled")
+}
+
+func TestMemoryStore_Resets(t *testing.T) {
+	s := NewMemoryStore()
+	userID := primitive.NewObjectID()
+	_, err := s.FindReset("hash")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "no reset yet")
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.DeleteResets(userID), "no reset yet")
+
+	reset := &passwordReset{ID: primitive.NewObjectID(), UserID: userID, TokenHash: "hash"}
+	assert.NoError(t, s.InsertReset(reset), "insert reset failed")
+	found, err := s.FindReset("hash")
+	assert.NoError(t, err, "reset fetch failed")
+	assert.Equal(t, reset, found)
+
+	assert.NoError(t, s.DeleteResets(userID), "delete resets failed")
+	_, err = s.FindReset("hash")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "reset should be deleted")
+}
+
+func TestMemoryStore_Sessions(t *testing.T) {
+	s := NewMemoryStore()
+	userID := primitive.NewObjectID()
+	_, err := s.FindSession("hash")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "no session yet")
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.DeleteSessions(userID, primitive.NilObjectID), "no session yet")
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	first := &Session{ID: primitive.NewObjectID(), UserID: userID, TokenHash: "hash", CreatedAt: now}
+	second := &Session{ID: primitive.NewObjectID(), UserID: userID, TokenHash: "other", CreatedAt: now.Add(time.Second)}
+	assert.NoError(t, s.InsertSession(second), "insert session failed")
+	assert.NoError(t, s.InsertSession(first), "insert session failed")
+	found, err := s.FindSession("hash")
+	assert.NoError(t, err, "session fetch failed")
+	assert.Equal(t, first, found)
+	sessions, err := s.FindSessions(userID)
+	assert.NoError(t, err, "sessions fetch failed")
+	assert.Equal(t, []Session{*first, *second}, sessions, "the oldest first")
+
+	assert.NoError(t, s.UpdateSession(first.ID, bson.M{sessionConnectedField: true}), "update session failed")
+	found, _ = s.FindSession("hash")
+	assert.True(t, found.Connected, "session should be updated")
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.UpdateSession(primitive.NewObjectID(), bson.M{}))
+
+	assert.NoError(t, s.DeleteSessions(userID, first.ID), "delete session failed")
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.DeleteSessions(userID, first.ID), "session already deleted")
+	assert.NoError(t, s.DeleteSessions(userID, primitive.NilObjectID), "delete sessions failed")
+	_, err = s.FindSession("other")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "all the sessions should be deleted")
+}
+
+func TestMemoryStore_Transaction(t *testing.T) {
+	s := NewMemoryStore()
+	userID, friendID := primitive.NewObjectID(), primitive.NewObjectID()
+	errAbort := errors.New("abort")
+	err := s.Transaction(func(tx Store) error {
+		assert.NoError(t, tx.PushFriend(userID, friendID), "push friend failed")
+		return errAbort
+	})
+	assert.Equal(t, errAbort, err, "transaction error should be returned as is")
+	_, err = s.FindFriends(userID)
+	assert.Equal(t, datastore.ErrNoDocFound, err, "changes should be rolled back")
+
+	err = s.Transaction(func(tx Store) error {
+		return tx.Transaction(func(tx Store) error {
+			return tx.PushFriend(userID, friendID)
+		})
+	})
+	assert.NoError(t, err, "nested transaction failed")
+	frnds, err := s.FindFriends(userID)
+	assert.NoError(t, err, "changes should be committed")
+	assert.Equal(t, []primitive.ObjectID{friendID}, frnds.FriendIDs)
+
+	usr := &User{ID: userID, Email: "john@doe.com", FirstName: "John"}
+	assert.NoError(t, s.InsertUser(usr), "insert user failed")
+	msg := message{ID: primitive.NewObjectID(), Sender: userID, Text: "hi", Timestamp: time.Now().UTC()}
+	assert.NoError(t, s.PushMessage(userID, friendID, msg), "push message failed")
+	err = s.Transaction(func(tx Store) error {
+		assert.NoError(t, tx.UpdateUser(userID, bson.M{"email": "jane@doe.com"}), "update user failed")
+		assert.NoError(t, tx.PullFriend(userID, friendID), "pull friend failed")
+		assert.NoError(t, tx.UpdateMessage(msg.ID, bson.M{"text": "edited"}), "update message failed")
+		assert.NoError(t, tx.PushMessage(userID, friendID, message{ID: primitive.NewObjectID(), Sender: friendID,
+			Text: "hello", Timestamp: time.Now().UTC()}), "push message failed")
+		return errAbort
+	})
+	assert.Equal(t, errAbort, err)
+	found, err := s.FindUserByEmail("john@doe.com")
+	assert.NoError(t, err, "email change should be rolled back")
+	assert.Equal(t, "John", found.FirstName)
+	_, err = s.FindUserByEmail("jane@doe.com")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "new email should be rolled back")
+	frnds, _ = s.FindFriends(userID)
+	assert.Equal(t, []primitive.ObjectID{friendID}, frnds.FriendIDs, "pulled friend should be restored")
+	ch, _ := s.FindChat(userID, friendID)
+	msgs, _ := s.FindMessages(ch.ID, messageQuery{})
+	if assert.Equal(t, 1, len(msgs), "pushed message should be rolled back") {
+		assert.Equal(t, "hi", msgs[0].Text, "edit should be rolled back")
+	}
+}
+
+func TestMemoryStore_Concurrency(t *testing.T) {
+	s := NewMemoryStore()
+	userID1, userID2 := primitive.NewObjectID(), primitive.NewObjectID()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.PushMessage(userID1, userID2, message{ID: primitive.NewObjectID(), Sender: userID1, Text: "hi"}))
+		}()
+	}
+	wg.Wait()
+	ch, err := s.FindChat(userID2, userID1)
+	assert.NoError(t, err, "chat fetch failed")
+	msgs, err := s.FindMessages(ch.ID, messageQuery{})
+	assert.NoError(t, err, "messages fetch failed")
+	assert.Equal(t, 50, len(msgs), "no message should be lost")
+}
+
+func TestMemoryStore_FindMessages(t *testing.T) {
+	s := NewMemoryStore()
+	conversationID, start := primitive.NewObjectID(), time.Now().UTC().Truncate(time.Millisecond)
+	msgs := make([]message, 0)
+	for i := 0; i < 6; i++ {
+		msg := message{ID: primitive.NewObjectID(), Conversation: conversationID, Timestamp: start.Add(
+			time.Duration(i/2) * time.Second)} // two messages per timestamp, ordered by ID
+		msgs = append(msgs, msg)
+	}
+	for _, i := range []int{5, 0, 3, 1, 4, 2} { // out of order
+		assert.NoError(t, s.InsertMessage(msgs[i]))
+	}
+	assert.NoError(t, s.InsertMessage(message{ID: primitive.NewObjectID(), Conversation: primitive.NewObjectID(),
+		Timestamp: start}))
+
+	found, err := s.FindMessages(conversationID, messageQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, msgs, found, "all the messages of the conversation, the oldest first")
+
+	found, err = s.FindMessages(conversationID, messageQuery{limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, msgs[4:], found, "the latest messages")
+
+	found, err = s.FindMessages(conversationID, messageQuery{before: msgs[3].cursor(), limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, msgs[1:3], found, "the latest messages before the cursor, even with the same timestamp")
+
+	found, err = s.FindMessages(conversationID, messageQuery{since: msgs[2].Timestamp, before: msgs[5].cursor()})
+	assert.NoError(t, err)
+	assert.Equal(t, msgs[2:5], found, "the messages since the given time")
+
+	msg, err := s.FindMessage(msgs[3].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, msgs[3], *msg)
+	_, err = s.FindMessage(primitive.NewObjectID())
+	assert.Equal(t, datastore.ErrNoDocFound, err)
+
+	userID, editedAt := primitive.NewObjectID(), start.Add(time.Minute)
+	assert.NoError(t, s.UpdateMessage(msgs[3].ID, bson.M{messageTextField: "edited", messageEditedAtField: editedAt}))
+	msg, err = s.FindMessage(msgs[3].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "edited", msg.Text)
+	assert.Equal(t, editedAt, msg.EditedAt)
+	assert.Equal(t, datastore.ErrNoDocUpdate, s.UpdateMessage(primitive.NewObjectID(), bson.M{messageDeletedField: true}))
+
+	assert.NoError(t, s.HideMessage(msgs[3].ID, userID))
+	assert.NoError(t, s.HideMessage(msgs[3].ID, userID), "hiding twice is harmless")
+	found, err = s.FindMessages(conversationID, messageQuery{visibleTo: userID})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(found), "the message hidden for the user left out")
+	found, err = s.FindMessages(conversationID, messageQuery{visibleTo: primitive.NewObjectID()})
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(found), "the message visible to the others")
+	msg, _ = s.FindMessage(msgs[3].ID)
+	assert.Equal(t, []primitive.ObjectID{userID}, msg.HiddenFor)
+}
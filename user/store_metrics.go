@@ -0,0 +1,224 @@
+package user
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// instrumentedStore is a Store recording the latency and the failures of each operation of the store it wraps
+type instrumentedStore struct {
+	Store
+}
+
+// instrument wraps the store to record the statistics of its operations
+func instrument(s Store) Store {
+	if _, ok := s.(instrumentedStore); ok {
+		return s
+	}
+	return instrumentedStore{s}
+}
+
+// Transaction records the latency of the whole transaction, the operations of which are recorded too. Its
+// failures are those of the function, and are left to the operations.
+func (s instrumentedStore) Transaction(fn func(tx Store) error) error {
+	defer storeOperationSeconds.ObserveSince(time.Now(), "transaction")
+	return s.Store.Transaction(func(tx Store) error {
+		return fn(instrumentedStore{tx})
+	})
+}
+
+func (s instrumentedStore) InsertUser(user *User) (err error) {
+	defer func(start time.Time) { observeStore("insert_user", start, err) }(time.Now())
+	return s.Store.InsertUser(user)
+}
+
+func (s instrumentedStore) FindUserByEmail(email string) (user *User, err error) {
+	defer func(start time.Time) { observeStore("find_user_by_email", start, err) }(time.Now())
+	return s.Store.FindUserByEmail(email)
+}
+
+func (s instrumentedStore) FindUserByID(userID primitive.ObjectID) (user *User, err error) {
+	defer func(start time.Time) { observeStore("find_user_by_id", start, err) }(time.Now())
+	return s.Store.FindUserByID(userID)
+}
+
+func (s instrumentedStore) UpdateUser(userID primitive.ObjectID, fields bson.M) (err error) {
+	defer func(start time.Time) { observeStore("update_user", start, err) }(time.Now())
+	return s.Store.UpdateUser(userID, fields)
+}
+
+func (s instrumentedStore) CreateInvites(userID primitive.ObjectID) (invitesID primitive.ObjectID, err error) {
+	defer func(start time.Time) { observeStore("create_invites", start, err) }(time.Now())
+	return s.Store.CreateInvites(userID)
+}
+
+func (s instrumentedStore) FindInvites(userID primitive.ObjectID) (invites *userInvites, err error) {
+	defer func(start time.Time) { observeStore("find_invites", start, err) }(time.Now())
+	return s.Store.FindInvites(userID)
+}
+
+func (s instrumentedStore) PushInvite(userID primitive.ObjectID, invType inviteType,
+	otherID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("push_invite", start, err) }(time.Now())
+	return s.Store.PushInvite(userID, invType, otherID)
+}
+
+func (s instrumentedStore) PullInvite(userID primitive.ObjectID, invType inviteType,
+	otherID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("pull_invite", start, err) }(time.Now())
+	return s.Store.PullInvite(userID, invType, otherID)
+}
+
+func (s instrumentedStore) PushInviteOutcome(userID primitive.ObjectID, outcome InviteOutcome) (err error) {
+	defer func(start time.Time) { observeStore("push_invite_outcome", start, err) }(time.Now())
+	return s.Store.PushInviteOutcome(userID, outcome)
+}
+
+func (s instrumentedStore) FindFriends(userID primitive.ObjectID) (frdns *friends, err error) {
+	defer func(start time.Time) { observeStore("find_friends", start, err) }(time.Now())
+	return s.Store.FindFriends(userID)
+}
+
+func (s instrumentedStore) PushFriend(userID, friendID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("push_friend", start, err) }(time.Now())
+	return s.Store.PushFriend(userID, friendID)
+}
+
+func (s instrumentedStore) PullFriend(userID, friendID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("pull_friend", start, err) }(time.Now())
+	return s.Store.PullFriend(userID, friendID)
+}
+
+func (s instrumentedStore) FindChat(userID1, userID2 primitive.ObjectID) (c *chat, err error) {
+	defer func(start time.Time) { observeStore("find_chat", start, err) }(time.Now())
+	return s.Store.FindChat(userID1, userID2)
+}
+
+func (s instrumentedStore) PushMessage(userID1, userID2 primitive.ObjectID, msg message) (err error) {
+	defer func(start time.Time) { observeStore("push_message", start, err) }(time.Now())
+	return s.Store.PushMessage(userID1, userID2, msg)
+}
+
+func (s instrumentedStore) InsertMessage(msg message) (err error) {
+	defer func(start time.Time) { observeStore("insert_message", start, err) }(time.Now())
+	return s.Store.InsertMessage(msg)
+}
+
+func (s instrumentedStore) FindMessage(messageID primitive.ObjectID) (msg *message, err error) {
+	defer func(start time.Time) { observeStore("find_message", start, err) }(time.Now())
+	return s.Store.FindMessage(messageID)
+}
+
+func (s instrumentedStore) FindMessages(conversationID primitive.ObjectID, query messageQuery) (msgs []message,
+	err error) {
+	defer func(start time.Time) { observeStore("find_messages", start, err) }(time.Now())
+	return s.Store.FindMessages(conversationID, query)
+}
+
+func (s instrumentedStore) CountMessages(conversationID primitive.ObjectID, query messageQuery) (count int,
+	err error) {
+	defer func(start time.Time) { observeStore("count_messages", start, err) }(time.Now())
+	return s.Store.CountMessages(conversationID, query)
+}
+
+func (s instrumentedStore) UpdateMessage(messageID primitive.ObjectID, fields bson.M) (err error) {
+	defer func(start time.Time) { observeStore("update_message", start, err) }(time.Now())
+	return s.Store.UpdateMessage(messageID, fields)
+}
+
+func (s instrumentedStore) HideMessage(messageID, userID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("hide_message", start, err) }(time.Now())
+	return s.Store.HideMessage(messageID, userID)
+}
+
+func (s instrumentedStore) DeleteMessages(conversationID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("delete_messages", start, err) }(time.Now())
+	return s.Store.DeleteMessages(conversationID)
+}
+
+func (s instrumentedStore) FindReadCursor(userID, conversationID primitive.ObjectID) (cursor *readCursor,
+	err error) {
+	defer func(start time.Time) { observeStore("find_read_cursor", start, err) }(time.Now())
+	return s.Store.FindReadCursor(userID, conversationID)
+}
+
+func (s instrumentedStore) AdvanceReadCursor(userID, conversationID primitive.ObjectID, until time.Time) (err error) {
+	defer func(start time.Time) { observeStore("advance_read_cursor", start, err) }(time.Now())
+	return s.Store.AdvanceReadCursor(userID, conversationID, until)
+}
+
+func (s instrumentedStore) InsertGroup(group *Group) (err error) {
+	defer func(start time.Time) { observeStore("insert_group", start, err) }(time.Now())
+	return s.Store.InsertGroup(group)
+}
+
+func (s instrumentedStore) FindGroup(groupID primitive.ObjectID) (group *Group, err error) {
+	defer func(start time.Time) { observeStore("find_group", start, err) }(time.Now())
+	return s.Store.FindGroup(groupID)
+}
+
+func (s instrumentedStore) FindGroupsByMember(userID primitive.ObjectID) (groups []*Group, err error) {
+	defer func(start time.Time) { observeStore("find_groups_by_member", start, err) }(time.Now())
+	return s.Store.FindGroupsByMember(userID)
+}
+
+func (s instrumentedStore) UpdateGroup(groupID primitive.ObjectID, fields bson.M) (err error) {
+	defer func(start time.Time) { observeStore("update_group", start, err) }(time.Now())
+	return s.Store.UpdateGroup(groupID, fields)
+}
+
+func (s instrumentedStore) FindBlocks(userID primitive.ObjectID) (b *blocks, err error) {
+	defer func(start time.Time) { observeStore("find_blocks", start, err) }(time.Now())
+	return s.Store.FindBlocks(userID)
+}
+
+func (s instrumentedStore) PushBlock(userID, blockedID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("push_block", start, err) }(time.Now())
+	return s.Store.PushBlock(userID, blockedID)
+}
+
+func (s instrumentedStore) PullBlock(userID, blockedID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("pull_block", start, err) }(time.Now())
+	return s.Store.PullBlock(userID, blockedID)
+}
+
+func (s instrumentedStore) InsertReset(reset *passwordReset) (err error) {
+	defer func(start time.Time) { observeStore("insert_reset", start, err) }(time.Now())
+	return s.Store.InsertReset(reset)
+}
+
+func (s instrumentedStore) FindReset(tokenHash string) (r *passwordReset, err error) {
+	defer func(start time.Time) { observeStore("find_reset", start, err) }(time.Now())
+	return s.Store.FindReset(tokenHash)
+}
+
+func (s instrumentedStore) DeleteResets(userID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("delete_resets", start, err) }(time.Now())
+	return s.Store.DeleteResets(userID)
+}
+
+func (s instrumentedStore) InsertSession(session *Session) (err error) {
+	defer func(start time.Time) { observeStore("insert_session", start, err) }(time.Now())
+	return s.Store.InsertSession(session)
+}
+
+func (s instrumentedStore) FindSession(tokenHash string) (session *Session, err error) {
+	defer func(start time.Time) { observeStore("find_session", start, err) }(time.Now())
+	return s.Store.FindSession(tokenHash)
+}
+
+func (s instrumentedStore) FindSessions(userID primitive.ObjectID) (sessions []Session, err error) {
+	defer func(start time.Time) { observeStore("find_sessions", start, err) }(time.Now())
+	return s.Store.FindSessions(userID)
+}
+
+func (s instrumentedStore) UpdateSession(sessionID primitive.ObjectID, fields bson.M) (err error) {
+	defer func(start time.Time) { observeStore("update_session", start, err) }(time.Now())
+	return s.Store.UpdateSession(sessionID, fields)
+}
+
+func (s instrumentedStore) DeleteSessions(userID, sessionID primitive.ObjectID) (err error) {
+	defer func(start time.Time) { observeStore("delete_sessions", start, err) }(time.Now())
+	return s.Store.DeleteSessions(userID, sessionID)
+}
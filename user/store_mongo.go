@@ -0,0 +1,663 @@
+package user
+
+import (
+	"context"
+	"gibber/datastore"
+	"gibber/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// mongoStore is the Store persisting the documents in mongo collections
+type mongoStore struct {
+	db   *mongo.Database
+	ctx  context.Context // session context when inside a transaction
+	inTx bool
+}
+
+// mongoIndexes are the indexes of the collections, created (if non-existent) along with the store
+var mongoIndexes = map[string][]mongo.IndexModel{
+	chatCollection: {{
+		Keys:    bson.D{{Key: chatUser1, Value: 1}, {Key: chatUser2, Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}},
+	readCursorCollection: {{
+		Keys:    bson.D{{Key: readCursorUserField, Value: 1}, {Key: readCursorConversationField, Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}},
+	messageCollection: {{ // pages of a conversation, the latest first
+		Keys: bson.D{
+			{Key: messageConversationField, Value: 1},
+			{Key: messageTimestampField, Value: -1},
+			{Key: datastore.ObjectID, Value: -1},
+		},
+	}},
+	resetCollection: {
+		{Keys: bson.D{{Key: resetTokenHashField, Value: 1}}, Options: options.Index().SetUnique(true)},
+		// the expired resets are removed by mongo itself
+		{Keys: bson.D{{Key: resetExpiresAtField, Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+	sessionCollection: {
+		{Keys: bson.D{{Key: sessionTokenHashField, Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: userIdField, Value: 1}}},
+		// the expired sessions are removed by mongo itself
+		{Keys: bson.D{{Key: sessionExpiresAtField, Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	},
+}
+
+// NewMongoStore gives a store backed by the given mongo database, creating the indexes and moving the messages
+// still embedded in the chat and group documents to their own documents
+func NewMongoStore(db *mongo.Database) Store {
+	s := &mongoStore{db: db, ctx: context.Background()}
+	for coll, indexes := range mongoIndexes {
+		if _, err := db.Collection(coll).Indexes().CreateMany(s.ctx, indexes); err != nil {
+			log.Logger().Errorf("creating indexes of %s failed: %s", coll, err)
+		}
+	}
+	for _, coll := range []string{chatCollection, groupCollection} {
+		if err := s.migrateEmbeddedMessages(coll); err != nil {
+			log.Logger().Errorf("moving the messages of %s to their own documents failed: %s", coll, err)
+		}
+	}
+	return s
+}
+
+// Transaction runs fn inside a mongo transaction, committing it only if fn succeeds
+func (s *mongoStore) Transaction(fn func(tx Store) error) (err error) {
+	if s.inTx { // nested transaction is part of the outer one
+		return fn(s)
+	}
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		log.Logger().Errorf("initializing mongo session failed: %s", err)
+		return
+	}
+	defer session.EndSession(context.Background())
+
+	err = session.StartTransaction()
+	if err != nil {
+		log.Logger().Errorf("initializing mongo transaction failed: %s", err)
+		return
+	}
+
+	return mongo.WithSession(s.ctx, session, func(sc mongo.SessionContext) (er error) {
+		if er = fn(&mongoStore{db: s.db, ctx: sc, inTx: true}); er != nil {
+			// ROLLBACK at the earliest to shorten transaction life-cycle
+			if abortErr := session.AbortTransaction(sc); abortErr != nil {
+				log.Logger().Errorf("aborting mongo transaction failed: %s", abortErr)
+			}
+			return
+		}
+		if er = session.CommitTransaction(sc); er != nil {
+			log.Logger().Errorf("committing mongo transaction failed: %s", er)
+		}
+		return
+	})
+}
+
+// InsertUser stores a new user document
+func (s *mongoStore) InsertUser(user *User) (err error) {
+	_, err = s.db.Collection(userCollection).InsertOne(s.ctx, user)
+	if err != nil {
+		log.Logger().Errorf("error while creating new user %s: %s", user.Email, err)
+	}
+	return
+}
+
+// FindUserByEmail gives the user document with the given email
+func (s *mongoStore) FindUserByEmail(email string) (user *User, err error) {
+	user = &User{}
+	err = findOne(s.ctx, bson.M{userEmailField: email}, s.db.Collection(userCollection), user)
+	return
+}
+
+// FindUserByID gives the user document with the given ID
+func (s *mongoStore) FindUserByID(userID primitive.ObjectID) (user *User, err error) {
+	user = &User{}
+	err = findOne(s.ctx, bson.M{datastore.ObjectID: userID}, s.db.Collection(userCollection), user)
+	return
+}
+
+// UpdateUser sets the given fields on the user document
+func (s *mongoStore) UpdateUser(userID primitive.ObjectID, fields bson.M) (err error) {
+	res, err := s.db.Collection(userCollection).UpdateOne(
+		s.ctx,
+		bson.M{datastore.ObjectID: userID},
+		bson.D{{Key: datastore.MongoSetOperator, Value: fields}})
+	if err != nil {
+		log.Logger().Errorf("error while updating user %s: %s", userID.Hex(), err)
+	} else if res.MatchedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// CreateInvites creates the empty invites document for the given user
+func (s *mongoStore) CreateInvites(userID primitive.ObjectID) (invitesID primitive.ObjectID, err error) {
+	id, err := createUserInvitesData(s.ctx, userID, s.db.Collection(userInvitesCollection))
+	if err == nil {
+		invitesID = id.(primitive.ObjectID)
+	}
+	return
+}
+
+// FindInvites gives the invites document of the given user
+func (s *mongoStore) FindInvites(userID primitive.ObjectID) (invites *userInvites, err error) {
+	invites = &userInvites{}
+	err = findOne(s.ctx, bson.M{userIdField: userID}, s.db.Collection(userInvitesCollection), invites)
+	return
+}
+
+// PushInvite appends the other user to the given invite list of the user
+func (s *mongoStore) PushInvite(userID primitive.ObjectID, invType inviteType, otherID primitive.ObjectID) error {
+	return updateInvites(s.ctx, userID, datastore.MongoPushOperator, invType, otherID,
+		s.db.Collection(userInvitesCollection))
+}
+
+// PullInvite removes the other user from the given invite list of the user
+func (s *mongoStore) PullInvite(userID primitive.ObjectID, invType inviteType, otherID primitive.ObjectID) error {
+	return updateInvites(s.ctx, userID, datastore.MongoPullOperator, invType, otherID,
+		s.db.Collection(userInvitesCollection))
+}
+
+// PushInviteOutcome appends the outcome of an invitation to the invites document of the user
+func (s *mongoStore) PushInviteOutcome(userID primitive.ObjectID, outcome InviteOutcome) (err error) {
+	res, err := s.db.Collection(userInvitesCollection).UpdateOne(
+		s.ctx,
+		bson.M{userIdField: userID},
+		bson.D{
+			{Key: datastore.MongoPushOperator, Value: bson.D{{Key: inviteOutcomesField, Value: outcome}}},
+		})
+	if err != nil {
+		log.Logger().Errorf("pushing %s invite outcome failed for user %s: %s", outcome.Status, userID.Hex(), err)
+	} else if res.ModifiedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// FindFriends gives the friends document of the given user
+func (s *mongoStore) FindFriends(userID primitive.ObjectID) (frnds *friends, err error) {
+	frnds = &friends{}
+	err = findOne(s.ctx, bson.M{userIdField: userID}, s.db.Collection(friendsCollection), frnds)
+	return
+}
+
+// PushFriend appends the friend to the friends document of the user
+func (s *mongoStore) PushFriend(userID, friendID primitive.ObjectID) (err error) {
+	// using upsert: true to create the friends document if non-existent
+	res, err := s.db.Collection(friendsCollection).UpdateOne(
+		s.ctx,
+		bson.M{userIdField: userID},
+		bson.D{
+			{Key: datastore.MongoPushOperator, Value: bson.D{{Key: friendsField, Value: friendID}}},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		log.Logger().Errorf("error while adding %s as friend for %s: %s", friendID.Hex(), userID.Hex(), err)
+	} else if res.ModifiedCount+res.UpsertedCount != 1 {
+		log.Logger().Infof("document not created/updated to add %s as friend for %s", friendID.Hex(),
+			userID.Hex())
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// PullFriend removes the friend from the friends document of the user
+func (s *mongoStore) PullFriend(userID, friendID primitive.ObjectID) (err error) {
+	res, err := s.db.Collection(friendsCollection).UpdateOne(
+		s.ctx,
+		bson.M{userIdField: userID},
+		bson.M{datastore.MongoPullOperator: bson.M{friendsField: friendID}})
+	if err != nil {
+		log.Logger().Errorf("error while removing %s as friend for %s: %s", friendID.Hex(), userID.Hex(), err)
+	} else if res.ModifiedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// FindChat gives the chat document b/w the two given users
+func (s *mongoStore) FindChat(userID1, userID2 primitive.ObjectID) (*chat, error) {
+	return getChatByUserIDs(s.ctx, userID1, userID2, s.db.Collection(chatCollection))
+}
+
+// PushMessage inserts the message document in the chat b/w the two given users, creating the chat document
+// with the first message
+func (s *mongoStore) PushMessage(userID1, userID2 primitive.ObjectID, msg message) (err error) {
+	chats := s.db.Collection(chatCollection)
+	key := chatKey(userID1, userID2) // ordering IDs
+	filter := bson.D{{Key: chatUser1, Value: key[0]}, {Key: chatUser2, Value: key[1]}}
+	ch := &chat{}
+	err = findOne(s.ctx, filter, chats, ch)
+	if err == datastore.ErrNoDocFound {
+		if err = upsertChat(s.ctx, userID1, userID2, chats); err == nil {
+			err = findOne(s.ctx, filter, chats, ch)
+		}
+	}
+	if err != nil {
+		return
+	}
+	msg.Conversation = ch.ID
+	return s.InsertMessage(msg)
+}
+
+// InsertMessage stores a new message document
+func (s *mongoStore) InsertMessage(msg message) (err error) {
+	_, err = s.db.Collection(messageCollection).InsertOne(s.ctx, msg)
+	if err != nil {
+		log.Logger().Errorf("error while storing msg in conversation %s: %s", msg.Conversation.Hex(), err)
+	}
+	return
+}
+
+// FindMessage gives the message document with the given ID
+func (s *mongoStore) FindMessage(messageID primitive.ObjectID) (msg *message, err error) {
+	msg = &message{}
+	err = findOne(s.ctx, bson.M{datastore.ObjectID: messageID}, s.db.Collection(messageCollection), msg)
+	return
+}
+
+// FindMessages gives the latest message documents of the conversation matching the query, the oldest first
+func (s *mongoStore) FindMessages(conversationID primitive.ObjectID, query messageQuery) (msgs []message,
+	err error) {
+	opts := options.Find().SetSort(bson.D{{Key: messageTimestampField, Value: -1}, {Key: datastore.ObjectID, Value: -1}})
+	if query.limit > 0 {
+		opts.SetLimit(int64(query.limit))
+	}
+	msgs = make([]message, 0)
+	filter := messageFilter(conversationID, query)
+	if err = findAll(s.ctx, filter, opts, s.db.Collection(messageCollection), &msgs); err != nil {
+		return
+	}
+	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 { // latest first as fetched
+		msgs[i], msgs[j] = msgs[j], msgs[i]
+	}
+	return
+}
+
+// CountMessages counts the message documents of the conversation matching the query
+func (s *mongoStore) CountMessages(conversationID primitive.ObjectID, query messageQuery) (count int, err error) {
+	filter := messageFilter(conversationID, query)
+	n, err := s.db.Collection(messageCollection).CountDocuments(s.ctx, filter)
+	if err != nil {
+		log.Logger().Errorf("counting documents for filter %v failed: %s", filter, err)
+	}
+	return int(n), err
+}
+
+// UpdateMessage sets the given fields on the message document
+func (s *mongoStore) UpdateMessage(messageID primitive.ObjectID, fields bson.M) (err error) {
+	res, err := s.db.Collection(messageCollection).UpdateOne(
+		s.ctx,
+		bson.M{datastore.ObjectID: messageID},
+		bson.D{{Key: datastore.MongoSetOperator, Value: fields}})
+	if err != nil {
+		log.Logger().Errorf("error while updating message %s: %s", messageID.Hex(), err)
+	} else if res.MatchedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// HideMessage adds the given user to the ones for whom the message document is deleted
+func (s *mongoStore) HideMessage(messageID, userID primitive.ObjectID) (err error) {
+	res, err := s.db.Collection(messageCollection).UpdateOne(
+		s.ctx,
+		bson.M{datastore.ObjectID: messageID},
+		bson.M{datastore.MongoAddToSetOperator: bson.M{messageHiddenForField: userID}})
+	if err != nil {
+		log.Logger().Errorf("error while hiding message %s for %s: %s", messageID.Hex(), userID.Hex(), err)
+	} else if res.MatchedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// DeleteMessages removes all the message documents of the given conversation
+func (s *mongoStore) DeleteMessages(conversationID primitive.ObjectID) (err error) {
+	_, err = s.db.Collection(messageCollection).DeleteMany(s.ctx, bson.M{messageConversationField: conversationID})
+	if err != nil {
+		log.Logger().Errorf("error while deleting messages of conversation %s: %s", conversationID.Hex(), err)
+	}
+	return
+}
+
+// FindReadCursor gives the read cursor document of the given user in the conversation
+func (s *mongoStore) FindReadCursor(userID, conversationID primitive.ObjectID) (cursor *readCursor, err error) {
+	cursor = &readCursor{}
+	err = findOne(s.ctx, bson.M{readCursorUserField: userID, readCursorConversationField: conversationID},
+		s.db.Collection(readCursorCollection), cursor)
+	return
+}
+
+// AdvanceReadCursor moves the read cursor document of the user in the conversation forward to the given time
+func (s *mongoStore) AdvanceReadCursor(userID, conversationID primitive.ObjectID, until time.Time) (err error) {
+	// using upsert: true to create the read cursor document if non-existent
+	res, err := s.db.Collection(readCursorCollection).UpdateOne(
+		s.ctx,
+		bson.M{readCursorUserField: userID, readCursorConversationField: conversationID},
+		bson.M{datastore.MongoMaxOperator: bson.M{readCursorUntilField: until}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		log.Logger().Errorf("error while advancing read cursor of %s in %s: %s", userID.Hex(), conversationID.Hex(),
+			err)
+	} else if res.ModifiedCount+res.UpsertedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// InsertGroup stores a new group document
+func (s *mongoStore) InsertGroup(group *Group) (err error) {
+	_, err = s.db.Collection(groupCollection).InsertOne(s.ctx, group)
+	if err != nil {
+		log.Logger().Errorf("error while creating new group %s: %s", group.Name, err)
+	}
+	return
+}
+
+// FindGroup gives the group document with the given ID
+func (s *mongoStore) FindGroup(groupID primitive.ObjectID) (group *Group, err error) {
+	group = &Group{}
+	err = findOne(s.ctx, bson.M{datastore.ObjectID: groupID}, s.db.Collection(groupCollection), group)
+	return
+}
+
+// FindGroupsByMember gives all the group documents of which the given user is a member, in creation order
+func (s *mongoStore) FindGroupsByMember(userID primitive.ObjectID) (groups []*Group, err error) {
+	groups = make([]*Group, 0)
+	err = findAll(s.ctx, bson.M{groupMemberIDField: userID}, options.Find().SetSort(bson.M{datastore.ObjectID: 1}),
+		s.db.Collection(groupCollection), &groups)
+	return
+}
+
+// UpdateGroup sets the given fields on the group document
+func (s *mongoStore) UpdateGroup(groupID primitive.ObjectID, fields bson.M) (err error) {
+	res, err := s.db.Collection(groupCollection).UpdateOne(
+		s.ctx,
+		bson.M{datastore.ObjectID: groupID},
+		bson.D{{Key: datastore.MongoSetOperator, Value: fields}})
+	if err != nil {
+		log.Logger().Errorf("error while updating group %s: %s", groupID.Hex(), err)
+	} else if res.MatchedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// FindBlocks gives the block list document of the given user
+func (s *mongoStore) FindBlocks(userID primitive.ObjectID) (blockList *blocks, err error) {
+	blockList = &blocks{}
+	err = findOne(s.ctx, bson.M{userIdField: userID}, s.db.Collection(blocksCollection), blockList)
+	return
+}
+
+// PushBlock adds the user to the block list document of the given user, unless already there
+func (s *mongoStore) PushBlock(userID, blockedID primitive.ObjectID) (err error) {
+	// using upsert: true to create the block list document if non-existent
+	res, err := s.db.Collection(blocksCollection).UpdateOne(
+		s.ctx,
+		bson.M{userIdField: userID},
+		bson.M{datastore.MongoAddToSetOperator: bson.M{blockedField: blockedID}},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		log.Logger().Errorf("error while blocking %s for %s: %s", blockedID.Hex(), userID.Hex(), err)
+	} else if res.ModifiedCount+res.UpsertedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// PullBlock removes the user from the block list document of the given user
+func (s *mongoStore) PullBlock(userID, blockedID primitive.ObjectID) (err error) {
+	res, err := s.db.Collection(blocksCollection).UpdateOne(
+		s.ctx,
+		bson.M{userIdField: userID},
+		bson.M{datastore.MongoPullOperator: bson.M{blockedField: blockedID}})
+	if err != nil {
+		log.Logger().Errorf("error while unblocking %s for %s: %s", blockedID.Hex(), userID.Hex(), err)
+	} else if res.ModifiedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// InsertReset stores a new password reset document
+func (s *mongoStore) InsertReset(reset *passwordReset) (err error) {
+	_, err = s.db.Collection(resetCollection).InsertOne(s.ctx, reset)
+	if err != nil {
+		log.Logger().Errorf("error while storing password reset for %s: %s", reset.UserID.Hex(), err)
+	}
+	return
+}
+
+// FindReset gives the password reset document with the given token hash
+func (s *mongoStore) FindReset(tokenHash string) (reset *passwordReset, err error) {
+	reset = &passwordReset{}
+	err = findOne(s.ctx, bson.M{resetTokenHashField: tokenHash}, s.db.Collection(resetCollection), reset)
+	return
+}
+
+// DeleteResets removes all the password reset documents of the given user
+func (s *mongoStore) DeleteResets(userID primitive.ObjectID) (err error) {
+	res, err := s.db.Collection(resetCollection).DeleteMany(s.ctx, bson.M{userIdField: userID})
+	if err != nil {
+		log.Logger().Errorf("error while deleting password resets of %s: %s", userID.Hex(), err)
+	} else if res.DeletedCount == 0 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// InsertSession stores a new session document
+func (s *mongoStore) InsertSession(session *Session) (err error) {
+	_, err = s.db.Collection(sessionCollection).InsertOne(s.ctx, session)
+	if err != nil {
+		log.Logger().Errorf("error while storing session of %s: %s", session.UserID.Hex(), err)
+	}
+	return
+}
+
+// FindSession gives the session document with the given token hash
+func (s *mongoStore) FindSession(tokenHash string) (session *Session, err error) {
+	session = &Session{}
+	err = findOne(s.ctx, bson.M{sessionTokenHashField: tokenHash}, s.db.Collection(sessionCollection), session)
+	return
+}
+
+// FindSessions gives the session documents of the given user, the oldest first
+func (s *mongoStore) FindSessions(userID primitive.ObjectID) (sessions []Session, err error) {
+	sessions = make([]Session, 0)
+	opts := options.Find().SetSort(bson.D{{Key: datastore.ObjectID, Value: 1}})
+	err = findAll(s.ctx, bson.M{userIdField: userID}, opts, s.db.Collection(sessionCollection), &sessions)
+	return
+}
+
+// UpdateSession sets the given fields of the session document
+func (s *mongoStore) UpdateSession(sessionID primitive.ObjectID, fields bson.M) (err error) {
+	res, err := s.db.Collection(sessionCollection).UpdateOne(
+		s.ctx,
+		bson.M{datastore.ObjectID: sessionID},
+		bson.D{{Key: datastore.MongoSetOperator, Value: fields}})
+	if err != nil {
+		log.Logger().Errorf("error while updating session %s: %s", sessionID.Hex(), err)
+	} else if res.MatchedCount != 1 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// DeleteSessions removes the given session document of the user, or all his session documents if the session ID
+// is zero
+func (s *mongoStore) DeleteSessions(userID, sessionID primitive.ObjectID) (err error) {
+	filter := bson.M{userIdField: userID}
+	if !sessionID.IsZero() {
+		filter[datastore.ObjectID] = sessionID
+	}
+	res, err := s.db.Collection(sessionCollection).DeleteMany(s.ctx, filter)
+	if err != nil {
+		log.Logger().Errorf("error while deleting sessions of %s: %s", userID.Hex(), err)
+	} else if res.DeletedCount == 0 {
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// migrateEmbeddedMessages moves the messages embedded in the (chat or group) documents of the given collection,
+// as they used to be stored, to message documents of their own. A document is left as is if its messages can't be
+// inserted, to be moved again on the next startup.
+func (s *mongoStore) migrateEmbeddedMessages(coll string) (err error) {
+	docs := make([]struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		Messages []message          `bson:"messages"`
+	}, 0)
+	err = findAll(s.ctx, bson.M{embeddedMessagesField: bson.M{datastore.MongoExistsOperator: true}}, nil,
+		s.db.Collection(coll), &docs)
+	if err != nil {
+		return
+	}
+	for _, doc := range docs {
+		if len(doc.Messages) > 0 {
+			msgs := make([]interface{}, 0, len(doc.Messages))
+			for _, msg := range doc.Messages {
+				msg.ID, msg.Conversation = primitive.NewObjectID(), doc.ID
+				msgs = append(msgs, msg)
+			}
+			if _, err = s.db.Collection(messageCollection).InsertMany(s.ctx, msgs); err != nil {
+				return
+			}
+		}
+		_, err = s.db.Collection(coll).UpdateOne(s.ctx, bson.M{datastore.ObjectID: doc.ID},
+			bson.M{datastore.MongoUnsetOperator: bson.M{embeddedMessagesField: ""}})
+		if err != nil {
+			return
+		}
+		log.Logger().Infof("%d messages of %s %s moved to their own documents", len(doc.Messages), coll,
+			doc.ID.Hex())
+	}
+	return
+}
+
+// findOne decodes the document matching the filter into the given result
+func findOne(ctx context.Context, filter interface{}, finder datastore.DatabaseFinder, result interface{}) (err error) {
+	err = finder.FindOne(ctx, filter).Decode(result)
+	if err == mongo.ErrNoDocuments {
+		err = datastore.ErrNoDocFound
+	} else if err != nil {
+		log.Logger().Errorf("decoding(unmarshal) fetch result for filter %v failed: %s", filter, err)
+	}
+	return
+}
+
+// findAll decodes all the documents matching the filter into the given results (pointer to a slice)
+func findAll(ctx context.Context, filter interface{}, opts *options.FindOptions, lister datastore.DatabaseLister,
+	results interface{}) (err error) {
+	cursor, err := lister.Find(ctx, filter, opts)
+	if err != nil {
+		log.Logger().Errorf("fetching documents for filter %v failed: %s", filter, err)
+		return
+	}
+	if err = cursor.All(ctx, results); err != nil {
+		log.Logger().Errorf("decoding(unmarshal) fetch results for filter %v failed: %s", filter, err)
+	}
+	return
+}
+
+// updateInvites pushes/pulls (based on the operator) the other user to/from the given invite list of the user
+func updateInvites(ctx context.Context, userID primitive.ObjectID, operator string, invType inviteType,
+	otherID primitive.ObjectID, updater datastore.DatabaseUpdater) (err error) {
+	res, err := updater.UpdateOne(
+		ctx,
+		bson.D{{Key: userIdField, Value: userID}},
+		bson.D{{Key: operator, Value: bson.D{{Key: string(invType), Value: otherID}}}},
+	)
+	if err != nil {
+		log.Logger().Errorf("%s %s invite %s failed for user %s: %s", operator, invType, otherID.Hex(),
+			userID.Hex(), err)
+	} else if res.ModifiedCount != 1 {
+		log.Logger().Errorf("%s %s invite %s failed for user %s as no doc modified", operator, invType,
+			otherID.Hex(), userID.Hex())
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// messageFilter gives the filter of the message documents of the conversation matching the query
+func messageFilter(conversationID primitive.ObjectID, query messageQuery) bson.M {
+	filter := bson.M{messageConversationField: conversationID}
+	timestamp := bson.M{}
+	if !query.since.IsZero() {
+		timestamp[datastore.MongoGteOperator] = query.since
+	}
+	if !query.after.IsZero() {
+		timestamp[datastore.MongoGtOperator] = query.after
+	}
+	if len(timestamp) > 0 {
+		filter[messageTimestampField] = timestamp
+	}
+	if !query.before.IsZero() {
+		filter[datastore.MongoOrOperator] = bson.A{
+			bson.M{messageTimestampField: bson.M{datastore.MongoLtOperator: query.before.Timestamp}},
+			bson.M{
+				messageTimestampField: query.before.Timestamp,
+				datastore.ObjectID:    bson.M{datastore.MongoLtOperator: query.before.ID},
+			},
+		}
+	}
+	sender := bson.M{}
+	if !query.notFrom.IsZero() {
+		sender[datastore.MongoNeOperator] = query.notFrom
+	}
+	if len(query.blocked) > 0 {
+		sender[datastore.MongoNinOperator] = query.blocked
+	}
+	if len(sender) > 0 {
+		filter[messageSenderField] = sender
+	}
+	if !query.visibleTo.IsZero() { // matches the messages of which none of the hiding users is the given one
+		filter[messageHiddenForField] = bson.M{datastore.MongoNeOperator: query.visibleTo}
+	}
+	return filter
+}
+
+// upsertChat creates the chat b/w the two users, unless it already exists
+func upsertChat(ctx context.Context, userID1, userID2 primitive.ObjectID,
+	updater datastore.DatabaseUpdater) (err error) {
+	key := chatKey(userID1, userID2) // ordering IDs
+	res, err := updater.UpdateOne(ctx,
+		bson.D{
+			{Key: chatUser1, Value: key[0]},
+			{Key: chatUser2, Value: key[1]},
+		},
+		bson.D{
+			{Key: datastore.MongoSetOnInsertOperator, Value: bson.D{
+				{Key: chatUser1, Value: key[0]},
+				{Key: chatUser2, Value: key[1]},
+			}},
+		},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		log.Logger().Errorf("error while creating chat b/w %s and %s: %s", userID1, userID2, err)
+	} else if res.MatchedCount+res.UpsertedCount != 1 {
+		log.Logger().Infof("document not created/found for chat b/w %s and %s", userID1, userID2)
+		err = datastore.ErrNoDocUpdate
+	}
+	return
+}
+
+// getChatByUserIDs fetches the chat b/w two users
+// it sorts the user IDs as to avoid storing both combination of userIds in the database
+func getChatByUserIDs(ctx context.Context, userID1, userID2 primitive.ObjectID,
+	finder datastore.DatabaseFinder) (ch *chat, err error) {
+	ch = &chat{}
+	key := chatKey(userID1, userID2) // ordering IDs
+	err = findOne(ctx, bson.D{{Key: chatUser1, Value: key[0]}, {Key: chatUser2, Value: key[1]}}, finder, ch)
+	if err != nil {
+		log.Logger().Errorf("no chat found with user IDs %s and %s", key[0], key[1]) // other errors are not expected
+	}
+	return
+}
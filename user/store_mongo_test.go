@@ -0,0 +1,89 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"gibber/datastore"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"testing"
+)
+
+var errUpdateFailed = errors.New("update failure")
+
+type databaseUpdateFail struct {
+	// implement DatabaseUpdate interface with failure update operation
+}
+
+func (d *databaseUpdateFail) UpdateOne(ctx context.Context, filter interface{}, update interface{},
+	opts ...*options.UpdateOptions) (res *mongo.UpdateResult, err error) {
+	err = errUpdateFailed
+	return
+}
+
+type databaseUpdateNoEffect struct {
+	// implement DatabaseUpdate interface with failure update operation
+}
+
+func (d *databaseUpdateNoEffect) UpdateOne(ctx context.Context, filter interface{}, update interface{},
+	opts ...*options.UpdateOptions) (res *mongo.UpdateResult, err error) {
+	res = new(mongo.UpdateResult) // default count values (fields) will be zero which is required
+	return
+}
+
+// mongoTestDB gives the live mongo database, skipping the test if mongo is not configured
+func mongoTestDB(t *testing.T) *mongo.Database {
+	if os.Getenv("GIBBER_MONGO_HOST") == "" {
+		t.Skip("GIBBER_MONGO_HOST not set, skipping live mongo test")
+	}
+	return datastore.MongoConn()
+}
+
+func TestGetChatByUserIDs(t *testing.T) {
+	db := mongoTestDB(t)
+	userId1, userId2 := primitive.NewObjectID(), primitive.NewObjectID()
+	chat, err := getChatByUserIDs(context.Background(), userId1, userId2, db.Collection(chatCollection))
+	assert.Equal(t, err, datastore.ErrNoDocFound, "invalid IDs provided so chat should be unavailable")
+	assert.Equal(t, primitive.ObjectID{}.String(), chat.ID.String(), "invalid IDs provided so chat should be unavailable")
+}
+
+func TestUpsertChat(t *testing.T) {
+	sender, receiver := primitive.NewObjectID(), primitive.NewObjectID()
+
+	err := upsertChat(context.Background(), sender, receiver, new(databaseUpdateFail))
+	assert.Equal(t, errUpdateFailed, err, "operation should fail")
+
+	err = upsertChat(context.Background(), sender, receiver, new(databaseUpdateNoEffect))
+	assert.Equal(t, datastore.ErrNoDocUpdate, err, "operation should fail")
+}
+
+func TestUpdateInvites(t *testing.T) {
+	userID, otherID := primitive.NewObjectID(), primitive.NewObjectID()
+
+	err := updateInvites(context.Background(), userID, datastore.MongoPushOperator, sent, otherID,
+		new(databaseUpdateFail))
+	assert.Equal(t, errUpdateFailed, err, "operation should fail")
+
+	err = updateInvites(context.Background(), userID, datastore.MongoPullOperator, sent, otherID,
+		new(databaseUpdateNoEffect))
+	assert.Equal(t, datastore.ErrNoDocUpdate, err, "operation should fail")
+}
+
+func TestMongoStore(t *testing.T) {
+	s := NewMongoStore(mongoTestDB(t))
+	user := &User{ID: primitive.NewObjectID(), Email: "john" + randomString(20) + "@doe.com"}
+	err := s.Transaction(func(tx Store) error {
+		return tx.InsertUser(user)
+	})
+	assert.NoError(t, err, "user insertion failed")
+
+	fetched, err := s.FindUserByEmail(user.Email)
+	assert.NoError(t, err, "user fetch failed")
+	assert.Equal(t, user.ID, fetched.ID, "same user should be fetched")
+
+	_, err = s.FindUserByID(primitive.NewObjectID())
+	assert.Equal(t, datastore.ErrNoDocFound, err, "non-existent user")
+}
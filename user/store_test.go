@@ -0,0 +1,26 @@
+package user
+
+import (
+	"gibber/datastore"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// TestMain runs the package tests on the in-memory store, unless mongo store is selected through GIBBER_STORE
+func TestMain(m *testing.M) {
+	if os.Getenv("GIBBER_STORE") == MongoStore {
+		SetStore(NewMongoStore(datastore.MongoConn()))
+	}
+	os.Exit(m.Run())
+}
+
+func TestNewStore(t *testing.T) {
+	s, err := NewStore(MemoryStore)
+	assert.NoError(t, err, "memory store creation failed")
+	assert.NotNil(t, s, "memory store should be created")
+
+	s, err = NewStore("unknown")
+	assert.Error(t, err, "unknown store backend should fail")
+	assert.Nil(t, s, "no store should be created for unknown backend")
+}
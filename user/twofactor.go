@@ -0,0 +1,284 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// user document field of the two-factor authentication
+const twoFactorField = "two_factor"
+
+// TOTP settings (RFC 6238), the defaults of the authenticator apps
+const (
+	totpIssuer        = "gibber"
+	totpSecretSize    = 20 // bytes, as the HMAC-SHA1 key
+	totpDigits        = 6
+	totpPeriod        = 30 // seconds
+	totpSkew          = 1  // periods accepted before and after the current one, for the clocks drifting apart
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 5 // bytes, 10 hex characters
+)
+
+// two-factor authentication errors
+var (
+	ErrSecondFactorRequired = errors.New("two-factor code required")
+	ErrInvalidTOTPCode      = errors.New("invalid two-factor code")
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorDisabled    = errors.New("two-factor authentication not enabled")
+	errNoPasswordChecked    = errors.New("password not checked before the two-factor code")
+)
+
+// TwoFactor is the TOTP two-factor authentication of a user. The secret is pending till a first code confirms
+// it, and only the hashes of the recovery codes are stored.
+type TwoFactor struct {
+	Secret        string   `bson:"secret" json:"-"` // base32 encoded
+	Enabled       bool     `bson:"enabled" json:"enabled"`
+	RecoveryCodes []string `bson:"recovery_codes" json:"-"` // single-use codes left, in place of a TOTP code
+	LastStep      int64    `bson:"last_step" json:"-"`      // of the last TOTP code used, which can't be used again
+}
+
+// EnrollTOTP generates a new TOTP secret for the current user, giving the otpauth URI to add it to an
+// authenticator app. The two-factor authentication is enabled once ConfirmTOTP gets a code generated with it.
+func (u *User) EnrollTOTP() (uri string, err error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err = rand.Read(secret); err != nil {
+		u.logger().Errorf("generating TOTP secret for %s failed: %s", u.Email, err)
+		return
+	}
+	twoFactor := TwoFactor{Secret: base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)}
+	err = store.Transaction(func(tx Store) error {
+		usr, er := tx.FindUserByID(u.ID)
+		if er != nil {
+			return er
+		}
+		if usr.TwoFactor.Enabled {
+			return ErrTwoFactorEnabled
+		}
+		return tx.UpdateUser(u.ID, bson.M{twoFactorField: twoFactor})
+	})
+	if err != nil {
+		u.logger().Warnf("TOTP enrollment of %s failed: %s", u.Email, err)
+		return
+	}
+	u.logger().Infof("TOTP secret generated for %s", u.Email)
+	label := url.PathEscape(totpIssuer + ":" + u.Email)
+	params := url.Values{
+		"secret":    {twoFactor.Secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode()), nil
+}
+
+// ConfirmTOTP enables the two-factor authentication of the current user, once he enters a code generated with the
+// secret given by EnrollTOTP. It gives the recovery codes, each to be used once in place of a code, which are not
+// to be seen again.
+func (u *User) ConfirmTOTP(code string) (recoveryCodes []string, err error) {
+	recoveryCodes = make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		b := make([]byte, recoveryCodeSize)
+		if _, err = rand.Read(b); err != nil {
+			u.logger().Errorf("generating recovery codes for %s failed: %s", u.Email, err)
+			return nil, err
+		}
+		raw := hex.EncodeToString(b)
+		recoveryCodes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashToken(raw)
+	}
+	valid := false
+	err = store.Transaction(func(tx Store) error {
+		usr, er := tx.FindUserByID(u.ID)
+		if er != nil {
+			return er
+		}
+		if usr.TwoFactor.Enabled {
+			return ErrTwoFactorEnabled
+		}
+		if usr.TwoFactor.Secret == "" {
+			return ErrTwoFactorDisabled // not enrolled
+		}
+		step, ok := usr.TwoFactor.verifyTOTP(code, time.Now())
+		if !ok {
+			return nil
+		}
+		valid = true
+		return tx.UpdateUser(u.ID, bson.M{twoFactorField: TwoFactor{Secret: usr.TwoFactor.Secret, Enabled: true,
+			RecoveryCodes: hashes, LastStep: step}})
+	})
+	if err == nil && !valid {
+		err = ErrInvalidTOTPCode
+	}
+	if err != nil {
+		u.logger().Warnf("TOTP confirmation of %s failed: %s", u.Email, err)
+		return nil, err
+	}
+	u.TwoFactor.Enabled = true
+	u.logger().Infof("two-factor authentication enabled for %s", u.Email)
+	return
+}
+
+// LoginSecondFactor completes the login of the current user, whose password LoginUser checked before failing with
+// ErrSecondFactorRequired, with a TOTP code or a recovery code. It returns the last login time of the user. A
+// wrong code counts as a failed login.
+func (u *User) LoginSecondFactor(code string) (lastLoginTime string, err error) {
+	defer func() {
+		if err != nil {
+			loginsTotal.Inc(loginFailed)
+		} else {
+			loginsTotal.Inc(loginSucceeded)
+		}
+	}()
+	if !u.passwordChecked {
+		return "", errNoPasswordChecked
+	}
+	now := time.Now().UTC()
+	var fetchDBUser *User
+	err = store.Transaction(func(tx Store) error {
+		usr, er := tx.FindUserByID(u.ID)
+		if er != nil {
+			return er
+		}
+		if er = usr.LoginFailures.Check(now); er != nil {
+			return er
+		}
+		twoFactor, ok := usr.TwoFactor.verify(code, now)
+		if !ok {
+			return nil
+		}
+		fetchDBUser = usr
+		return tx.UpdateUser(u.ID, bson.M{twoFactorField: twoFactor, lastLogin: now, lastSeenField: now,
+			loginFailuresField: LoginFailures{}})
+	})
+	if err != nil {
+		u.logger().Warnf("two-factor login of %s failed: %s", u.Email, err)
+		return
+	}
+	if fetchDBUser == nil {
+		u.logger().Warnf("user %s entered incorrect two-factor code", u.Email)
+		u.failLogin(now)
+		return "", ErrInvalidTOTPCode
+	}
+	u.passwordChecked = false
+	return u.loggedIn(fetchDBUser), nil
+}
+
+// DisableTOTP disables the two-factor authentication of the current user, once he re-authenticates with his
+// password and a TOTP code or a recovery code. A wrong password fails with bcrypt.ErrMismatchedHashAndPassword and
+// a wrong code with ErrInvalidTOTPCode, both counting as failed logins.
+func (u *User) DisableTOTP(password, code string) (err error) {
+	now := time.Now().UTC()
+	var failure error
+	err = store.Transaction(func(tx Store) error {
+		usr, er := tx.FindUserByID(u.ID)
+		if er != nil {
+			return er
+		}
+		if er = usr.LoginFailures.Check(now); er != nil {
+			return er
+		}
+		if !usr.TwoFactor.Enabled {
+			return ErrTwoFactorDisabled
+		}
+		if failure = bcrypt.CompareHashAndPassword([]byte(usr.Password), []byte(password)); failure != nil {
+			return nil
+		}
+		if _, ok := usr.TwoFactor.verify(code, now); !ok {
+			failure = ErrInvalidTOTPCode
+			return nil
+		}
+		return tx.UpdateUser(u.ID, bson.M{twoFactorField: TwoFactor{}})
+	})
+	if err != nil {
+		u.logger().Warnf("disabling two-factor authentication of %s failed: %s", u.Email, err)
+		return
+	}
+	if failure != nil {
+		u.logger().Warnf("user %s failed to re-authenticate to disable two-factor authentication: %s", u.Email,
+			failure)
+		u.failLogin(now)
+		return failure
+	}
+	u.TwoFactor = TwoFactor{}
+	u.logger().Infof("two-factor authentication disabled for %s", u.Email)
+	return
+}
+
+// verify checks the given TOTP code or recovery code, giving the two-factor authentication updated to not accept
+// it again
+func (t TwoFactor) verify(code string, now time.Time) (updated TwoFactor, ok bool) {
+	if !t.Enabled {
+		return t, false
+	}
+	if step, ok := t.verifyTOTP(code, now); ok {
+		t.LastStep = step
+		return t, true
+	}
+	hash := hashToken(strings.ReplaceAll(strings.ToLower(strings.TrimSpace(code)), "-", ""))
+	for i, recoveryCode := range t.RecoveryCodes {
+		if hmac.Equal([]byte(recoveryCode), []byte(hash)) {
+			t.RecoveryCodes = append(t.RecoveryCodes[:i:i], t.RecoveryCodes[i+1:]...)
+			return t, true
+		}
+	}
+	return t, false
+}
+
+// verifyTOTP checks the given TOTP code at the given time, giving the time step it was generated for. A code of a
+// step not after the last one used is refused, for a code not to be replayed.
+func (t TwoFactor) verifyTOTP(code string, now time.Time) (step int64, ok bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step = current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= t.LastStep {
+			continue
+		}
+		expected, err := totpCode(t.Secret, step)
+		if err == nil && hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPCode gives the TOTP code of the given base32 encoded secret at the given time, as an authenticator app shows
+// it
+func TOTPCode(secret string, at time.Time) (string, error) {
+	return totpCode(secret, at.Unix()/totpPeriod)
+}
+
+// totpCode generates the TOTP code of the given base32 encoded secret for the given time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo), nil
+}
@@ -0,0 +1,82 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// enrollTestTOTP enrolls the given user in two-factor authentication, giving his TOTP secret
+func enrollTestTOTP(t *testing.T, usr *User) string {
+	uri, err := usr.EnrollTOTP()
+	assert.NoError(t, err, "TOTP enrollment failed")
+	parsed, err := url.Parse(uri)
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", parsed.Scheme)
+	assert.Equal(t, "totp", parsed.Host)
+	assert.Equal(t, totpIssuer, parsed.Query().Get("issuer"))
+	return parsed.Query().Get("secret")
+}
+
+func TestTOTPCode(t *testing.T) {
+	// the SHA1 test vectors of RFC 6238, for the 6 last digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	for at, code := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924",
+		20000000000: "353130"} {
+		generated, err := TOTPCode(secret, time.Unix(at, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, code, generated, "code at %d", at)
+	}
+}
+
+func TestUser_TwoFactor(t *testing.T) {
+	defaults := loginThrottle
+	defer SetLoginThrottle(defaults)
+	SetLoginThrottle(LoginThrottle{MaxFailures: 10, Window: time.Hour, Lockout: time.Hour})
+	usr, _ := createGroupTestUsers(t, 0)
+	_, err := usr.ConfirmTOTP("123456")
+	assert.Equal(t, ErrTwoFactorDisabled, err, "not enrolled yet")
+	secret := enrollTestTOTP(t, usr)
+	_, err = usr.ConfirmTOTP("000000")
+	assert.Equal(t, ErrInvalidTOTPCode, err)
+	now := time.Now()
+	code, _ := TOTPCode(secret, now)
+	recoveryCodes, err := usr.ConfirmTOTP(code)
+	assert.NoError(t, err, "TOTP confirmation failed")
+	assert.Equal(t, recoveryCodeCount, len(recoveryCodes))
+	_, err = usr.EnrollTOTP()
+	assert.Equal(t, ErrTwoFactorEnabled, err)
+
+	login := &User{Email: usr.Email}
+	_, err = login.LoginSecondFactor(code)
+	assert.Equal(t, errNoPasswordChecked, err, "the password is checked first")
+	_, err = login.LoginUser("password")
+	assert.Equal(t, ErrSecondFactorRequired, err)
+	_, err = login.LoginSecondFactor(code)
+	assert.Equal(t, ErrInvalidTOTPCode, err, "a code can't be used twice")
+	next, _ := TOTPCode(secret, now.Add(totpPeriod*time.Second))
+	_, err = login.LoginSecondFactor(next)
+	assert.NoError(t, err, "two-factor login failed")
+	assert.Equal(t, usr.ID, login.ID)
+
+	login = &User{Email: usr.Email}
+	_, err = login.LoginUser("password")
+	assert.Equal(t, ErrSecondFactorRequired, err)
+	_, err = login.LoginSecondFactor(recoveryCodes[0])
+	assert.NoError(t, err, "login with a recovery code failed")
+	_, err = login.LoginUser("password")
+	assert.Equal(t, ErrSecondFactorRequired, err)
+	_, err = login.LoginSecondFactor(recoveryCodes[0])
+	assert.Equal(t, ErrInvalidTOTPCode, err, "a recovery code can be used once")
+
+	assert.Equal(t, bcrypt.ErrMismatchedHashAndPassword, usr.DisableTOTP("wrong", recoveryCodes[1]))
+	assert.Equal(t, ErrInvalidTOTPCode, usr.DisableTOTP("password", "000000"))
+	stored, _ := GetUserByID(usr.ID)
+	assert.Equal(t, 3, stored.LoginFailures.Count, "the wrong codes and password count as failed logins")
+	assert.NoError(t, usr.DisableTOTP("password", recoveryCodes[1]), "disabling two-factor authentication failed")
+	assert.Equal(t, ErrTwoFactorDisabled, usr.DisableTOTP("password", recoveryCodes[2]))
+	_, err = (&User{Email: usr.Email}).LoginUser("password")
+	assert.NoError(t, err, "the password is enough once disabled")
+}
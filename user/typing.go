@@ -0,0 +1,40 @@
+package user
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// NotifyTyping tells the given friend, live, that the current user started (or stopped) typing a message to him.
+// Nothing is stored, so a friend not connected is told nothing.
+func (u *User) NotifyTyping(friendID primitive.ObjectID, typing bool) {
+	publisher.Publish(friendID, u.typingEvent(primitive.NilObjectID, typing))
+}
+
+// NotifyGroupTyping tells the other members of the given group (but the ones who blocked him), live, that the
+// current user started (or stopped) typing a message to the group
+func (u *User) NotifyGroupTyping(groupID primitive.ObjectID, typing bool) (err error) {
+	group, err := store.FindGroup(groupID)
+	if err != nil {
+		u.logger().Errorf("fetching group %s failed: %s", groupID.Hex(), err)
+		return
+	}
+	if group.member(u.ID) == nil {
+		return ErrNotGroupMember
+	}
+	event := u.typingEvent(groupID, typing)
+	for _, memberID := range u.notBlocking(group.otherMembers(u.ID)) {
+		publisher.Publish(memberID, event)
+	}
+	return
+}
+
+// typingEvent gives the event telling that the current user started (or stopped) typing in the given group, or
+// in a chat if no group is given
+func (u *User) typingEvent(groupID primitive.ObjectID, typing bool) Event {
+	event := Event{Type: TypingEvent, Group: groupID, From: u.ID, FromName: u.FirstName, Timestamp: time.Now().UTC()}
+	if !typing {
+		event.Type = TypingStoppedEvent
+	}
+	return event
+}
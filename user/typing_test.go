@@ -0,0 +1,47 @@
+package user
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"testing"
+)
+
+func TestUser_NotifyTyping(t *testing.T) {
+	p, restore := recordEvents()
+	defer restore()
+	sender := &User{ID: primitive.NewObjectID(), FirstName: "John"}
+	receiver := primitive.NewObjectID()
+
+	sender.NotifyTyping(receiver, true)
+	sender.NotifyTyping(receiver, false)
+	if assert.Equal(t, 2, len(p.events[receiver]), "typing should be published to receiver") {
+		assert.Equal(t, TypingEvent, p.events[receiver][0].Type)
+		assert.Equal(t, sender.ID, p.events[receiver][0].From)
+		assert.Equal(t, "John", p.events[receiver][0].FromName)
+		assert.True(t, p.events[receiver][0].Group.IsZero())
+		assert.Equal(t, TypingStoppedEvent, p.events[receiver][1].Type)
+	}
+	assert.Equal(t, 0, len(p.events[sender.ID]), "nothing should be published to sender")
+}
+
+func TestUser_NotifyGroupTyping(t *testing.T) {
+	owner, friends := createGroupTestUsers(t, 2)
+	group, err := owner.CreateGroup("typists", []primitive.ObjectID{friends[0].ID, friends[1].ID})
+	assert.NoError(t, err, "creating group failed")
+
+	p, restore := recordEvents()
+	defer restore()
+	assert.NoError(t, friends[0].NotifyGroupTyping(group.ID, true))
+	for _, member := range []*User{owner, friends[1]} {
+		if assert.Equal(t, 1, len(p.events[member.ID]), "typing should be published to other members") {
+			assert.Equal(t, TypingEvent, p.events[member.ID][0].Type)
+			assert.Equal(t, group.ID, p.events[member.ID][0].Group)
+			assert.Equal(t, friends[0].FirstName, p.events[member.ID][0].FromName)
+		}
+	}
+	assert.Equal(t, 0, len(p.events[friends[0].ID]), "nothing should be published to the typist")
+
+	stranger := &User{ID: primitive.NewObjectID()}
+	assert.Equal(t, ErrNotGroupMember, stranger.NotifyGroupTyping(group.ID, true))
+	assert.Error(t, owner.NotifyGroupTyping(primitive.NewObjectID(), true), "unknown group")
+}
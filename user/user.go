@@ -1,15 +1,12 @@
 package user
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"gibber/datastore"
 	"gibber/log"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 	"regexp"
 	"time"
@@ -21,7 +18,6 @@ const (
 	userFirstNameField = "first_name"
 	userLastNameField  = "last_name"
 	userEmailField     = "email"
-	userLoggedIn       = "logged_in"
 	lastLogin          = "last_login"
 	userPasswordField  = "password"
 	invitesDataField   = "invites_data_id"
@@ -49,22 +45,28 @@ type inviteType string
 
 // User captures the details about a client connected to the service
 type User struct {
-	ID        primitive.ObjectID `bson:"_id" json:"-"`
-	FirstName string             `bson:"first_name" json:"first_name"`
-	LastName  string             `bson:"last_name" json:"last_name"`
-	Email     string             `bson:"email" json:"email"`
-	Password  string             `bson:"password" json:"password"` // hashed
-	LastLogin time.Time          `bson:"last_login" json:"last_login"`
-	LoggedIn  bool               `bson:"logged_in" json:"logged_in"`             // depicts if the user is currently logged in
-	InvitesId primitive.ObjectID `bson:"invites_data_id" json:"invites_data_id"` // object ID of invitesData
+	ID            primitive.ObjectID `bson:"_id" json:"-"`
+	FirstName     string             `bson:"first_name" json:"first_name"`
+	LastName      string             `bson:"last_name" json:"last_name"`
+	Email         string             `bson:"email" json:"email"`
+	Password      string             `bson:"password" json:"password"` // hashed
+	LastLogin     time.Time          `bson:"last_login" json:"last_login"`
+	LastSeen      time.Time          `bson:"last_seen" json:"last_seen"`             // as a session last started or ended
+	InvitesId     primitive.ObjectID `bson:"invites_data_id" json:"invites_data_id"` // object ID of invitesData
+	LoginFailures LoginFailures      `bson:"login_failures" json:"-"`                // recent failed logins to the account
+	Unverified    bool               `bson:"unverified" json:"unverified"`           // till the email is verified
+	Verification  Verification       `bson:"verification" json:"-"`                  // code sent to verify the email
+	TwoFactor     TwoFactor          `bson:"two_factor" json:"two_factor"`           // TOTP, if enrolled
+
+	log             *log.Log // of the session acting as the user, the default logger if nil
+	passwordChecked bool     // by LoginUser, the login waiting for the two-factor code
 }
 
 // CreateUser create a new user with given user details
 func CreateUser(user *User) (userId interface{}, err error) {
-	var fetchUser *User
 	if user.existingUser() {
-		reason := fmt.Sprintf("user %#v already exist with email %s", fetchUser, user.Email) // passed email userId should be unique
-		log.Logger().Printf(reason)
+		reason := fmt.Sprintf("user already exist with email %s", user.Email) // passed email userId should be unique
+		log.Logger().Warnf("%s", reason)
 		err = errors.New(reason)
 		return
 	}
@@ -72,478 +74,270 @@ func CreateUser(user *User) (userId interface{}, err error) {
 	if err != nil {
 		return
 	}
+	user.ID = primitive.NewObjectID()
 	user.Password = string(hashedPassword)
-	user.LoggedIn = true // as user is just created, he becomes online, until he quits the session
-	userMap, _ := getMap(user)
-	userMap["last_login"] = time.Now().UTC()
+	user.LastLogin = time.Now().UTC()
+	user.LastSeen = user.LastLogin
 
-	session, err := datastore.MongoConn().Client().StartSession()
-	if err != nil {
-		log.Logger().Printf("initializing mongo session failed: %s", err)
-		return
-	}
-
-	err = session.StartTransaction()
-	if err != nil {
-		log.Logger().Printf("initializing mongo transaction failed: %s", err)
-		return
-	}
-
-	err = mongo.WithSession(context.Background(), session, func(sc mongo.SessionContext) (er error) {
+	err = store.Transaction(func(tx Store) (er error) {
 		// create user
-		res, er := datastore.MongoConn().Collection(userCollection).InsertOne(sc, userMap)
-		if er != nil {
-			_ = session.AbortTransaction(sc)
-			er = fmt.Errorf("error while creating new user %#v: %s", userMap, er)
-			log.Logger().Print(er)
+		if er = tx.InsertUser(user); er != nil {
+			er = fmt.Errorf("error while creating new user %s: %s", user.Email, er)
+			log.Logger().Errorf("%s", er)
 			return
 		}
-
-		userId = res.InsertedID
-		user.ID = res.InsertedID.(primitive.ObjectID)
-		log.Logger().Printf("user %#v successfully created with userId: %v", userMap, res)
+		log.Logger().Infof("user %s successfully created with userId: %s", user.Email, user.ID.Hex())
 
 		// create user_invite
-		var invitesId primitive.ObjectID
-		invitesDataId, er := createUserInvitesData(sc, userId, datastore.MongoConn().Collection(userInvitesCollection))
-		invitesId = invitesDataId.(primitive.ObjectID)
+		invitesId, er := tx.CreateInvites(user.ID)
 		if er != nil {
-			_ = session.AbortTransaction(sc)
-			log.Logger().Printf("error creating user invite: %s", er)
-			return er
+			log.Logger().Errorf("error creating user invite: %s", er)
+			return
 		}
 
 		// update invite_user doc ID in user's doc
-		updateRes, er := datastore.MongoConn().Collection(userCollection).UpdateOne(
-			sc,
-			bson.M{datastore.ObjectID: userId},
-			bson.D{{
-				Key: datastore.MongoSetOperator, Value: bson.D{{Key: invitesDataField, Value: invitesId}},
-			}})
-		if er != nil || updateRes.ModifiedCount != 1 {
-			_ = session.AbortTransaction(sc)
-			log.Logger().Printf("error while setting up invites data for user%s: %s", userId, err)
-			err = datastore.ErrNoDocUpdate
-			return er
-		}
-
-		// commit transaction
-		if er := session.CommitTransaction(sc); er != nil {
-			log.Logger().Printf("committing mongo transaction failed: %s", er)
-			er = session.AbortTransaction(sc)
-			if er != nil {
-				log.Logger().Printf("aborting mongo transaction failed: %s", er)
-			}
+		if er = tx.UpdateUser(user.ID, bson.M{invitesDataField: invitesId}); er != nil {
+			log.Logger().Errorf("error while setting up invites data for user %s: %s", user.ID.Hex(), er)
+			return
 		}
-		return nil
+		user.InvitesId = invitesId
+		return
 	})
-
-	session.EndSession(context.Background())
+	if err != nil {
+		user.ID = primitive.NilObjectID
+		return
+	}
+	registrationsTotal.Inc()
+	userId = user.ID
 	return
 }
 
 // GetUserByEmail gets the details of a user by email
 func GetUserByEmail(email string) (user *User, err error) {
-	collection := datastore.MongoConn().Collection(userCollection)
-	user = &User{}
-	err = collection.FindOne(context.Background(), bson.M{userEmailField: email}).Decode(user)
-	if err == mongo.ErrNoDocuments {
-		log.Logger().Printf("no user found with email: %s", email)
+	user, err = store.FindUserByEmail(email)
+	if err == datastore.ErrNoDocFound {
+		log.Logger().Warnf("no user found with email: %s", email)
 		// no changes in error so that it can be used to verify unique email ID before insertion
 	} else if err != nil {
-		log.Logger().Printf("decoding(unmarshal) user fetch result for email %s failed: %s", email, err)
+		log.Logger().Errorf("user fetch for email %s failed: %s", email, err)
 		err = errFetchUser
 	}
+	if err != nil {
+		user = &User{}
+	}
 	return
 }
 
 // GetUserByID gets the details of a user by ID (object ID)
 func GetUserByID(objectID primitive.ObjectID) (user *User, err error) {
-	user = &User{}
-	err = datastore.MongoConn().
-		Collection(userCollection).
-		FindOne(context.Background(), bson.M{datastore.ObjectID: objectID}).
-		Decode(user)
+	user, err = store.FindUserByID(objectID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			log.Logger().Printf("no user found with ID: %s", objectID.String())
+		if err == datastore.ErrNoDocFound {
+			log.Logger().Warnf("no user found with ID: %s", objectID.String())
 		} else {
-			log.Logger().Printf("decoding(unmarshal) user fetch result for email %s failed: %s", objectID.String(), err)
+			log.Logger().Errorf("user fetch for ID %s failed: %s", objectID.String(), err)
 		}
+		user = &User{}
 	}
 	return
 }
 
 // LoginUser logs in a given user with the given password. In case of successful login, it returns
 // the last login time of the user. In case of password mismatch or any other issue, an error will be raised.
+// While the account is locked out after too many failed logins, it fails with a LockedError without checking the
+// password. For a user with two-factor authentication, it fails with ErrSecondFactorRequired once the password is
+// checked, the login being completed by LoginSecondFactor.
 func (u *User) LoginUser(password string) (lastLoginTime string, err error) {
+	defer func() {
+		if err == ErrSecondFactorRequired {
+			return // neither failed nor succeeded yet
+		} else if err != nil {
+			loginsTotal.Inc(loginFailed)
+		} else {
+			loginsTotal.Inc(loginSucceeded)
+		}
+	}()
 	fetchDBUser, err := GetUserByEmail(u.Email)
 	if err != nil {
-		log.Logger().Printf("authenticate u failed: %s", err)
+		u.logger().Warnf("authenticate u failed: %s", err)
+		return
+	}
+	now := time.Now().UTC()
+	if err = fetchDBUser.LoginFailures.Check(now); err != nil {
+		u.logger().Warnf("login of %s refused: %s", u.Email, err)
 		return
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(fetchDBUser.Password), []byte(password))
 	if err != nil {
-		log.Logger().Print(err)
+		u.logger().Warnf("user %s entered incorrect password: %s", u.Email, err)
+		u.failLogin(now)
 		return
 	}
+	if fetchDBUser.TwoFactor.Enabled {
+		u.ID = fetchDBUser.ID
+		u.Email = fetchDBUser.Email
+		u.passwordChecked = true
+		return "", ErrSecondFactorRequired
+	}
 
-	result, err := datastore.MongoConn().Collection(userCollection).UpdateOne(
-		context.Background(),
-		bson.D{
-			{
-				Key:   userEmailField,
-				Value: u.Email,
-			},
-		},
-		bson.D{
-			{
-				Key: datastore.MongoSetOperator,
-				Value: bson.D{
-					{
-						Key:   userLoggedIn,
-						Value: true,
-					},
-					{
-						Key:   lastLogin,
-						Value: time.Now().UTC(),
-					},
-				},
-			},
-		},
-	)
+	err = store.UpdateUser(fetchDBUser.ID, bson.M{lastLogin: now, lastSeenField: now, loginFailuresField: LoginFailures{}})
 	if err != nil {
-		log.Logger().Printf("error while logging out u %s: %s", u.Email, err)
-		return
-	} else if result.MatchedCount != 1 {
-		log.Logger().Printf("error while logging out %s as no doc updated for", u.Email)
-		err = datastore.ErrNoDocUpdate
+		u.logger().Errorf("error while logging in u %s: %s", u.Email, err)
 		return
 	}
+	return u.loggedIn(fetchDBUser), nil
+}
 
+// loggedIn loads the details of the user logged in, as read before the login, giving his last login time
+func (u *User) loggedIn(fetchDBUser *User) (lastLoginTime string) {
 	u.ID = fetchDBUser.ID
 	u.FirstName = fetchDBUser.FirstName
 	u.LastName = fetchDBUser.LastName
 	u.Email = fetchDBUser.Email
 	u.Password = fetchDBUser.Password
 	u.LastLogin = fetchDBUser.LastLogin
-	u.LoggedIn = fetchDBUser.LoggedIn
+	u.LastSeen = fetchDBUser.LastSeen
 	u.InvitesId = fetchDBUser.InvitesId
-	lastLoginTime = fetchDBUser.LastLogin.Format(time.RFC3339)
-	return
+	u.Unverified = fetchDBUser.Unverified
+	u.TwoFactor = fetchDBUser.TwoFactor
+	return fetchDBUser.LastLogin.Format(time.RFC3339)
 }
 
-// UpdatePassword updates the password for the current user
-func (u *User) UpdatePassword(newEncryptedPassword string) (err error) {
-	result, err := datastore.MongoConn().Collection(userCollection).UpdateOne(
-		context.Background(),
-		bson.D{
-			{
-				Key:   userEmailField,
-				Value: u.Email,
-			},
-		},
-		bson.D{
-			{
-				Key: datastore.MongoSetOperator,
-				Value: bson.D{
-					{
-						Key:   userPasswordField,
-						Value: newEncryptedPassword,
-					},
-				},
-			},
-		})
+// UpdatePassword updates the password for the current user, and revokes all his sessions but the given one (that
+// changing it), so that whoever knew the former password is logged out
+func (u *User) UpdatePassword(newEncryptedPassword string, keepSessionID primitive.ObjectID) (err error) {
+	err = store.Transaction(func(tx Store) (er error) {
+		if er = tx.UpdateUser(u.ID, bson.M{userPasswordField: newEncryptedPassword}); er != nil {
+			return
+		}
+		return revokeSessionsBut(tx, u.ID, keepSessionID)
+	})
 	if err != nil {
-		log.Logger().Printf("password update failed for u %s: %s", u.Email, err)
-	} else {
-		log.Logger().Printf("password update successful for u %s: %+v", u.Email, result)
+		u.logger().Errorf("password update failed for u %s: %s", u.Email, err)
+		return
 	}
+	u.logger().Infof("password update successful for u %s", u.Email)
+	u.Password = newEncryptedPassword
 	return
 }
 
 // UpdateName updates the first and/or last name of the given user
 func (u *User) UpdateName(firstName, lastName string) (err error) {
-	var updatedDoc bson.D
-	if firstName != "" && lastName != "" {
-		updatedDoc = bson.D{
-			{
-				Key:   userFirstNameField,
-				Value: firstName,
-			},
-			{
-				Key:   userLastNameField,
-				Value: lastName,
-			},
-		}
-	} else if firstName != "" {
-		updatedDoc = bson.D{
-			{
-				Key:   userFirstNameField,
-				Value: firstName,
-			},
-		}
-	} else if lastName != "" {
-		updatedDoc = bson.D{
-			{
-				Key:   userLastNameField,
-				Value: lastName,
-			},
-		}
-	} else { // nothing to update
-		log.Logger().Println("nothing to update as both firstName and lastName are blank")
-		return
+	updatedDoc := bson.M{}
+	if firstName != "" {
+		updatedDoc[userFirstNameField] = firstName
 	}
-	result, err := datastore.MongoConn().Collection(userCollection).UpdateOne(
-		context.Background(),
-		bson.D{
-			{
-				Key:   userEmailField,
-				Value: u.Email,
-			},
-		},
-		bson.D{{Key: datastore.MongoSetOperator, Value: updatedDoc}},
-	)
-	if err != nil {
-		log.Logger().Printf("name update failed for u %s: %s", u.Email, err)
-	} else {
-		log.Logger().Printf("name update successful for u %s: %+v", u.Email, result)
+	if lastName != "" {
+		updatedDoc[userLastNameField] = lastName
 	}
-	return
-}
-
-// SeeOnlineFriends fetches the details of the users which are currently online
-func (u *User) SeeOnlineFriends() (onlineFriends []string, err error) {
-	//fetchedUser := &User{}
-	//MongoConn().Collection(userCollection).FindOne(
-	//	context.Background(),
-	//	bson.M{
-	//		userEmailField: u.Email,
-	//	}).Decode(fetchedUser)
-	//_, err = GetUserInvitesData(fetchedUser.InvitesId)
-	//if err != nil {
-	//	return
-	//}
-	//friendEmails, err := GetAcceptedInvitations(u.Email)
-	//if err != nil {
-	//	reason := fmt.Sprintf("error while fetching u %s accepted invitations: %s", u.Email, err)
-	//	Logger().Print(reason)
-	//}
-	//onlineFriends = make([]string, 5)
-	//for _, acceptedInvite := range friendEmails {
-	//	friend := &User{}
-	//	if acceptedInvite.Sender != u.Email {
-	//		MongoConn().Collection(userCollection).FindOne(
-	//			context.Background(),
-	//			bson.M{
-	//				userEmailField: acceptedInvite.Sender,
-	//				userLoggedIn:   true,
-	//			}).Decode(friend)
-	//	}
-	//	if friend.Password != "" { // a u is found
-	//		onlineFriends = append(onlineFriends, fmt.Sprintf("%s %s: %s", friend.FirstName, friend.LastName,
-	//			friend.Email))
-	//	}
-	//}
-	return
-}
-
-// Logout logs out the current user from the service
-// TODO: avoid multiple login for a single user
-func (u *User) Logout() (err error) {
-	result, err := datastore.MongoConn().Collection(userCollection).UpdateOne(
-		context.Background(),
-		bson.D{
-			{Key: userEmailField, Value: u.Email},
-		},
-		bson.D{
-			{Key: datastore.MongoSetOperator, Value: bson.D{{Key: userLoggedIn, Value: false}}},
-		},
-	)
-	if err != nil {
-		log.Logger().Printf("error while logging out u %s: %s", u.Email, err)
+	if len(updatedDoc) == 0 { // nothing to update
+		u.logger().Debugf("nothing to update as both firstName and lastName are blank")
 		return
-	} else if result.ModifiedCount != 1 {
-		log.Logger().Printf("error while logging out u %s", u.Email)
-		err = datastore.ErrNoDocUpdate
+	}
+	err = store.UpdateUser(u.ID, updatedDoc)
+	if err != nil {
+		u.logger().Errorf("name update failed for u %s: %s", u.Email, err)
 		return
 	}
+	u.logger().Infof("name update successful for u %s", u.Email)
+	if firstName != "" {
+		u.FirstName = firstName
+	}
+	if lastName != "" {
+		u.LastName = lastName
+	}
 	return
 }
 
-// SendInvitation sends an invite to a given user
+// SendInvitation sends an invite to a given user. It fails if they are already friends, if any of them has
+// blocked the other one, or if any of them hasn't verified his email yet.
 func (u *User) SendInvitation(recv *User) (err error) {
-	session, err := datastore.MongoConn().Client().StartSession()
+	if u.Unverified || recv.Unverified {
+		return ErrNotVerified
+	}
+	blocked, err := eitherBlocked(u.ID, recv.ID)
 	if err != nil {
-		log.Logger().Printf("initializing mongo session failed: %s", err)
+		u.logger().Errorf("checking block b/w %s and %s failed: %s", u.Email, recv.Email, err)
 		return
 	}
-
-	err = session.StartTransaction()
+	if blocked {
+		return ErrBlocked
+	}
+	friends, err := isFriendOf(store, u.ID, recv.ID)
 	if err != nil {
-		log.Logger().Printf("initializing mongo transaction failed: %s", err)
+		u.logger().Errorf("checking friendship b/w %s and %s failed: %s", u.Email, recv.Email, err)
 		return
 	}
-
-	err = mongo.WithSession(context.Background(), session, func(sc mongo.SessionContext) (er error) {
-		result, er := datastore.MongoConn().Collection(userInvitesCollection).UpdateOne(
-			sc,
-			bson.D{
-				{Key: userIdField, Value: u.ID},
-			},
-			bson.D{
-				{Key: datastore.MongoPushOperator, Value: bson.D{{Key: string(sent), Value: recv.ID}}},
-			},
-		)
-		if er != nil {
-			_ = session.AbortTransaction(sc)
-			er = fmt.Errorf("sending invitation failed from %s to %s: %s", u.Email, recv.Email, err)
-			log.Logger().Println(er)
-		} else if result.ModifiedCount != 1 {
-			_ = session.AbortTransaction(sc)
-			log.Logger().Printf("sending invitation failed from %s to %s as no doc modified", u.Email, recv.Email)
-		}
-
-		result, er = datastore.MongoConn().Collection(userInvitesCollection).UpdateOne(
-			sc,
-			bson.D{
-				{Key: userIdField, Value: recv.ID},
-			},
-			bson.D{
-				{Key: datastore.MongoPushOperator, Value: bson.D{{Key: string(received), Value: u.ID}}},
-			},
-		)
-		if er != nil {
-			_ = session.AbortTransaction(sc)
-			er = fmt.Errorf("sending invitation failed from %s to %s: %s", u.Email, recv.Email, err)
-			log.Logger().Print(er)
-		} else if result.ModifiedCount != 1 {
-			_ = session.AbortTransaction(sc)
-			reason := fmt.Sprintf("sending invitation failed from %s to %s as no doc modified", u.Email, recv.Email)
-			log.Logger().Println(reason)
+	if friends {
+		return errAlreadyFriends
+	}
+	err = store.Transaction(func(tx Store) (er error) {
+		if er = tx.PushInvite(u.ID, sent, recv.ID); er != nil {
+			u.logger().Errorf("sending invitation failed from %s to %s: %s", u.Email, recv.Email, er)
+			return
 		}
-
-		// commit transaction
-		if er := session.CommitTransaction(sc); er != nil {
-			log.Logger().Printf("committing mongo transaction failed: %s", er)
-			er = session.AbortTransaction(sc)
-			if er != nil {
-				log.Logger().Printf("aborting mongo transaction failed: %s", er)
-			}
+		if er = tx.PushInvite(recv.ID, received, u.ID); er != nil {
+			u.logger().Errorf("receiving invitation failed from %s to %s: %s", u.Email, recv.Email, er)
 		}
 		return
 	})
+	if err == nil {
+		invitationsTotal.Inc(string(sent))
+	}
 	return
 }
 
 // AddFriend accepts the invite sent from a given user to the current user. After this action,
-// they become friends, and can start a chat (conversation)
+// they become friends, and can start a chat (conversation). Like inviting, it fails if the current user hasn't
+// verified his email yet.
 func (u *User) AddFriend(userID primitive.ObjectID) (err error) {
-	// Delete the received request from u's invite data (THINK OF SOFT DELETE) - Done
-	// Delete the sent request from u's invites data (THINK OF SOFT DELETE) - Done
-	// Add u as u's friend
-	// Add u as u's
-	session, err := datastore.MongoConn().Client().StartSession()
-	if err != nil {
-		log.Logger().Printf("initializing mongo session failed: %s", err)
-		return
-	}
-
-	err = session.StartTransaction()
-	if err != nil {
-		log.Logger().Printf("initializing mongo transaction failed: %s", err)
-		return
+	if u.Unverified {
+		return ErrNotVerified
 	}
-
-	err = mongo.WithSession(context.Background(), session, func(sc mongo.SessionContext) (er error) {
-		var res *mongo.UpdateResult
-		res, er = datastore.MongoConn().Collection(userInvitesCollection).UpdateOne(
-			sc,
-			bson.M{userIdField: u.ID},
-			bson.D{
-				{Key: datastore.MongoPullOperator, Value: bson.D{{Key: string(received), Value: userID}}},
-			})
-		if er != nil {
-			_ = session.AbortTransaction(sc) // ROLLBACK at the earliest to shorten transaction life-cycle
-			if er == mongo.ErrNoDocuments {
-				log.Logger().Printf("invite data not found for invite accepting u %s", u.ID.String())
-			} else {
-				log.Logger().Printf("invite data fetch failed for invite accepting u %s: %s", u.ID.String(), er)
-			}
-			return
-		} else if res.ModifiedCount != 1 {
-			log.Logger().Printf("invite data not updated for invite accepting u %s", u.ID.String())
-			er = datastore.ErrNoDocUpdate
+	err = store.Transaction(func(tx Store) (er error) {
+		if er = resolveInvitation(tx, userID, u.ID, accepted); er != nil {
+			u.logger().Infof("invite data not updated for invite accepting u %s: %s", u.ID.String(), er)
 			return
 		}
-
-		res, er = datastore.MongoConn().Collection(userInvitesCollection).UpdateOne(
-			sc,
-			bson.M{userIdField: userID},
-			bson.D{
-				{Key: datastore.MongoPullOperator, Value: bson.D{{Key: string(sent), Value: u.ID}}},
-			})
-		if er != nil {
-			_ = session.AbortTransaction(sc)
-			if er == mongo.ErrNoDocuments {
-				log.Logger().Printf("invite data not found for invite sending u %s", u.ID.String())
-			} else {
-				log.Logger().Printf("invite data fetch failed for invite sending u %s: %s", u.ID.String(), er)
-			}
-			return
-		} else if res.ModifiedCount != 1 {
-			log.Logger().Printf("invite data not updated for invite sending u %s", userID.String())
-			err = datastore.ErrNoDocUpdate
+		if er = tx.PushFriend(u.ID, userID); er != nil {
+			u.logger().Errorf("error while adding %s as friend for %s: %s", userID.String(), u.ID.String(), er)
 			return
 		}
-
-		// using upsert: true to create the friends document if non-existent
-		res, er = datastore.MongoConn().Collection(friendsCollection).UpdateOne(
-			sc,
-			bson.M{userIdField: u.ID},
-			bson.D{
-				{Key: datastore.MongoPushOperator, Value: bson.D{{Key: friendsField, Value: userID}}},
-			},
-			options.Update().SetUpsert(true))
-		if er != nil {
-			_ = session.AbortTransaction(sc) // ROLLBACK at the earliest to shorten transaction life-cycle
-			log.Logger().Printf("error while adding %s as friend for %s: %s", userID.String(), u.ID.String(), er)
-			return
-		} else if res.ModifiedCount+res.UpsertedCount != 1 {
-			log.Logger().Printf("document not created/updated to add %s as friend for %s", userID.String(), u.ID.String())
-			err = datastore.ErrNoDocUpdate
-			return
-		}
-
-		// using upsert: true to create the friends document if non-existent
-		res, err = datastore.MongoConn().Collection(friendsCollection).UpdateOne(
-			sc,
-			bson.M{userIdField: userID},
-			bson.D{
-				{Key: datastore.MongoPushOperator, Value: bson.D{{Key: friendsField, Value: u.ID}}},
-			},
-			options.Update().SetUpsert(true))
-		if err != nil {
-			_ = session.AbortTransaction(sc) // ROLLBACK at the earliest to shorten transaction life-cycle
-			log.Logger().Printf("error while adding %s as friend for %s: %s", u.ID.String(), userID.String(), err)
-			return
-		} else if res.ModifiedCount+res.UpsertedCount != 1 {
-			log.Logger().Printf("document not created/updated to add %s as friend for %s", u.ID.String(),
-				userID.String())
-			err = datastore.ErrNoDocUpdate
-			return
+		if er = tx.PushFriend(userID, u.ID); er != nil {
+			u.logger().Errorf("error while adding %s as friend for %s: %s", u.ID.String(), userID.String(), er)
 		}
+		return
+	})
+	if err == nil {
+		invitationsTotal.Inc(string(accepted))
+	}
+	return
+}
 
-		// commit transaction
-		if er := session.CommitTransaction(sc); er != nil {
-			log.Logger().Printf("committing mongo transaction failed: %s", er)
-			er = session.AbortTransaction(sc)
-			if er != nil {
-				log.Logger().Printf("aborting mongo transaction failed: %s", er)
-			}
-		}
+// RejectInvitation rejects the invite sent from a given user to the current user
+func (u *User) RejectInvitation(userID primitive.ObjectID) (err error) {
+	err = store.Transaction(func(tx Store) error {
+		return resolveInvitation(tx, userID, u.ID, rejected)
+	})
+	if err != nil {
+		u.logger().Errorf("rejecting invitation from %s by %s failed: %s", userID.Hex(), u.Email, err)
 		return
+	}
+	invitationsTotal.Inc(string(rejected))
+	return
+}
+
+// CancelInvitation cancels the invite sent from the current user to a given user
+func (u *User) CancelInvitation(user *User) (err error) {
+	err = store.Transaction(func(tx Store) error {
+		return resolveInvitation(tx, u.ID, user.ID, cancelled)
 	})
+	if err != nil {
+		u.logger().Errorf("cancelling invitation from %s to %s failed: %s", u.Email, user.Email, err)
+		return
+	}
+	u.logger().Infof("user %s invitation cancelled", user.Email)
+	invitationsTotal.Inc(string(cancelled))
 	return
 }
 
@@ -574,24 +368,26 @@ func (u *User) GetRejectedInvitations() ([]primitive.ObjectID, error) {
 	return u.getInvitations(rejected)
 }
 
-// CancelInvitation cancels the invite received from a given user
-func (u *User) CancelInvitation(user *User) error {
-	log.Logger().Printf("user %s invitation cancelled", user.Email)
-	return nil
+// GetInactiveSentInvitations gets the outcome of the invites sent to other users, which are accepted, rejected
+// or cancelled, the latest first
+func (u *User) GetInactiveSentInvitations() ([]InviteOutcome, error) {
+	return u.getInviteOutcomes(true)
+}
+
+// GetInactiveReceivedInvitations gets the outcome of the invites received from other users, which are accepted,
+// rejected or cancelled, the latest first
+func (u *User) GetInactiveReceivedInvitations() ([]InviteOutcome, error) {
+	return u.getInviteOutcomes(false)
 }
 
 // SeeFriends fetches the list of userIDs which are friends with current user
 func (u *User) SeeFriends() (frdns []primitive.ObjectID, err error) {
-	friendData := friends{}
-	err = datastore.MongoConn().Collection(friendsCollection).FindOne(
-		context.Background(),
-		bson.M{userIdField: u.ID}).
-		Decode(&friendData)
+	friendData, err := store.FindFriends(u.ID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			log.Logger().Printf("frdns data not found for u %s", u.ID.String())
+		if err == datastore.ErrNoDocFound {
+			u.logger().Warnf("frdns data not found for u %s", u.ID.String())
 		} else {
-			log.Logger().Printf("frdns data fetch failed for u %s: %s", u.ID.String(), err)
+			u.logger().Errorf("frdns data fetch failed for u %s: %s", u.ID.String(), err)
 		}
 		return
 	}
@@ -599,75 +395,96 @@ func (u *User) SeeFriends() (frdns []primitive.ObjectID, err error) {
 	return
 }
 
+// SetLogger sets the logger of the session acting as the user, tagging the entries logged on its behalf
+func (u *User) SetLogger(logger *log.Log) {
+	u.log = logger
+}
+
+// logger gives the logger of the session acting as the user
+func (u *User) logger() *log.Log {
+	if u.log == nil {
+		return log.Logger()
+	}
+	return u.log
+}
+
 // String representation of a user
 func (u *User) String() string {
 	return u.ID.String()
 }
 
-// GetChat fetches the chat b/w the current user and the given userID. It returns the actual
-// content as a formatted string, and the timestamp of the last message.
-func (u *User) GetChat(friendID primitive.ObjectID) (content string, timestamp time.Time) {
-	friend, _ := GetUserByID(friendID)
-	content = fmt.Sprintf("\n\n******************* chat: %s %s *****************\n\n",
-		friend.FirstName, friend.LastName) // TODO: Use buffers instead
-	chat, err := getChatByUserIDs(u.ID, friendID, datastore.MongoConn().Collection(chatCollection))
+// getInvitations fetches the invitation of a given type for the user
+func (u *User) getInvitations(invType inviteType) (invites []primitive.ObjectID, err error) {
+	invitesData, err := store.FindInvites(u.ID)
 	if err != nil {
-		log.Logger().Print(err)
-		return
-	}
-	for _, msg := range chat.Messages {
-		var sender string
-		if msg.Sender == u.ID {
-			sender = "You"
+		if err == datastore.ErrNoDocFound {
+			u.logger().Warnf("invite data not found for u %s", u.ID.String())
 		} else {
-			sender = friend.FirstName
+			u.logger().Errorf("invite data fetch failed for u %s: %s", u.ID.String(), err)
 		}
-		content += fmt.Sprintf(printMessage(msg, sender) + "\n")
-		timestamp = msg.Timestamp
+		return
+	}
+	list := invitesData.list(invType)
+	if list == nil {
+		err = errInvalidInviteType
+		return
 	}
+	invites = *list
 	return
 }
 
-// getInvitations fetches the invitation of a given type for the user
-func (u *User) getInvitations(invType inviteType) (invites []primitive.ObjectID, err error) {
-	invitesData := userInvites{}
-	err = datastore.MongoConn().Collection(userInvitesCollection).FindOne(
-		context.Background(),
-		bson.M{userIdField: u.ID}).
-		Decode(&invitesData)
+// getInviteOutcomes fetches the outcomes of the inactive invitations sent or received by the user, the latest first
+func (u *User) getInviteOutcomes(sent bool) (outcomes []InviteOutcome, err error) {
+	invitesData, err := store.FindInvites(u.ID)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			log.Logger().Printf("invite data not found for u %s", u.ID.String())
-		} else {
-			log.Logger().Printf("invite data fetch failed for u %s: %s", u.ID.String(), err)
-		}
+		u.logger().Errorf("invite data fetch failed for u %s: %s", u.ID.String(), err)
 		return
 	}
-	switch invType {
-	case sent:
-		invites = invitesData.Sent
-	case received:
-		invites = invitesData.Received
-	case accepted:
-		invites = invitesData.Accepted
-	case rejected:
-		invites = invitesData.Rejected
-	case cancelled:
-		invites = invitesData.Cancelled
-	default:
-		err = errInvalidInviteType
+	outcomes = make([]InviteOutcome, 0)
+	for i := len(invitesData.Outcomes) - 1; i >= 0; i-- {
+		if invitesData.Outcomes[i].Sent == sent {
+			outcomes = append(outcomes, invitesData.Outcomes[i])
+		}
 	}
 	return
 }
 
+// resolveInvitation makes the active invitation from the sender to the receiver inactive with the given status,
+// moving it to the list of that status for both of them. It fails if no such invitation is active.
+func resolveInvitation(tx Store, senderID, receiverID primitive.ObjectID, status inviteType) (err error) {
+	if err = tx.PullInvite(senderID, sent, receiverID); err != nil {
+		return
+	}
+	if err = tx.PullInvite(receiverID, received, senderID); err != nil {
+		return
+	}
+	if err = tx.PushInvite(senderID, status, receiverID); err != nil {
+		return
+	}
+	if err = tx.PushInvite(receiverID, status, senderID); err != nil {
+		return
+	}
+	outcome := InviteOutcome{
+		UserID:    receiverID,
+		Sent:      true,
+		Status:    status,
+		Timestamp: time.Now().UTC().Truncate(time.Millisecond), // precision kept by the store
+	}
+	if err = tx.PushInviteOutcome(senderID, outcome); err != nil {
+		return
+	}
+	outcome.UserID, outcome.Sent = senderID, false
+	return tx.PushInviteOutcome(receiverID, outcome)
+}
+
 // existingUser checks that a given user already exists in the system based on the email
 func (u *User) existingUser() (exists bool) {
 	_, err := GetUserByEmail(u.Email) // if u not exists, it will throw an error
-	if err == mongo.ErrNoDocuments {
+	if err == datastore.ErrNoDocFound {
 		return
 	}
 	if err != nil { // some other error occurred
-		log.Logger().Printf("u email unique check failed: %s", err)
+		u.logger().Errorf("u email unique check failed: %s", err)
 		return
 	}
 	exists = true
@@ -6,12 +6,14 @@ import (
 	"gibber/datastore"
 	"gibber/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
 )
 
 // user invites collection and fields
 const (
 	userInvitesCollection = "user_invites"
 	userIdField           = "user_id"
+	inviteOutcomesField   = "outcomes"
 )
 
 // userInvites stores reference for all the invitations sent and received associated with a user
@@ -23,6 +25,15 @@ type userInvites struct {
 	Accepted  []primitive.ObjectID `bson:"accepted" json:"accepted"`   // accepted requests by user
 	Rejected  []primitive.ObjectID `bson:"rejected" json:"rejected"`   // rejected requests by user
 	Cancelled []primitive.ObjectID `bson:"cancelled" json:"cancelled"` // cancelled sent requests by user
+	Outcomes  []InviteOutcome      `bson:"outcomes" json:"outcomes"`   // what happened to the inactive requests
+}
+
+// InviteOutcome records what happened to an invitation of a user, which is no longer active
+type InviteOutcome struct {
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"` // other user of the invitation
+	Sent      bool               `bson:"sent" json:"sent"`       // whether the invitation was sent by the user
+	Status    inviteType         `bson:"status" json:"status"`   // accepted, rejected or cancelled
+	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
 }
 
 // createUserInvitesData creates the empty invites collection for the given user
@@ -34,12 +45,13 @@ func createUserInvitesData(ctx context.Context, userId interface{}, dbConn datas
 		Accepted:  make([]primitive.ObjectID, 0),
 		Rejected:  make([]primitive.ObjectID, 0),
 		Cancelled: make([]primitive.ObjectID, 0),
+		Outcomes:  make([]InviteOutcome, 0),
 	}
 	userInvitesDataMap, _ := getMap(*userInvites)
 	userInvitesDataMap["user_id"] = userId.(primitive.ObjectID)
 	res, err := dbConn.InsertOne(ctx, userInvitesDataMap)
 	if err != nil {
-		log.Logger().Printf("error creating user %s invites data: %s", userId, err)
+		log.Logger().Errorf("error creating user %s invites data: %s", userId, err)
 	} else {
 		userInvitesDataId = res.InsertedID
 	}
@@ -51,6 +63,36 @@ func (u *userInvites) String() string {
 	return u.ID.String()
 }
 
+// list gives the invite list of the given type, nil for an invalid type
+func (u *userInvites) list(invType inviteType) *[]primitive.ObjectID {
+	switch invType {
+	case sent:
+		return &u.Sent
+	case received:
+		return &u.Received
+	case accepted:
+		return &u.Accepted
+	case rejected:
+		return &u.Rejected
+	case cancelled:
+		return &u.Cancelled
+	default:
+		return nil
+	}
+}
+
+// clone gives a deep copy of the invites data
+func (u *userInvites) clone() *userInvites {
+	c := *u
+	c.Sent = append(make([]primitive.ObjectID, 0, len(u.Sent)), u.Sent...)
+	c.Received = append(make([]primitive.ObjectID, 0, len(u.Received)), u.Received...)
+	c.Accepted = append(make([]primitive.ObjectID, 0, len(u.Accepted)), u.Accepted...)
+	c.Rejected = append(make([]primitive.ObjectID, 0, len(u.Rejected)), u.Rejected...)
+	c.Cancelled = append(make([]primitive.ObjectID, 0, len(u.Cancelled)), u.Cancelled...)
+	c.Outcomes = append(make([]InviteOutcome, 0, len(u.Outcomes)), u.Outcomes...)
+	return &c
+}
+
 // getMap gives the map converted form for a given struct, to be used to store as document (JSON) in mongo
 func getMap(data interface{}) (dataMap map[string]interface{}, err error) {
 	if data == nil {
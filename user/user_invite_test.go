@@ -21,6 +21,14 @@ func (d *databaseInsertFail) InsertOne(ctx context.Context, document interface{}
 	return nil, errInsertFailed
 }
 
+type databaseInsertSuccess struct {
+	// implementing DatabaseInserter interface for successful inserts
+}
+
+func (d *databaseInsertSuccess) InsertOne(ctx context.Context, document interface{}, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	return &mongo.InsertOneResult{InsertedID: primitive.NewObjectID()}, nil
+}
+
 func TestCreateUserInvitesData(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -33,7 +41,7 @@ func TestCreateUserInvitesData(t *testing.T) {
 			name:     "success scenario",
 			userId:   primitive.NewObjectID(),
 			expIdLen: len(primitive.NewObjectID().Hex()),
-			dbConn:   datastore.MongoConn().Collection(userInvitesCollection),
+			dbConn:   new(databaseInsertSuccess),
 			err:      nil,
 		},
 		{
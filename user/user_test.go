@@ -4,7 +4,6 @@ import (
 	"gibber/datastore"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 	"math/rand"
 	"testing"
@@ -70,7 +69,7 @@ func TestGetUserByID(t *testing.T) {
 	assert.NotEqual(t, primitive.ObjectID{}.Hex(), userFetched.InvitesId.Hex(), "invite ID should be created")
 
 	userFetched, err = GetUserByID(primitive.NewObjectID())
-	assert.Equal(t, mongo.ErrNoDocuments, err, "non-existent user ID")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "non-existent user ID")
 	assert.Equal(t, &User{}, userFetched, "empty user should be returned")
 }
 
@@ -123,8 +122,19 @@ func TestUser_UpdatePassword(t *testing.T) {
 	hash, err := bcrypt.GenerateFromPassword([]byte("password_new"), bcrypt.DefaultCost)
 	assert.NoError(t, err, "password hashing failed")
 
-	err = user.UpdatePassword(string(hash))
+	current, _, err := user.StartSession(ChatSession, time.Hour, "10.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	_, apiToken, err := user.StartSession(APISession, time.Hour, "10.0.0.2:1234")
+	assert.NoError(t, err, "starting session failed")
+	err = user.UpdatePassword(string(hash), current.ID)
 	assert.NoError(t, err, "update password failed")
+	sessions, err := user.Sessions()
+	assert.NoError(t, err, "fetching sessions failed")
+	if assert.Equal(t, 1, len(sessions), "the other sessions should be revoked") {
+		assert.Equal(t, current.ID, sessions[0].ID, "the session changing the password goes on")
+	}
+	_, _, err = AuthenticateAPI(apiToken)
+	assert.Equal(t, ErrInvalidSessionToken, err, "a revoked API session doesn't authenticate")
 }
 
 func TestUser_UpdateName(t *testing.T) {
@@ -185,8 +195,11 @@ func TestUser_Logout(t *testing.T) {
 	assert.NoError(t, err, "user login failed")
 	assert.True(t, len(lastLogin) > 0, "last login will be some non-empty timestamp")
 
-	err = user.Logout()
+	session, _, err := user.StartSession(ChatSession, time.Hour, "127.0.0.1:1234")
+	assert.NoError(t, err, "starting session failed")
+	err = user.Logout(session.ID)
 	assert.NoError(t, err, "user logout failed")
+	assert.Equal(t, datastore.ErrNoDocUpdate, user.Logout(session.ID), "session already ended")
 }
 
 func TestUser_SendInvitation(t *testing.T) {
@@ -376,6 +389,56 @@ func TestUser_CancelInvitation(t *testing.T) {
 	assert.NoError(t, err, "fetching new user invitations failed")
 }
 
+func TestUser_InvitationLifecycle(t *testing.T) {
+	sender, _ := createGroupTestUsers(t, 0)
+	acceptor, _ := createGroupTestUsers(t, 0)
+	rejector, _ := createGroupTestUsers(t, 0)
+	withdrawn, _ := createGroupTestUsers(t, 0)
+	for _, usr := range []*User{acceptor, rejector, withdrawn} {
+		assert.NoError(t, sender.SendInvitation(usr), "sending invitation failed")
+	}
+
+	assert.NoError(t, acceptor.AddFriend(sender.ID), "acceptor invitation failed")
+	assert.NoError(t, rejector.RejectInvitation(sender.ID), "rejector invitation failed")
+	assert.NoError(t, sender.CancelInvitation(withdrawn), "cancelling invitation failed")
+	assert.Equal(t, datastore.ErrNoDocUpdate, sender.CancelInvitation(withdrawn), "invitation no longer active")
+	assert.Equal(t, datastore.ErrNoDocUpdate, rejector.AddFriend(sender.ID), "invitation no longer active")
+
+	invites, err := sender.GetSentInvitations()
+	assert.NoError(t, err, "fetching sent invitations failed")
+	assert.Equal(t, 0, len(invites), "no invitation should be active")
+	for usr, list := range map[*User]func() ([]primitive.ObjectID, error){
+		acceptor:  sender.GetAcceptedInvitations,
+		rejector:  sender.GetRejectedInvitations,
+		withdrawn: sender.GetCanceledSentInvitations,
+	} {
+		invites, err = list()
+		assert.NoError(t, err, "fetching inactive invitations failed")
+		assert.Equal(t, []primitive.ObjectID{usr.ID}, invites)
+	}
+
+	outcomes, err := sender.GetInactiveSentInvitations()
+	assert.NoError(t, err, "fetching inactive sent invitations failed")
+	assert.Equal(t, 3, len(outcomes))
+	assert.Equal(t, withdrawn.ID, outcomes[0].UserID, "latest outcome should come first")
+	assert.Equal(t, cancelled, outcomes[0].Status)
+	outcomes, err = sender.GetInactiveReceivedInvitations()
+	assert.NoError(t, err, "fetching inactive received invitations failed")
+	assert.Equal(t, 0, len(outcomes))
+
+	for usr, status := range map[*User]inviteType{acceptor: accepted, rejector: rejected, withdrawn: cancelled} {
+		invites, err = usr.GetReceivedInvitations()
+		assert.NoError(t, err, "fetching received invitations failed")
+		assert.Equal(t, 0, len(invites), "no invitation should be active")
+		outcomes, err = usr.GetInactiveReceivedInvitations()
+		assert.NoError(t, err, "fetching inactive received invitations failed")
+		assert.Equal(t, 1, len(outcomes))
+		assert.Equal(t, sender.ID, outcomes[0].UserID)
+		assert.Equal(t, status, outcomes[0].Status)
+		assert.False(t, outcomes[0].Sent)
+	}
+}
+
 func TestUser_GetInvitation(t *testing.T) {
 	user1 := new(User)
 	user1.FirstName = "John"
@@ -391,7 +454,7 @@ func TestUser_GetInvitation(t *testing.T) {
 
 	user1.ID = primitive.NewObjectID()
 	invites, err = user1.getInvitations(accepted)
-	assert.Equal(t, mongo.ErrNoDocuments, err)
+	assert.Equal(t, datastore.ErrNoDocFound, err)
 	assert.Equal(t, 0, len(invites))
 }
 
@@ -451,7 +514,7 @@ func TestUserProfile(t *testing.T) {
 	assert.NotEqual(t, 0, len(userProfile), "user profile fetch failed")
 
 	userProfile, err = UserProfile(primitive.ObjectID{})
-	assert.Equal(t, mongo.ErrNoDocuments, err, "user profile fetch should fail as non-existent user")
+	assert.Equal(t, datastore.ErrNoDocFound, err, "user profile fetch should fail as non-existent user")
 	assert.Empty(t, userProfile, "user profile content should be empty as non-existent profile")
 }
 
@@ -481,21 +544,19 @@ func TestUser_ShowChat(t *testing.T) {
 	user2ID, err := CreateUser(user2)
 	assert.NoError(t, err, "user creation failed")
 
-	err = SendMessage(user1ID.(primitive.ObjectID), user2ID.(primitive.ObjectID), "test message",
-		datastore.MongoConn().Collection(datastore.ChatCollection))
+	_, err = SendMessage(user1ID.(primitive.ObjectID), user2ID.(primitive.ObjectID), "test message")
 	assert.NoError(t, err, "new document should be created for the chat")
 
-	err = SendMessage(user2ID.(primitive.ObjectID), user1ID.(primitive.ObjectID), "test message reply",
-		datastore.MongoConn().Collection(datastore.ChatCollection))
+	_, err = SendMessage(user2ID.(primitive.ObjectID), user1ID.(primitive.ObjectID), "test message reply")
 	assert.NoError(t, err, "new document should be created for the chat")
 
-	content, timestamp := user1.GetChat(user2ID.(primitive.ObjectID))
-	assert.NotEqual(t, "", content, "non-empty content should arrive")
-	assert.NotEqual(t, time.Time{}, timestamp, "non-empty (non-ZERO value) should be returned")
+	page, err := user1.GetChat(user2ID.(primitive.ObjectID), Cursor{})
+	assert.NoError(t, err, "fetching chat failed")
+	assert.NotEqual(t, "", page.Content, "non-empty content should arrive")
+	assert.NotEqual(t, time.Time{}, page.Latest, "non-empty (non-ZERO value) should be returned")
 
-	content, timestamp = user1.GetChat(primitive.NewObjectID())
-	assert.Equal(t, time.Time{}, timestamp, "non-empty (non-ZERO value) should be returned")
-	assert.True(t, len(content) > 0, "should be non-empty as friends are added")
+	_, err = user1.GetChat(primitive.NewObjectID(), Cursor{})
+	assert.Equal(t, datastore.ErrNoDocFound, err, "unknown user")
 }
 
 var seededRand = rand.New(rand.NewSource(time.Now().UnixNano()))
@@ -0,0 +1,141 @@
+package user
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"gibber/mail"
+	"go.mongodb.org/mongo-driver/bson"
+	"math/big"
+	"time"
+)
+
+// user document fields of the email verification
+const (
+	unverifiedField   = "unverified"
+	verificationField = "verification"
+)
+
+// verification code settings
+const (
+	verificationCodeDigits  = 6
+	maxVerificationAttempts = 5         // wrong codes entered, whichever codes were sent, before locking out
+	verificationLockout     = time.Hour // no code accepted for this long after the last wrong one, once locked out
+)
+
+// email verification errors
+var (
+	ErrInvalidVerificationCode = errors.New("invalid or expired verification code")
+	ErrNotVerified             = errors.New("email not verified")
+)
+
+// Verification is the code mailed to a user to verify his email. Only the hash of the code is stored. The wrong
+// codes are counted across the codes sent, for a resend not to give more guesses.
+type Verification struct {
+	CodeHash  string    `bson:"code_hash" json:"-"`
+	ExpiresAt time.Time `bson:"expires_at" json:"expires_at"`
+	Attempts  int       `bson:"attempts" json:"attempts"`   // wrong codes entered
+	FailedAt  time.Time `bson:"failed_at" json:"failed_at"` // when the last wrong code was entered
+}
+
+// RegisterUser creates a new user, unverified till he enters the code sent by SendVerificationCode. Till then, he
+// can't invite anyone, nor be invited.
+func RegisterUser(user *User) (userID interface{}, err error) {
+	user.Unverified = true
+	return CreateUser(user)
+}
+
+// SendVerificationCode mails a code valid for the given time to the current user, to verify his email. The code
+// replaces any code sent to him before, the wrong codes entered so far still counting.
+func (u *User) SendVerificationCode(ttl time.Duration) (err error) {
+	code, err := newVerificationCode()
+	if err != nil {
+		u.logger().Errorf("generating verification code for %s failed: %s", u.Email, err)
+		return
+	}
+	verification := Verification{CodeHash: u.hashVerificationCode(code), ExpiresAt: time.Now().UTC().Add(ttl)}
+	err = store.Transaction(func(tx Store) error {
+		usr, er := tx.FindUserByID(u.ID)
+		if er != nil {
+			return er
+		}
+		verification.Attempts, verification.FailedAt = usr.Verification.Attempts, usr.Verification.FailedAt
+		return tx.UpdateUser(u.ID, bson.M{verificationField: verification})
+	})
+	if err != nil {
+		u.logger().Errorf("storing verification code for %s failed: %s", u.Email, err)
+		return
+	}
+	err = mailer.Send(mail.Message{
+		To:      u.Email,
+		Subject: "Verify your gibber email",
+		Body: fmt.Sprintf("Hi %s,\n\nEnter this code to verify your email: %s\n\nIt is valid till %s.\n",
+			u.FirstName, code, verification.ExpiresAt.Format(time.RFC1123)),
+	})
+	if err != nil {
+		u.logger().Errorf("mailing verification code to %s failed: %s", u.Email, err)
+		return
+	}
+	u.logger().Infof("verification code sent to %s", u.Email)
+	return
+}
+
+// VerifyEmail verifies the email of the current user with the given code. It fails with
+// ErrInvalidVerificationCode if the code is wrong or expired, or if too many wrong codes were entered, no code
+// being accepted then till verificationLockout after the last wrong one.
+func (u *User) VerifyEmail(code string) (err error) {
+	valid := false
+	err = store.Transaction(func(tx Store) error {
+		usr, er := tx.FindUserByID(u.ID)
+		if er != nil {
+			return er
+		}
+		if !usr.Unverified {
+			valid = true // already verified
+			return nil
+		}
+		v, now := usr.Verification, time.Now().UTC()
+		if v.Attempts >= maxVerificationAttempts && now.After(v.FailedAt.Add(verificationLockout)) {
+			v.Attempts = 0 // locked out no longer, the count starts over
+		}
+		if v.CodeHash == "" || now.After(v.ExpiresAt) || v.Attempts >= maxVerificationAttempts {
+			return nil
+		}
+		if u.hashVerificationCode(code) != v.CodeHash {
+			v.Attempts, v.FailedAt = v.Attempts+1, now
+			return tx.UpdateUser(u.ID, bson.M{verificationField: v})
+		}
+		valid = true
+		return tx.UpdateUser(u.ID, bson.M{unverifiedField: false, verificationField: Verification{}})
+	})
+	if err != nil {
+		u.logger().Errorf("verifying email of %s failed: %s", u.Email, err)
+		return
+	}
+	if !valid {
+		u.logger().Warnf("invalid verification code entered by %s", u.Email)
+		return ErrInvalidVerificationCode
+	}
+	u.Unverified = false
+	u.logger().Infof("email %s verified", u.Email)
+	return
+}
+
+// newVerificationCode generates a random numeric verification code
+func newVerificationCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < verificationCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", verificationCodeDigits, n), nil
+}
+
+// hashVerificationCode gives the hash of the verification code of the current user, as stored. The code being
+// short, it is hashed along with the user ID.
+func (u *User) hashVerificationCode(code string) string {
+	return hashToken(u.ID.Hex() + ":" + code)
+}
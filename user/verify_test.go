@@ -0,0 +1,71 @@
+package user
+
+import (
+	"gibber/mail"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// mailedVerificationCode gives the verification code last mailed to the given email
+func mailedVerificationCode(t *testing.T, email string) string {
+	msg, ok := mailer.(*mail.MemoryMailer).Last(email)
+	if !ok {
+		t.Fatalf("no email sent to %s", email)
+	}
+	return regexp.MustCompile(`\b[0-9]{6}\b`).FindString(msg.Body)
+}
+
+func TestUser_VerifyEmail(t *testing.T) {
+	verified, _ := createGroupTestUsers(t, 0)
+	usr := &User{FirstName: "New", LastName: "Doe", Email: "john" + randomString(15) + "@doe.com", Password: "password"}
+	_, err := RegisterUser(usr)
+	assert.NoError(t, err, "registration failed")
+	stored, err := GetUserByID(usr.ID)
+	assert.NoError(t, err)
+	assert.True(t, stored.Unverified, "a registered user is unverified")
+	assert.Equal(t, ErrNotVerified, usr.SendInvitation(verified), "an unverified user can't invite")
+	assert.Equal(t, ErrNotVerified, verified.SendInvitation(stored), "an unverified user can't be invited")
+	assert.NoError(t, store.Transaction(func(tx Store) error { // pending nonetheless
+		if er := tx.PushInvite(verified.ID, sent, usr.ID); er != nil {
+			return er
+		}
+		return tx.PushInvite(usr.ID, received, verified.ID)
+	}))
+	assert.Equal(t, ErrNotVerified, usr.AddFriend(verified.ID), "an unverified user can't accept an invitation")
+
+	assert.Equal(t, ErrInvalidVerificationCode, usr.VerifyEmail("123456"), "no code sent yet")
+	assert.NoError(t, usr.SendVerificationCode(time.Hour), "sending code failed")
+	code := mailedVerificationCode(t, usr.Email)
+	for i := 0; i < maxVerificationAttempts; i++ {
+		assert.Equal(t, ErrInvalidVerificationCode, usr.VerifyEmail("wrong"))
+	}
+	assert.Equal(t, ErrInvalidVerificationCode, usr.VerifyEmail(code), "too many wrong codes")
+	assert.NoError(t, usr.SendVerificationCode(time.Hour), "resending code failed")
+	code = mailedVerificationCode(t, usr.Email)
+	assert.Equal(t, ErrInvalidVerificationCode, usr.VerifyEmail(code), "a resent code doesn't end the lockout")
+
+	stored, err = GetUserByID(usr.ID)
+	assert.NoError(t, err)
+	stored.Verification.FailedAt = stored.Verification.FailedAt.Add(-verificationLockout - time.Second)
+	assert.NoError(t, store.UpdateUser(usr.ID, bson.M{verificationField: stored.Verification}))
+	assert.NoError(t, usr.VerifyEmail(code), "verification failed once the lockout ended")
+	assert.False(t, usr.Unverified)
+	login := &User{Email: usr.Email}
+	_, err = login.LoginUser("password")
+	assert.NoError(t, err)
+	assert.False(t, login.Unverified, "the verification is persisted")
+	assert.NoError(t, login.SendInvitation(verified), "a verified user can invite")
+	assert.NoError(t, login.AddFriend(verified.ID), "a verified user can accept an invitation")
+	assert.NoError(t, login.VerifyEmail("any"), "an already verified email stays verified")
+}
+
+func TestUser_VerifyEmail_Expired(t *testing.T) {
+	usr := &User{FirstName: "New", LastName: "Doe", Email: "john" + randomString(15) + "@doe.com", Password: "password"}
+	_, err := RegisterUser(usr)
+	assert.NoError(t, err, "registration failed")
+	assert.NoError(t, usr.SendVerificationCode(-time.Second), "sending code failed")
+	assert.Equal(t, ErrInvalidVerificationCode, usr.VerifyEmail(mailedVerificationCode(t, usr.Email)))
+}